@@ -7,6 +7,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/otiai10/gosseract/v2 v2.4.1
 	github.com/rs/zerolog v1.33.0
+	golang.org/x/image v0.18.0
 )
 
 require (
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/config"
+	imageprocessor "run-tracker-telebot/src/pkg/image-processor"
+	"strings"
+)
+
+// runEvaluate measures how well the screenshots of a fixture directory are read, once
+// without preprocessing and once with the given steps:
+//
+//	run-tracker-telebot evaluate [-fixtures dir] [-steps crop,grayscale,invert]
+func runEvaluate(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("evaluate", flag.ContinueOnError)
+	dir := flags.String("fixtures", "src/pkg/image-processor/testdata/screenshots", "directory with the screenshots and "+imageprocessor.FIXTURES_FILE)
+	input := flags.String("steps", strings.Join(imageprocessor.DEFAULT_STEPS, ","), "preprocessing steps to compare against none")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	steps, err := imageprocessor.ParseSteps(*input)
+	if err != nil {
+		log.Warn().Msgf("Invalid preprocessing steps: %v", err)
+		return 2
+	}
+
+	fixtures, err := imageprocessor.LoadFixtures(*dir)
+	if err != nil {
+		log.Warn().Msgf("Error loading fixtures: %v", err)
+		return 1
+	}
+	if len(fixtures) == 0 {
+		log.Warn().Msgf("No fixtures in %s", *dir)
+		return 1
+	}
+
+	imageProcessor, err := imageprocessor.NewImageProcessor(cfg.OCR)
+	if err != nil {
+		log.Warn().Msgf("Error setting up OCR: %v", err)
+		return 2
+	}
+	before := imageProcessor.Evaluate(*dir, fixtures, nil)
+	after := imageProcessor.Evaluate(*dir, fixtures, steps)
+
+	for _, evaluation := range []imageprocessor.Evaluation{before, after} {
+		name := strings.Join(evaluation.Steps, ",")
+		if name == "" {
+			name = "none"
+		}
+		for _, miss := range evaluation.Misses {
+			log.Info().Msgf("[%s] %s", name, miss)
+		}
+		log.Info().Msgf("[%s] %d/%d parsed, %d distances, %d paces, %d correct (%.0f%%)",
+			name, evaluation.Parsed, evaluation.Total, evaluation.Distance, evaluation.Pace, evaluation.Correct, evaluation.Accuracy()*100)
+	}
+
+	return 0
+}
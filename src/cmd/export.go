@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/config"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"run-tracker-telebot/src/pkg/exporter"
+	"run-tracker-telebot/src/pkg/stats"
+	"time"
+)
+
+// runExport writes workouts of a group to a file without starting the bot:
+//
+//	run-tracker-telebot export -group <chat id> [-user <user id>] [-format csv|json] [-period "last month"] [-out file]
+func runExport(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	groupID := flags.Int64("group", 0, "chat id of the group to export")
+	userID := flags.Int64("user", 0, "only export this user, 0 exports everyone")
+	format := flags.String("format", exporter.FORMAT_CSV, "csv or json")
+	input := flags.String("period", stats.PERIOD_ALL, "period to export, anything /stats understands")
+	out := flags.String("out", "", "output file, defaults to a name built from the period")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *groupID == 0 || !exporter.IsFormat(*format) {
+		flags.Usage()
+		return 2
+	}
+
+	period, err := stats.ParsePeriod(*input, time.Now(), stats.DEFAULT_WEEK_START)
+	if err != nil {
+		log.Warn().Msgf("Invalid export period: %v", err)
+		return 2
+	}
+
+	databaseManager := databasemanager.NewDatabaseManager(cfg.Storage)
+	if err := databaseManager.LoadData(); err != nil {
+		log.Warn().Msgf("Error loading workout data: %v", err)
+		return 1
+	}
+	if err := databaseManager.LoadUserData(); err != nil {
+		log.Warn().Msgf("Error loading users: %v", err)
+		return 1
+	}
+
+	if *out == "" {
+		*out = exporter.FileName(*format, period)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Warn().Msgf("Error creating export file: %v", err)
+		return 1
+	}
+	defer file.Close()
+
+	count, err := exporter.NewExporter(databaseManager).Export(file, *format, *groupID, *userID, period)
+	if err != nil {
+		log.Warn().Msgf("Error exporting workouts: %v", err)
+		return 1
+	}
+
+	log.Info().Msgf("Exported %d workouts to %s", count, *out)
+	return 0
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+const (
+	FAKE_TOKEN  = "123456:fake-token"
+	FAKE_BOT_ID = int64(123456)
+	// POLL_WAIT is how long getUpdates waits for an update, short so the bot stops quickly.
+	POLL_WAIT = 50 * time.Millisecond
+)
+
+var tagRegex = regexp.MustCompile(`<[^>]+>`)
+
+// botMessage is a message the bot sent, as the user sees it: without HTML tags.
+type botMessage struct {
+	Method  string
+	ChatID  int64
+	ReplyTo int64
+	Text    string
+}
+
+// fakeBotAPI stands in for the Bot API: it queues the updates a test pushes for
+// getUpdates, keeps what the bot sends and serves the files a test adds.
+type fakeBotAPI struct {
+	t      *testing.T
+	server *httptest.Server
+
+	lock     sync.Mutex
+	updates  []gotgbot.Update
+	nextID   int64
+	pushed   chan struct{}
+	files    map[string][]byte
+	calls    map[string]int
+	messages chan botMessage
+	// held makes sendMessage wait until it is closed, keeping the handler running.
+	held chan struct{}
+}
+
+func newFakeBotAPI(t *testing.T) *fakeBotAPI {
+	api := &fakeBotAPI{
+		t:        t,
+		pushed:   make(chan struct{}, 1),
+		files:    make(map[string][]byte),
+		calls:    make(map[string]int),
+		messages: make(chan botMessage, 100),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bot"+FAKE_TOKEN+"/", api.handleMethod)
+	mux.HandleFunc("/file/bot"+FAKE_TOKEN+"/", api.handleFile)
+	api.server = httptest.NewServer(mux)
+	t.Cleanup(api.server.Close)
+
+	return api
+}
+
+// URL is the API URL to set the bot up with.
+func (api *fakeBotAPI) URL() string {
+	return api.server.URL
+}
+
+// push queues an update for getUpdates, numbering it and its message.
+func (api *fakeBotAPI) push(update gotgbot.Update) {
+	api.lock.Lock()
+	api.nextID++
+	update.UpdateId = api.nextID
+	if update.Message != nil {
+		update.Message.MessageId = api.nextID
+	}
+	api.updates = append(api.updates, update)
+	api.lock.Unlock()
+
+	select {
+	case api.pushed <- struct{}{}:
+	default:
+	}
+}
+
+// addFile serves data as the file fileID.
+func (api *fakeBotAPI) addFile(fileID string, data []byte) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	api.files[fileID] = data
+}
+
+// hold makes the messages of the bot wait until release is called, as if Telegram
+// hung, so that the handlers sending them keep running.
+func (api *fakeBotAPI) hold() (release func()) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	held := make(chan struct{})
+	api.held = held
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(held) })
+	}
+}
+
+// called is how often the bot called method, "download" counts file downloads.
+func (api *fakeBotAPI) called(method string) int {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	return api.calls[method]
+}
+
+// next waits for the next message the bot sends.
+func (api *fakeBotAPI) next(timeout time.Duration) (botMessage, bool) {
+	select {
+	case msg := <-api.messages:
+		return msg, true
+	case <-time.After(timeout):
+		return botMessage{}, false
+	}
+}
+
+func (api *fakeBotAPI) handleMethod(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+FAKE_TOKEN+"/")
+	params, err := readParams(r)
+	if err != nil {
+		api.fail(w, http.StatusBadRequest, fmt.Sprintf("Bad Request: %v", err))
+		return
+	}
+
+	api.lock.Lock()
+	api.calls[method]++
+	held := api.held
+	api.lock.Unlock()
+
+	if method == "sendMessage" && held != nil {
+		select {
+		case <-held:
+		case <-r.Context().Done():
+		}
+	}
+
+	switch method {
+	case "getMe":
+		api.ok(w, gotgbot.User{Id: FAKE_BOT_ID, IsBot: true, FirstName: "Run Tracker", Username: "run_tracker_fake_bot"})
+	case "deleteWebhook", "answerCallbackQuery":
+		api.ok(w, true)
+	case "getUpdates":
+		offset, _ := strconv.ParseInt(params["offset"], 10, 64)
+		api.ok(w, api.waitUpdates(r, offset))
+	case "sendMessage":
+		api.ok(w, api.sent(method, params, params["text"]))
+	case "sendPhoto", "sendDocument":
+		api.ok(w, api.sent(method, params, params["caption"]))
+	case "editMessageText":
+		api.sent(method, params, params["text"])
+		api.ok(w, true)
+	case "getFile":
+		api.lock.Lock()
+		data, ok := api.files[params["file_id"]]
+		api.lock.Unlock()
+		if !ok {
+			api.fail(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+			return
+		}
+		api.ok(w, gotgbot.File{FileId: params["file_id"], FileSize: int64(len(data)), FilePath: "photos/" + params["file_id"]})
+	default:
+		api.fail(w, http.StatusNotFound, "Not Found: method not supported by the fake")
+	}
+}
+
+func (api *fakeBotAPI) handleFile(w http.ResponseWriter, r *http.Request) {
+	fileID := strings.TrimPrefix(r.URL.Path, "/file/bot"+FAKE_TOKEN+"/photos/")
+
+	api.lock.Lock()
+	api.calls["download"]++
+	data, ok := api.files[fileID]
+	api.lock.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(data)
+}
+
+// waitUpdates drops the updates before offset, as Telegram confirms them, and returns
+// the others, waiting up to POLL_WAIT for one.
+func (api *fakeBotAPI) waitUpdates(r *http.Request, offset int64) []gotgbot.Update {
+	deadline := time.After(POLL_WAIT)
+	for {
+		api.lock.Lock()
+		pending := api.updates[:0]
+		for _, update := range api.updates {
+			if update.UpdateId >= offset {
+				pending = append(pending, update)
+			}
+		}
+		api.updates = pending
+		updates := append([]gotgbot.Update{}, pending...)
+		api.lock.Unlock()
+
+		if len(updates) > 0 {
+			return updates
+		}
+		select {
+		case <-api.pushed:
+		case <-deadline:
+			return updates
+		case <-r.Context().Done():
+			return updates
+		}
+	}
+}
+
+// sent records a message of the bot and returns it as Telegram would.
+func (api *fakeBotAPI) sent(method string, params map[string]string, text string) gotgbot.Message {
+	chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+	msg := botMessage{
+		Method: method,
+		ChatID: chatID,
+		Text:   html.UnescapeString(tagRegex.ReplaceAllString(text, "")),
+	}
+	if reply := params["reply_parameters"]; reply != "" {
+		var parameters gotgbot.ReplyParameters
+		if err := json.Unmarshal([]byte(reply), &parameters); err == nil {
+			msg.ReplyTo = parameters.MessageId
+		}
+	}
+
+	select {
+	case api.messages <- msg:
+	default:
+		api.t.Errorf("too many messages not read by the test, dropping %+v", msg)
+	}
+
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	api.nextID++
+	return gotgbot.Message{
+		MessageId: api.nextID,
+		Date:      time.Now().Unix(),
+		Chat:      gotgbot.Chat{Id: chatID, Type: "private"},
+		From:      &gotgbot.User{Id: FAKE_BOT_ID, IsBot: true, FirstName: "Run Tracker"},
+		Text:      text,
+	}
+}
+
+func (api *fakeBotAPI) ok(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func (api *fakeBotAPI) fail(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": code, "description": description})
+}
+
+// readParams reads the parameters of a call, sent as a JSON object of strings or as a
+// multipart form when files are uploaded.
+func readParams(r *http.Request) (map[string]string, error) {
+	params := make(map[string]string)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			return nil, err
+		}
+		for key, values := range r.MultipartForm.Value {
+			params[key] = values[0]
+		}
+		return params, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return params, nil
+	}
+	return params, json.Unmarshal(body, &params)
+}
@@ -1,55 +1,122 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"run-tracker-telebot/src/log"
 	chatmanager "run-tracker-telebot/src/pkg/chat-manager"
+	"run-tracker-telebot/src/pkg/config"
 	databasemanager "run-tracker-telebot/src/pkg/database-manager"
 	imageprocessor "run-tracker-telebot/src/pkg/image-processor"
-	"run-tracker-telebot/src/pkg/shared"
 	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/joho/godotenv"
 )
 
-const logsDir = "./logs"
+// SHUTDOWN_TIMEOUT is how long updates being handled get to finish on shutdown, reading
+// a screenshot can take a few seconds. Tests shorten it.
+var SHUTDOWN_TIMEOUT = 30 * time.Second
 
 func main() {
-	log.InitLogger()
-	log.Info().Msgf("Initiatized Logger")
-
 	rootDir, err := os.Getwd()
 	if err != nil {
-		log.Warn().Msgf("Error getting current directory:", err)
+		fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
 	}
-	err = godotenv.Load(filepath.Join(rootDir, ".env"))
+	envErr := godotenv.Load(filepath.Join(rootDir, ".env"))
+
+	cfg, err := config.Load("")
 	if err != nil {
-		log.Warn().Msgf("Error loading .env file:", err)
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	log.InitLogger(cfg.Log.Level, cfg.Log.Dir)
+	log.Info().Msgf("Initiatized Logger")
+	if envErr != nil {
+		log.Warn().Msgf("Error loading .env file: %v", envErr)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(cfg, os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "evaluate" {
+		os.Exit(runEvaluate(cfg, os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "record" {
+		os.Exit(runRecord(cfg, os.Args[2:]))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Error().Msgf("Invalid config:\n%v", err)
+		log.Close()
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	code := run(ctx, cfg)
+	stop()
+
+	if err := log.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing log file: %v\n", err)
 	}
+	os.Exit(code)
+}
 
-	imageProcessor := imageprocessor.NewImageProcessor()
-	databaseManager := databasemanager.NewDatabaseManager(
-		shared.WORKOUT_DATA_DIR+"/"+shared.WORKOUT_DATA_FILE,
-		shared.WORKOUT_DATA_DIR+"/"+shared.AUTHORIZED_USERS_FILE,
-	)
-	chatManager := chatmanager.NewChatManager(databaseManager, imageProcessor)
+// run serves the bot with the validated config until ctx is done, then shuts it down
+// within SHUTDOWN_TIMEOUT. It returns the exit code: 0 after a clean shutdown, 1 when
+// the bot could not start and 3 when handlers were cut off or the store could not be
+// flushed.
+func run(ctx context.Context, cfg *config.Config) int {
+	imageProcessor, err := imageprocessor.NewImageProcessor(cfg.OCR)
+	if err != nil {
+		log.Error().Msgf("Error setting up OCR: %v", err)
+		return 1
+	}
+	databaseManager := databasemanager.NewDatabaseManager(cfg.Storage)
+	chatManager, err := chatmanager.NewChatManager(cfg, databaseManager, imageProcessor)
+	if err != nil {
+		log.Error().Msgf("Error setting up the bot: %v", err)
+		return 1
+	}
 
 	err = databaseManager.LoadData()
 	if err != nil {
 		log.Warn().Msgf("Error loading workout data: %v", err)
 	}
 
-	// Start the chat manager
-	chatManager.Start()
+	err = databaseManager.LoadSettings()
+	if err != nil {
+		log.Warn().Msgf("Error loading settings: %v", err)
+	}
 
-	// Create a channel to receive OS signals
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	err = chatManager.Start()
+	if err != nil {
+		log.Error().Msgf("Error starting the bot: %v", err)
+		return 1
+	}
 
 	// Block until a signal is received
-	<-sig
+	<-ctx.Done()
+	log.Info().Msgf("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	code := 0
+	if err := chatManager.Stop(shutdownCtx); err != nil {
+		log.Error().Msgf("Error stopping the bot: %v", err)
+		code = 3
+	}
+	if err := databaseManager.Flush(); err != nil {
+		log.Error().Msgf("Error flushing the store: %v", err)
+		code = 3
+	}
 
 	log.Info().Msgf("Exiting...")
+	return code
 }
@@ -1 +1,442 @@
 package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	chatmanager "run-tracker-telebot/src/pkg/chat-manager"
+	"run-tracker-telebot/src/pkg/config"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	imageprocessor "run-tracker-telebot/src/pkg/image-processor"
+	"run-tracker-telebot/src/pkg/localizer"
+	"run-tracker-telebot/src/pkg/stats"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+const (
+	TEST_PASSWORD = "secret"
+	TEST_USER     = int64(42)
+	// ANSWER_TIMEOUT is how long a step waits for the answer of the bot, reading a
+	// screenshot included.
+	ANSWER_TIMEOUT = 10 * time.Second
+	// STEP_PAUSE is the time a user takes to answer. A conversation moves on once its
+	// handler returns, just after the handler answered.
+	STEP_PAUSE = 50 * time.Millisecond
+)
+
+// RUNKEEPER_TEXT is what OCR reads off a RunKeeper summary. OCR has to work, but what
+// it reads is not under test, the image logging scenario parses this text instead.
+const RUNKEEPER_TEXT = `Running
+5.02
+km
+30:07
+time
+6:00
+min/km
+312
+Calories`
+
+// step is a message of the user and what the bot should answer.
+type step struct {
+	// send is the text sent, photo sends a screenshot instead.
+	send  string
+	photo bool
+	// want is the catalog key of the answer, contains a text the answer shows.
+	want     string
+	contains string
+}
+
+// testBot is the bot set up as run sets it up, talking to a fake Bot API.
+type testBot struct {
+	t   *testing.T
+	api *fakeBotAPI
+	db  *databasemanager.DatabaseManager
+	cm  *chatmanager.ChatManager
+	loc *localizer.Localizer
+	// ocr is the error reading text off an image, nil when Tesseract works.
+	ocr error
+	// location is the timezone the bot logs workouts in.
+	location *time.Location
+}
+
+// testConfig is a valid config of a bot talking to api, storing its data in a
+// temporary directory that is also the working directory.
+func testConfig(t *testing.T, api *fakeBotAPI) *config.Config {
+	t.Helper()
+
+	// handleImage saves the screenshot in the working directory
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	cfg := config.Default()
+	cfg.Telegram.Token = FAKE_TOKEN
+	cfg.Telegram.APIURL = api.URL()
+	cfg.Telegram.ListenAddr = "127.0.0.1:0"
+	cfg.Auth.Password = TEST_PASSWORD
+	cfg.Storage.DataDir = dir
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	return cfg
+}
+
+func startTestBot(t *testing.T) *testBot {
+	t.Helper()
+
+	api := newFakeBotAPI(t)
+	cfg := testConfig(t, api)
+	location, err := time.LoadLocation(cfg.Defaults.Timezone)
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+
+	imageProcessor, err := imageprocessor.NewImageProcessor(cfg.OCR)
+	if err != nil {
+		t.Fatalf("NewImageProcessor: %v", err)
+	}
+	imageProcessor.Parsers = []imageprocessor.Parser{{
+		Name:    imageprocessor.PARSER_RUNKEEPER,
+		Matches: func(text string) bool { return true },
+		Parse: func(text string) (*imageprocessor.ParseResult, error) {
+			return imageProcessor.ParseRunKeepWorkoutDetails(RUNKEEPER_TEXT)
+		},
+	}}
+
+	// An empty store, as LoadData cannot read empty files
+	databaseManager := databasemanager.NewDatabaseManager(cfg.Storage)
+	for _, path := range []string{databaseManager.FilePath, databaseManager.UserFilePath} {
+		if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	if err := databaseManager.LoadData(); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	if err := databaseManager.LoadSettings(); err != nil {
+		t.Fatalf("LoadSettings: %v", err)
+	}
+
+	chatManager, err := chatmanager.NewChatManager(cfg, databaseManager, imageProcessor)
+	if err != nil {
+		t.Fatalf("NewChatManager: %v", err)
+	}
+	if err := chatManager.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	// Stopping the updater before it polls races in gotgbot, e.g. when a test skips
+	waitFor(t, "the bot to poll", func() bool { return api.called("getUpdates") > 0 })
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := chatManager.Stop(ctx); err != nil {
+			t.Errorf("Stop: %v", err)
+		}
+		if err := databaseManager.Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+	})
+
+	return &testBot{
+		t:        t,
+		api:      api,
+		db:       databaseManager,
+		cm:       chatManager,
+		loc:      localizer.NewLocalizer(),
+		ocr:      imageProcessor.CheckOCR(),
+		location: location,
+	}
+}
+
+// today is the date of today where the bot is, not where the test runs.
+func (bot *testBot) today() time.Time {
+	return time.Now().In(bot.location)
+}
+
+// authorize signs the user up, as if they went through /start.
+func (bot *testBot) authorize(name string) {
+	bot.t.Helper()
+
+	if err := bot.db.SaveUser(name, TEST_USER); err != nil {
+		bot.t.Fatalf("SaveUser: %v", err)
+	}
+}
+
+// addWorkout logs a workout of the user in their chat with the bot.
+func (bot *testBot) addWorkout(date time.Time, distance string) {
+	bot.t.Helper()
+
+	bot.db.Data.Lock()
+	defer bot.db.Data.Unlock()
+
+	details := map[string]string{"Distance": distance, "Pace": "6:00"}
+	if !bot.db.InsertWorkoutEntry(TEST_USER, TEST_USER, date.Format(stats.DATE_LAYOUT), details) {
+		bot.t.Fatalf("InsertWorkoutEntry(%s, %s) failed", date, distance)
+	}
+}
+
+// run sends the steps one after another, each once the bot answered the one before.
+func (bot *testBot) run(steps []step) {
+	bot.t.Helper()
+
+	for i, s := range steps {
+		msg := &gotgbot.Message{
+			Date: time.Now().Unix(),
+			Chat: gotgbot.Chat{Id: TEST_USER, Type: "private"},
+			From: &gotgbot.User{Id: TEST_USER, FirstName: "Alice", LanguageCode: "en"},
+			Text: s.send,
+		}
+		if s.photo {
+			fileID := "screenshot-" + strings.Repeat("x", i+1)
+			bot.api.addFile(fileID, screenshot(bot.t))
+			msg.Photo = []gotgbot.PhotoSize{{FileId: fileID, FileUniqueId: fileID, Width: 64, Height: 128}}
+		} else if strings.HasPrefix(s.send, "/") {
+			msg.Entities = []gotgbot.MessageEntity{{Type: "bot_command", Length: int64(len(strings.Fields(s.send)[0]))}}
+		}
+		bot.api.push(gotgbot.Update{Message: msg})
+
+		answer, ok := bot.api.next(ANSWER_TIMEOUT)
+		if !ok {
+			bot.t.Fatalf("step %d %q: no answer", i+1, s.send)
+		}
+		if answer.ChatID != TEST_USER {
+			bot.t.Errorf("step %d %q: answered in chat %d, want %d", i+1, s.send, answer.ChatID, TEST_USER)
+		}
+		if s.want != "" && !strings.Contains(answer.Text, bot.loc.T("en", s.want)) {
+			bot.t.Fatalf("step %d %q: got %q, want %s: %q", i+1, s.send, answer.Text, s.want, bot.loc.T("en", s.want))
+		}
+		if s.contains != "" && !strings.Contains(answer.Text, s.contains) {
+			bot.t.Fatalf("step %d %q: got %q, want it to contain %q", i+1, s.send, answer.Text, s.contains)
+		}
+		time.Sleep(STEP_PAUSE)
+	}
+
+	if extra, ok := bot.api.next(100 * time.Millisecond); ok {
+		bot.t.Errorf("unexpected message after the last step: %+v", extra)
+	}
+}
+
+// screenshot is a blank PNG, its text does not matter as the parser is fixed.
+func screenshot(t *testing.T) []byte {
+	img := image.NewGray(image.Rect(0, 0, 64, 128))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestOnboarding(t *testing.T) {
+	bot := startTestBot(t)
+
+	bot.run([]step{
+		{send: "/start", want: "start.ask_password"},
+		{send: "hunter2", want: "auth.invalid_password"},
+		{send: TEST_PASSWORD, want: "auth.password_valid"},
+		{send: "Alice", contains: "Alice"},
+		{send: "/getdistance", want: "distance.ask_duration"},
+	})
+
+	if name, err := bot.db.GetUsernameFromId(TEST_USER); err != nil || name != "Alice" {
+		t.Errorf("saved user: got %q, %v, want Alice", name, err)
+	}
+}
+
+func TestImageLogging(t *testing.T) {
+	bot := startTestBot(t)
+	if bot.ocr != nil {
+		t.Skipf("Tesseract is not available: %v", bot.ocr)
+	}
+	bot.authorize("Alice")
+
+	bot.run([]step{
+		{photo: true, contains: "5.02"},
+	})
+
+	if got := bot.api.called("download"); got != 1 {
+		t.Errorf("screenshot downloaded %d times, want 1", got)
+	}
+	workouts, err := bot.db.GetUserWorkouts(TEST_USER, TEST_USER)
+	if err != nil {
+		t.Fatalf("GetUserWorkouts: %v", err)
+	}
+	workout, ok := workouts[bot.today().Format(stats.DATE_LAYOUT)]
+	if !ok || workout.Distance != "5.02" || workout.Pace != "6:00" {
+		t.Errorf("logged workouts: got %+v, want 5.02 km at 6:00 today", workouts)
+	}
+}
+
+func TestGetDistance(t *testing.T) {
+	bot := startTestBot(t)
+	bot.authorize("Alice")
+	bot.addWorkout(bot.today(), "5.25")
+	bot.addWorkout(bot.today().AddDate(-1, 0, 0), "42.00")
+
+	bot.run([]step{
+		{send: "/getdistance", want: "distance.ask_duration"},
+		{send: "MONTH", want: "distance.ask_month"},
+		{send: "this month", contains: "Alice: 5.25KM"},
+		{send: "/getdistance this week", contains: "Alice: 5.25KM"},
+	})
+}
+
+func TestDelete(t *testing.T) {
+	bot := startTestBot(t)
+	bot.authorize("Alice")
+	bot.addWorkout(bot.today(), "10.00")
+
+	bot.run([]step{
+		{send: "/delete", want: "delete.ask_date"},
+		{send: "someday", want: "delete.invalid_date"},
+		{send: "today", want: "delete.deleted"},
+		{send: "today", want: "delete.not_found"},
+	})
+
+	if bot.db.HasWorkout(TEST_USER, TEST_USER, bot.today().Format(stats.DATE_LAYOUT)) {
+		t.Errorf("workout still logged after /delete")
+	}
+}
+
+// sendStart sends /start, which the bot answers right away.
+func sendStart(api *fakeBotAPI) {
+	api.push(gotgbot.Update{Message: &gotgbot.Message{
+		Date:     time.Now().Unix(),
+		Chat:     gotgbot.Chat{Id: TEST_USER, Type: "private"},
+		From:     &gotgbot.User{Id: TEST_USER, FirstName: "Alice", LanguageCode: "en"},
+		Text:     "/start",
+		Entities: []gotgbot.MessageEntity{{Type: "bot_command", Length: 6}},
+	}})
+}
+
+// waitFor waits until done returns true, failing the test after ANSWER_TIMEOUT.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(ANSWER_TIMEOUT)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStop(t *testing.T) {
+	bot := startTestBot(t)
+	release := bot.api.hold()
+	t.Cleanup(release)
+
+	sendStart(bot.api)
+	waitFor(t, "the answer to /start", func() bool { return bot.api.called("sendMessage") > 0 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := bot.cm.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stop with a handler running = %v, want the deadline exceeded", err)
+	}
+
+	// The handler finishing later does not stop the updater a second time
+	release()
+	if err := bot.cm.Stop(context.Background()); err != nil {
+		t.Errorf("Stop after a cut off stop = %v, want nil", err)
+	}
+}
+
+// runBot runs the bot as main does, until the returned stop is called, which returns
+// the exit code of run.
+func runBot(t *testing.T, api *fakeBotAPI, cfg *config.Config) (stop func() int) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	code := make(chan int, 1)
+	go func() {
+		code <- run(ctx, cfg)
+	}()
+	waitFor(t, "the bot to poll", func() bool { return api.called("getUpdates") > 0 })
+
+	return func() int {
+		cancel()
+		select {
+		case c := <-code:
+			return c
+		case <-time.After(SHUTDOWN_TIMEOUT + ANSWER_TIMEOUT):
+			t.Fatal("run did not return after the shutdown timeout")
+			return -1
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Run("clean shutdown", func(t *testing.T) {
+		api := newFakeBotAPI(t)
+		stop := runBot(t, api, testConfig(t, api))
+		if code := stop(); code != 0 {
+			t.Errorf("exit code %d, want 0", code)
+		}
+	})
+
+	t.Run("bot cannot start", func(t *testing.T) {
+		api := newFakeBotAPI(t)
+		cfg := testConfig(t, api)
+		// Telegram cannot be reached to check the token
+		api.server.Close()
+
+		if code := run(context.Background(), cfg); code != 1 {
+			t.Errorf("exit code %d, want 1", code)
+		}
+	})
+
+	t.Run("store not flushed", func(t *testing.T) {
+		api := newFakeBotAPI(t)
+		cfg := testConfig(t, api)
+		// A directory in place of the workouts file cannot be synced
+		if err := os.Mkdir(cfg.Storage.Path(cfg.Storage.WorkoutsFile), 0o755); err != nil {
+			t.Fatalf("Mkdir: %v", err)
+		}
+
+		stop := runBot(t, api, cfg)
+		if code := stop(); code != 3 {
+			t.Errorf("exit code %d, want 3", code)
+		}
+	})
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	timeout := SHUTDOWN_TIMEOUT
+	SHUTDOWN_TIMEOUT = 200 * time.Millisecond
+	t.Cleanup(func() { SHUTDOWN_TIMEOUT = timeout })
+
+	api := newFakeBotAPI(t)
+	stop := runBot(t, api, testConfig(t, api))
+	release := api.hold()
+	t.Cleanup(release)
+
+	sendStart(api)
+	waitFor(t, "the answer to /start", func() bool { return api.called("sendMessage") > 0 })
+
+	started := time.Now()
+	code := stop()
+	if elapsed := time.Since(started); elapsed < SHUTDOWN_TIMEOUT {
+		t.Errorf("run returned after %v, before the shutdown timeout of %v", elapsed, SHUTDOWN_TIMEOUT)
+	}
+	if code != 3 {
+		t.Errorf("exit code %d with a handler cut off, want 3", code)
+	}
+}
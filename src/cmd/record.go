@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/config"
+	imageprocessor "run-tracker-telebot/src/pkg/image-processor"
+	"strings"
+)
+
+// runRecord adds a screenshot to the OCR corpus of the parser tests, writing the text
+// OCR reads off it and the values the parsers read now, to be checked by hand:
+//
+//	run-tracker-telebot record [-app runkeeper] [-name dark_mi] [-image] screenshot.jpg
+func runRecord(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("record", flag.ContinueOnError)
+	corpus := flags.String("corpus", filepath.Join("src/pkg/image-processor", imageprocessor.CORPUS_DIR), "directory of the corpus")
+	app := flags.String("app", "", "app of the screenshot, the parser that recognizes it when empty")
+	name := flags.String("name", "", "name of the fixture, e.g. dark_km, the name of the screenshot when empty")
+	keepImage := flags.Bool("image", false, "copy the screenshot into the corpus too")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		log.Warn().Msgf("Usage: record [-app app] [-name name] [-image] screenshot")
+		return 2
+	}
+	imagePath := flags.Arg(0)
+
+	if *name == "" {
+		*name = strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+	}
+
+	imageProcessor, err := imageprocessor.NewImageProcessor(cfg.OCR)
+	if err != nil {
+		log.Warn().Msgf("Error setting up OCR: %v", err)
+		return 2
+	}
+
+	dir, golden, err := imageProcessor.Record(imagePath, *corpus, *app, *name, *keepImage)
+	if err != nil {
+		log.Warn().Msgf("Error recording %s: %v", imagePath, err)
+		return 1
+	}
+
+	log.Info().Msgf("Recorded %s.txt, the parsers read %q km at %q in %q", filepath.Join(dir, *name), golden.Distance, golden.Pace, golden.Time)
+	log.Info().Msgf("Check the values in %s.json against the screenshot before committing", filepath.Join(dir, *name))
+	return 0
+}
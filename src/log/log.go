@@ -2,9 +2,9 @@ package log
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"io"
 	"path/filepath"
@@ -16,22 +16,17 @@ import (
 
 var (
 	Logger  zerolog.Logger
-	debug   bool
-	logpath string
+	logfile *os.File
 )
 
-func init() {
-	flag.BoolVar(&debug, "debug", true, "enable debug log")
-	flag.StringVar(&logpath, "logpath", "logs/", "logging path of log file")
-}
-
-func InitLogger() {
-	// val := flag.Lookup("logpath").Value.(flag.Getter).Get().(string)
-
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	if debug {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+// InitLogger logs from level on, e.g. "info", to the console and to a new file in
+// logpath. An unknown level logs everything from debug on.
+func InitLogger(level string, logpath string) {
+	parsed, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil || level == "" {
+		parsed = zerolog.DebugLevel
 	}
+	zerolog.SetGlobalLevel(parsed)
 	// required to keep nano value
 	zerolog.TimeFieldFormat = time.RFC3339Nano
 	zerolog.CallerMarshalFunc = func(pc uintptr, file string, line int) string {
@@ -47,11 +42,12 @@ func InitLogger() {
 		ts := time.Now().Format("20060102150405")
 		logfilename := fmt.Sprintf("%s.%s.%s.log", prog, host, ts)
 		if err := os.MkdirAll(logpath, os.ModePerm); err != nil {
-			fmt.Println("Error: logpath %v", logpath, err)
+			fmt.Printf("Error: logpath %v: %v\n", logpath, err)
 		}
-		logfile := filepath.Join(logpath, logfilename)
-		if file, err := os.OpenFile(logfile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.ModePerm); err == nil {
+		logfilepath := filepath.Join(logpath, logfilename)
+		if file, err := os.OpenFile(logfilepath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.ModePerm); err == nil {
 			w.Out = file
+			logfile = file
 		} else {
 			fmt.Printf("cannot create log: %v \n", err)
 		}
@@ -64,6 +60,24 @@ func InitLogger() {
 	Logger = zerolog.New(multi).With().Timestamp().Caller().Logger()
 }
 
+// Close flushes the log file to disk and closes it, later logs only go to the console.
+func Close() error {
+	if logfile == nil {
+		return nil
+	}
+
+	Logger = Logger.Output(zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) {
+		w.TimeFormat = "0102 15:04:05.000000"
+	}))
+	file := logfile
+	logfile = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 func Output(w io.Writer) zerolog.Logger {
 	return Logger.Output(w)
 }
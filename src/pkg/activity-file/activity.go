@@ -0,0 +1,252 @@
+package activityfile
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"run-tracker-telebot/src/pkg/stats"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported file formats, named after their extension.
+const (
+	FORMAT_GPX = "gpx"
+	FORMAT_TCX = "tcx"
+	FORMAT_FIT = "fit"
+)
+
+const (
+	EARTH_RADIUS = 6371000.0
+
+	// Slower than this between two points counts as standing still, m/s.
+	MOVING_SPEED = 0.5
+
+	// GPS and barometric altitude wobble by a few meters, smaller climbs are noise.
+	ELEVATION_THRESHOLD = 3.0
+)
+
+// Point is one sample of a track. Files leave out what the device did not record.
+type Point struct {
+	Time         time.Time
+	Lat          float64
+	Lon          float64
+	HasPosition  bool
+	Elevation    float64
+	HasElevation bool
+	HeartRate    int
+	// Distance is the distance since the start in meters as measured by the device, 0 if unknown.
+	Distance float64
+}
+
+// Activity is a workout read from an activity file. Distances are in km, elevation in m.
+type Activity struct {
+	Format        string
+	Sport         string
+	Start         time.Time
+	Points        []Point
+	Distance      float64
+	MovingTime    time.Duration
+	ElevationGain float64
+	AvgHeartRate  int
+	MaxHeartRate  int
+}
+
+// IsActivityFile tells by its name if a file is one Parse understands.
+func IsActivityFile(name string) bool {
+	switch formatOf(name) {
+	case FORMAT_GPX, FORMAT_TCX, FORMAT_FIT:
+		return true
+	}
+	return false
+}
+
+// Parse reads a GPX, TCX or FIT file, picking the format by the file name. Totals the
+// file carries itself, like the session of a FIT file, are preferred over totals
+// computed from the track.
+func Parse(name string, data []byte) (*Activity, error) {
+	var activity *Activity
+	var err error
+
+	format := formatOf(name)
+	switch format {
+	case FORMAT_GPX:
+		activity, err = parseGPX(data)
+	case FORMAT_TCX:
+		activity, err = parseTCX(data)
+	case FORMAT_FIT:
+		activity, err = parseFIT(data)
+	default:
+		return nil, fmt.Errorf("unsupported activity file: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s file: %w", format, err)
+	}
+
+	activity.Format = format
+	activity.summarize()
+
+	if activity.Distance <= 0 {
+		return nil, fmt.Errorf("no distance in %s file", format)
+	}
+	if activity.MovingTime <= 0 {
+		return nil, fmt.Errorf("no times in %s file", format)
+	}
+	return activity, nil
+}
+
+// Pace is the moving time per km.
+func (a *Activity) Pace() time.Duration {
+	return time.Duration(float64(a.MovingTime) / a.Distance)
+}
+
+// WorkoutDetails describes the activity with the same keys the image processor uses
+// for screenshots, so both are logged the same way.
+func (a *Activity) WorkoutDetails() map[string]string {
+	details := map[string]string{
+		"Distance":  strconv.FormatFloat(a.Distance, 'f', 2, 64),
+		"Pace":      stats.FormatPace(a.Pace()),
+		"TotalTime": FormatClock(a.MovingTime),
+	}
+	if a.ElevationGain > 0 {
+		details["ElevationGain"] = strconv.FormatFloat(a.ElevationGain, 'f', 0, 64)
+	}
+	if a.AvgHeartRate > 0 {
+		details["HeartRate"] = strconv.Itoa(a.AvgHeartRate)
+	}
+	if a.MaxHeartRate > 0 {
+		details["MaxHeartRate"] = strconv.Itoa(a.MaxHeartRate)
+	}
+	return details
+}
+
+// Track is the part of the points with a position, for drawing the route. Distance is
+// set on every point of the track, measured from the start in meters.
+func (a *Activity) Track() []Point {
+	var track []Point
+	var distance float64
+	var previous *Point
+	for i := range a.Points {
+		point := &a.Points[i]
+		if previous != nil {
+			distance += stepDistance(previous, point)
+		}
+		previous = point
+
+		if point.HasPosition {
+			positioned := *point
+			positioned.Distance = distance
+			track = append(track, positioned)
+		}
+	}
+	return track
+}
+
+// FormatClock renders a duration like a watch does, 27:33 or 1:02:03.
+func FormatClock(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// summarize fills in the totals the file did not carry from its points.
+func (a *Activity) summarize() {
+	if a.Start.IsZero() {
+		for _, point := range a.Points {
+			if !point.Time.IsZero() {
+				a.Start = point.Time
+				break
+			}
+		}
+	}
+
+	var distance, gain float64
+	var moving time.Duration
+	var heartRateSum, heartRates, maxHeartRate int
+	var previous *Point
+	var reference float64
+	hasReference := false
+
+	for i := range a.Points {
+		point := &a.Points[i]
+
+		if point.HeartRate > 0 {
+			heartRateSum += point.HeartRate
+			heartRates++
+			if point.HeartRate > maxHeartRate {
+				maxHeartRate = point.HeartRate
+			}
+		}
+
+		if point.HasElevation {
+			if !hasReference || point.Elevation < reference {
+				reference = point.Elevation
+				hasReference = true
+			} else if point.Elevation-reference >= ELEVATION_THRESHOLD {
+				gain += point.Elevation - reference
+				reference = point.Elevation
+			}
+		}
+
+		if previous != nil {
+			step := stepDistance(previous, point)
+			distance += step
+
+			elapsed := point.Time.Sub(previous.Time)
+			if !previous.Time.IsZero() && elapsed > 0 && step/elapsed.Seconds() >= MOVING_SPEED {
+				moving += elapsed
+			}
+		}
+		previous = point
+	}
+
+	if previous != nil && previous.Distance > 0 {
+		distance = previous.Distance
+	}
+
+	if a.Distance <= 0 {
+		a.Distance = distance / 1000
+	}
+	if a.MovingTime <= 0 {
+		a.MovingTime = moving
+	}
+	if a.ElevationGain <= 0 {
+		a.ElevationGain = gain
+	}
+	if a.AvgHeartRate <= 0 && heartRates > 0 {
+		a.AvgHeartRate = int(math.Round(float64(heartRateSum) / float64(heartRates)))
+	}
+	if a.MaxHeartRate <= 0 {
+		a.MaxHeartRate = maxHeartRate
+	}
+}
+
+// stepDistance is the distance between two consecutive points in meters, from the
+// device's own distance when both have it.
+func stepDistance(from *Point, to *Point) float64 {
+	if from.Distance > 0 && to.Distance >= from.Distance {
+		return to.Distance - from.Distance
+	}
+	if from.HasPosition && to.HasPosition {
+		return Haversine(from.Lat, from.Lon, to.Lat, to.Lon)
+	}
+	return 0
+}
+
+// Haversine is the great-circle distance between two coordinates in meters.
+func Haversine(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EARTH_RADIUS * math.Asin(math.Sqrt(h))
+}
+
+func formatOf(name string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+}
@@ -0,0 +1,180 @@
+package activityfile
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 5, 1, 6, 30, 0, 0, time.UTC)
+
+func readTestFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return data
+}
+
+func near(got float64, want float64, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file          string
+		sport         string
+		distance      float64
+		movingTime    time.Duration
+		elevationGain float64
+		avgHeartRate  int
+		maxHeartRate  int
+		// track are the latitudes and distances from the start in meters of the track.
+		track     [][2]float64
+		trackTime []time.Duration
+	}{
+		{
+			// Distance and time from the track, the last 5 minutes are spent standing
+			file:          "run.gpx",
+			sport:         "running",
+			distance:      1.50,
+			movingTime:    9 * time.Minute,
+			elevationGain: 11,
+			avgHeartRate:  150,
+			maxHeartRate:  160,
+			track:         [][2]float64{{52.5200, 0}, {52.5245, 500}, {52.5290, 1001}, {52.5335, 1501}, {52.5335, 1501}},
+			trackTime:     []time.Duration{0, 3 * time.Minute, 6 * time.Minute, 9 * time.Minute, 14 * time.Minute},
+		},
+		{
+			// Distance and time from the laps, a point without position is left off the track
+			file:          "run.tcx",
+			sport:         "Running",
+			distance:      2.00,
+			movingTime:    10*time.Minute + 30*time.Second,
+			elevationGain: 8,
+			avgHeartRate:  155,
+			maxHeartRate:  172,
+			track:         [][2]float64{{52.5200, 0}, {52.5290, 1001}, {52.5380, 2001}},
+			trackTime:     []time.Duration{0, 5 * time.Minute, 10*time.Minute + 30*time.Second},
+		},
+		{
+			// Totals from the big endian session, two records with compressed timestamps
+			file:          "run.fit",
+			sport:         "running",
+			distance:      0.40,
+			movingTime:    2 * time.Minute,
+			elevationGain: 12,
+			avgHeartRate:  150,
+			maxHeartRate:  165,
+			track:         [][2]float64{{52.5200, 0}, {52.5209, 100}, {52.5218, 200}, {52.5227, 300}, {52.5236, 400}},
+			trackTime:     []time.Duration{0, 30 * time.Second, time.Minute, 90 * time.Second, 2 * time.Minute},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			activity, err := Parse(test.file, readTestFile(t, test.file))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			if activity.Format != formatOf(test.file) || activity.Sport != test.sport || !activity.Start.Equal(testStart) {
+				t.Errorf("got %s %q starting %s, want %s %q starting %s", activity.Format, activity.Sport, activity.Start,
+					formatOf(test.file), test.sport, testStart)
+			}
+			if !near(activity.Distance, test.distance, 0.005) || activity.MovingTime != test.movingTime {
+				t.Errorf("got %.3f km in %v, want %.2f km in %v", activity.Distance, activity.MovingTime, test.distance, test.movingTime)
+			}
+			if !near(activity.ElevationGain, test.elevationGain, 0.01) {
+				t.Errorf("elevation gain = %.1f, want %.1f", activity.ElevationGain, test.elevationGain)
+			}
+			if activity.AvgHeartRate != test.avgHeartRate || activity.MaxHeartRate != test.maxHeartRate {
+				t.Errorf("heart rate = %d/%d, want %d/%d", activity.AvgHeartRate, activity.MaxHeartRate, test.avgHeartRate, test.maxHeartRate)
+			}
+
+			track := activity.Track()
+			if len(track) != len(test.track) {
+				t.Fatalf("track has %d points, want %d", len(track), len(test.track))
+			}
+			for i, point := range track {
+				if !near(point.Lat, test.track[i][0], 0.00001) || !near(point.Lon, 13.405, 0.00001) || !near(point.Distance, test.track[i][1], 1) {
+					t.Errorf("point %d at %.5f,%.5f after %.0f m, want %.5f,13.40500 after %.0f m",
+						i, point.Lat, point.Lon, point.Distance, test.track[i][0], test.track[i][1])
+				}
+				if want := testStart.Add(test.trackTime[i]); !point.Time.Equal(want) {
+					t.Errorf("point %d at %s, want %s", i, point.Time, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkoutDetails(t *testing.T) {
+	activity, err := Parse("RUN.FIT", readTestFile(t, "run.fit"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	want := map[string]string{
+		"Distance":      "0.40",
+		"Pace":          "5'00\"/km",
+		"TotalTime":     "2:00",
+		"ElevationGain": "12",
+		"HeartRate":     "150",
+		"MaxHeartRate":  "165",
+	}
+	details := activity.WorkoutDetails()
+	for key, value := range want {
+		if details[key] != value {
+			t.Errorf("%s = %q, want %q", key, details[key], value)
+		}
+	}
+}
+
+func TestParseTruncatedFIT(t *testing.T) {
+	// A watch that crashed while writing the session: the totals come from the records
+	data := readTestFile(t, "run.fit")
+	activity, err := Parse("run.fit", data[:len(data)-12])
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !near(activity.Distance, 0.40, 0.005) || activity.MovingTime != 2*time.Minute || activity.MaxHeartRate != 160 {
+		t.Errorf("got %.3f km in %v, max heart rate %d, want 0.40 km in 2m0s with 160", activity.Distance, activity.MovingTime, activity.MaxHeartRate)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"run.kml", "<kml/>"},
+		{"run.fit", "not a fit file at all"},
+		{"run.gpx", "<gpx"},
+		{"run.gpx", `<gpx><trk><trkseg><trkpt lat="52.52" lon="13.405"/></trkseg></trk></gpx>`},
+		{"run.tcx", `<TrainingCenterDatabase><Activities><Activity Sport="Running"/></Activities></TrainingCenterDatabase>`},
+	}
+
+	for _, test := range tests {
+		if activity, err := Parse(test.name, []byte(test.data)); err == nil {
+			t.Errorf("Parse(%s, %q) = %+v, want error", test.name, test.data, activity)
+		}
+	}
+}
+
+func TestFormatClock(t *testing.T) {
+	tests := map[time.Duration]string{
+		27*time.Minute + 33*time.Second:           "27:33",
+		time.Hour + 2*time.Minute + 3*time.Second: "1:02:03",
+		59*time.Second + 600*time.Millisecond:     "1:00",
+	}
+	for duration, want := range tests {
+		if got := FormatClock(duration); got != want {
+			t.Errorf("FormatClock(%v) = %q, want %q", duration, got, want)
+		}
+	}
+}
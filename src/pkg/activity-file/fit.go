@@ -0,0 +1,280 @@
+package activityfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Only the parts of the FIT protocol needed for a run are read: the record messages
+// with the track and the session message with the totals of the device.
+const (
+	FIT_MESSAGE_SESSION = 18
+	FIT_MESSAGE_RECORD  = 20
+
+	FIT_FIELD_TIMESTAMP = 253
+
+	FIT_RECORD_LAT               = 0
+	FIT_RECORD_LON               = 1
+	FIT_RECORD_ALTITUDE          = 2
+	FIT_RECORD_HEART_RATE        = 3
+	FIT_RECORD_DISTANCE          = 5
+	FIT_RECORD_ENHANCED_ALTITUDE = 78
+
+	FIT_SESSION_START_TIME     = 2
+	FIT_SESSION_SPORT          = 5
+	FIT_SESSION_TIMER_TIME     = 8
+	FIT_SESSION_DISTANCE       = 9
+	FIT_SESSION_AVG_HEART_RATE = 16
+	FIT_SESSION_MAX_HEART_RATE = 17
+	FIT_SESSION_TOTAL_ASCENT   = 22
+)
+
+// FIT timestamps count seconds since 1989-12-31 00:00 UTC.
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// Sports of the session message, the rest are shown as their number.
+var fitSports = map[int64]string{0: "generic", 1: "running", 2: "cycling", 5: "swimming", 11: "walking", 17: "hiking"}
+
+type fitField struct {
+	number   byte
+	size     int
+	baseType byte
+}
+
+type fitDefinition struct {
+	global    uint16
+	byteOrder binary.ByteOrder
+	fields    []fitField
+	size      int
+}
+
+func parseFIT(data []byte) (*Activity, error) {
+	if len(data) < 12 || int(data[0]) < 12 || len(data) < int(data[0]) || string(data[8:12]) != ".FIT" {
+		return nil, fmt.Errorf("not a FIT file")
+	}
+
+	headerSize := int(data[0])
+	end := headerSize + int(binary.LittleEndian.Uint32(data[4:8]))
+	// Files cut off by a crashing watch are still worth reading
+	if end > len(data) {
+		end = len(data)
+	}
+
+	activity := &Activity{}
+	definitions := make(map[byte]*fitDefinition)
+	var lastTimestamp uint32
+
+	for pos := headerSize; pos < end; {
+		header := data[pos]
+		pos++
+
+		var local byte
+		compressed := header&0x80 != 0
+		if compressed {
+			// Compressed timestamp header: the low 5 bits of the timestamp, relative to the last one
+			local = (header >> 5) & 0x03
+			offset := uint32(header & 0x1F)
+			timestamp := lastTimestamp&^0x1F + offset
+			if offset < lastTimestamp&0x1F {
+				timestamp += 0x20
+			}
+			lastTimestamp = timestamp
+		} else {
+			local = header & 0x0F
+			if header&0x40 != 0 {
+				definition, size, err := parseFITDefinition(data[pos:end], header&0x20 != 0)
+				if err != nil {
+					return nil, err
+				}
+				definitions[local] = definition
+				pos += size
+				continue
+			}
+		}
+
+		definition := definitions[local]
+		if definition == nil {
+			return nil, fmt.Errorf("data message %d without definition at byte %d", local, pos)
+		}
+		if pos+definition.size > end {
+			break
+		}
+		values := definition.decode(data[pos : pos+definition.size])
+		pos += definition.size
+
+		if timestamp, ok := values[FIT_FIELD_TIMESTAMP]; ok {
+			lastTimestamp = uint32(timestamp)
+		} else if compressed {
+			values[FIT_FIELD_TIMESTAMP] = int64(lastTimestamp)
+		}
+
+		switch definition.global {
+		case FIT_MESSAGE_RECORD:
+			activity.Points = append(activity.Points, fitPoint(values))
+		case FIT_MESSAGE_SESSION:
+			fitSession(activity, values)
+		}
+	}
+
+	return activity, nil
+}
+
+func parseFITDefinition(data []byte, hasDeveloperFields bool) (*fitDefinition, int, error) {
+	if len(data) < 5 {
+		return nil, 0, fmt.Errorf("truncated definition message")
+	}
+
+	definition := &fitDefinition{byteOrder: binary.LittleEndian}
+	if data[1] == 1 {
+		definition.byteOrder = binary.BigEndian
+	}
+	definition.global = definition.byteOrder.Uint16(data[2:4])
+
+	count := int(data[4])
+	pos := 5
+	if len(data) < pos+count*3 {
+		return nil, 0, fmt.Errorf("truncated definition message")
+	}
+	for i := 0; i < count; i++ {
+		field := fitField{number: data[pos], size: int(data[pos+1]), baseType: data[pos+2]}
+		definition.fields = append(definition.fields, field)
+		definition.size += field.size
+		pos += 3
+	}
+
+	// Developer fields are skipped, only their size matters
+	if hasDeveloperFields {
+		if len(data) < pos+1 {
+			return nil, 0, fmt.Errorf("truncated definition message")
+		}
+		count = int(data[pos])
+		pos++
+		if len(data) < pos+count*3 {
+			return nil, 0, fmt.Errorf("truncated definition message")
+		}
+		for i := 0; i < count; i++ {
+			definition.size += int(data[pos+1])
+			pos += 3
+		}
+	}
+
+	return definition, pos, nil
+}
+
+// decode reads the integer fields of a data message, leaving out invalid values
+// and everything else, like strings and arrays.
+func (d *fitDefinition) decode(data []byte) map[byte]int64 {
+	values := make(map[byte]int64)
+	pos := 0
+	for _, field := range d.fields {
+		raw := data[pos : pos+field.size]
+		pos += field.size
+
+		if value, ok := fitInteger(raw, field.baseType, d.byteOrder); ok {
+			values[field.number] = value
+		}
+	}
+	return values
+}
+
+// fitInteger decodes a single integer of the given base type. Every type has its own
+// marker for "no value": all bits set, the largest signed value or zero.
+func fitInteger(raw []byte, baseType byte, byteOrder binary.ByteOrder) (int64, bool) {
+	var value uint64
+	switch len(raw) {
+	case 1:
+		value = uint64(raw[0])
+	case 2:
+		value = uint64(byteOrder.Uint16(raw))
+	case 4:
+		value = uint64(byteOrder.Uint32(raw))
+	case 8:
+		value = byteOrder.Uint64(raw)
+	default:
+		return 0, false
+	}
+	bits := uint(len(raw) * 8)
+	allSet := uint64(1)<<bits - 1
+	if bits == 64 {
+		allSet = math.MaxUint64
+	}
+
+	switch baseType & 0x1F {
+	case 0x00, 0x02, 0x04, 0x06, 0x0D, 0x0F: // enum, uint8, uint16, uint32, byte, uint64
+		if value == allSet {
+			return 0, false
+		}
+		return int64(value), true
+	case 0x01, 0x03, 0x05, 0x0E: // sint8, sint16, sint32, sint64
+		if value == allSet>>1 {
+			return 0, false
+		}
+		// Sign extend
+		shift := 64 - bits
+		return int64(value<<shift) >> shift, true
+	case 0x0A, 0x0B, 0x0C, 0x10: // uint8z, uint16z, uint32z, uint64z
+		if value == 0 {
+			return 0, false
+		}
+		return int64(value), true
+	}
+	return 0, false
+}
+
+func fitPoint(values map[byte]int64) Point {
+	point := Point{}
+	if timestamp, ok := values[FIT_FIELD_TIMESTAMP]; ok {
+		point.Time = fitTime(timestamp)
+	}
+
+	lat, hasLat := values[FIT_RECORD_LAT]
+	lon, hasLon := values[FIT_RECORD_LON]
+	if hasLat && hasLon {
+		point.Lat, point.Lon, point.HasPosition = semicircles(lat), semicircles(lon), true
+	}
+
+	if altitude, ok := values[FIT_RECORD_ENHANCED_ALTITUDE]; ok {
+		point.Elevation, point.HasElevation = float64(altitude)/5-500, true
+	} else if altitude, ok := values[FIT_RECORD_ALTITUDE]; ok {
+		point.Elevation, point.HasElevation = float64(altitude)/5-500, true
+	}
+
+	point.HeartRate = int(values[FIT_RECORD_HEART_RATE])
+	point.Distance = float64(values[FIT_RECORD_DISTANCE]) / 100
+	return point
+}
+
+// fitSession takes over the totals of the device. A file can hold several sessions,
+// e.g. for a triathlon, they add up.
+func fitSession(activity *Activity, values map[byte]int64) {
+	if start, ok := values[FIT_SESSION_START_TIME]; ok && activity.Start.IsZero() {
+		activity.Start = fitTime(start)
+	}
+	if sport, ok := values[FIT_SESSION_SPORT]; ok && activity.Sport == "" {
+		activity.Sport = fitSports[sport]
+		if activity.Sport == "" {
+			activity.Sport = fmt.Sprintf("sport %d", sport)
+		}
+	}
+
+	activity.MovingTime += time.Duration(values[FIT_SESSION_TIMER_TIME]) * time.Millisecond
+	activity.Distance += float64(values[FIT_SESSION_DISTANCE]) / 100 / 1000
+	activity.ElevationGain += float64(values[FIT_SESSION_TOTAL_ASCENT])
+
+	if heartRate := int(values[FIT_SESSION_AVG_HEART_RATE]); heartRate > 0 && activity.AvgHeartRate == 0 {
+		activity.AvgHeartRate = heartRate
+	}
+	if heartRate := int(values[FIT_SESSION_MAX_HEART_RATE]); heartRate > activity.MaxHeartRate {
+		activity.MaxHeartRate = heartRate
+	}
+}
+
+func fitTime(timestamp int64) time.Time {
+	return fitEpoch.Add(time.Duration(timestamp) * time.Second)
+}
+
+func semicircles(value int64) float64 {
+	return float64(value) * 180 / math.Pow(2, 31)
+}
@@ -0,0 +1,58 @@
+package activityfile
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+// GPX 1.1, with the heart rate of Garmin's TrackPointExtension that most apps write.
+// Namespaces are ignored, so ns3:TrackPointExtension matches as well.
+type gpxFile struct {
+	Tracks []struct {
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Lat       float64  `xml:"lat,attr"`
+				Lon       float64  `xml:"lon,attr"`
+				Elevation *float64 `xml:"ele"`
+				Time      string   `xml:"time"`
+				HeartRate int      `xml:"extensions>TrackPointExtension>hr"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+func parseGPX(data []byte) (*Activity, error) {
+	var file gpxFile
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&file); err != nil {
+		return nil, err
+	}
+
+	activity := &Activity{}
+	for _, track := range file.Tracks {
+		if activity.Sport == "" {
+			activity.Sport = track.Type
+		}
+		for _, segment := range track.Segments {
+			for _, trackPoint := range segment.Points {
+				point := Point{
+					Lat:         trackPoint.Lat,
+					Lon:         trackPoint.Lon,
+					HasPosition: true,
+					HeartRate:   trackPoint.HeartRate,
+				}
+				if trackPoint.Elevation != nil {
+					point.Elevation = *trackPoint.Elevation
+					point.HasElevation = true
+				}
+				if parsed, err := time.Parse(time.RFC3339, trackPoint.Time); err == nil {
+					point.Time = parsed
+				}
+				activity.Points = append(activity.Points, point)
+			}
+		}
+	}
+
+	return activity, nil
+}
@@ -0,0 +1,74 @@
+package activityfile
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+// Garmin Training Center XML. Laps carry the timer time and distance of the device.
+type tcxFile struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Laps  []struct {
+			StartTime        string  `xml:"StartTime,attr"`
+			TotalTimeSeconds float64 `xml:"TotalTimeSeconds"`
+			DistanceMeters   float64 `xml:"DistanceMeters"`
+			MaximumHeartRate int     `xml:"MaximumHeartRateBpm>Value"`
+			Points           []struct {
+				Time      string   `xml:"Time"`
+				Lat       *float64 `xml:"Position>LatitudeDegrees"`
+				Lon       *float64 `xml:"Position>LongitudeDegrees"`
+				Altitude  *float64 `xml:"AltitudeMeters"`
+				Distance  float64  `xml:"DistanceMeters"`
+				HeartRate int      `xml:"HeartRateBpm>Value"`
+			} `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+func parseTCX(data []byte) (*Activity, error) {
+	var file tcxFile
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&file); err != nil {
+		return nil, err
+	}
+
+	activity := &Activity{}
+	var lapTime, lapDistance float64
+	for _, tcxActivity := range file.Activities {
+		if activity.Sport == "" {
+			activity.Sport = tcxActivity.Sport
+		}
+
+		for _, lap := range tcxActivity.Laps {
+			if start, err := time.Parse(time.RFC3339, lap.StartTime); err == nil && activity.Start.IsZero() {
+				activity.Start = start
+			}
+			lapTime += lap.TotalTimeSeconds
+			lapDistance += lap.DistanceMeters
+			if lap.MaximumHeartRate > activity.MaxHeartRate {
+				activity.MaxHeartRate = lap.MaximumHeartRate
+			}
+
+			for _, trackPoint := range lap.Points {
+				point := Point{Distance: trackPoint.Distance, HeartRate: trackPoint.HeartRate}
+				if trackPoint.Lat != nil && trackPoint.Lon != nil {
+					point.Lat, point.Lon, point.HasPosition = *trackPoint.Lat, *trackPoint.Lon, true
+				}
+				if trackPoint.Altitude != nil {
+					point.Elevation, point.HasElevation = *trackPoint.Altitude, true
+				}
+				if parsed, err := time.Parse(time.RFC3339, trackPoint.Time); err == nil {
+					point.Time = parsed
+				}
+				activity.Points = append(activity.Points, point)
+			}
+		}
+	}
+
+	// Lap totals come from the device, which knows about pauses
+	activity.MovingTime = time.Duration(lapTime * float64(time.Second))
+	activity.Distance = lapDistance / 1000
+
+	return activity, nil
+}
@@ -0,0 +1,122 @@
+package chart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var (
+	BACKGROUND = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	FOREGROUND = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	GRID       = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+
+	// PALETTE is cycled through when a chart has more than one series.
+	PALETTE = []color.RGBA{
+		{0x1f, 0x77, 0xb4, 0xff},
+		{0xff, 0x7f, 0x0e, 0xff},
+		{0x2c, 0xa0, 0x2c, 0xff},
+		{0xd6, 0x27, 0x28, 0xff},
+		{0x94, 0x67, 0xbd, 0xff},
+		{0x8c, 0x56, 0x4b, 0xff},
+		{0xe3, 0x77, 0xc2, 0xff},
+		{0x7f, 0x7f, 0x7f, 0xff},
+	}
+)
+
+const (
+	LINE_HEIGHT = 13
+	CHAR_WIDTH  = 7
+)
+
+// canvas is a thin wrapper around an RGBA image with the few primitives charts need.
+type canvas struct {
+	img *image.RGBA
+}
+
+func newCanvas(width int, height int) *canvas {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{BACKGROUND}, image.Point{}, draw.Src)
+	return &canvas{img: img}
+}
+
+func (c *canvas) fillRect(x0, y0, x1, y1 int, col color.Color) {
+	draw.Draw(c.img, image.Rect(x0, y0, x1, y1), &image.Uniform{col}, image.Point{}, draw.Src)
+}
+
+// line draws a line with Bresenham's algorithm, widened by thickness pixels.
+func (c *canvas) line(x0, y0, x1, y1 int, thickness int, col color.Color) {
+	dx := abs(x1 - x0)
+	dy := -abs(y1 - y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	err := dx + dy
+	for {
+		c.dot(x0, y0, thickness, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func (c *canvas) dot(x, y int, size int, col color.Color) {
+	half := size / 2
+	c.fillRect(x-half, y-half, x-half+size, y-half+size, col)
+}
+
+// text draws s with its top-left corner at (x, y).
+func (c *canvas) text(x, y int, s string, col color.Color) {
+	drawer := &font.Drawer{
+		Dst:  c.img,
+		Src:  &image.Uniform{col},
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y+basicfont.Face7x13.Ascent),
+	}
+	drawer.DrawString(s)
+}
+
+// textCentered draws s horizontally centered on x, every rune is a glyph of the same width.
+func (c *canvas) textCentered(x, y int, s string, col color.Color) {
+	c.text(x-utf8.RuneCountInString(s)*CHAR_WIDTH/2, y, s, col)
+}
+
+func (c *canvas) textRight(x, y int, s string, col color.Color) {
+	c.text(x-utf8.RuneCountInString(s)*CHAR_WIDTH, y, s, col)
+}
+
+func (c *canvas) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
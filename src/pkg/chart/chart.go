@@ -0,0 +1,200 @@
+package chart
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+const (
+	WIDTH  = 800
+	HEIGHT = 480
+
+	MARGIN_TOP    = 40
+	MARGIN_BOTTOM = 50
+	MARGIN_LEFT   = 70
+	MARGIN_RIGHT  = 20
+
+	Y_TICKS = 5
+)
+
+// Series is one line (or set of bars) of a chart, one value per x label.
+type Series struct {
+	Name   string
+	Values []float64
+}
+
+type Chart struct {
+	Title   string
+	XLabels []string
+	Series  []Series
+	// YFormat renders the y-axis tick labels, defaults to "%.1f".
+	YFormat func(float64) string
+	// InvertY puts smaller values at the top, which reads naturally for pace.
+	InvertY bool
+}
+
+// plotArea maps chart values to pixel coordinates.
+type plotArea struct {
+	left, top, right, bottom int
+	min, max, step           float64
+	invert                   bool
+}
+
+func (p plotArea) y(value float64) int {
+	ratio := (value - p.min) / (p.max - p.min)
+	if p.invert {
+		ratio = 1 - ratio
+	}
+	return p.bottom - int(math.Round(ratio*float64(p.bottom-p.top)))
+}
+
+// x returns the center of the i-th of n slots.
+func (p plotArea) x(i int, n int) int {
+	slot := float64(p.right-p.left) / float64(n)
+	return p.left + int(slot*float64(i)+slot/2)
+}
+
+// RenderBar renders the first series as a bar chart starting from zero.
+func (ch Chart) RenderBar() ([]byte, error) {
+	if len(ch.Series) == 0 || len(ch.Series[0].Values) == 0 {
+		return nil, fmt.Errorf("no data to chart")
+	}
+
+	values := ch.Series[0].Values
+	c := newCanvas(WIDTH, HEIGHT)
+	area := ch.drawFrame(c, 0, maxOf(ch.Series))
+
+	slot := float64(area.right-area.left) / float64(len(values))
+	barWidth := int(slot * 0.7)
+	for i, value := range values {
+		x := area.x(i, len(values))
+		c.fillRect(x-barWidth/2, area.y(value), x+barWidth/2, area.bottom, PALETTE[0])
+		if value > 0 {
+			c.textCentered(x, area.y(value)-LINE_HEIGHT-2, fmt.Sprintf("%.1f", value), FOREGROUND)
+		}
+	}
+
+	return c.encode()
+}
+
+// RenderLine renders every series as a polyline, with a legend when there is more than one.
+func (ch Chart) RenderLine() ([]byte, error) {
+	if len(ch.Series) == 0 || len(ch.XLabels) == 0 {
+		return nil, fmt.Errorf("no data to chart")
+	}
+
+	c := newCanvas(WIDTH, HEIGHT)
+	area := ch.drawFrame(c, minOf(ch.Series), maxOf(ch.Series))
+
+	for i, series := range ch.Series {
+		col := PALETTE[i%len(PALETTE)]
+		for j := range series.Values {
+			x, y := area.x(j, len(ch.XLabels)), area.y(series.Values[j])
+			if j > 0 {
+				c.line(area.x(j-1, len(ch.XLabels)), area.y(series.Values[j-1]), x, y, 2, col)
+			}
+			c.dot(x, y, 5, col)
+		}
+	}
+
+	if len(ch.Series) > 1 {
+		for i, series := range ch.Series {
+			col := PALETTE[i%len(PALETTE)]
+			y := area.top + i*(LINE_HEIGHT+2)
+			c.fillRect(area.left+8, y+3, area.left+18, y+10, col)
+			c.text(area.left+22, y, series.Name, FOREGROUND)
+		}
+	}
+
+	return c.encode()
+}
+
+// drawFrame draws the title, grid, axes and labels and returns the area left for data.
+func (ch Chart) drawFrame(c *canvas, min float64, max float64) plotArea {
+	area := plotArea{
+		left:   MARGIN_LEFT,
+		top:    MARGIN_TOP,
+		right:  WIDTH - MARGIN_RIGHT,
+		bottom: HEIGHT - MARGIN_BOTTOM,
+		invert: ch.InvertY,
+	}
+	area.min, area.max, area.step = niceRange(min, max)
+
+	format := ch.YFormat
+	if format == nil {
+		format = func(v float64) string { return fmt.Sprintf("%.1f", v) }
+	}
+
+	c.textCentered(WIDTH/2, 12, ch.Title, FOREGROUND)
+
+	for value := area.min; value <= area.max+area.step/2; value += area.step {
+		y := area.y(value)
+		c.line(area.left, y, area.right, y, 1, GRID)
+		c.textRight(area.left-6, y-LINE_HEIGHT/2, format(value), FOREGROUND)
+	}
+
+	c.line(area.left, area.top, area.left, area.bottom, 1, FOREGROUND)
+	c.line(area.left, area.bottom, area.right, area.bottom, 1, FOREGROUND)
+
+	// Skip labels when they would overlap.
+	every := 1 + (utf8.RuneCountInString(ch.longestLabel())+2)*CHAR_WIDTH*len(ch.XLabels)/(area.right-area.left)
+	for i, label := range ch.XLabels {
+		if i%every != 0 {
+			continue
+		}
+		c.textCentered(area.x(i, len(ch.XLabels)), area.bottom+8, label, FOREGROUND)
+	}
+
+	return area
+}
+
+func (ch Chart) longestLabel() string {
+	longest := ""
+	for _, label := range ch.XLabels {
+		if utf8.RuneCountInString(label) > utf8.RuneCountInString(longest) {
+			longest = label
+		}
+	}
+	return longest
+}
+
+// niceRange widens [min, max] to multiples of a round step (1, 2, 2.5 or 5 times a
+// power of ten) so that there are about Y_TICKS readable tick labels.
+func niceRange(min float64, max float64) (float64, float64, float64) {
+	if max <= min {
+		max = min + 1
+	}
+
+	rawStep := (max - min) / Y_TICKS
+	magnitude := math.Pow(10, math.Floor(math.Log10(rawStep)))
+	step := magnitude
+	for _, multiplier := range []float64{1, 2, 2.5, 5, 10} {
+		step = multiplier * magnitude
+		if step >= rawStep {
+			break
+		}
+	}
+
+	return math.Floor(min/step) * step, math.Ceil(max/step) * step, step
+}
+
+func maxOf(series []Series) float64 {
+	max := math.Inf(-1)
+	for _, s := range series {
+		for _, v := range s.Values {
+			max = math.Max(max, v)
+		}
+	}
+	return max
+}
+
+func minOf(series []Series) float64 {
+	min := math.Inf(1)
+	for _, s := range series {
+		for _, v := range s.Values {
+			min = math.Min(min, v)
+		}
+	}
+	return min
+}
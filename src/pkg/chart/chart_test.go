@@ -0,0 +1,100 @@
+package chart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestNiceRange(t *testing.T) {
+	tests := []struct {
+		min, max                   float64
+		wantMin, wantMax, wantStep float64
+	}{
+		{0, 42.2, 0, 50, 10},
+		{0, 10, 0, 10, 2},
+		{0, 12, 0, 12.5, 2.5},
+		{3.3, 7.9, 3, 8, 1},
+		// Paces in seconds, 4:30 to 6:10
+		{270, 370, 260, 380, 20},
+		// A single value still has a range
+		{5, 5, 5, 6, 0.2},
+	}
+
+	for _, test := range tests {
+		min, max, step := niceRange(test.min, test.max)
+		if min != test.wantMin || max != test.wantMax || step != test.wantStep {
+			t.Errorf("niceRange(%v, %v) = %v, %v, %v, want %v, %v, %v",
+				test.min, test.max, min, max, step, test.wantMin, test.wantMax, test.wantStep)
+		}
+	}
+}
+
+func TestPlotArea(t *testing.T) {
+	area := plotArea{left: 0, top: 0, right: 100, bottom: 200, min: 0, max: 10}
+
+	// One slot per x label, values on the center of theirs
+	for i, want := range []int{12, 37, 62, 87} {
+		if got := area.x(i, 4); got != want {
+			t.Errorf("x(%d, 4) = %d, want %d", i, got, want)
+		}
+	}
+	for value, want := range map[float64]int{0: 200, 5: 100, 10: 0} {
+		if got := area.y(value); got != want {
+			t.Errorf("y(%v) = %d, want %d", value, got, want)
+		}
+	}
+
+	area.invert = true
+	if got := area.y(0); got != 0 {
+		t.Errorf("inverted y(0) = %d, want 0 at the top", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	ch := Chart{
+		Title:   "Wöchentliche Distanz (KM) - Alice",
+		XLabels: []string{"04-29", "05-06", "05-13"},
+		Series:  []Series{{Name: "Alice", Values: []float64{8, 10, 2}}, {Name: "Bob", Values: []float64{3, 0, 12}}},
+	}
+
+	for name, render := range map[string]func() ([]byte, error){"bar": ch.RenderBar, "line": ch.RenderLine} {
+		data, err := render()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: not a PNG: %v", name, err)
+		}
+		if bounds := img.Bounds(); bounds.Dx() != WIDTH || bounds.Dy() != HEIGHT {
+			t.Errorf("%s: %dx%d, want %dx%d", name, bounds.Dx(), bounds.Dy(), WIDTH, HEIGHT)
+		}
+		// The first series is drawn in the first color
+		if !hasColor(img, PALETTE[0]) {
+			t.Errorf("%s: no pixel of the first series", name)
+		}
+	}
+
+	if _, err := (Chart{Title: "empty"}).RenderBar(); err == nil {
+		t.Errorf("RenderBar() without series succeeded, want error")
+	}
+	if _, err := (Chart{Series: ch.Series}).RenderLine(); err == nil {
+		t.Errorf("RenderLine() without labels succeeded, want error")
+	}
+}
+
+func hasColor(img image.Image, want color.RGBA) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if uint8(r>>8) == want.R && uint8(g>>8) == want.G && uint8(b>>8) == want.B {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package chart
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+const (
+	ROUTE_WIDTH  = 800
+	ROUTE_HEIGHT = 600
+
+	// Thumbnails leave out the labels, they would not be readable anyway.
+	ROUTE_THUMB_WIDTH  = 240
+	ROUTE_THUMB_HEIGHT = 180
+
+	ROUTE_MARGIN = 30
+
+	// At most this many distance ticks are drawn, longer routes get ticks every 2, 5, 10... km.
+	ROUTE_MAX_TICKS = 20
+
+	// Length of a degree of latitude, or of longitude at the equator.
+	KM_PER_DEGREE = 2 * math.Pi * 6371 / 360
+)
+
+var (
+	ROUTE_LINE   = PALETTE[0]
+	ROUTE_START  = PALETTE[2]
+	ROUTE_FINISH = PALETTE[3]
+)
+
+// RoutePoint is a position of a track, with the distance since the start in meters.
+type RoutePoint struct {
+	Lat      float64
+	Lon      float64
+	Distance float64
+}
+
+// projection maps coordinates to pixels. Routes are small enough for an equirectangular
+// projection, with longitudes shrunk by the cosine of the latitude to keep the shape.
+type projection struct {
+	minX, maxY       float64
+	cosLat           float64
+	scale            float64
+	offsetX, offsetY int
+	// Size of the bounding box of the route, in projected units.
+	boxW, boxH float64
+}
+
+func newProjection(points []RoutePoint, width int, height int, margin int) projection {
+	minLat, maxLat := points[0].Lat, points[0].Lat
+	minLon, maxLon := points[0].Lon, points[0].Lon
+	for _, point := range points {
+		minLat, maxLat = math.Min(minLat, point.Lat), math.Max(maxLat, point.Lat)
+		minLon, maxLon = math.Min(minLon, point.Lon), math.Max(maxLon, point.Lon)
+	}
+
+	p := projection{cosLat: math.Cos((minLat + maxLat) / 2 * math.Pi / 180)}
+	p.minX, p.maxY = minLon*p.cosLat, maxLat
+	p.boxW, p.boxH = (maxLon-minLon)*p.cosLat, maxLat-minLat
+
+	plotW, plotH := float64(width-2*margin), float64(height-2*margin)
+	p.scale = math.Min(plotW/math.Max(p.boxW, 1e-9), plotH/math.Max(p.boxH, 1e-9))
+
+	// Center the route, the shorter side gets the extra space
+	p.offsetX = margin + int((plotW-p.boxW*p.scale)/2)
+	p.offsetY = margin + int((plotH-p.boxH*p.scale)/2)
+	return p
+}
+
+func (p projection) point(lat float64, lon float64) (int, int) {
+	x := p.offsetX + int(math.Round((lon*p.cosLat-p.minX)*p.scale))
+	y := p.offsetY + int(math.Round((p.maxY-lat)*p.scale))
+	return x, y
+}
+
+// RenderRoute draws a track as a PNG map: the bounding box with its size, the route as a
+// polyline, a tick every km and markers at the start and the finish. No map tiles are
+// used, so the image is drawn on a plain background.
+func RenderRoute(points []RoutePoint, width int, height int) ([]byte, error) {
+	if len(points) < 2 {
+		return nil, fmt.Errorf("not enough points for a route")
+	}
+
+	labels := width >= ROUTE_WIDTH/2
+	margin := ROUTE_MARGIN
+	thickness, tick, marker := 3, 4, 7
+	if !labels {
+		margin = ROUTE_MARGIN / 3
+		thickness, tick, marker = 2, 1, 5
+	}
+
+	c := newCanvas(width, height)
+	p := newProjection(points, width, height, margin)
+
+	// Bounding box, labeled with its size in km
+	x0, y0 := p.offsetX, p.offsetY
+	x1, y1 := x0+int(math.Round(p.boxW*p.scale)), y0+int(math.Round(p.boxH*p.scale))
+	c.rect(x0-marker, y0-marker, x1+marker, y1+marker, GRID)
+	if labels {
+		size := fmt.Sprintf("%.2f x %.2f km", p.boxW*KM_PER_DEGREE, p.boxH*KM_PER_DEGREE)
+		c.textCentered((x0+x1)/2, y1+marker+2, size, FOREGROUND)
+	}
+
+	previousX, previousY := p.point(points[0].Lat, points[0].Lon)
+	for _, point := range points[1:] {
+		x, y := p.point(point.Lat, point.Lon)
+		c.line(previousX, previousY, x, y, thickness, ROUTE_LINE)
+		previousX, previousY = x, y
+	}
+
+	step := tickStep(points[len(points)-1].Distance / 1000)
+	next := step
+	for i := 1; i < len(points); i++ {
+		from, to := points[i-1], points[i]
+		for to.Distance >= next*1000 && to.Distance > from.Distance {
+			// Interpolate where the tick falls between the two points
+			ratio := (next*1000 - from.Distance) / (to.Distance - from.Distance)
+			x, y := p.point(from.Lat+(to.Lat-from.Lat)*ratio, from.Lon+(to.Lon-from.Lon)*ratio)
+			c.circle(x, y, tick, FOREGROUND)
+			if labels {
+				c.text(x+tick+2, y-LINE_HEIGHT, fmt.Sprintf("%g", next), FOREGROUND)
+			}
+			next += step
+		}
+	}
+
+	// The finish is a square and the start a circle on top, so both show on a loop
+	finishX, finishY := p.point(points[len(points)-1].Lat, points[len(points)-1].Lon)
+	c.dot(finishX, finishY, 2*marker+3, BACKGROUND)
+	c.dot(finishX, finishY, 2*marker-1, ROUTE_FINISH)
+	startX, startY := p.point(points[0].Lat, points[0].Lon)
+	c.circle(startX, startY, marker-2, BACKGROUND)
+	c.circle(startX, startY, marker-3, ROUTE_START)
+
+	return c.encode()
+}
+
+// tickStep picks the km between two ticks, so a marathon is not covered in them.
+func tickStep(distance float64) float64 {
+	for _, step := range []float64{1, 2, 5, 10, 20, 50} {
+		if distance/step <= ROUTE_MAX_TICKS {
+			return step
+		}
+	}
+	return 100
+}
+
+// rect draws the outline of a rectangle.
+func (c *canvas) rect(x0, y0, x1, y1 int, col color.Color) {
+	c.fillRect(x0, y0, x1+1, y0+1, col)
+	c.fillRect(x0, y1, x1+1, y1+1, col)
+	c.fillRect(x0, y0, x0+1, y1+1, col)
+	c.fillRect(x1, y0, x1+1, y1+1, col)
+}
+
+// circle draws a filled circle around (x, y).
+func (c *canvas) circle(x, y int, radius int, col color.Color) {
+	for dy := -radius; dy <= radius; dy++ {
+		half := int(math.Sqrt(float64(radius*radius - dy*dy)))
+		c.fillRect(x-half, y+dy, x+half+1, y+dy+1, col)
+	}
+}
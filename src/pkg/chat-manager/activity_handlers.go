@@ -0,0 +1,89 @@
+package chatmanager
+
+import (
+	"run-tracker-telebot/src/log"
+	activityfile "run-tracker-telebot/src/pkg/activity-file"
+	"run-tracker-telebot/src/pkg/chart"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"run-tracker-telebot/src/pkg/stats"
+	"strconv"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// activityDocument filters documents that are GPX, TCX or FIT activity files.
+func activityDocument(msg *gotgbot.Message) bool {
+	return msg.Document != nil && activityfile.IsActivityFile(msg.Document.FileName)
+}
+
+// handleActivityFile logs a workout from an activity file shared from a watch app. The
+// file holds what the watch measured, so it is logged with more confidence than a
+// screenshot, on the day the activity started. Tracks with GPS get a route map.
+func (cm *ChatManager) handleActivityFile(b *gotgbot.Bot, ctx *ext.Context) error {
+	document := ctx.EffectiveMessage.Document
+	if document.FileSize > MAX_DOWNLOAD_SIZE {
+		return cm.replyError(b, ctx, "activity.too_large")
+	}
+
+	data, err := cm.fetchFile(document.FileId)
+	if err != nil {
+		log.Warn().Msgf("Error downloading activity file: %v", err)
+		return cm.sendError(b, ctx, "activity.error")
+	}
+
+	activity, err := activityfile.Parse(document.FileName, data)
+	if err != nil {
+		log.Warn().Msgf("Error reading activity file %s: %v", document.FileName, err)
+		return cm.replyError(b, ctx, "activity.invalid", document.FileName)
+	}
+
+	workoutDetails := activity.WorkoutDetails()
+	workoutDetails["Source"] = activity.Format
+	workoutDetails["Confidence"] = strconv.FormatFloat(databasemanager.CONFIDENCE_ACTIVITY_FILE, 'f', 2, 64)
+	if sport, ok := stats.ParseActivityType(activity.Sport); ok {
+		workoutDetails["Type"] = sport
+	}
+	if sport, ok := captionActivity(ctx); ok {
+		workoutDetails["Type"] = sport
+	}
+
+	date := cm.now(ctx).Format(stats.DATE_LAYOUT)
+	if !activity.Start.IsZero() {
+		date = activity.Start.In(cm.location(ctx)).Format(stats.DATE_LAYOUT)
+	}
+
+	// Treadmill runs and files without GPS are logged without a map
+	route, err := renderRoute(activity.Track())
+	if err != nil {
+		log.Debug().Msgf("No route map for %s: %v", document.FileName, err)
+		return cm.logWorkout(b, ctx, date, workoutDetails, nil)
+	}
+
+	return cm.logWorkout(b, ctx, date, workoutDetails, route)
+}
+
+// routeMaps are the route of a workout in full size for the reply, and as the
+// thumbnail kept for the history once the workout is saved.
+type routeMaps struct {
+	Map       []byte
+	Thumbnail []byte
+}
+
+// renderRoute draws both route maps of a track.
+func renderRoute(track []activityfile.Point) (*routeMaps, error) {
+	points := make([]chart.RoutePoint, len(track))
+	for i, point := range track {
+		points[i] = chart.RoutePoint{Lat: point.Lat, Lon: point.Lon, Distance: point.Distance}
+	}
+
+	routeMap, err := chart.RenderRoute(points, chart.ROUTE_WIDTH, chart.ROUTE_HEIGHT)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := chart.RenderRoute(points, chart.ROUTE_THUMB_WIDTH, chart.ROUTE_THUMB_HEIGHT)
+	if err != nil {
+		return nil, err
+	}
+	return &routeMaps{Map: routeMap, Thumbnail: thumbnail}, nil
+}
@@ -0,0 +1,25 @@
+package chatmanager
+
+import (
+	"run-tracker-telebot/src/log"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// isAdmin tells if the effective user administers the effective chat. In a private
+// chat the user is the only member, so always an admin.
+func (cm *ChatManager) isAdmin(b *gotgbot.Bot, ctx *ext.Context) bool {
+	if ctx.EffectiveChat.Type == gotgbot.ChatTypePrivate {
+		return true
+	}
+
+	member, err := cm.Messenger.GetChatMember(ctx.EffectiveChat.Id, ctx.EffectiveUser.Id)
+	if err != nil {
+		log.Warn().Msgf("Error getting chat member %d in chat %d: %v", ctx.EffectiveUser.Id, ctx.EffectiveChat.Id, err)
+		return false
+	}
+
+	status := member.GetStatus()
+	return status == "creator" || status == "administrator"
+}
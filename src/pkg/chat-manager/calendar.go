@@ -0,0 +1,175 @@
+package chatmanager
+
+import (
+	"fmt"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"run-tracker-telebot/src/pkg/stats"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
+)
+
+// The calendar keeps its state in the callback data:
+//
+//	cal:m:<YYYY-MM>:<start>     show another month
+//	cal:d:<YYYY-MM-DD>:<start>  pick a day, the first pick becomes start
+//	cal:-                       buttons that do nothing, like the weekday names
+const (
+	CALENDAR_CALLBACK = "cal"
+	CALENDAR_MONTH    = "m"
+	CALENDAR_DAY      = "d"
+	CALENDAR_NOOP     = "-"
+
+	CALENDAR_MONTH_LAYOUT = "2006-01"
+)
+
+// calendarKeyboard renders month as a grid of days, weeks starting on the group's
+// week start. The picked start date, if any, is marked.
+func (cm *ChatManager) calendarKeyboard(ctx *ext.Context, month time.Time, start string) gotgbot.InlineKeyboardMarkup {
+	locale := cm.locale(ctx)
+	month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	noop := CALENDAR_CALLBACK + ":" + CALENDAR_NOOP
+
+	navigate := func(month time.Time) string {
+		return fmt.Sprintf("%s:%s:%s:%s", CALENDAR_CALLBACK, CALENDAR_MONTH, month.Format(CALENDAR_MONTH_LAYOUT), start)
+	}
+
+	keyboard := [][]gotgbot.InlineKeyboardButton{{
+		{Text: "«", CallbackData: navigate(month.AddDate(0, -1, 0))},
+		{Text: cm.Localizer.FormatMonth(locale, month), CallbackData: noop},
+		{Text: "»", CallbackData: navigate(month.AddDate(0, 1, 0))},
+	}}
+
+	weekStart := cm.weekStart(ctx)
+	var weekdays []gotgbot.InlineKeyboardButton
+	for i := 0; i < 7; i++ {
+		weekdays = append(weekdays, gotgbot.InlineKeyboardButton{
+			Text:         cm.weekdayName(ctx, (weekStart+time.Weekday(i))%7),
+			CallbackData: noop,
+		})
+	}
+	keyboard = append(keyboard, weekdays)
+
+	next := month.AddDate(0, 1, 0)
+	for week := stats.WeekOf(month, weekStart).Start; week.Before(next); week = week.AddDate(0, 0, 7) {
+		var days []gotgbot.InlineKeyboardButton
+		for day := week; day.Before(week.AddDate(0, 0, 7)); day = day.AddDate(0, 0, 1) {
+			if day.Month() != month.Month() {
+				days = append(days, gotgbot.InlineKeyboardButton{Text: " ", CallbackData: noop})
+				continue
+			}
+
+			date := day.Format(stats.DATE_LAYOUT)
+			text := strconv.Itoa(day.Day())
+			if date == start {
+				text = "[" + text + "]"
+			}
+			days = append(days, gotgbot.InlineKeyboardButton{
+				Text:         text,
+				CallbackData: fmt.Sprintf("%s:%s:%s:%s", CALENDAR_CALLBACK, CALENDAR_DAY, date, start),
+			})
+		}
+		keyboard = append(keyboard, days)
+	}
+
+	return gotgbot.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// replyCalendar asks for the first day of a custom range.
+func (cm *ChatManager) replyCalendar(b *gotgbot.Bot, ctx *ext.Context) error {
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_TEXT, cm.translate(ctx, "distance.ask_week_range"), cm.calendarKeyboard(ctx, cm.now(ctx), ""))
+}
+
+// handleCalendar handles the calendar buttons while in the WEEKRANGE state. The first
+// picked day becomes the start of the range, the second one ends it and the message
+// is replaced by the totals of the range.
+func (cm *ChatManager) handleCalendar(b *gotgbot.Bot, ctx *ext.Context) error {
+	query := ctx.CallbackQuery
+	messageID := query.Message.GetMessageId()
+
+	parts := strings.SplitN(query.Data, ":", 4)
+	if len(parts) != 4 {
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		if err != nil {
+			return err
+		}
+		return handlers.NextConversationState(WEEKRANGE)
+	}
+	action, value, start := parts[1], parts[2], parts[3]
+
+	switch action {
+	case CALENDAR_MONTH:
+		month, err := time.Parse(CALENDAR_MONTH_LAYOUT, value)
+		if err != nil {
+			log.Warn().Msgf("Invalid calendar callback data: %s", query.Data)
+			break
+		}
+		err = cm.Messenger.EditMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+			ChatId:      ctx.EffectiveChat.Id,
+			MessageId:   messageID,
+			ReplyMarkup: cm.calendarKeyboard(ctx, month, start),
+		})
+		if err != nil {
+			log.Warn().Msgf("Error editing calendar in telegram: %v", err)
+		}
+
+	case CALENDAR_DAY:
+		day, err := time.Parse(stats.DATE_LAYOUT, value)
+		if err != nil {
+			log.Warn().Msgf("Invalid calendar callback data: %s", query.Data)
+			break
+		}
+
+		if start == "" {
+			text := cm.translate(ctx, "distance.ask_end_date", cm.Localizer.FormatDate(cm.locale(ctx), day))
+			cm.edit(b, ctx, messageID, messagerenderer.TEMPLATE_TEXT, text, cm.calendarKeyboard(ctx, day, value))
+			break
+		}
+
+		first, err := time.Parse(stats.DATE_LAYOUT, start)
+		if err != nil {
+			log.Warn().Msgf("Invalid calendar callback data: %s", query.Data)
+			break
+		}
+		if day.Before(first) {
+			first, day = day, first
+		}
+
+		totals, err := cm.periodTotals(ctx, stats.Period{Name: stats.PERIOD_RANGE, Start: first, End: day.AddDate(0, 0, 1)}, databasemanager.ACTIVITY_RUN)
+		if err != nil {
+			log.Warn().Msgf("Error getting total distance for user: %v", err)
+			_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "distance.error")})
+			if err != nil {
+				return err
+			}
+			return handlers.EndConversation()
+		}
+
+		cm.edit(b, ctx, messageID, messagerenderer.TEMPLATE_TOTALS, totals, gotgbot.InlineKeyboardMarkup{InlineKeyboard: [][]gotgbot.InlineKeyboardButton{}})
+
+		_, err = cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		if err != nil {
+			return err
+		}
+		return handlers.EndConversation()
+	}
+
+	_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+	if err != nil {
+		return err
+	}
+	return handlers.NextConversationState(WEEKRANGE)
+}
+
+// handleExpiredCalendar answers calendar buttons once the conversation is over, e.g.
+// after /cancel or a restart of the bot.
+func (cm *ChatManager) handleExpiredCalendar(b *gotgbot.Bot, ctx *ext.Context) error {
+	_, err := cm.Messenger.AnswerCallbackQuery(ctx.CallbackQuery.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "distance.calendar_expired")})
+	return err
+}
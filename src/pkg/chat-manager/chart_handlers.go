@@ -0,0 +1,181 @@
+package chatmanager
+
+import (
+	"bytes"
+	"fmt"
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/chart"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"run-tracker-telebot/src/pkg/stats"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const (
+	CHART_WEEK  = "week"
+	CHART_MONTH = "month"
+	CHART_PACE  = "pace"
+
+	CHART_WEEKS     = 12
+	CHART_PACE_RUNS = 20
+)
+
+func (cm *ChatManager) handleChart(b *gotgbot.Bot, ctx *ext.Context) error {
+	kind := strings.ToLower(commandArgs(ctx.EffectiveMessage.Text))
+	if kind == "" {
+		kind = CHART_WEEK
+	}
+
+	locale := cm.locale(ctx)
+	var image []byte
+	var err error
+	switch kind {
+	case CHART_WEEK:
+		image, err = cm.weeklyDistanceChart(locale, ctx.EffectiveChat.Id, ctx.EffectiveUser.Id, cm.now(ctx), cm.weekStart(ctx))
+	case CHART_MONTH:
+		image, err = cm.groupMonthChart(locale, ctx.EffectiveChat.Id, cm.now(ctx))
+	case CHART_PACE:
+		image, err = cm.paceTrendChart(locale, ctx.EffectiveChat.Id, ctx.EffectiveUser.Id)
+	default:
+		return cm.replyText(b, ctx, "chart.usage")
+	}
+
+	if err != nil {
+		log.Warn().Msgf("Error rendering %s chart: %v", kind, err)
+		return cm.replyError(b, ctx, "chart.empty")
+	}
+
+	_, err = cm.Messenger.SendPhoto(ctx.EffectiveChat.Id, gotgbot.NamedFile{
+		File:     bytes.NewReader(image),
+		FileName: kind + ".png",
+	}, &gotgbot.SendPhotoOpts{
+		ReplyParameters: &gotgbot.ReplyParameters{MessageId: ctx.EffectiveMessage.MessageId},
+	})
+	if err != nil {
+		log.Warn().Msgf("Error sending chart to user in telegram: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// userRuns are the runs of the user, the charts leave out other types of activity.
+func (cm *ChatManager) userRuns(chatID int64, userID int64) ([]stats.Run, error) {
+	userWorkouts, err := cm.DatabaseManager.GetUserWorkouts(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := stats.FromWorkouts(databasemanager.WorkoutsOfType(userWorkouts, databasemanager.ACTIVITY_RUN))
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("no workouts found for user: %v", userID)
+	}
+
+	return runs, nil
+}
+
+func (cm *ChatManager) weeklyDistanceChart(locale string, chatID int64, userID int64, now time.Time, weekStart time.Weekday) ([]byte, error) {
+	runs, err := cm.userRuns(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := cm.DatabaseManager.GetUsernameFromId(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	weeks, totals := stats.WeeklyTotals(runs, CHART_WEEKS, now, weekStart)
+	labels := make([]string, len(weeks))
+	for i, week := range weeks {
+		labels[i] = week.Format("01-02")
+	}
+
+	return chart.Chart{
+		Title:   cm.Localizer.T(locale, "chart.title_week", username),
+		XLabels: labels,
+		Series:  []chart.Series{{Name: username, Values: totals}},
+	}.RenderBar()
+}
+
+func (cm *ChatManager) groupMonthChart(locale string, chatID int64, now time.Time) ([]byte, error) {
+	groupWorkouts, err := cm.DatabaseManager.GetAllWorkouts(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	period, err := stats.ParsePeriod(stats.PERIOD_MONTH, now, stats.DEFAULT_WEEK_START)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels []string
+	var series []chart.Series
+	for userID, workouts := range groupWorkouts {
+		username, err := cm.DatabaseManager.GetUsernameFromId(userID)
+		if err != nil {
+			log.Warn().Msgf("Error getting username for user %d: %v", userID, err)
+			continue
+		}
+
+		days, totals := stats.CumulativeByDay(stats.FromWorkouts(databasemanager.WorkoutsOfType(databasemanager.CountedWorkouts(workouts), databasemanager.ACTIVITY_RUN)), period, now)
+		if len(totals) == 0 || totals[len(totals)-1] == 0 {
+			continue
+		}
+
+		if labels == nil {
+			for _, day := range days {
+				labels = append(labels, day.Format("02"))
+			}
+		}
+		series = append(series, chart.Series{Name: username, Values: totals})
+	}
+
+	// Keep the legend stable, biggest total first.
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Values[len(series[i].Values)-1] > series[j].Values[len(series[j].Values)-1]
+	})
+
+	return chart.Chart{
+		Title:   cm.Localizer.T(locale, "chart.title_month", cm.MessageRenderer.PeriodLabel(locale, period)),
+		XLabels: labels,
+		Series:  series,
+	}.RenderLine()
+}
+
+func (cm *ChatManager) paceTrendChart(locale string, chatID int64, userID int64) ([]byte, error) {
+	runs, err := cm.userRuns(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := cm.DatabaseManager.GetUsernameFromId(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(runs) > CHART_PACE_RUNS {
+		runs = runs[len(runs)-CHART_PACE_RUNS:]
+	}
+
+	labels := make([]string, len(runs))
+	paces := make([]float64, len(runs))
+	for i, run := range runs {
+		labels[i] = run.Date.Format("01-02")
+		paces[i] = run.Pace.Seconds()
+	}
+
+	return chart.Chart{
+		Title:   cm.Localizer.T(locale, "chart.title_pace", username),
+		XLabels: labels,
+		Series:  []chart.Series{{Name: username, Values: paces}},
+		YFormat: func(seconds float64) string {
+			return fmt.Sprintf("%d:%02d", int(seconds)/60, int(seconds)%60)
+		},
+		InvertY: true,
+	}.RenderLine()
+}
@@ -1,21 +1,27 @@
 package chatmanager
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/config"
 	databasemanager "run-tracker-telebot/src/pkg/database-manager"
 	imageprocessor "run-tracker-telebot/src/pkg/image-processor"
+	"run-tracker-telebot/src/pkg/localizer"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"run-tracker-telebot/src/pkg/stats"
+	"run-tracker-telebot/src/pkg/validator"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
-	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/conversation"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/filters/callbackquery"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/filters/message"
 )
 
@@ -34,21 +40,40 @@ type Chat struct {
 }
 
 type ChatManager struct {
-	Bot             *gotgbot.Bot
+	Bot *gotgbot.Bot
+	// Messenger is how handlers talk to Telegram, through Bot outside of tests.
+	Messenger       Messenger
 	DatabaseManager *databasemanager.DatabaseManager
 	ImageProcessor  *imageprocessor.ImageProcessor
+	MessageRenderer *messagerenderer.MessageRenderer
+	Localizer       *localizer.Localizer
 	Token           string
 	AuthorizedUsers map[int]bool
+	// Password is what new users answer /start with to be authorized.
+	Password string
+	// DefaultTimezone is used for users and groups that did not pick one.
+	DefaultTimezone string
+	// pending are screenshots waiting for the user to pick the right values,
+	// duplicates are workouts waiting for the user to confirm they are no duplicates.
+	pending    *pendingWorkouts
+	duplicates *pendingWorkouts
+	// Mode is how updates reach the bot, MODE_POLLING or MODE_WEBHOOK.
+	Mode    string
+	Webhook WebhookConfig
+	// ListenAddr is where the health checks, the metrics and the webhook are served.
+	ListenAddr    string
+	updater       *ext.Updater
+	server        *http.Server
+	mux           *http.ServeMux
+	webhookActive bool
+	// commands are the names of the registered commands, the labels of their metrics.
+	commands map[string]bool
 }
 
-const TELEGRAM_FILE_URL = "https://api.telegram.org/file/bot"
-
-func NewChatManager(databaseManager *databasemanager.DatabaseManager, imageProcessor *imageprocessor.ImageProcessor) *ChatManager {
-	token, exists := os.LookupEnv("TELEGRAM_BOT_TOKEN")
-	if !exists {
-		log.Warn().Msgf("TELEGRAM_BOT_TOKEN not found in environment variables.")
-	}
-
+// NewChatManager sets up the bot with the validated config, checking the token with
+// the Bot API at cfg.Telegram.APIURL.
+func NewChatManager(cfg *config.Config, databaseManager *databasemanager.DatabaseManager, imageProcessor *imageprocessor.ImageProcessor) (*ChatManager, error) {
+	token := cfg.Telegram.Token
 	log.Debug().Msgf("Token: %s", token)
 
 	bot, err := gotgbot.NewBot(token, &gotgbot.BotOpts{
@@ -57,20 +82,42 @@ func NewChatManager(databaseManager *databasemanager.DatabaseManager, imageProce
 			UseTestEnvironment: false,
 			DefaultRequestOpts: &gotgbot.RequestOpts{
 				Timeout: gotgbot.DefaultTimeout,
-				APIURL:  gotgbot.DefaultAPIURL,
+				APIURL:  cfg.Telegram.APIURL,
 			},
 		},
 	})
 
 	if err != nil {
-		panic("failed to create new bot: " + err.Error())
+		return nil, fmt.Errorf("failed to create new bot: %w", err)
 	}
 
+	return newChatManager(cfg, bot, NewBotMessenger(bot), databaseManager, imageProcessor), nil
+}
+
+// newChatManager sets up the bot with the messenger the handlers talk to Telegram
+// through, tests pass a fake one.
+func newChatManager(cfg *config.Config, bot *gotgbot.Bot, messenger Messenger, databaseManager *databasemanager.DatabaseManager, imageProcessor *imageprocessor.ImageProcessor) *ChatManager {
+	loc := localizer.NewLocalizer()
+
 	return &ChatManager{
 		Bot:             bot,
+		Messenger:       messenger,
 		DatabaseManager: databaseManager,
 		ImageProcessor:  imageProcessor,
-		Token:           token,
+		MessageRenderer: messagerenderer.NewMessageRenderer(loc),
+		Localizer:       loc,
+		Token:           cfg.Telegram.Token,
+		pending:         newPendingWorkouts(),
+		duplicates:      newPendingWorkouts(),
+		Mode:            strings.ToLower(cfg.Telegram.UpdateMode),
+		Webhook: WebhookConfig{
+			URL:         cfg.Telegram.WebhookURL,
+			Path:        cfg.Telegram.WebhookPath,
+			SecretToken: cfg.Telegram.WebhookSecret,
+		},
+		ListenAddr:      cfg.Telegram.ListenAddr,
+		Password:        cfg.Auth.Password,
+		DefaultTimezone: cfg.Defaults.Timezone,
 	}
 }
 
@@ -84,108 +131,186 @@ const (
 	AUTH       = "auth"
 )
 
-const HELP_MANUAL = "Commands:\n" +
-	"/start - Start the bot\n" +
-	"/historyUser - Get your workout history\n" +
-	"/historyAll - Get all workout history for the group\n" +
-	"/getdistance - Get total distance for a specified date range (month or week)\n" +
-	"/delete - Delete a workout entry\n" +
-	"/cancel - Cancel the current operation\n" +
-	"/help - Show this help message\n" +
-	"Send a workout image to log the details"
+// Start registers the handlers, starts the HTTP server of the health checks and starts
+// receiving updates, through a webhook in MODE_WEBHOOK and by long polling otherwise
+// or when the webhook cannot be set up.
+// It returns once updates are coming in, Stop stops them.
+func (cm *ChatManager) Start() error {
+	log.Debug().Msgf("Loading authorized users...")
+	cm.DatabaseManager.LoadUserData()
+
+	updater := ext.NewUpdater(cm.newDispatcher(), nil)
+	cm.updater = updater
+
+	if err := cm.startServer(); err != nil {
+		log.Warn().Msgf("Error starting HTTP server, no health checks and metrics: %v", err)
+	}
 
-func (cm *ChatManager) Start() {
+	if cm.Mode == MODE_WEBHOOK {
+		err := cm.startWebhook(updater)
+		if err == nil {
+			log.Printf("%s has been started with a webhook...\n", cm.Bot.User.Username)
+			return nil
+		}
+		log.Warn().Msgf("Error starting webhook, falling back to polling: %v", err)
+	} else if cm.Mode != MODE_POLLING {
+		log.Warn().Msgf("Unknown UPDATE_MODE %q, using %s", cm.Mode, MODE_POLLING)
+	}
 
-	// Create updater and dispatcher.
+	err := updater.StartPolling(cm.Bot, &ext.PollingOpts{
+		DropPendingUpdates: true,
+		GetUpdatesOpts: &gotgbot.GetUpdatesOpts{
+			Timeout: 9,
+			RequestOpts: &gotgbot.RequestOpts{
+				Timeout: time.Second * 10,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start polling: %w", err)
+	}
+	log.Printf("%s has been started...\n", cm.Bot.User.Username)
+	return nil
+}
+
+// newDispatcher registers the handlers, every update goes through them.
+func (cm *ChatManager) newDispatcher() *ext.Dispatcher {
 	dispatcher := ext.NewDispatcher(&ext.DispatcherOpts{
 		// If an error is returned by a handler, log it and continue going.
 		Error: func(b *gotgbot.Bot, ctx *ext.Context, err error) ext.DispatcherAction {
-			log.Warn().Msgf("an error occurred while handling update:", err.Error())
+			log.Warn().Msgf("an error occurred while handling update: %v", err.Error())
+			handlerErrors.Inc(cm.updateLabel(ctx))
 			return ext.DispatcherActionNoop
 		},
 		MaxRoutines: ext.DefaultMaxRoutines,
 	})
 
-	log.Debug().Msgf("Loading authorized users...")
-	cm.DatabaseManager.LoadUserData()
-
-	updater := ext.NewUpdater(dispatcher, nil)
+	cm.commands = make(map[string]bool)
+	dispatcher.AddHandlerToGroup(updateCounter{cm: cm}, METRICS_GROUP)
 
 	// Add handlers for commands and messages
 	// dispatcher.AddHandler(handlers.NewCommand("start", cm.handleStart))
 	dispatcher.AddHandler(handlers.NewConversation(
-		[]ext.Handler{handlers.NewCommand("start", cm.handleStart)},
+		[]ext.Handler{cm.command("start", cm.handleStart)},
 		map[string][]ext.Handler{
 			AUTH:    {handlers.NewMessage(noCommands, cm.handleAuth)},
 			ONBOARD: {handlers.NewMessage(noCommands, cm.handleOnboard)},
 			HELP:    {handlers.NewMessage(noCommands, cm.handleHelp)},
 		},
 		&handlers.ConversationOpts{
-			Exits:        []ext.Handler{handlers.NewCommand("cancel", cm.handleCancel)},
-			StateStorage: conversation.NewInMemoryStorage(conversation.KeyStrategySenderAndChat),
+			Exits:        []ext.Handler{cm.command("cancel", cm.handleCancel)},
+			StateStorage: newCountedStorage("start"),
 			AllowReEntry: true,
 		},
 	))
 
-	dispatcher.AddHandler(handlers.NewCommand("historyUser", cm.middleWareAuth(cm.handleUserHistory)))
-	dispatcher.AddHandler(handlers.NewCommand("historyAll", cm.middleWareAuth(cm.handleAllHistory)))
+	dispatcher.AddHandler(cm.command("historyUser", cm.middleWareAuth(cm.handleUserHistory)))
+	dispatcher.AddHandler(cm.command("historyAll", cm.middleWareAuth(cm.handleAllHistory)))
+	dispatcher.AddHandler(handlers.NewCallback(callbackquery.Prefix(HISTORY_CALLBACK+":"), cm.middleWareAuth(cm.handleHistoryPage)))
+	dispatcher.AddHandler(handlers.NewCallback(callbackquery.Prefix(ROUTE_CALLBACK+":"), cm.middleWareAuth(cm.handleRouteThumbnail)))
+	dispatcher.AddHandler(handlers.NewCallback(callbackquery.Prefix(PICK_CALLBACK+":"), cm.middleWareAuth(cm.handlePick)))
+	dispatcher.AddHandler(handlers.NewCallback(callbackquery.Prefix(DUPLICATE_CALLBACK+":"), cm.middleWareAuth(cm.handleDuplicate)))
+	dispatcher.AddHandler(cm.command("stats", cm.middleWareAuth(cm.handleStats)))
+	dispatcher.AddHandler(cm.command("chart", cm.middleWareAuth(cm.handleChart)))
+	dispatcher.AddHandler(cm.command("export", cm.middleWareAuth(cm.handleExport)))
+	dispatcher.AddHandler(cm.command("duplicates", cm.middleWareAuth(cm.handleScanDuplicates)))
+	dispatcher.AddHandler(cm.command("rules", cm.middleWareAuth(cm.handleRules)))
+	dispatcher.AddHandler(cm.command("review", cm.middleWareAuth(cm.handleReview)))
+	dispatcher.AddHandler(cm.command("type", cm.middleWareAuth(cm.handleType)))
+	dispatcher.AddHandler(handlers.NewCallback(callbackquery.Prefix(REVIEW_CALLBACK+":"), cm.middleWareAuth(cm.handleReviewChoice)))
+	dispatcher.AddHandler(handlers.NewConversation(
+		[]ext.Handler{cm.command("import", cm.middleWareAuth(cm.handleWelcomeImport))},
+		map[string][]ext.Handler{
+			IMPORT: {
+				handlers.NewMessage(message.Document, cm.handleImportFile),
+				handlers.NewMessage(noCommands, cm.handleImportNotFile),
+			},
+		},
+		&handlers.ConversationOpts{
+			Exits:        []ext.Handler{cm.command("cancel", cm.handleCancel)},
+			StateStorage: newCountedStorage("import"),
+			AllowReEntry: true,
+		},
+	))
 	dispatcher.AddHandler(handlers.NewConversation(
-		[]ext.Handler{handlers.NewCommand("delete", cm.middleWareAuth(cm.handleWelcomeDelete))},
+		[]ext.Handler{cm.command("delete", cm.middleWareAuth(cm.handleWelcomeDelete))},
 		map[string][]ext.Handler{
 			USER: {handlers.NewMessage(noCommands, cm.handleDelete)},
 		},
 		&handlers.ConversationOpts{
-			Exits:        []ext.Handler{handlers.NewCommand("cancel", cm.handleCancel)},
-			StateStorage: conversation.NewInMemoryStorage(conversation.KeyStrategySenderAndChat),
+			Exits:        []ext.Handler{cm.command("cancel", cm.handleCancel)},
+			StateStorage: newCountedStorage("delete"),
 			AllowReEntry: true,
 		},
 	))
 
 	dispatcher.AddHandler(handlers.NewConversation(
-		[]ext.Handler{handlers.NewCommand("getdistance", cm.middleWareAuth(cm.handleWelcomeDistance))},
+		[]ext.Handler{cm.command("getdistance", cm.middleWareAuth(cm.handleWelcomeDistance))},
 		map[string][]ext.Handler{
-			DURATION:   {handlers.NewMessage(noCommands, cm.handleDurationDecision)},
-			WEEKRANGE:  {handlers.NewMessage(noCommands, cm.handleWeekRange)},
+			DURATION: {handlers.NewMessage(noCommands, cm.handleDurationDecision)},
+			WEEKRANGE: {
+				handlers.NewMessage(noCommands, cm.handleWeekRange),
+				handlers.NewCallback(callbackquery.Prefix(CALENDAR_CALLBACK+":"), cm.handleCalendar),
+			},
 			MONTHRANGE: {handlers.NewMessage(noCommands, cm.handleMonthRange)},
 		},
 		&handlers.ConversationOpts{
-			Exits:        []ext.Handler{handlers.NewCommand("cancel", cm.handleCancel)},
-			StateStorage: conversation.NewInMemoryStorage(conversation.KeyStrategySenderAndChat),
+			Exits:        []ext.Handler{cm.command("cancel", cm.handleCancel)},
+			StateStorage: newCountedStorage("getdistance"),
 			AllowReEntry: true,
 		},
 	))
 
-	dispatcher.AddHandler(handlers.NewCommand("help", cm.handleHelp))
+	dispatcher.AddHandler(handlers.NewCallback(callbackquery.Prefix(CALENDAR_CALLBACK+":"), cm.handleExpiredCalendar))
+	dispatcher.AddHandler(cm.command("weekstart", cm.middleWareAuth(cm.handleWeekStart)))
+
+	dispatcher.AddHandler(cm.command("language", cm.handleLanguage))
+	dispatcher.AddHandler(handlers.NewCallback(callbackquery.Prefix(LANGUAGE_CALLBACK+":"), cm.handleLanguageChoice))
+	dispatcher.AddHandler(cm.command("timezone", cm.handleTimezone))
+	dispatcher.AddHandler(cm.command("help", cm.handleHelp))
 	dispatcher.AddHandler(handlers.NewMessage(message.Photo, cm.handleImage))
+	dispatcher.AddHandler(handlers.NewMessage(activityDocument, cm.handleActivityFile))
 
-	err := updater.StartPolling(cm.Bot, &ext.PollingOpts{
-		DropPendingUpdates: true,
-		GetUpdatesOpts: &gotgbot.GetUpdatesOpts{
-			Timeout: 9,
-			RequestOpts: &gotgbot.RequestOpts{
-				Timeout: time.Second * 10,
-			},
-		},
-	})
-	if err != nil {
-		panic("failed to start polling: " + err.Error())
+	return dispatcher
+}
+
+// Stop stops receiving updates, deleting the webhook in webhook mode, stops the HTTP
+// server and waits for the updates being handled, screenshots being read included,
+// until ctx is done. The updater is stopped once, calling Stop again does nothing even
+// when handlers were still running.
+func (cm *ChatManager) Stop(ctx context.Context) error {
+	if cm.updater == nil {
+		return nil
 	}
-	log.Printf("%s has been started...\n", cm.Bot.User.Username)
 
-	// Idle, to keep updates coming in, and avoid bot stopping.
-	updater.Idle()
+	cm.stopWebhook()
+	cm.stopServer(ctx)
+
+	// The updater waits for the handlers without a deadline, and cannot be stopped twice
+	updater := cm.updater
+	cm.updater = nil
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- updater.Stop()
+	}()
+
+	select {
+	case err := <-stopped:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("handlers still running: %w", ctx.Err())
+	}
 }
 
 func (cm *ChatManager) handleAuth(b *gotgbot.Bot, ctx *ext.Context) error {
 
 	userInput := ctx.EffectiveMessage.Text
 
-	if !isValidPassword(userInput) {
+	if !cm.isValidPassword(userInput) {
 		log.Debug().Msgf("Invalid password: %s", userInput)
-		_, err := ctx.EffectiveMessage.Reply(b, "Bro you sure you're authorized?", nil)
+		err := cm.replyError(b, ctx, "auth.invalid_password")
 		if err != nil {
-			log.Warn().Msgf("failed to send message:", err)
-			return fmt.Errorf("failed to send message: %w", err)
+			return err
 		}
 		return fmt.Errorf("invalid password")
 	}
@@ -193,25 +318,23 @@ func (cm *ChatManager) handleAuth(b *gotgbot.Bot, ctx *ext.Context) error {
 	log.Debug().Msgf("Password is valid: %s", userInput)
 	log.Debug().Msgf("Passing to next state: %s", ONBOARD)
 
-	_, err := ctx.EffectiveMessage.Reply(b, "Password is valid! Please share with me your name :)", nil)
+	err := cm.replyText(b, ctx, "auth.password_valid")
 	if err != nil {
-		log.Warn().Msgf("failed to send message:", err)
-		return fmt.Errorf("failed to send message: %w", err)
+		return err
 	}
 
 	return handlers.NextConversationState(ONBOARD)
 }
 
-func isValidPassword(userInput string) bool {
+func (cm *ChatManager) isValidPassword(userInput string) bool {
 	log.Debug().Msgf("Checking password: %s", userInput)
 
-	secretPassword, exists := os.LookupEnv("SECRET_PASSWORD")
-	if !exists {
-		log.Warn().Msgf("SECRET_PASSWORD not found in environment variables, please set that up!")
+	if cm.Password == "" {
+		log.Warn().Msgf("No auth password set, please set that up!")
 		return false
 	}
 
-	return userInput == secretPassword
+	return userInput == cm.Password
 }
 
 func noCommands(msg *gotgbot.Message) bool {
@@ -219,11 +342,9 @@ func noCommands(msg *gotgbot.Message) bool {
 }
 
 func (cm *ChatManager) handleCancel(b *gotgbot.Bot, ctx *ext.Context) error {
-	_, err := ctx.EffectiveMessage.Reply(b, "Oh, goodbye!", &gotgbot.SendMessageOpts{
-		ParseMode: "html",
-	})
+	err := cm.replyText(b, ctx, "cancel.goodbye")
 	if err != nil {
-		return fmt.Errorf("failed to send cancel message: %w", err)
+		return err
 	}
 	return handlers.EndConversation()
 }
@@ -235,20 +356,18 @@ func (cm *ChatManager) middleWareAuth(f func(*gotgbot.Bot, *ext.Context) error)
 			return f(b, ctx)
 		}
 		log.Warn().Msgf("Unauthorized user, or user is a bot, or user has invalid id: %d", ctx.EffectiveUser.Id)
-		_, err := b.SendMessage(ctx.EffectiveChat.Id, "You are not authorized to use this bot, use /start to authenticate.", nil)
+		err := cm.sendError(b, ctx, "auth.unauthorized")
 		if err != nil {
-			log.Warn().Msgf("failed to send message:", err)
-			return fmt.Errorf("failed to send message: %w", err)
+			return err
 		}
 		return nil
 	}
 }
 
 func (cm *ChatManager) handleStart(b *gotgbot.Bot, ctx *ext.Context) error {
-	_, err := b.SendMessage(ctx.EffectiveChat.Id, "Hi! Before we start, what is the secret password?", nil)
+	err := cm.sendText(b, ctx, "start.ask_password")
 	if err != nil {
-		log.Warn().Msgf("failed to send message:", err)
-		return fmt.Errorf("failed to send message: %w", err)
+		return err
 
 	}
 	log.Debug().Msgf("Passing to next state: %s", AUTH)
@@ -264,141 +383,16 @@ func (cm *ChatManager) handleOnboard(b *gotgbot.Bot, ctx *ext.Context) error {
 	log.Debug().Msgf("Saving user's name: %s", userInput)
 	cm.DatabaseManager.SaveUser(userInput, ctx.EffectiveUser.Id)
 
-	_, err := ctx.EffectiveMessage.Reply(b, "Welcome "+userInput+"! Send me a workout image and I will log the details.\n"+HELP_MANUAL, nil)
+	err := cm.reply(b, ctx, messagerenderer.TEMPLATE_WELCOME, userInput, nil)
 	if err != nil {
-		log.Warn().Msgf("failed to send message:", err)
-		return fmt.Errorf("failed to send message: %w", err)
+		return err
 	}
 
 	return handlers.NextConversationState(HELP)
 }
 
 func (cm *ChatManager) handleHelp(b *gotgbot.Bot, ctx *ext.Context) error {
-	helpMessage := "Welcome to Run Tracker Bot!\n" +
-		HELP_MANUAL
-
-	_, err := b.SendMessage(ctx.EffectiveChat.Id, helpMessage, nil)
-	if err != nil {
-		log.Warn().Msgf("failed to send help message:", err)
-		return fmt.Errorf("failed to send help message: %w", err)
-	}
-
-	return err
-}
-
-func (cm *ChatManager) handleAllHistory(b *gotgbot.Bot, ctx *ext.Context) error {
-
-	chatID := ctx.EffectiveChat.Id
-
-	groupWorkouts, err := cm.DatabaseManager.GetAllWorkouts(chatID)
-	if err != nil {
-		log.Warn().Msgf("Workouts do not exist for groupID %d: %v", chatID, err)
-		_, err := b.SendMessage(ctx.EffectiveChat.Id, "No Workout history exists, please submit images to begin!", nil)
-		return err
-	}
-
-	// Prepare a string to send as a message
-	var message string
-	// message += fmt.Sprintf("Workouts for Group %d:\n", chatID)
-
-	// Iterate over the groupWorkouts map
-	for userId, dates := range groupWorkouts {
-		username, err := cm.DatabaseManager.GetUsernameFromId(userId)
-		if err != nil {
-			log.Warn().Msgf("Error getting username for user %d: %v", userId, err)
-			return err
-		}
-
-		message += fmt.Sprintf("User: %s\n", username)
-		for date, workoutEntry := range dates {
-			message += fmt.Sprintf("Date: %s\n", date)
-			message += fmt.Sprintf("- Distance: %sKM, Pace: %s \n", workoutEntry.Distance, workoutEntry.Pace)
-		}
-	}
-
-	// Process and send all workouts for the group
-	log.Info().Msgf("All Workouts for Group %d: %v\n", chatID, groupWorkouts)
-
-	_, err = ctx.EffectiveMessage.Reply(b, "Workouts for Group\n"+message+"\n\n", nil)
-	if err != nil {
-		log.Warn().Msgf("Error sending message to user in telegram:", err)
-		return err
-	}
-	return nil
-}
-
-func (cm *ChatManager) handleUserHistory(b *gotgbot.Bot, ctx *ext.Context) error {
-
-	chatID := ctx.EffectiveChat.Id
-	userID := ctx.EffectiveUser.Id
-
-	userWorkouts, err := cm.DatabaseManager.GetUserWorkouts(chatID, userID)
-	if err != nil {
-		log.Warn().Msgf("Error getting workouts for user %d in group %d: %v", userID, chatID, err)
-		_, err := ctx.EffectiveMessage.Reply(b, "No existing workout history for user in group.", nil)
-		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
-		}
-		return err
-	}
-
-	var message string
-	username, err := cm.DatabaseManager.GetUsernameFromId(userID)
-	if err != nil {
-		log.Warn().Msgf("Error getting username for user %d: %v", userID, err)
-		return err
-	}
-
-	message += fmt.Sprintf("User: %s\n", username)
-	for date, workout := range userWorkouts {
-		message += fmt.Sprintf("Date: %s\n", date)
-		message += fmt.Sprintf("- Distance: %sKM, Pace: %s \n\n", workout.Distance, workout.Pace)
-	}
-
-	// Process and send workouts for the specified user
-	log.Info().Msgf("Workouts for User %d: %v\n", userID, userWorkouts)
-	_, err = ctx.EffectiveMessage.Reply(b, "Workouts for User!\n "+message+"\n", nil)
-	if err != nil {
-		log.Warn().Msgf("Error sending message to user in telegram:", err)
-		return err
-	}
-
-	log.Debug().Msgf("Releasing lock...")
-	// workouts, ok := cm.DatabaseManager.Data.Workouts[ctx.EffectiveUser.Id]
-	// if !ok {
-	// 	log.Warn().Msgf("No workout history found.")
-	// 	_, err := b.SendMessage(ctx.EffectiveChat.Id, "No workout history found.", nil)
-	// 	return err
-	// }
-
-	// response := "Your workout history:\n"
-	// for _, entry := range workouts {
-	// 	entryTimestamp, err := strconv.ParseInt(entry.Timestamp, 10, 64)
-	// 	if err != nil {
-	// 		log.Warn().Msgf("Error parsing timestamp:", err)
-	// 		return err
-	// 	}
-
-	// 	timestamp := time.Unix(entryTimestamp, 0)
-	// 	response += timestamp.Format("2006-01-02 15:04:05") + ": " + entry.Text + "\n"
-	// }
-
-	// _, err := b.SendMessage(ctx.EffectiveChat.Id, response, nil)
-	return nil
-}
-
-func isVerifiedDateFormat(date string) bool {
-
-	// check if date is in the form of "2006-01-02"
-
-	const layout = "2006-01-02"
-	_, err := time.Parse(layout, date)
-	if err != nil {
-		log.Warn().Msgf("Date is not in the right form: ", err)
-		return false
-	}
-
-	return true
+	return cm.send(b, ctx, messagerenderer.TEMPLATE_HELP, nil, nil)
 }
 
 func (cm *ChatManager) handleDelete(b *gotgbot.Bot, ctx *ext.Context) error {
@@ -407,28 +401,24 @@ func (cm *ChatManager) handleDelete(b *gotgbot.Bot, ctx *ext.Context) error {
 
 	// Wait for user's response
 	// Add handler to capture the user's response
-	dateInput := ctx.EffectiveMessage.Text
-
-	if !isVerifiedDateFormat(dateInput) {
-		log.Warn().Msgf("Invalid date format. Please provide the date in the format YYYY-MM-DD.")
-		_, err := ctx.EffectiveMessage.Reply(b, "Invalid date format. Please provide the date in the format YYYY-MM-DD.", nil)
+	date, err := stats.ParseDate(ctx.EffectiveMessage.Text, cm.now(ctx))
+	if err != nil {
+		log.Warn().Msgf("Invalid date to delete: %v", err)
+		err := cm.replyError(b, ctx, "delete.invalid_date")
 		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
 			return err
 		}
-		return fmt.Errorf("Invalid date format. Please provide the date in the format YYYY-MM-DD.")
+		return fmt.Errorf("Invalid date to delete: %q", ctx.EffectiveMessage.Text)
 	}
 
-	if cm.DatabaseManager.DeleteWorkout(chatID, userID, dateInput) {
-		_, err := ctx.EffectiveMessage.Reply(b, "Workout entry deleted successfully.", nil)
+	if cm.DatabaseManager.DeleteWorkout(chatID, userID, date.Format(stats.DATE_LAYOUT)) {
+		err := cm.replyText(b, ctx, "delete.deleted")
 		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
 			return err
 		}
 	} else {
-		_, err := ctx.EffectiveMessage.Reply(b, "No workout entry found for the provided date.", nil)
+		err := cm.replyError(b, ctx, "delete.not_found")
 		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
 			return err
 		}
 	}
@@ -439,9 +429,8 @@ func (cm *ChatManager) handleDelete(b *gotgbot.Bot, ctx *ext.Context) error {
 
 func (cm *ChatManager) handleWelcomeDelete(b *gotgbot.Bot, ctx *ext.Context) error {
 	// Prompt the user to provide the date of the workout entry to delete
-	_, err := ctx.EffectiveMessage.Reply(b, "Please provide the date of the workout entry you want to delete (format: YYYY-MM-DD):", nil)
+	err := cm.replyText(b, ctx, "delete.ask_date")
 	if err != nil {
-		log.Warn().Msgf("Error sending message to user in telegram:", err)
 		return err
 	}
 
@@ -449,10 +438,18 @@ func (cm *ChatManager) handleWelcomeDelete(b *gotgbot.Bot, ctx *ext.Context) err
 }
 
 func (cm *ChatManager) handleWelcomeDistance(b *gotgbot.Bot, ctx *ext.Context) error {
+	// "/getdistance last week" is answered right away
+	if input := commandArgs(ctx.EffectiveMessage.Text); input != "" {
+		err := cm.replyPeriodTotals(b, ctx, input)
+		if err != nil {
+			return err
+		}
+		return handlers.EndConversation()
+	}
+
 	// Prompt the user to provide the date of the workout entry to delete
-	_, err := b.SendMessage(ctx.EffectiveChat.Id, "Do you want to search by WEEK or MONTH?:", nil)
+	err := cm.sendText(b, ctx, "distance.ask_duration")
 	if err != nil {
-		log.Warn().Msgf("Error sending message to user in telegram:", err)
 		return err
 	}
 
@@ -467,9 +464,8 @@ func (cm *ChatManager) handleDurationDecision(b *gotgbot.Bot, ctx *ext.Context)
 
 	if userInput == "WEEK" {
 		log.Debug().Msgf("Passing to next state: %s", WEEKRANGE)
-		_, err := ctx.EffectiveMessage.Reply(b, "Please Enter the Date Range that you want to search (startDate, endDate) (format: YYYY-MM-DD, YYYY-MM-DD), example (2024-05-01, 2024-05-10):", nil)
+		err := cm.replyCalendar(b, ctx)
 		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
 			return err
 		}
 
@@ -478,18 +474,16 @@ func (cm *ChatManager) handleDurationDecision(b *gotgbot.Bot, ctx *ext.Context)
 	} else if userInput == "MONTH" {
 		log.Debug().Msgf("Passing to next state: %s", MONTHRANGE)
 
-		_, err := ctx.EffectiveMessage.Reply(b, "Which Month do you want to search? (format: YYYY-MM) (example: 2024-01)", nil)
+		err := cm.replyText(b, ctx, "distance.ask_month")
 		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
 			return err
 		}
 
 		return handlers.NextConversationState(MONTHRANGE)
 	} else {
 		log.Debug().Msgf("Invalid input: %s", userInput)
-		_, err := ctx.EffectiveMessage.Reply(b, "You've just entered an invalid input, please use the words WEEK or MONTH", nil)
+		err := cm.replyError(b, ctx, "distance.invalid_duration")
 		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
 			return err
 		}
 
@@ -499,351 +493,173 @@ func (cm *ChatManager) handleDurationDecision(b *gotgbot.Bot, ctx *ext.Context)
 }
 
 func (cm *ChatManager) handleWeekRange(b *gotgbot.Bot, ctx *ext.Context) error {
-
-	userInput := ctx.EffectiveMessage.Text
-
-	if !isValidDateRange(userInput) {
-		log.Warn().Msgf("Invalid date range format. Please provide the date range in the format startDate, endDate.")
-		_, err := ctx.EffectiveMessage.Reply(b, "Invalid date range format. Please provide the date range in the format startDate, endDate.", nil)
-		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
-			return err
-		}
-		return fmt.Errorf("Invalid date range format. Please provide the date range in the format startDate, endDate.")
-	}
-
-	dateRange := strings.TrimSpace(userInput)
-	log.Debug().Msgf("Splitting the dateRange with delimiter ',': %s", dateRange)
-	// Split by comma to get startDate and endDate
-	dates := strings.Split(dateRange, ",")
-	if len(dates) != 2 {
-		log.Warn().Msgf("Invalid date range format. Please provide the date range in the format startDate, endDate.")
-	}
-
-	startDate := strings.TrimSpace(dates[0])
-	endDate := strings.TrimSpace(dates[1])
-
-	// Process the date range
-	totalDistanceByUser, err := cm.DatabaseManager.GetTotalDistanceByWeek(ctx.EffectiveChat.Id, startDate, endDate)
-	if err != nil {
-		log.Warn().Msgf("Error getting total distance for user: %v", err)
-		_, err := b.SendMessage(ctx.EffectiveChat.Id, "Error getting total distance for user.", nil)
-		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
-			return err
-		}
-		return err
-	}
-
-	var message string
-	message += fmt.Sprintf("Total Distance for each user: \n")
-	for userId, distance := range totalDistanceByUser {
-		username, err := cm.DatabaseManager.GetUsernameFromId(userId)
-		if err != nil {
-			log.Warn().Msgf("Error getting username for user %d: %v", userId, err)
-			return err
-		}
-
-		message += fmt.Sprintf("User: %s, Total Distance: %sKM\n", username, distance)
-	}
-
-	// Prompt the user to provide the date of the workout entry to delete
-	_, err = ctx.EffectiveMessage.Reply(b, message, nil)
-	if err != nil {
-		log.Warn().Msgf("Error sending message to user in telegram:", err)
-		return err
-	}
-
-	return nil
+	return cm.replyRangeTotals(b, ctx, "distance.invalid_week_range")
 }
 
-func isValidDateRange(dateRange string) bool {
-	// Trim any surrounding whitespace
-	dateRange = strings.TrimSpace(dateRange)
-
-	log.Debug().Msgf("Splitting the dateRange with delimiter ',': %s", dateRange)
-	// Split by comma to get startDate and endDate
-	dates := strings.Split(dateRange, ",")
-	if len(dates) != 2 {
-		log.Warn().Msgf("Invalid date range format. Please provide the date range in the format startDate, endDate.")
-		return false
-	}
-
-	startDateStr := strings.TrimSpace(dates[0])
-	endDateStr := strings.TrimSpace(dates[1])
-
-	// Define the date layout
-	const layout = "2006-01-02"
+func (cm *ChatManager) handleMonthRange(b *gotgbot.Bot, ctx *ext.Context) error {
+	return cm.replyRangeTotals(b, ctx, "distance.invalid_month")
+}
 
-	// Parse startDate
-	startDate, err := time.Parse(layout, startDateStr)
+// replyRangeTotals answers a range or month typed during /getdistance, anything
+// ParsePeriod understands is accepted, e.g. "last monday to yesterday" or "may 2024",
+// optionally with a type of activity like "may 2024 cycle".
+func (cm *ChatManager) replyRangeTotals(b *gotgbot.Bot, ctx *ext.Context, invalidKey string) error {
+	rest, activity := stats.SplitActivityType(ctx.EffectiveMessage.Text)
+	period, err := stats.ParsePeriod(rest, cm.now(ctx), cm.weekStart(ctx))
 	if err != nil {
-		log.Warn().Msgf("Error parsing startDate:", err)
-		return false
+		log.Warn().Msgf("Invalid distance period: %v", err)
+		return cm.replyError(b, ctx, invalidKey)
 	}
 
-	// Parse endDate
-	endDate, err := time.Parse(layout, endDateStr)
+	totals, err := cm.periodTotals(ctx, period, activity)
 	if err != nil {
-		log.Warn().Msgf("Error parsing endDate:", err)
-		return false
-	}
-
-	// Check if endDate is not earlier than startDate
-	if endDate.Before(startDate) {
-		log.Warn().Msgf("endDate is earlier than startDate")
-		return false
+		log.Warn().Msgf("Error getting total distance for user: %v", err)
+		return cm.sendError(b, ctx, "distance.error")
 	}
 
-	// If all checks pass
-	return true
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_TOTALS, totals, nil)
 }
 
-func (cm *ChatManager) handleMonthRange(b *gotgbot.Bot, ctx *ext.Context) error {
-	userInput := ctx.EffectiveMessage.Text
-
-	if !isValidMonthAndYear(userInput) {
-		log.Warn().Msgf("Invalid month format. Please provide the month in the format YYYY-MM.")
-		_, err := ctx.EffectiveMessage.Reply(b, "Invalid month format. Please provide the month in the format YYYY-MM.", nil)
+func (cm *ChatManager) handleImage(b *gotgbot.Bot, ctx *ext.Context) error {
+	log.Debug().Msgf("Handling image...")
+	if ctx.Message.Photo == nil {
+		err := cm.replyError(b, ctx, "image.invalid")
 		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
 			return err
 		}
+		log.Warn().Msgf("No image found in message.")
 		return err
 	}
 
-	userInput = strings.TrimSpace(userInput)
-
-	log.Debug().Msgf("Splitting the dateRange with delimiter '-': %s", userInput)
-	// Split by comma to get startDate and endDate
-	dates := strings.Split(userInput, "-")
-	if len(dates) != 2 {
-		log.Warn().Msgf("Invalid date range format. Please provide the date range in the format startDate, endDate.")
-	}
-
-	year := strings.TrimSpace(dates[0])
-	month := strings.TrimSpace(dates[1])
-
-	totalDistanceByUser, err := cm.DatabaseManager.GetTotalDistanceByMonth(ctx.EffectiveChat.Id, month, year)
-	if err != nil {
-		log.Warn().Msgf("Error getting total distance for user: %v", err)
-		_, err := b.SendMessage(ctx.EffectiveChat.Id, "Error getting total distance for user.", nil)
-		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
-			return err
-		}
-		return err
-	}
+	photo := ctx.Message.Photo[len(ctx.Message.Photo)-1]
 
-	convertedMonth, err := convertNumToMonth(month)
+	log.Debug().Msgf("Getting file...")
+	data, err := cm.fetchFile(photo.FileId)
 	if err != nil {
-		log.Warn().Msgf("Error converting month to string: %v", err)
-		return err
+		log.Warn().Msgf("Error downloading image file: %v", err)
+		return cm.sendError(b, ctx, "image.error")
 	}
 
-	var message string
-	message += fmt.Sprintf("Total Distance for each user in " + convertedMonth + " : \n")
-	for userId, distance := range totalDistanceByUser {
-		username, err := cm.DatabaseManager.GetUsernameFromId(userId)
-		if err != nil {
-			log.Warn().Msgf("Error getting username for user %d: %v", userId, err)
-			return err
-		}
-
-		message += fmt.Sprintf("User: %s, Total Distance: %sKM\n", username, distance)
+	imagePath := "image.jpg"
+	if err := os.WriteFile(imagePath, data, 0644); err != nil {
+		log.Warn().Msgf("Error saving image file: %v", err)
+		return cm.sendError(b, ctx, "image.error")
 	}
+	log.Info().Msgf("Image saved to %s", imagePath)
 
-	_, err = ctx.EffectiveMessage.Reply(b, message, nil)
+	// The hash spots the same screenshot sent again, without it the fingerprint still does
+	imageHash, err := imageprocessor.PerceptualHash(data)
 	if err != nil {
-		log.Warn().Msgf("Error sending message to user in telegram:", err)
-		return err
+		log.Warn().Msgf("Error hashing image: %v", err)
 	}
 
-	return nil
-}
-
-func convertNumToMonth(month string) (string, error) {
-	months := map[string]string{
-		"01": "JAN",
-		"02": "FEB",
-		"03": "MAR",
-		"04": "APR",
-		"05": "MAY",
-		"06": "JUN",
-		"07": "JUL",
-		"08": "AUG",
-		"09": "SEP",
-		"10": "OCT",
-		"11": "NOV",
-		"12": "DEC",
+	result, err := cm.ImageProcessor.ReadWorkout(imagePath)
+	switch {
+	case errors.Is(err, imageprocessor.ErrUnknownApp):
+		log.Warn().Msgf("Unknown app in image: %v", err)
+		return cm.replyError(b, ctx, "image.invalid_details")
+	case errors.Is(err, imageprocessor.ErrMissingField):
+		log.Warn().Msgf("Error extracting workout details: %v", err)
+		return cm.replyError(b, ctx, "image.extract_error")
+	case err != nil:
+		log.Warn().Msgf("Error processing image: %v", err)
+		return cm.sendError(b, ctx, "image.error")
 	}
-
-	if monthName, exists := months[month]; exists {
-		return monthName, nil
+	if activity, ok := captionActivity(ctx); ok {
+		result.Activity = activity
 	}
+	log.Debug().Msgf("Workout details: %v", result.WorkoutDetails())
 
-	return "", errors.New("invalid month format")
-}
-
-func isValidMonthAndYear(userInput string) bool {
-	// Normalize input to uppercase for case-insensitive comparison
-	userInput = strings.ToUpper(strings.TrimSpace(userInput))
+	// Stamp the workout with the calendar date of the user, not of the server
+	date := cm.now(ctx).Format(stats.DATE_LAYOUT)
 
-	// List of valid month abbreviations
-	const layout = "2006-01"
-
-	// Parse startDate
-	_, err := time.Parse(layout, userInput)
-	if err != nil {
-		log.Warn().Msgf("Error parsing startDate:", err)
-		return false
+	// Rather ask than log a misread distance or pace
+	if ambiguous := result.Ambiguous(); len(ambiguous) > 0 {
+		return cm.askCandidates(b, ctx, date, result, ambiguous, imageHash)
 	}
 
-	return true
+	return cm.logWorkout(b, ctx, date, screenshotDetails(result, imageHash), nil)
 }
 
-func (cm *ChatManager) downloadFile(url string, filepath string, ctx *ext.Context) error {
-	// Download the file
-	log.Debug().Msgf("Downloading image file from %s", url)
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Warn().Msgf("Error downloading image file:", err)
-		_, err := cm.Bot.SendMessage(ctx.EffectiveChat.Id, "Error processing image. Please try again.", nil)
-		return err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Warn().Msgf("Bad Status Code:", resp.StatusCode)
-		log.Warn().Msgf("Error downloading image file:", err)
-		_, err := cm.Bot.SendMessage(ctx.EffectiveChat.Id, "Error processing image. Please try again.", nil)
-		return err
-	}
-
-	defer resp.Body.Close()
-
-	// Save the image to a file
-	imagePath := "image.jpg"
-	out, err := os.Create(imagePath)
-	if err != nil {
-		log.Warn().Msgf("Error saving image file:", err)
-		_, err := cm.Bot.SendMessage(ctx.EffectiveChat.Id, "Error processing image. Please try again.", nil)
-		return err
-	}
-
-	defer out.Close()
-
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		log.Warn().Msgf("Error saving image file:", err)
-		_, err := cm.Bot.SendMessage(ctx.EffectiveChat.Id, "Error processing image. Please try again.", nil)
-		return err
-	}
-
-	log.Info().Msgf("Image saved to %s", imagePath)
-	return nil
+// screenshotDetails are the workout details read from a screenshot. Its confidence is
+// that of a screenshot, scaled by how sure the parser was about the least certain field.
+func screenshotDetails(result *imageprocessor.ParseResult, imageHash string) map[string]string {
+	workoutDetails := result.WorkoutDetails()
+	workoutDetails["Source"] = databasemanager.SOURCE_SCREENSHOT
+	workoutDetails["ImageHash"] = imageHash
+	confidence := databasemanager.CONFIDENCE_SCREENSHOT * result.Confidence()
+	workoutDetails["Confidence"] = strconv.FormatFloat(confidence, 'f', 2, 64)
+	return workoutDetails
 }
 
-func (cm *ChatManager) handleImage(b *gotgbot.Bot, ctx *ext.Context) error {
-
-	userId := ctx.EffectiveUser.Id
-	chatId := ctx.EffectiveChat.Id
-
-	log.Debug().Msgf("Handling image...")
-	if ctx.Message.Photo == nil {
-		_, err := ctx.EffectiveMessage.Reply(b, "Please send a valid image.", nil)
-		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
-			return err
-		}
-		log.Warn().Msgf("No image found in message.")
-		return err
-	}
-
-	photo := ctx.Message.Photo[len(ctx.Message.Photo)-1]
-
-	/*
-		Use this method to get basic information about a file and prepare it for downloading.
-		For the moment, bots can download files of up to 20MB in size.
-		On success, a File object is returned.
-		The file can then be downloaded via the link https://api.telegram.org/file/bot<token>/<file_path>,
-		where <file_path> is taken from the response.
-	*/
-
-	log.Debug().Msgf("Getting file...")
-	file, err := b.GetFile(photo.FileId, nil)
-	if err != nil {
-		log.Warn().Msgf("Error getting image file:", err)
-		_, err := b.SendMessage(ctx.EffectiveChat.Id, "Error processing image. Please try again.", nil)
-		return err
+// logWorkout saves the workout details of a screenshot or an activity file and
+// confirms them to the user, with the route map attached when there is one. Workouts
+// that look like one the user logged before are only saved once the user confirms.
+func (cm *ChatManager) logWorkout(b *gotgbot.Bot, ctx *ext.Context, date string, workoutDetails map[string]string, route *routeMaps) error {
+	duplicate := cm.DatabaseManager.FindDuplicate(ctx.EffectiveUser.Id, date, workoutDetails, imageprocessor.SimilarImages)
+	if duplicate != nil {
+		return cm.askDuplicate(b, ctx, date, workoutDetails, route, duplicate)
 	}
+	return cm.saveWorkout(b, ctx, date, workoutDetails, route)
+}
 
-	log.Debug().Msgf("Received file: %v", file)
-
-	imagePath := "image.jpg"
-	log.Info().Msgf("Downloading image file into %s", imagePath)
-
-	log.Debug().Msgf("File Download Path: %s", file.FilePath)
-	cm.downloadFile(TELEGRAM_FILE_URL+cm.Token+"/"+file.FilePath, imagePath, ctx)
+// saveWorkout is logWorkout without looking for duplicates. Implausible workouts are
+// saved flagged, for the admins to review. The route thumbnail is only written once
+// the workout is in, so a skipped duplicate leaves the files alone.
+func (cm *ChatManager) saveWorkout(b *gotgbot.Bot, ctx *ext.Context, date string, workoutDetails map[string]string, route *routeMaps) error {
+	// Check, insert and save the workout data under one lock, so that the daily
+	// distance checked is still the one when the workout is inserted
+	log.Debug().Msgf("Locking the database")
+	cm.DatabaseManager.Data.Lock()
 
-	text, err := cm.ImageProcessor.ProcessImage(imagePath)
-	if err != nil {
-		log.Warn().Msgf("Error processing image:", err)
-		_, err := b.SendMessage(ctx.EffectiveChat.Id, "Error processing image. Please try again.", nil)
-		return err
+	flags := validator.NewValidator(cm.DatabaseManager).Check(ctx.EffectiveChat.Id, ctx.EffectiveUser.Id, date, workoutDetails)
+	if len(flags) > 0 {
+		log.Info().Msgf("Flagging workout of user %d on %s: %v", ctx.EffectiveUser.Id, date, flags)
+		workoutDetails["Review"] = databasemanager.REVIEW_FLAGGED
+		workoutDetails["Flags"] = strings.Join(flags, ",")
 	}
 
-	isApple := cm.ImageProcessor.IsAppleWorkout(text)
-	var workoutDetails map[string]string
-	if isApple {
-		workoutDetails, err = cm.ImageProcessor.ParseWorkoutDetails(text)
+	didInsert := cm.DatabaseManager.InsertWorkoutEntry(ctx.EffectiveChat.Id, ctx.EffectiveUser.Id, date, workoutDetails)
+	if didInsert && route != nil {
+		name, err := cm.DatabaseManager.SaveRoute(ctx.EffectiveChat.Id, ctx.EffectiveUser.Id, date, route.Thumbnail)
 		if err != nil {
-			log.Warn().Msgf("Error extracting workout details:", err)
-			_, err := b.SendMessage(ctx.EffectiveChat.Id, "Error extracting workout details. Please try again.", nil)
-			return err
+			log.Warn().Msgf("Error saving route map: %v", err)
+		} else {
+			cm.DatabaseManager.SetRoute(ctx.EffectiveChat.Id, ctx.EffectiveUser.Id, date, name)
 		}
-		log.Debug().Msgf("Workout details: %v", workoutDetails)
-	} else if cm.ImageProcessor.IsRunKeeper(text) {
-		workoutDetails, err = cm.ImageProcessor.ParseRunKeepWorkoutDetails(text)
-		if err != nil {
-			log.Warn().Msgf("Error extracting workout details:", err)
-			_, err := b.SendMessage(ctx.EffectiveChat.Id, "Error extracting workout details. Please try again.", nil)
-			return err
-		}
-		log.Debug().Msgf("Workout details: %v", workoutDetails)
 	}
-
-	// Save the workout data
-	log.Debug().Msgf("Locking the database")
-	cm.DatabaseManager.Data.Lock()
-
-	date := time.Now().Format("2006-01-02")
-	didInsert := cm.DatabaseManager.InsertWorkoutEntry(chatId, userId, date, workoutDetails)
-
-	// cm.DatabaseManager.Data.Workouts[ctx.EffectiveUser.Id] = append(cm.DatabaseManager.Data.Workouts[ctx.EffectiveUser.Id], databasemanager.WorkoutEntry{
-	// 	Text:      strings.Join([]string{workoutDetails["Distance"], workoutDetails["Pace"], workoutDetails["HeartRate"]}, ", "),
-	// 	Timestamp: timestamp,
-	// })
+	// Saved before unlocking, so a flush on shutdown waits for the file to be written
+	var err error
+	if didInsert {
+		err = cm.DatabaseManager.SaveData()
+	}
 
 	log.Debug().Msgf("Unlocking the database")
 	cm.DatabaseManager.Data.Unlock()
 
 	if didInsert {
-		err = cm.DatabaseManager.SaveData()
 		if err != nil {
-			log.Warn().Msgf("Error saving workout data:", err)
-			_, err := b.SendMessage(ctx.EffectiveChat.Id, "Error saving workout data.", nil)
-			return err
+			log.Warn().Msgf("Error saving workout data: %v", err)
+			return cm.sendError(b, ctx, "image.save_error")
 		}
 
-		_, err = ctx.EffectiveMessage.Reply(b, "Workout logged!\n"+
-			"Date: "+workoutDetails["Date"]+"\n"+
-			"Distance: "+workoutDetails["Distance"]+"KM\n"+
-			"Avg Pace: "+workoutDetails["Pace"]+"\n", nil)
-		return err
+		workout := messagerenderer.Workout{
+			Date:          date,
+			Type:          workoutDetails["Type"],
+			Distance:      workoutDetails["Distance"],
+			Pace:          workoutDetails["Pace"],
+			Time:          workoutDetails["TotalTime"],
+			ElevationGain: workoutDetails["ElevationGain"],
+			HeartRate:     workoutDetails["HeartRate"],
+			Flags:         cm.flagReasons(ctx, flags),
+		}
+		if route != nil {
+			return cm.replyPhoto(b, ctx, route.Map, "route.png", messagerenderer.TEMPLATE_WORKOUT_LOGGED, workout)
+		}
+		return cm.reply(b, ctx, messagerenderer.TEMPLATE_WORKOUT_LOGGED, workout, nil)
 	} else {
-		_, err = ctx.EffectiveMessage.Reply(b, "Invalid workout details. No insertion performed into database.", nil)
+		err := cm.replyError(b, ctx, "image.invalid_details")
 		if err != nil {
-			log.Warn().Msgf("Error sending message to user in telegram:", err)
 			return err
 		}
 		log.Warn().Msgf("Invalid workout details. No insertion performed into database.")
@@ -0,0 +1,119 @@
+package chatmanager
+
+import (
+	"fmt"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	imageprocessor "run-tracker-telebot/src/pkg/image-processor"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"run-tracker-telebot/src/pkg/stats"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const (
+	DUPLICATE_CALLBACK = "dup"
+	DUPLICATE_SKIP     = "skip"
+	DUPLICATE_LOG      = "log"
+)
+
+// askDuplicate warns that a workout looks like one the user logged before and asks
+// whether to skip it or log it anyway, with the callback data "dup:<message id>:skip|log".
+func (cm *ChatManager) askDuplicate(b *gotgbot.Bot, ctx *ext.Context, date string, workoutDetails map[string]string, route *routeMaps, duplicate *databasemanager.Duplicate) error {
+	messageID := ctx.EffectiveMessage.MessageId
+	cm.duplicates.add(pendingKey(ctx.EffectiveChat.Id, messageID), &pendingWorkout{
+		UserID:  ctx.EffectiveUser.Id,
+		Date:    date,
+		Created: time.Now(),
+		Details: workoutDetails,
+		Route:   route,
+	})
+
+	day := duplicate.Date
+	if parsed, err := time.Parse(stats.DATE_LAYOUT, duplicate.Date); err == nil {
+		day = cm.Localizer.FormatDate(cm.locale(ctx), parsed)
+	}
+	text := cm.translate(ctx, "duplicate.ask", day, duplicate.Entry.Distance, duplicate.Entry.Pace)
+	if duplicate.ChatID != ctx.EffectiveChat.Id {
+		text += "\n" + cm.translate(ctx, "duplicate.other_group")
+	}
+
+	keyboard := gotgbot.InlineKeyboardMarkup{InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{
+		{Text: cm.translate(ctx, "duplicate.skip"), CallbackData: fmt.Sprintf("%s:%d:%s", DUPLICATE_CALLBACK, messageID, DUPLICATE_SKIP)},
+		{Text: cm.translate(ctx, "duplicate.log"), CallbackData: fmt.Sprintf("%s:%d:%s", DUPLICATE_CALLBACK, messageID, DUPLICATE_LOG)},
+	}}}
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_TEXT, text, keyboard)
+}
+
+// handleDuplicate takes the answer to askDuplicate. Only the user who sent the workout
+// can answer.
+func (cm *ChatManager) handleDuplicate(b *gotgbot.Bot, ctx *ext.Context) error {
+	query := ctx.CallbackQuery
+
+	parts := strings.SplitN(query.Data, ":", 3)
+	if len(parts) != 3 || (parts[2] != DUPLICATE_SKIP && parts[2] != DUPLICATE_LOG) {
+		log.Warn().Msgf("Invalid duplicate callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+	messageID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		log.Warn().Msgf("Invalid duplicate callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	key := pendingKey(ctx.EffectiveChat.Id, messageID)
+	if pending := cm.duplicates.get(key); pending != nil && query.From.Id != pending.UserID {
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "duplicate.not_yours")})
+		return err
+	}
+	pending := cm.duplicates.take(key)
+	if pending == nil {
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "duplicate.expired")})
+		return err
+	}
+
+	if parts[2] == DUPLICATE_SKIP {
+		cm.edit(b, ctx, query.Message.GetMessageId(), messagerenderer.TEMPLATE_TEXT, cm.translate(ctx, "duplicate.skipped"), gotgbot.InlineKeyboardMarkup{})
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	cm.edit(b, ctx, query.Message.GetMessageId(), messagerenderer.TEMPLATE_TEXT, cm.translate(ctx, "duplicate.logging"), gotgbot.InlineKeyboardMarkup{})
+	if _, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil); err != nil {
+		log.Warn().Msgf("Error answering duplicate callback: %v", err)
+	}
+	return cm.saveWorkout(b, ctx, pending.Date, pending.Details, pending.Route)
+}
+
+// handleScanDuplicates lists, for admins, the workouts logged in the chat that look like
+// the same run logged twice, here or in another group.
+func (cm *ChatManager) handleScanDuplicates(b *gotgbot.Bot, ctx *ext.Context) error {
+	if !cm.isAdmin(b, ctx) {
+		return cm.replyError(b, ctx, "duplicates.not_admin")
+	}
+
+	pairs := cm.DatabaseManager.ScanDuplicates(ctx.EffectiveChat.Id, imageprocessor.SimilarImages)
+
+	report := messagerenderer.Duplicates{}
+	for _, pair := range pairs {
+		name, err := cm.DatabaseManager.GetUsernameFromId(pair.First.UserID)
+		if err != nil {
+			name = strconv.FormatInt(pair.First.UserID, 10)
+		}
+		report.Pairs = append(report.Pairs, messagerenderer.DuplicatePair{
+			Name:       name,
+			First:      messagerenderer.Workout{Date: pair.First.Date, Distance: pair.First.Entry.Distance, Pace: pair.First.Entry.Pace},
+			Second:     messagerenderer.Workout{Date: pair.Second.Date, Type: pair.Second.Entry.Type, Distance: pair.Second.Entry.Distance, Pace: pair.Second.Entry.Pace},
+			SameImage:  pair.Second.SameImage,
+			OtherGroup: pair.Second.ChatID != pair.First.ChatID,
+		})
+	}
+
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_DUPLICATES, report, nil)
+}
@@ -0,0 +1,65 @@
+package chatmanager
+
+import (
+	"bytes"
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/exporter"
+	"run-tracker-telebot/src/pkg/stats"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// handleExport sends the workouts of the user as a file, "/export [group] [csv|json] [period]".
+// Admins can export the whole group with "group". Without a period everything is exported.
+func (cm *ChatManager) handleExport(b *gotgbot.Bot, ctx *ext.Context) error {
+	args := strings.Fields(strings.ToLower(commandArgs(ctx.EffectiveMessage.Text)))
+
+	userID := ctx.EffectiveUser.Id
+	if len(args) > 0 && args[0] == SETTING_GROUP {
+		if !cm.isAdmin(b, ctx) {
+			return cm.replyError(b, ctx, "export.not_admin")
+		}
+		userID = 0
+		args = args[1:]
+	}
+
+	format := exporter.FORMAT_CSV
+	if len(args) > 0 && exporter.IsFormat(args[0]) {
+		format = args[0]
+		args = args[1:]
+	}
+
+	input := strings.Join(args, " ")
+	if input == "" {
+		input = stats.PERIOD_ALL
+	}
+	period, err := stats.ParsePeriod(input, cm.now(ctx), cm.weekStart(ctx))
+	if err != nil {
+		log.Warn().Msgf("Invalid export period: %v", err)
+		return cm.replyError(b, ctx, "export.invalid_period", cm.translate(ctx, "export.usage"))
+	}
+
+	var file bytes.Buffer
+	count, err := exporter.NewExporter(cm.DatabaseManager).Export(&file, format, ctx.EffectiveChat.Id, userID, period)
+	if err != nil || count == 0 {
+		log.Warn().Msgf("Nothing to export for user %d in group %d: %v", userID, ctx.EffectiveChat.Id, err)
+		return cm.replyError(b, ctx, "export.empty")
+	}
+
+	caption := cm.translate(ctx, "export.caption", count, cm.MessageRenderer.PeriodLabel(cm.locale(ctx), period))
+	_, err = cm.Messenger.SendDocument(ctx.EffectiveChat.Id, gotgbot.NamedFile{
+		File:     &file,
+		FileName: exporter.FileName(format, period),
+	}, &gotgbot.SendDocumentOpts{
+		Caption:         caption,
+		ReplyParameters: &gotgbot.ReplyParameters{MessageId: ctx.EffectiveMessage.MessageId},
+	})
+	if err != nil {
+		log.Warn().Msgf("Error sending export to user in telegram: %v", err)
+		return cm.replyError(b, ctx, "export.error")
+	}
+
+	return nil
+}
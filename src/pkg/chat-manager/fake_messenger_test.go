@@ -0,0 +1,160 @@
+package chatmanager
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// sent is something the bot sent: a message, a photo or document with its caption,
+// or an edit.
+type sent struct {
+	Kind    string
+	ChatID  int64
+	ReplyTo int64
+	Text    string
+	Markup  gotgbot.ReplyMarkup
+}
+
+// Kinds of what the bot sent.
+const (
+	SENT_MESSAGE  = "message"
+	SENT_PHOTO    = "photo"
+	SENT_DOCUMENT = "document"
+	SENT_EDIT     = "edit"
+)
+
+// fakeMessenger keeps what the handlers send in memory, and serves the files and chat
+// members a test sets up.
+type fakeMessenger struct {
+	lock   sync.Mutex
+	nextID int64
+	sent   []sent
+	// answers are the texts of answered callback queries, empty for a silent answer.
+	answers []string
+	// files are the contents of files by their id, members the status of users in
+	// groups, e.g. "administrator".
+	files   map[string][]byte
+	members map[int64]string
+}
+
+func newFakeMessenger() *fakeMessenger {
+	return &fakeMessenger{
+		nextID:  1000,
+		files:   make(map[string][]byte),
+		members: make(map[int64]string),
+	}
+}
+
+func (f *fakeMessenger) record(item sent) *gotgbot.Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.nextID++
+	f.sent = append(f.sent, item)
+	return &gotgbot.Message{MessageId: f.nextID, Chat: gotgbot.Chat{Id: item.ChatID}, Text: item.Text}
+}
+
+// take returns what was sent since the last call.
+func (f *fakeMessenger) take() []sent {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	items := f.sent
+	f.sent = nil
+	return items
+}
+
+func (f *fakeMessenger) SendMessage(chatID int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error) {
+	item := sent{Kind: SENT_MESSAGE, ChatID: chatID, Text: text}
+	if opts != nil {
+		item.Markup = opts.ReplyMarkup
+		if opts.ReplyParameters != nil {
+			item.ReplyTo = opts.ReplyParameters.MessageId
+		}
+	}
+	return f.record(item), nil
+}
+
+func (f *fakeMessenger) Reply(chatID int64, messageID int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error) {
+	item := sent{Kind: SENT_MESSAGE, ChatID: chatID, ReplyTo: messageID, Text: text}
+	if opts != nil {
+		item.Markup = opts.ReplyMarkup
+	}
+	return f.record(item), nil
+}
+
+func (f *fakeMessenger) SendPhoto(chatID int64, photo gotgbot.InputFile, opts *gotgbot.SendPhotoOpts) (*gotgbot.Message, error) {
+	item := sent{Kind: SENT_PHOTO, ChatID: chatID}
+	if opts != nil {
+		item.Text = opts.Caption
+	}
+	return f.record(item), nil
+}
+
+func (f *fakeMessenger) SendDocument(chatID int64, document gotgbot.InputFile, opts *gotgbot.SendDocumentOpts) (*gotgbot.Message, error) {
+	item := sent{Kind: SENT_DOCUMENT, ChatID: chatID}
+	if opts != nil {
+		item.Text = opts.Caption
+	}
+	return f.record(item), nil
+}
+
+func (f *fakeMessenger) EditMessage(text string, opts *gotgbot.EditMessageTextOpts) error {
+	f.record(sent{Kind: SENT_EDIT, ChatID: opts.ChatId, ReplyTo: opts.MessageId, Text: text, Markup: opts.ReplyMarkup})
+	return nil
+}
+
+func (f *fakeMessenger) EditMarkup(opts *gotgbot.EditMessageReplyMarkupOpts) error {
+	f.record(sent{Kind: SENT_EDIT, ChatID: opts.ChatId, ReplyTo: opts.MessageId, Markup: opts.ReplyMarkup})
+	return nil
+}
+
+func (f *fakeMessenger) AnswerCallbackQuery(queryID string, opts *gotgbot.AnswerCallbackQueryOpts) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	text := ""
+	if opts != nil {
+		text = opts.Text
+	}
+	f.answers = append(f.answers, text)
+	return true, nil
+}
+
+func (f *fakeMessenger) GetChatMember(chatID int64, userID int64) (gotgbot.ChatMember, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch f.members[userID] {
+	case "creator":
+		return gotgbot.ChatMemberOwner{User: gotgbot.User{Id: userID}}, nil
+	case "administrator":
+		return gotgbot.ChatMemberAdministrator{User: gotgbot.User{Id: userID}}, nil
+	}
+	return gotgbot.ChatMemberMember{User: gotgbot.User{Id: userID}}, nil
+}
+
+func (f *fakeMessenger) GetFile(fileID string) (*gotgbot.File, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	data, ok := f.files[fileID]
+	if !ok {
+		return nil, fmt.Errorf("file not found: %s", fileID)
+	}
+	return &gotgbot.File{FileId: fileID, FileSize: int64(len(data)), FilePath: fileID}, nil
+}
+
+func (f *fakeMessenger) Download(file *gotgbot.File) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	data, ok := f.files[file.FileId]
+	if !ok {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
@@ -0,0 +1,589 @@
+package chatmanager
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"run-tracker-telebot/src/pkg/config"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	imageprocessor "run-tracker-telebot/src/pkg/image-processor"
+	"run-tracker-telebot/src/pkg/localizer"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"run-tracker-telebot/src/pkg/stats"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const (
+	TEST_PASSWORD = "secret"
+	TEST_CHAT     = int64(-100)
+	TEST_USER     = int64(42)
+)
+
+// harness drives the handlers of a chat manager with a fresh store, as if the updates
+// came from Telegram, and keeps what they sent in the fake messenger.
+type harness struct {
+	t          *testing.T
+	cm         *ChatManager
+	db         *databasemanager.DatabaseManager
+	messenger  *fakeMessenger
+	dispatcher *ext.Dispatcher
+	nextID     int64
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.Telegram.Token = "123:test"
+	cfg.Auth.Password = TEST_PASSWORD
+	cfg.Storage.DataDir = t.TempDir()
+	cfg.Storage.RoutesDir = filepath.Join(cfg.Storage.DataDir, "routes")
+
+	// An empty store, as LoadData cannot read empty files
+	db := databasemanager.NewDatabaseManager(cfg.Storage)
+	for _, path := range []string{db.FilePath, db.UserFilePath} {
+		if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	if err := db.LoadData(); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	if err := db.LoadSettings(); err != nil {
+		t.Fatalf("LoadSettings: %v", err)
+	}
+	if err := db.LoadUserData(); err != nil {
+		t.Fatalf("LoadUserData: %v", err)
+	}
+
+	messenger := newFakeMessenger()
+	bot := &gotgbot.Bot{User: gotgbot.User{Id: 1, IsBot: true, Username: "run_tracker_test_bot"}}
+	cm := newChatManager(cfg, bot, messenger, db, nil)
+
+	return &harness{t: t, cm: cm, db: db, messenger: messenger, dispatcher: cm.newDispatcher()}
+}
+
+// send handles a text message of TEST_USER in TEST_CHAT, a command when it starts with /,
+// and returns what the bot sent in answer.
+func (h *harness) send(text string) []sent {
+	h.t.Helper()
+
+	h.nextID++
+	msg := &gotgbot.Message{
+		MessageId: h.nextID,
+		Date:      time.Now().Unix(),
+		Chat:      gotgbot.Chat{Id: TEST_CHAT, Type: "group"},
+		From:      &gotgbot.User{Id: TEST_USER, FirstName: "Alice"},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		msg.Entities = []gotgbot.MessageEntity{{Type: "bot_command", Offset: 0, Length: int64(len(strings.Fields(text)[0]))}}
+	}
+
+	return h.process(msg)
+}
+
+// sendDocument handles a file of TEST_USER sent to TEST_CHAT as a document.
+func (h *harness) sendDocument(name string, data []byte) []sent {
+	h.t.Helper()
+
+	h.nextID++
+	fileID := fmt.Sprintf("file-%d", h.nextID)
+	h.messenger.lock.Lock()
+	h.messenger.files[fileID] = data
+	h.messenger.lock.Unlock()
+
+	return h.process(&gotgbot.Message{
+		MessageId: h.nextID,
+		Date:      time.Now().Unix(),
+		Chat:      gotgbot.Chat{Id: TEST_CHAT, Type: "group"},
+		From:      &gotgbot.User{Id: TEST_USER, FirstName: "Alice"},
+		Document:  &gotgbot.Document{FileId: fileID, FileName: name, FileSize: int64(len(data))},
+	})
+}
+
+// sendPhoto handles a screenshot of TEST_USER sent to TEST_CHAT. handleImage saves it
+// in the working directory, the test moves to a temporary one.
+func (h *harness) sendPhoto(data []byte) []sent {
+	h.t.Helper()
+
+	dir := h.t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		h.t.Fatalf("Getwd: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		h.t.Fatalf("Chdir: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	h.nextID++
+	fileID := fmt.Sprintf("photo-%d", h.nextID)
+	h.messenger.lock.Lock()
+	h.messenger.files[fileID] = data
+	h.messenger.lock.Unlock()
+
+	return h.process(&gotgbot.Message{
+		MessageId: h.nextID,
+		Date:      time.Now().Unix(),
+		Chat:      gotgbot.Chat{Id: TEST_CHAT, Type: "group"},
+		From:      &gotgbot.User{Id: TEST_USER, FirstName: "Alice"},
+		Photo:     []gotgbot.PhotoSize{{FileId: fileID, FileUniqueId: fileID, Width: 64, Height: 128}},
+	})
+}
+
+func (h *harness) process(msg *gotgbot.Message) []sent {
+	h.t.Helper()
+
+	err := h.dispatcher.ProcessUpdate(h.cm.Bot, &gotgbot.Update{UpdateId: msg.MessageId, Message: msg}, nil)
+	if err != nil {
+		h.t.Fatalf("ProcessUpdate(%q): %v", msg.Text, err)
+	}
+	return h.messenger.take()
+}
+
+// press handles a tap of TEST_USER on an inline button with data under the bot
+// message messageID, and returns what the bot sent or edited in answer.
+func (h *harness) press(messageID int64, data string) []sent {
+	h.t.Helper()
+
+	h.nextID++
+	query := &gotgbot.CallbackQuery{
+		Id:   fmt.Sprintf("query-%d", h.nextID),
+		From: gotgbot.User{Id: TEST_USER, FirstName: "Alice"},
+		// A value, as decoded from Telegram, or the context has no effective message
+		Message: gotgbot.Message{
+			MessageId: messageID,
+			Date:      time.Now().Unix(),
+			Chat:      gotgbot.Chat{Id: TEST_CHAT, Type: "group"},
+		},
+		Data: data,
+	}
+
+	err := h.dispatcher.ProcessUpdate(h.cm.Bot, &gotgbot.Update{UpdateId: h.nextID, CallbackQuery: query}, nil)
+	if err != nil {
+		h.t.Fatalf("ProcessUpdate(%q): %v", data, err)
+	}
+	return h.messenger.take()
+}
+
+// expect checks that the bot answered text with exactly the catalog message key,
+// rendered with template.
+func (h *harness) expect(text string, template string, key string, args ...interface{}) {
+	h.t.Helper()
+
+	want, err := h.cm.MessageRenderer.Render("en", template, h.cm.Localizer.T("en", key, args...))
+	if err != nil {
+		h.t.Fatalf("Render(%s): %v", key, err)
+	}
+
+	got := h.send(text)
+	if len(got) != 1 || got[0].Text != want {
+		h.t.Fatalf("after %q: got %+v, want one message %q", text, got, want)
+	}
+	if got[0].ChatID != TEST_CHAT {
+		h.t.Errorf("after %q: sent to chat %d, want %d", text, got[0].ChatID, TEST_CHAT)
+	}
+}
+
+// authorize saves TEST_USER as name, as if they went through /start.
+func (h *harness) authorize(name string) {
+	h.t.Helper()
+
+	if err := h.db.SaveUser(name, TEST_USER); err != nil {
+		h.t.Fatalf("SaveUser: %v", err)
+	}
+}
+
+// addWorkout logs a workout of TEST_USER in TEST_CHAT.
+func (h *harness) addWorkout(date time.Time, distance string) {
+	h.t.Helper()
+
+	h.db.Data.Lock()
+	defer h.db.Data.Unlock()
+
+	details := map[string]string{"Distance": distance, "Pace": "6:00"}
+	if !h.db.InsertWorkoutEntry(TEST_CHAT, TEST_USER, date.Format(stats.DATE_LAYOUT), details) {
+		h.t.Fatalf("InsertWorkoutEntry(%s, %s) failed", date, distance)
+	}
+}
+
+func TestOnboarding(t *testing.T) {
+	h := newHarness(t)
+
+	h.expect("/start", messagerenderer.TEMPLATE_TEXT, "start.ask_password")
+	h.expect("hunter2", messagerenderer.TEMPLATE_ERROR, "auth.invalid_password")
+	h.expect(TEST_PASSWORD, messagerenderer.TEMPLATE_TEXT, "auth.password_valid")
+
+	got := h.send("Alice")
+	if len(got) == 0 || !strings.Contains(got[0].Text, "Alice") {
+		t.Fatalf("welcome: got %+v, want a welcome naming Alice", got)
+	}
+	if got[0].ReplyTo != h.nextID {
+		t.Errorf("welcome: replied to %d, want %d", got[0].ReplyTo, h.nextID)
+	}
+	if !h.db.IsAuthorizedUser(TEST_USER) {
+		t.Errorf("user is not authorized after onboarding")
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	h := newHarness(t)
+
+	h.expect("/getdistance", messagerenderer.TEMPLATE_ERROR, "auth.unauthorized")
+	h.expect("/delete", messagerenderer.TEMPLATE_ERROR, "auth.unauthorized")
+}
+
+func TestGetDistance(t *testing.T) {
+	h := newHarness(t)
+	h.authorize("Alice")
+
+	today := time.Now().UTC()
+	h.addWorkout(today, "5.25")
+	h.addWorkout(today.AddDate(-1, 0, 0), "42.00")
+
+	h.expect("/getdistance", messagerenderer.TEMPLATE_TEXT, "distance.ask_duration")
+	h.expect("YEAR", messagerenderer.TEMPLATE_ERROR, "distance.invalid_duration")
+	h.expect("MONTH", messagerenderer.TEMPLATE_TEXT, "distance.ask_month")
+
+	got := h.send("this month")
+	if len(got) != 1 || !strings.Contains(got[0].Text, "Alice: 5.25KM") {
+		t.Fatalf("totals: got %+v, want Alice with 5.25KM", got)
+	}
+
+	got = h.send("/getdistance this week")
+	if len(got) != 1 || !strings.Contains(got[0].Text, "Alice: 5.25KM") {
+		t.Fatalf("/getdistance this week: got %+v, want Alice with 5.25KM", got)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	h := newHarness(t)
+	h.authorize("Alice")
+
+	h.expect("/getdistance", messagerenderer.TEMPLATE_TEXT, "distance.ask_duration")
+	h.expect("/cancel", messagerenderer.TEMPLATE_TEXT, "cancel.goodbye")
+
+	if got := h.send("MONTH"); len(got) != 0 {
+		t.Errorf("after /cancel: got %+v, want no answer", got)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	h := newHarness(t)
+	h.authorize("Alice")
+	h.addWorkout(time.Now().UTC(), "10.00")
+
+	h.expect("/delete", messagerenderer.TEMPLATE_TEXT, "delete.ask_date")
+	h.expect("someday", messagerenderer.TEMPLATE_ERROR, "delete.invalid_date")
+	h.expect("today", messagerenderer.TEMPLATE_TEXT, "delete.deleted")
+
+	if h.db.HasWorkout(TEST_CHAT, TEST_USER, time.Now().UTC().Format(stats.DATE_LAYOUT)) {
+		t.Errorf("workout still logged after /delete")
+	}
+
+	h.expect("/delete", messagerenderer.TEMPLATE_TEXT, "delete.ask_date")
+	h.expect("today", messagerenderer.TEMPLATE_ERROR, "delete.not_found")
+}
+
+// buttons returns the callback data of the inline buttons of a sent message.
+func buttons(item sent) []string {
+	var data []string
+	if markup, ok := item.Markup.(gotgbot.InlineKeyboardMarkup); ok {
+		for _, row := range markup.InlineKeyboard {
+			for _, button := range row {
+				data = append(data, button.CallbackData)
+			}
+		}
+	}
+	return data
+}
+
+func TestHistoryPaging(t *testing.T) {
+	h := newHarness(t)
+	h.authorize("Alice")
+
+	today := time.Now().UTC()
+	for days := 0; days < HISTORY_PAGE_SIZE+2; days++ {
+		h.addWorkout(today.AddDate(0, 0, -days), "5.00")
+	}
+	h.addWorkout(today.AddDate(0, -3, 0), "42.00")
+
+	// The raw filter alone would not fit into the callback data.
+	from := today.AddDate(0, 0, -40)
+	filter := fmt.Sprintf("from %d %s %d to %d %s %d", from.Day(), strings.ToLower(from.Month().String()), from.Year(),
+		today.Day(), strings.ToLower(today.Month().String()), today.Year())
+	got := h.send("/historyUser " + filter)
+	if len(got) != 1 {
+		t.Fatalf("/historyUser %s: got %+v, want one message", filter, got)
+	}
+
+	var next string
+	for _, data := range buttons(got[0]) {
+		if len(data) > MAX_CALLBACK_DATA {
+			t.Errorf("callback data %q is longer than %d bytes", data, MAX_CALLBACK_DATA)
+		}
+		if strings.HasPrefix(data, HISTORY_CALLBACK+":") {
+			next = data
+		}
+	}
+	if next == "" {
+		t.Fatalf("/historyUser %s: no page button in %+v", filter, got[0].Markup)
+	}
+
+	got = h.press(1, next)
+	if len(got) != 1 || got[0].Kind != SENT_EDIT {
+		t.Fatalf("next page: got %+v, want one edit", got)
+	}
+	if !strings.Contains(got[0].Text, "2/2") || strings.Contains(got[0].Text, "42.00") {
+		t.Errorf("next page: got %q, want the last page without the workout outside the filter", got[0].Text)
+	}
+}
+
+func TestHistoryFilterEncoding(t *testing.T) {
+	now := time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+
+	mr := messagerenderer.NewMessageRenderer(localizer.NewLocalizer())
+
+	for _, input := range []string{"", "last 5", "last week", "2024-05-03", "all", "may 2024", "from december 31 2023 to may 14 2024"} {
+		filter, err := parseHistoryFilter(input, now, stats.DEFAULT_WEEK_START)
+		if err != nil {
+			t.Fatalf("parseHistoryFilter(%q): %v", input, err)
+		}
+
+		decoded, err := decodeHistoryFilter(filter.encode())
+		if err != nil {
+			t.Fatalf("decodeHistoryFilter(%q): %v", filter.encode(), err)
+		}
+		if decoded.Last != filter.Last || decoded.label(mr, "de") != filter.label(mr, "de") {
+			t.Errorf("%q: decoded %q as %+v, want %+v", input, filter.encode(), decoded, filter)
+		}
+		if (decoded.Period == nil) != (filter.Period == nil) ||
+			decoded.Period != nil && (!decoded.Period.Start.Equal(filter.Period.Start) || !decoded.Period.End.Equal(filter.Period.End)) {
+			t.Errorf("%q: decoded period %+v, want %+v", input, decoded.Period, filter.Period)
+		}
+	}
+
+	for _, data := range []string{"l0", "lx", "20240501", "x-20240501", "20240501-"} {
+		if _, err := decodeHistoryFilter(data); err == nil {
+			t.Errorf("decodeHistoryFilter(%q) succeeded, want error", data)
+		}
+	}
+
+	// Titles in the language of the user
+	for input, want := range map[string]string{"last 5": "letzte 5", "all": mr.Localizer.T("de", "period.all")} {
+		filter, _ := parseHistoryFilter(input, now, stats.DEFAULT_WEEK_START)
+		if got := filter.label(mr, "de"); got != want {
+			t.Errorf("label of %q = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestActivityFileRoute(t *testing.T) {
+	h := newHarness(t)
+	h.authorize("Alice")
+
+	gpx, err := os.ReadFile(filepath.Join("..", "activity-file", "testdata", "run.gpx"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	got := h.sendDocument("run.gpx", gpx)
+	if len(got) != 1 || got[0].Kind != SENT_PHOTO {
+		t.Fatalf("run.gpx: got %+v, want the route map", got)
+	}
+	workouts, _ := h.db.GetUserWorkouts(TEST_CHAT, TEST_USER)
+	route := workouts["2024-05-01"].Route
+	if route == "" || !h.db.HasRoute(route) {
+		t.Fatalf("run.gpx: route %q not saved", route)
+	}
+
+	// The same file again is a duplicate, skipping it must not touch the saved route
+	if err := os.Remove(filepath.Join(h.db.RoutesDir, route)); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	got = h.sendDocument("run.gpx", gpx)
+	if len(got) != 1 {
+		t.Fatalf("run.gpx again: got %+v, want the duplicate question", got)
+	}
+	var skip string
+	for _, data := range buttons(got[0]) {
+		if strings.HasSuffix(data, ":"+DUPLICATE_SKIP) {
+			skip = data
+		}
+	}
+	if skip == "" {
+		t.Fatalf("run.gpx again: no skip button in %+v", got[0])
+	}
+	h.press(got[0].ReplyTo, skip)
+	if h.db.HasRoute(route) {
+		t.Errorf("skipping the duplicate wrote the route map")
+	}
+}
+
+// AMBIGUOUS_TEXT is a RunKeeper summary read without the labels of pace and time, and
+// without a time that would tell which clock is the pace.
+const AMBIGUOUS_TEXT = `Running
+5.02 km
+6:00
+6:30`
+
+func TestPickCandidates(t *testing.T) {
+	h := newHarness(t)
+	h.authorize("Alice")
+
+	// Whatever OCR reads, the screenshot shows AMBIGUOUS_TEXT
+	ip := &imageprocessor.ImageProcessor{}
+	ip.Parsers = []imageprocessor.Parser{{
+		Name:    imageprocessor.PARSER_RUNKEEPER,
+		Matches: func(text string) bool { return true },
+		Parse: func(text string) (*imageprocessor.ParseResult, error) {
+			return ip.ParseRunKeepWorkoutDetails(AMBIGUOUS_TEXT)
+		},
+	}}
+	h.cm.ImageProcessor = ip
+
+	var screenshot bytes.Buffer
+	if err := png.Encode(&screenshot, image.NewGray(image.Rect(0, 0, 64, 128))); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	got := h.sendPhoto(screenshot.Bytes())
+	if len(got) != 1 {
+		t.Fatalf("screenshot: got %+v, want the pace question", got)
+	}
+	screenshotID := h.nextID
+	want := []string{
+		fmt.Sprintf("%s:%d:0", PICK_CALLBACK, screenshotID),
+		fmt.Sprintf("%s:%d:1", PICK_CALLBACK, screenshotID),
+		fmt.Sprintf("%s:%d:%s", PICK_CALLBACK, screenshotID, PICK_CANCEL),
+	}
+	if data := buttons(got[0]); strings.Join(data, " ") != strings.Join(want, " ") {
+		t.Fatalf("pace question buttons = %v, want %v", data, want)
+	}
+
+	// A candidate that is not offered leaves the question open
+	h.press(got[0].ReplyTo, fmt.Sprintf("%s:%d:7", PICK_CALLBACK, screenshotID))
+	if workouts, _ := h.db.GetUserWorkouts(TEST_CHAT, TEST_USER); len(workouts) != 0 {
+		t.Fatalf("logged %v after picking a candidate out of range", workouts)
+	}
+
+	h.press(got[0].ReplyTo, want[1])
+	workouts, _ := h.db.GetUserWorkouts(TEST_CHAT, TEST_USER)
+	workout, ok := workouts[time.Now().UTC().Format(stats.DATE_LAYOUT)]
+	if !ok || workout.Distance != "5.02" || workout.Pace != "6:30" || workout.Confidence != 0.54 {
+		t.Errorf("logged %+v, want 5.02 km at the picked 6:30 with 0.6 times the 0.9 of the distance", workouts)
+	}
+
+	// The question is settled, a second tap cannot log twice
+	h.press(got[0].ReplyTo, want[0])
+	expired := h.cm.Localizer.T("en", "pick.expired")
+	h.messenger.lock.Lock()
+	answers := h.messenger.answers
+	h.messenger.lock.Unlock()
+	if len(answers) != 3 || answers[0] != expired || answers[2] != expired {
+		t.Errorf("callback answers = %q, want the first and last taps expired", answers)
+	}
+	if workouts, _ := h.db.GetUserWorkouts(TEST_CHAT, TEST_USER); workouts[time.Now().UTC().Format(stats.DATE_LAYOUT)].Pace != "6:30" {
+		t.Errorf("a second tap changed the workout to %+v", workouts)
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   string
+		offset int
+	}{
+		{"Asia/Singapore", "Asia/Singapore", 8 * 3600},
+		{"auto", "", 0},
+		{"gmt", "UTC", 0},
+		{"UTC+8", "UTC+08:00", 8 * 3600},
+		{"utc-3", "UTC-03:00", -3 * 3600},
+		{"GMT+0", "UTC", 0},
+		{"UTC+5:30", "UTC+05:30", 5*3600 + 30*60},
+		{"+0545", "UTC+05:45", 5*3600 + 45*60},
+		{"UTC -09:30", "UTC-09:30", -(9*3600 + 30*60)},
+		{"UTC+14", "UTC+14:00", 14 * 3600},
+		// What is saved loads again
+		{"UTC+05:30", "UTC+05:30", 5*3600 + 30*60},
+		// Saved before offsets were fixed zones
+		{"Etc/GMT-8", "Etc/GMT-8", 8 * 3600},
+	}
+
+	for _, test := range tests {
+		got, err := parseTimezone(test.input)
+		if err != nil || got != test.want {
+			t.Errorf("parseTimezone(%q) = %q, %v, want %q", test.input, got, err, test.want)
+			continue
+		}
+		if got == "" {
+			continue
+		}
+		location, err := loadLocation(got)
+		if err != nil {
+			t.Errorf("loadLocation(%q): %v", got, err)
+			continue
+		}
+		if _, offset := time.Date(2024, 1, 15, 12, 0, 0, 0, location).Zone(); offset != test.offset {
+			t.Errorf("%q is %ds off UTC, want %d", got, offset, test.offset)
+		}
+	}
+
+	for _, input := range []string{"Mars/Olympus", "Local", "UTC+5:15", "UTC+15", "UTC+14:30", "+5:3", "8", "UTC+"} {
+		if got, err := parseTimezone(input); err == nil {
+			t.Errorf("parseTimezone(%q) = %q, want error", input, got)
+		}
+	}
+}
+
+// TestSaveWorkoutConcurrently logs runs of one day in several groups at the same time,
+// only as many as fit under the daily distance cap may pass unflagged.
+func TestSaveWorkoutConcurrently(t *testing.T) {
+	h := newHarness(t)
+	const groups = 8
+	date := time.Now().Format(stats.DATE_LAYOUT)
+
+	var wg sync.WaitGroup
+	for i := 0; i < groups; i++ {
+		ctx := ext.NewContext(&gotgbot.Update{Message: &gotgbot.Message{
+			MessageId: int64(i + 1),
+			Chat:      gotgbot.Chat{Id: TEST_CHAT - int64(i), Type: "group"},
+			From:      &gotgbot.User{Id: TEST_USER, FirstName: "Alice"},
+		}}, nil)
+		// Distances differ, the same run posted to several groups counts once
+		details := map[string]string{"Distance": fmt.Sprintf("%d.00", 51+i), "Pace": "6:00"}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.cm.saveWorkout(h.cm.Bot, ctx, date, details, nil); err != nil {
+				t.Errorf("saveWorkout: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	unflagged := 0
+	for i := 0; i < groups; i++ {
+		workouts, err := h.db.GetUserWorkouts(TEST_CHAT-int64(i), TEST_USER)
+		if err != nil || len(workouts) != 1 {
+			t.Fatalf("group %d: %d workouts, %v, want 1", i, len(workouts), err)
+		}
+		if workouts[date].Review != databasemanager.REVIEW_FLAGGED {
+			unflagged++
+		}
+	}
+	// Any two runs of 51 km and more are over the default cap of 100 km
+	if unflagged != 1 {
+		t.Errorf("%d of %d runs unflagged, want 1", unflagged, groups)
+	}
+}
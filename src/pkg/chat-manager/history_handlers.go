@@ -0,0 +1,409 @@
+package chatmanager
+
+import (
+	"bytes"
+	"fmt"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"run-tracker-telebot/src/pkg/stats"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const (
+	HISTORY_CALLBACK  = "hist"
+	HISTORY_USER      = "u"
+	HISTORY_ALL       = "a"
+	HISTORY_PAGE_SIZE = 10
+
+	ROUTE_CALLBACK = "route"
+	// Route buttons per row of the history keyboard.
+	ROUTE_BUTTONS_PER_ROW = 2
+
+	// Telegram rejects the whole message if any callback_data is longer than this.
+	MAX_CALLBACK_DATA = 64
+	// Compact date layout of the filter in the callback data.
+	CALLBACK_DATE_LAYOUT = "20060102"
+)
+
+type historyItem struct {
+	UserID int64
+	Date   string
+	Entry  databasemanager.WorkoutEntry
+}
+
+// historyFilter narrows down the history either to a period or to the most recent entries.
+type historyFilter struct {
+	Period *stats.Period
+	Last   int
+}
+
+func parseHistoryFilter(input string, now time.Time, weekStart time.Weekday) (historyFilter, error) {
+	input = strings.ToLower(strings.TrimSpace(input))
+	filter := historyFilter{}
+	if input == "" {
+		return filter, nil
+	}
+
+	// "last 5" are the most recent entries, "last week" is a period
+	if fields := strings.Fields(input); len(fields) == 2 && fields[0] == "last" {
+		if last, err := strconv.Atoi(fields[1]); err == nil {
+			if last <= 0 {
+				return filter, fmt.Errorf("invalid number of entries: %q", fields[1])
+			}
+			filter.Last = last
+			return filter, nil
+		}
+	}
+
+	period, err := stats.ParsePeriod(input, now, weekStart)
+	if err != nil {
+		return filter, err
+	}
+	filter.Period = &period
+
+	return filter, nil
+}
+
+// encode packs the parsed filter into the callback data of the page buttons, the raw
+// user input can be too long for it: "l<N>" for the last entries and
+// "<start>-<end>" for a period, with an empty start for "all time".
+func (f historyFilter) encode() string {
+	switch {
+	case f.Last > 0:
+		return "l" + strconv.Itoa(f.Last)
+	case f.Period != nil:
+		start := ""
+		if !f.Period.Start.IsZero() {
+			start = f.Period.Start.Format(CALLBACK_DATE_LAYOUT)
+		}
+		return start + "-" + f.Period.End.Format(CALLBACK_DATE_LAYOUT)
+	}
+	return ""
+}
+
+func decodeHistoryFilter(data string) (historyFilter, error) {
+	filter := historyFilter{}
+	if data == "" {
+		return filter, nil
+	}
+
+	if strings.HasPrefix(data, "l") {
+		last, err := strconv.Atoi(data[1:])
+		if err != nil || last <= 0 {
+			return filter, fmt.Errorf("invalid number of entries: %q", data)
+		}
+		filter.Last = last
+		return filter, nil
+	}
+
+	startData, endData, found := strings.Cut(data, "-")
+	if !found {
+		return filter, fmt.Errorf("invalid filter: %q", data)
+	}
+	end, err := time.Parse(CALLBACK_DATE_LAYOUT, endData)
+	if err != nil {
+		return filter, fmt.Errorf("invalid filter end: %w", err)
+	}
+
+	period := stats.Period{Name: stats.PERIOD_ALL, End: end}
+	if startData != "" {
+		period.Start, err = time.Parse(CALLBACK_DATE_LAYOUT, startData)
+		if err != nil {
+			return filter, fmt.Errorf("invalid filter start: %w", err)
+		}
+		period.Name = stats.PERIOD_RANGE
+		if end.Equal(period.Start.AddDate(0, 0, 1)) {
+			period.Name = stats.PERIOD_DAY
+		}
+	}
+	filter.Period = &period
+
+	return filter, nil
+}
+
+// label describes the filter in the history title, the same on every page.
+func (f historyFilter) label(mr *messagerenderer.MessageRenderer, locale string) string {
+	switch {
+	case f.Last > 0:
+		return mr.Localizer.T(locale, "history.last", f.Last)
+	case f.Period != nil:
+		// Later pages decode a month as the range of its days
+		period := *f.Period
+		if period.Name == stats.PERIOD_MONTH {
+			period.Name = stats.PERIOD_RANGE
+		}
+		return mr.PeriodLabel(locale, period)
+	}
+	return ""
+}
+
+// apply sorts the items newest first and drops the ones the filter excludes.
+func (f historyFilter) apply(items []historyItem, usernames func(int64) string) []historyItem {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Date != items[j].Date {
+			return items[i].Date > items[j].Date
+		}
+		return usernames(items[i].UserID) < usernames(items[j].UserID)
+	})
+
+	if f.Period != nil {
+		filtered := items[:0]
+		for _, item := range items {
+			date, err := time.Parse(stats.DATE_LAYOUT, item.Date)
+			if err == nil && f.Period.Contains(date) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	if f.Last > 0 && len(items) > f.Last {
+		items = items[:f.Last]
+	}
+
+	return items
+}
+
+func (cm *ChatManager) handleUserHistory(b *gotgbot.Bot, ctx *ext.Context) error {
+	return cm.replyHistory(b, ctx, HISTORY_USER, ctx.EffectiveUser.Id)
+}
+
+func (cm *ChatManager) handleAllHistory(b *gotgbot.Bot, ctx *ext.Context) error {
+	return cm.replyHistory(b, ctx, HISTORY_ALL, 0)
+}
+
+func (cm *ChatManager) replyHistory(b *gotgbot.Bot, ctx *ext.Context, scope string, userID int64) error {
+	filter, err := parseHistoryFilter(commandArgs(ctx.EffectiveMessage.Text), cm.now(ctx), cm.weekStart(ctx))
+	if err != nil {
+		log.Warn().Msgf("Invalid history filter: %v", err)
+		return cm.replyError(b, ctx, "history.invalid_filter", cm.translate(ctx, "history.usage"))
+	}
+
+	history, keyboard, err := cm.historyPage(ctx, scope, userID, 0, filter)
+	if err != nil {
+		log.Warn().Msgf("Error getting workout history for chat %d: %v", ctx.EffectiveChat.Id, err)
+		return cm.replyError(b, ctx, "history.empty")
+	}
+
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_HISTORY, history, keyboard)
+}
+
+// handleHistoryPage handles the Prev/Next buttons, with callback data
+// "hist:<scope>:<userID>:<page>:<filter>", by editing the message in place.
+func (cm *ChatManager) handleHistoryPage(b *gotgbot.Bot, ctx *ext.Context) error {
+	query := ctx.CallbackQuery
+
+	parts := strings.SplitN(query.Data, ":", 5)
+	if len(parts) != 5 {
+		log.Warn().Msgf("Invalid history callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	userID, errUser := strconv.ParseInt(parts[2], 10, 64)
+	page, errPage := strconv.Atoi(parts[3])
+	filter, errFilter := decodeHistoryFilter(parts[4])
+	if errUser != nil || errPage != nil || errFilter != nil {
+		log.Warn().Msgf("Invalid history callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	history, keyboard, err := cm.historyPage(ctx, parts[1], userID, page, filter)
+	if err != nil {
+		log.Warn().Msgf("Error getting workout history for chat %d: %v", ctx.EffectiveChat.Id, err)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "history.gone")})
+		return err
+	}
+
+	cm.edit(b, ctx, query.Message.GetMessageId(), messagerenderer.TEMPLATE_HISTORY, history, keyboard)
+
+	_, err = cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+	return err
+}
+
+// handleRouteThumbnail sends the route map thumbnail of a workout for the buttons
+// under the history, with callback data "route:<userID>:<date>".
+func (cm *ChatManager) handleRouteThumbnail(b *gotgbot.Bot, ctx *ext.Context) error {
+	query := ctx.CallbackQuery
+
+	parts := strings.SplitN(query.Data, ":", 3)
+	if len(parts) != 3 {
+		log.Warn().Msgf("Invalid route callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		log.Warn().Msgf("Invalid route callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+	date := parts[2]
+
+	var thumbnail []byte
+	userWorkouts, err := cm.DatabaseManager.GetUserWorkouts(ctx.EffectiveChat.Id, userID)
+	if err == nil && userWorkouts[date].Route != "" {
+		thumbnail, err = cm.DatabaseManager.LoadRoute(userWorkouts[date].Route)
+	}
+	if err != nil || thumbnail == nil {
+		log.Warn().Msgf("No route map for user %d on %s: %v", userID, date, err)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "history.route_gone")})
+		return err
+	}
+
+	_, err = cm.Messenger.SendPhoto(ctx.EffectiveChat.Id, gotgbot.NamedFile{
+		File:     bytes.NewReader(thumbnail),
+		FileName: "route.png",
+	}, &gotgbot.SendPhotoOpts{
+		Caption: cm.translate(ctx, "history.route_caption", cm.usernameOrId(userID), date),
+		ReplyParameters: &gotgbot.ReplyParameters{
+			MessageId:                query.Message.GetMessageId(),
+			AllowSendingWithoutReply: true,
+		},
+	})
+	if err != nil {
+		log.Warn().Msgf("Error sending route map to user in telegram: %v", err)
+		return err
+	}
+
+	_, err = cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+	return err
+}
+
+func (cm *ChatManager) historyItems(chatID int64, scope string, userID int64) ([]historyItem, error) {
+	var items []historyItem
+
+	if scope == HISTORY_USER {
+		userWorkouts, err := cm.DatabaseManager.GetUserWorkouts(chatID, userID)
+		if err != nil {
+			return nil, err
+		}
+		for date, entry := range userWorkouts {
+			items = append(items, historyItem{UserID: userID, Date: date, Entry: entry})
+		}
+		return items, nil
+	}
+
+	groupWorkouts, err := cm.DatabaseManager.GetAllWorkouts(chatID)
+	if err != nil {
+		return nil, err
+	}
+	for id, dates := range groupWorkouts {
+		for date, entry := range dates {
+			items = append(items, historyItem{UserID: id, Date: date, Entry: entry})
+		}
+	}
+
+	return items, nil
+}
+
+func (cm *ChatManager) historyPage(ctx *ext.Context, scope string, userID int64, page int, filter historyFilter) (messagerenderer.History, gotgbot.InlineKeyboardMarkup, error) {
+	chatID := ctx.EffectiveChat.Id
+	keyboard := gotgbot.InlineKeyboardMarkup{InlineKeyboard: [][]gotgbot.InlineKeyboardButton{}}
+
+	items, err := cm.historyItems(chatID, scope, userID)
+	if err != nil {
+		return messagerenderer.History{}, keyboard, err
+	}
+	if len(items) == 0 {
+		return messagerenderer.History{}, keyboard, fmt.Errorf("no workouts found for chat: %v", chatID)
+	}
+
+	items = filter.apply(items, cm.usernameOrId)
+
+	pages := (len(items) + HISTORY_PAGE_SIZE - 1) / HISTORY_PAGE_SIZE
+	if pages == 0 {
+		pages = 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+
+	history := messagerenderer.History{Page: page, Pages: pages}
+	if scope == HISTORY_USER {
+		history.Title = cm.translate(ctx, "history.user_title", cm.usernameOrId(userID))
+	} else {
+		history.Title = cm.translate(ctx, "history.group_title")
+	}
+	if label := filter.label(cm.MessageRenderer, cm.locale(ctx)); label != "" {
+		history.Title += fmt.Sprintf(" (%s)", label)
+	}
+
+	end := (page + 1) * HISTORY_PAGE_SIZE
+	if end > len(items) {
+		end = len(items)
+	}
+	var routeButtons []gotgbot.InlineKeyboardButton
+	for _, item := range items[page*HISTORY_PAGE_SIZE : end] {
+		workout := messagerenderer.Workout{
+			Date:     item.Date,
+			Type:     item.Entry.Type,
+			Distance: item.Entry.Distance,
+			Pace:     item.Entry.Pace,
+			HasRoute: item.Entry.Route != "",
+			Flagged:  !item.Entry.Counted(),
+		}
+		label := item.Date
+		if scope == HISTORY_ALL {
+			workout.Name = cm.usernameOrId(item.UserID)
+			label = workout.Name + " " + item.Date
+		}
+		history.Workouts = append(history.Workouts, workout)
+
+		if workout.HasRoute {
+			routeButtons = append(routeButtons, gotgbot.InlineKeyboardButton{
+				Text:         cm.translate(ctx, "history.route", label),
+				CallbackData: fmt.Sprintf("%s:%d:%s", ROUTE_CALLBACK, item.UserID, item.Date),
+			})
+		}
+	}
+	for len(routeButtons) > 0 {
+		row := routeButtons
+		if len(row) > ROUTE_BUTTONS_PER_ROW {
+			row = row[:ROUTE_BUTTONS_PER_ROW]
+		}
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+		routeButtons = routeButtons[len(row):]
+	}
+
+	var buttons []gotgbot.InlineKeyboardButton
+	callbackData := func(page int) string {
+		return fmt.Sprintf("%s:%s:%d:%d:%s", HISTORY_CALLBACK, scope, userID, page, filter.encode())
+	}
+	if len(callbackData(pages)) > MAX_CALLBACK_DATA {
+		// Never happens with the compact filter, but a failing button must not cost the reply.
+		log.Warn().Msgf("History callback data too long: %s", callbackData(pages))
+		return history, keyboard, nil
+	}
+	if page > 0 {
+		buttons = append(buttons, gotgbot.InlineKeyboardButton{Text: cm.translate(ctx, "history.prev"), CallbackData: callbackData(page - 1)})
+	}
+	if page < pages-1 {
+		buttons = append(buttons, gotgbot.InlineKeyboardButton{Text: cm.translate(ctx, "history.next"), CallbackData: callbackData(page + 1)})
+	}
+	if len(buttons) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, buttons)
+	}
+
+	return history, keyboard, nil
+}
+
+// usernameOrId falls back to the raw id for users that never onboarded.
+func (cm *ChatManager) usernameOrId(userID int64) string {
+	username, err := cm.DatabaseManager.GetUsernameFromId(userID)
+	if err != nil {
+		return strconv.FormatInt(userID, 10)
+	}
+	return username
+}
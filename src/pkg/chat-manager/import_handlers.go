@@ -0,0 +1,130 @@
+package chatmanager
+
+import (
+	"fmt"
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/importer"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
+)
+
+const (
+	IMPORT = "import"
+
+	// Bots can't download files larger than this from Telegram.
+	MAX_DOWNLOAD_SIZE = 20 * 1024 * 1024
+
+	// The report lists this many failed, flagged or skipped rows, and only counts the rest.
+	IMPORT_MAX_ISSUES = 20
+)
+
+// handleWelcomeImport asks for the file to import, unless it was sent along with
+// /import as the caption.
+func (cm *ChatManager) handleWelcomeImport(b *gotgbot.Bot, ctx *ext.Context) error {
+	if ctx.EffectiveMessage.Document != nil {
+		return cm.handleImportFile(b, ctx)
+	}
+
+	err := cm.replyText(b, ctx, "import.ask_file")
+	if err != nil {
+		return err
+	}
+
+	return handlers.NextConversationState(IMPORT)
+}
+
+// handleImportFile imports the workouts of an uploaded CSV or zip archive and reports
+// what happened to every row.
+func (cm *ChatManager) handleImportFile(b *gotgbot.Bot, ctx *ext.Context) error {
+	document := ctx.EffectiveMessage.Document
+	if document.FileSize > MAX_DOWNLOAD_SIZE {
+		err := cm.replyError(b, ctx, "import.too_large")
+		if err != nil {
+			return err
+		}
+		return handlers.EndConversation()
+	}
+
+	data, err := cm.fetchFile(document.FileId)
+	if err != nil {
+		log.Warn().Msgf("Error downloading import file: %v", err)
+		err := cm.replyError(b, ctx, "import.error")
+		if err != nil {
+			return err
+		}
+		return handlers.EndConversation()
+	}
+
+	result, err := importer.NewImporter(cm.DatabaseManager).ImportFile(data, ctx.EffectiveChat.Id, ctx.EffectiveUser.Id, cm.location(ctx))
+	if err != nil {
+		log.Warn().Msgf("Error importing %s: %v", document.FileName, err)
+		err := cm.replyError(b, ctx, "import.unknown_format")
+		if err != nil {
+			return err
+		}
+		return handlers.EndConversation()
+	}
+
+	err = cm.reply(b, ctx, messagerenderer.TEMPLATE_IMPORT_REPORT, cm.importReport(ctx, result), nil)
+	if err != nil {
+		return err
+	}
+
+	return handlers.EndConversation()
+}
+
+// handleImportNotFile reminds the user that a file is expected.
+func (cm *ChatManager) handleImportNotFile(b *gotgbot.Bot, ctx *ext.Context) error {
+	err := cm.replyError(b, ctx, "import.not_a_file")
+	if err != nil {
+		return err
+	}
+
+	return handlers.NextConversationState(IMPORT)
+}
+
+func (cm *ChatManager) importReport(ctx *ext.Context, result importer.Result) messagerenderer.ImportReport {
+	report := messagerenderer.ImportReport{
+		Source:   cm.translate(ctx, "import.format."+result.Format),
+		Imported: result.Imported,
+		Skipped:  len(result.Skipped),
+		Failed:   len(result.Failed),
+	}
+
+	issues := append(append(result.Failed, result.Flagged...), result.Skipped...)
+	for _, issue := range issues {
+		if len(report.Issues) == IMPORT_MAX_ISSUES {
+			report.More++
+			continue
+		}
+		report.Issues = append(report.Issues, messagerenderer.ImportIssue{
+			Row:    issue.Row,
+			Reason: cm.importReason(ctx, issue),
+		})
+	}
+
+	return report
+}
+
+// importReason explains an issue, flagged rows with the checks they failed.
+func (cm *ChatManager) importReason(ctx *ext.Context, issue importer.Issue) string {
+	detail := issue.Detail
+	if issue.Reason == importer.REASON_FLAGGED {
+		detail = strings.Join(cm.flagReasons(ctx, strings.Split(issue.Detail, ",")), ", ")
+	}
+	return cm.translate(ctx, "import.reason."+issue.Reason, detail)
+}
+
+// fetchFile downloads a file sent to the bot into memory.
+func (cm *ChatManager) fetchFile(fileID string) ([]byte, error) {
+	file, err := cm.Messenger.GetFile(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file: %w", err)
+	}
+
+	return cm.Messenger.Download(file)
+}
@@ -0,0 +1,137 @@
+package chatmanager
+
+import (
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const LANGUAGE_CALLBACK = "lang"
+
+// Arguments shared by the settings commands: "auto" clears a setting, "group" sets it
+// for the whole group instead of the user.
+const (
+	SETTING_AUTO  = "auto"
+	SETTING_GROUP = "group"
+)
+
+// handleLanguage shows the current language with a button per locale, or changes it:
+// "/language de", "/language auto" for the user, "/language group de" for the group.
+func (cm *ChatManager) handleLanguage(b *gotgbot.Bot, ctx *ext.Context) error {
+	args := strings.Fields(strings.ToLower(commandArgs(ctx.EffectiveMessage.Text)))
+
+	switch {
+	case len(args) == 0:
+		return cm.reply(b, ctx, messagerenderer.TEMPLATE_TEXT, cm.languageText(ctx), cm.languageKeyboard())
+	case len(args) == 2 && args[0] == SETTING_GROUP:
+		return cm.setGroupLanguage(b, ctx, args[1])
+	case len(args) == 1:
+		return cm.setUserLanguage(b, ctx, args[0])
+	default:
+		return cm.replyError(b, ctx, "language.unknown", strings.Join(args, " "), cm.availableLanguages())
+	}
+}
+
+// handleLanguageChoice handles the buttons of /language, with callback data "lang:<locale>".
+func (cm *ChatManager) handleLanguageChoice(b *gotgbot.Bot, ctx *ext.Context) error {
+	query := ctx.CallbackQuery
+
+	locale := strings.TrimPrefix(query.Data, LANGUAGE_CALLBACK+":")
+	if !cm.Localizer.Supported(locale) {
+		log.Warn().Msgf("Invalid language callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	err := cm.DatabaseManager.UpdateUserSettings(ctx.EffectiveUser.Id, func(settings *databasemanager.UserSettings) {
+		settings.Language = locale
+	})
+	if err != nil {
+		log.Warn().Msgf("Error saving language of user %d: %v", ctx.EffectiveUser.Id, err)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "language.error")})
+		return err
+	}
+
+	cm.edit(b, ctx, query.Message.GetMessageId(), messagerenderer.TEMPLATE_TEXT, cm.languageText(ctx), cm.languageKeyboard())
+
+	_, err = cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "language.set", cm.Localizer.Name(locale))})
+	return err
+}
+
+func (cm *ChatManager) setUserLanguage(b *gotgbot.Bot, ctx *ext.Context, locale string) error {
+	if locale != SETTING_AUTO && !cm.Localizer.Supported(locale) {
+		return cm.replyError(b, ctx, "language.unknown", locale, cm.availableLanguages())
+	}
+
+	err := cm.DatabaseManager.UpdateUserSettings(ctx.EffectiveUser.Id, func(settings *databasemanager.UserSettings) {
+		settings.Language = settingValue(locale)
+	})
+	if err != nil {
+		log.Warn().Msgf("Error saving language of user %d: %v", ctx.EffectiveUser.Id, err)
+		return cm.replyError(b, ctx, "language.error")
+	}
+
+	if locale == SETTING_AUTO {
+		return cm.replyText(b, ctx, "language.reset")
+	}
+	return cm.replyText(b, ctx, "language.set", cm.Localizer.Name(locale))
+}
+
+func (cm *ChatManager) setGroupLanguage(b *gotgbot.Bot, ctx *ext.Context, locale string) error {
+	if !cm.isAdmin(b, ctx) {
+		return cm.replyError(b, ctx, "language.not_admin")
+	}
+	if locale != SETTING_AUTO && !cm.Localizer.Supported(locale) {
+		return cm.replyError(b, ctx, "language.unknown", locale, cm.availableLanguages())
+	}
+
+	err := cm.DatabaseManager.UpdateGroupSettings(ctx.EffectiveChat.Id, func(settings *databasemanager.GroupSettings) {
+		settings.Language = settingValue(locale)
+	})
+	if err != nil {
+		log.Warn().Msgf("Error saving language of group %d: %v", ctx.EffectiveChat.Id, err)
+		return cm.replyError(b, ctx, "language.error")
+	}
+
+	if locale == SETTING_AUTO {
+		return cm.replyText(b, ctx, "language.reset")
+	}
+	return cm.replyText(b, ctx, "language.group_set", cm.Localizer.Name(locale))
+}
+
+func (cm *ChatManager) languageText(ctx *ext.Context) string {
+	return cm.translate(ctx, "language.current", cm.Localizer.Name(cm.locale(ctx))) + "\n" +
+		cm.translate(ctx, "language.usage", cm.availableLanguages())
+}
+
+func (cm *ChatManager) languageKeyboard() gotgbot.InlineKeyboardMarkup {
+	var buttons []gotgbot.InlineKeyboardButton
+	for _, locale := range cm.Localizer.Locales() {
+		buttons = append(buttons, gotgbot.InlineKeyboardButton{
+			Text:         cm.Localizer.Name(locale),
+			CallbackData: LANGUAGE_CALLBACK + ":" + locale,
+		})
+	}
+	return gotgbot.InlineKeyboardMarkup{InlineKeyboard: [][]gotgbot.InlineKeyboardButton{buttons}}
+}
+
+// availableLanguages lists the locales as "de (Deutsch), en (English), ...".
+func (cm *ChatManager) availableLanguages() string {
+	var languages []string
+	for _, locale := range cm.Localizer.Locales() {
+		languages = append(languages, locale+" ("+cm.Localizer.Name(locale)+")")
+	}
+	return strings.Join(languages, ", ")
+}
+
+// settingValue maps "auto" to the empty setting, which falls back to the next source.
+func settingValue(locale string) string {
+	if locale == SETTING_AUTO {
+		return ""
+	}
+	return locale
+}
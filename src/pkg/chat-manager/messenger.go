@@ -0,0 +1,95 @@
+package chatmanager
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// Messenger is what the handlers need of Telegram. The bot talks to Telegram through
+// BotMessenger, tests through a fake that keeps what was sent in memory.
+type Messenger interface {
+	SendMessage(chatID int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error)
+	// Reply sends a message quoting the message messageID, or without quoting when it
+	// is gone.
+	Reply(chatID int64, messageID int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error)
+	SendPhoto(chatID int64, photo gotgbot.InputFile, opts *gotgbot.SendPhotoOpts) (*gotgbot.Message, error)
+	SendDocument(chatID int64, document gotgbot.InputFile, opts *gotgbot.SendDocumentOpts) (*gotgbot.Message, error)
+	// EditMessage replaces the text of a message the bot sent, opts say which.
+	EditMessage(text string, opts *gotgbot.EditMessageTextOpts) error
+	// EditMarkup replaces only the keyboard of a message the bot sent.
+	EditMarkup(opts *gotgbot.EditMessageReplyMarkupOpts) error
+	AnswerCallbackQuery(queryID string, opts *gotgbot.AnswerCallbackQueryOpts) (bool, error)
+	GetChatMember(chatID int64, userID int64) (gotgbot.ChatMember, error)
+	GetFile(fileID string) (*gotgbot.File, error)
+	// Download reads a file got with GetFile, up to MAX_DOWNLOAD_SIZE.
+	Download(file *gotgbot.File) ([]byte, error)
+}
+
+// BotMessenger sends through the Bot API.
+type BotMessenger struct {
+	Bot *gotgbot.Bot
+}
+
+func NewBotMessenger(bot *gotgbot.Bot) *BotMessenger {
+	return &BotMessenger{Bot: bot}
+}
+
+func (m *BotMessenger) SendMessage(chatID int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error) {
+	return m.Bot.SendMessage(chatID, text, opts)
+}
+
+func (m *BotMessenger) Reply(chatID int64, messageID int64, text string, opts *gotgbot.SendMessageOpts) (*gotgbot.Message, error) {
+	if opts == nil {
+		opts = &gotgbot.SendMessageOpts{}
+	}
+	opts.ReplyParameters = &gotgbot.ReplyParameters{MessageId: messageID, AllowSendingWithoutReply: true}
+	return m.Bot.SendMessage(chatID, text, opts)
+}
+
+func (m *BotMessenger) SendPhoto(chatID int64, photo gotgbot.InputFile, opts *gotgbot.SendPhotoOpts) (*gotgbot.Message, error) {
+	return m.Bot.SendPhoto(chatID, photo, opts)
+}
+
+func (m *BotMessenger) SendDocument(chatID int64, document gotgbot.InputFile, opts *gotgbot.SendDocumentOpts) (*gotgbot.Message, error) {
+	return m.Bot.SendDocument(chatID, document, opts)
+}
+
+func (m *BotMessenger) EditMessage(text string, opts *gotgbot.EditMessageTextOpts) error {
+	_, _, err := m.Bot.EditMessageText(text, opts)
+	return err
+}
+
+func (m *BotMessenger) EditMarkup(opts *gotgbot.EditMessageReplyMarkupOpts) error {
+	_, _, err := m.Bot.EditMessageReplyMarkup(opts)
+	return err
+}
+
+func (m *BotMessenger) AnswerCallbackQuery(queryID string, opts *gotgbot.AnswerCallbackQueryOpts) (bool, error) {
+	return m.Bot.AnswerCallbackQuery(queryID, opts)
+}
+
+func (m *BotMessenger) GetChatMember(chatID int64, userID int64) (gotgbot.ChatMember, error) {
+	return m.Bot.GetChatMember(chatID, userID, nil)
+}
+
+func (m *BotMessenger) GetFile(fileID string) (*gotgbot.File, error) {
+	return m.Bot.GetFile(fileID, nil)
+}
+
+// Download reads the file from the file URL of the Bot API the bot is set up with.
+func (m *BotMessenger) Download(file *gotgbot.File) ([]byte, error) {
+	resp, err := http.Get(file.URL(m.Bot, nil))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status code downloading file: %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, MAX_DOWNLOAD_SIZE))
+}
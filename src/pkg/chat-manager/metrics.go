@@ -0,0 +1,126 @@
+package chatmanager
+
+import (
+	"errors"
+	"run-tracker-telebot/src/pkg/metrics"
+	"strings"
+	"sync"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/conversation"
+)
+
+var (
+	updatesTotal = metrics.NewCounter("runtracker_updates_total",
+		"Updates received, by the command or kind of message.", "command")
+	handlerErrors = metrics.NewCounter("runtracker_handler_errors_total",
+		"Errors returned by handlers, by the command or kind of message.", "command")
+	conversationsStarted = metrics.NewCounter("runtracker_conversations_started_total",
+		"Conversations started, e.g. /getdistance asking for the period.", "conversation")
+	conversationsActive = metrics.NewGauge("runtracker_conversations_active",
+		"Conversations waiting for an answer of the user.", "conversation")
+)
+
+// Labels of updates that are no commands. Unregistered commands share one label, so
+// made up commands cannot add series.
+const (
+	LABEL_UNKNOWN_COMMAND = "unknown_command"
+	LABEL_PHOTO           = "photo"
+	LABEL_DOCUMENT        = "document"
+	LABEL_MESSAGE         = "message"
+	LABEL_OTHER           = "other"
+)
+
+// METRICS_GROUP runs before the handlers, so every update is counted once.
+const METRICS_GROUP = -1
+
+// command registers a command handler, counting updates by its name. Commands are
+// matched case-insensitively, so the name is counted in lowercase.
+func (cm *ChatManager) command(name string, response handlers.Response) handlers.Command {
+	cm.commands[strings.ToLower(name)] = true
+	return handlers.NewCommand(name, response)
+}
+
+// updateLabel is what an update is counted as, e.g. "stats", "callback:pick" or "photo".
+func (cm *ChatManager) updateLabel(ctx *ext.Context) string {
+	if ctx.CallbackQuery != nil {
+		prefix, _, _ := strings.Cut(ctx.CallbackQuery.Data, ":")
+		return "callback:" + prefix
+	}
+
+	msg := ctx.EffectiveMessage
+	switch {
+	case msg == nil:
+		return LABEL_OTHER
+	case strings.HasPrefix(msg.Text, "/"):
+		name, _, _ := strings.Cut(strings.ToLower(strings.Fields(msg.Text)[0][1:]), "@")
+		if cm.commands[name] {
+			return name
+		}
+		return LABEL_UNKNOWN_COMMAND
+	case len(msg.Photo) > 0:
+		return LABEL_PHOTO
+	case msg.Document != nil:
+		return LABEL_DOCUMENT
+	}
+	return LABEL_MESSAGE
+}
+
+// updateCounter counts every update, it runs in METRICS_GROUP and lets the handlers
+// of the other groups handle it.
+type updateCounter struct {
+	cm *ChatManager
+}
+
+func (u updateCounter) CheckUpdate(b *gotgbot.Bot, ctx *ext.Context) bool {
+	return true
+}
+
+func (u updateCounter) HandleUpdate(b *gotgbot.Bot, ctx *ext.Context) error {
+	updatesTotal.Inc(u.cm.updateLabel(ctx))
+	return nil
+}
+
+func (u updateCounter) Name() string {
+	return "metrics"
+}
+
+// countedStorage keeps the states of a conversation, counting conversations as they
+// start and end.
+type countedStorage struct {
+	conversation.Storage
+	name string
+	// lock keeps the count right when a user answers twice at once.
+	lock sync.Mutex
+}
+
+func newCountedStorage(name string) *countedStorage {
+	return &countedStorage{
+		Storage: conversation.NewInMemoryStorage(conversation.KeyStrategySenderAndChat),
+		name:    name,
+	}
+}
+
+func (s *countedStorage) Set(ctx *ext.Context, state conversation.State) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.Storage.Get(ctx)
+	if errors.Is(err, conversation.KeyNotFound) {
+		conversationsStarted.Inc(s.name)
+		conversationsActive.Add(1, s.name)
+	}
+	return s.Storage.Set(ctx, state)
+}
+
+func (s *countedStorage) Delete(ctx *ext.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.Storage.Get(ctx); err == nil {
+		conversationsActive.Add(-1, s.name)
+	}
+	return s.Storage.Delete(ctx)
+}
@@ -0,0 +1,78 @@
+package chatmanager
+
+import (
+	"fmt"
+	imageprocessor "run-tracker-telebot/src/pkg/image-processor"
+	"sync"
+	"time"
+)
+
+// Questions nobody answered are forgotten after this.
+const PENDING_TIMEOUT = 24 * time.Hour
+
+// pendingWorkout is a workout waiting for an answer of the user before it is logged: a
+// screenshot too ambiguous to log without asking, or a workout that looks like a duplicate.
+type pendingWorkout struct {
+	UserID  int64
+	Date    string
+	Created time.Time
+	// Result and Fields are for ambiguous screenshots, Fields are the ones still to ask
+	// about, the first one is being asked.
+	Result *imageprocessor.ParseResult
+	Fields []string
+	// ImageHash is the perceptual hash of the screenshot.
+	ImageHash string
+	// Details and Route are for possible duplicates, what logWorkout got.
+	Details map[string]string
+	Route   *routeMaps
+}
+
+// pendingWorkouts keeps the questions in memory, keyed by chat and message.
+// A restart forgets them, the user then sends the workout again.
+type pendingWorkouts struct {
+	sync.Mutex
+	workouts map[string]*pendingWorkout
+}
+
+func newPendingWorkouts() *pendingWorkouts {
+	return &pendingWorkouts{workouts: make(map[string]*pendingWorkout)}
+}
+
+func pendingKey(chatID int64, messageID int64) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+func (p *pendingWorkouts) add(key string, workout *pendingWorkout) {
+	p.Lock()
+	defer p.Unlock()
+
+	for other, pending := range p.workouts {
+		if time.Since(pending.Created) > PENDING_TIMEOUT {
+			delete(p.workouts, other)
+		}
+	}
+	p.workouts[key] = workout
+}
+
+func (p *pendingWorkouts) get(key string) *pendingWorkout {
+	p.Lock()
+	defer p.Unlock()
+	return p.workouts[key]
+}
+
+func (p *pendingWorkouts) remove(key string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.workouts, key)
+}
+
+// take removes the workout and returns it, nil when it was taken already, so a double
+// tap cannot answer twice.
+func (p *pendingWorkouts) take(key string) *pendingWorkout {
+	p.Lock()
+	defer p.Unlock()
+
+	pending := p.workouts[key]
+	delete(p.workouts, key)
+	return pending
+}
@@ -0,0 +1,152 @@
+package chatmanager
+
+import (
+	"fmt"
+	"run-tracker-telebot/src/log"
+	imageprocessor "run-tracker-telebot/src/pkg/image-processor"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const (
+	PICK_CALLBACK = "pick"
+	PICK_CANCEL   = "x"
+	// Candidates offered for a field, the others are too unlikely to bother.
+	PICK_MAX_CANDIDATES = 4
+)
+
+// pick answers the question about the first field left with a candidate. It runs under
+// the lock, so a double tap cannot answer twice, and returns the next field to ask
+// about, empty once the workout is settled and forgotten.
+func (p *pendingWorkouts) pick(key string, index int) (string, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	pending := p.workouts[key]
+	if pending == nil || len(pending.Fields) == 0 {
+		return "", fmt.Errorf("no question pending for %s", key)
+	}
+	if err := pending.Result.Choose(pending.Fields[0], index); err != nil {
+		return "", err
+	}
+
+	pending.Fields = pending.Fields[1:]
+	if len(pending.Fields) == 0 {
+		delete(p.workouts, key)
+		return "", nil
+	}
+	return pending.Fields[0], nil
+}
+
+// askCandidates asks the user which of the candidates of the ambiguous fields are right,
+// one field at a time, before the workout is logged.
+func (cm *ChatManager) askCandidates(b *gotgbot.Bot, ctx *ext.Context, date string, result *imageprocessor.ParseResult, fields []string, imageHash string) error {
+	messageID := ctx.EffectiveMessage.MessageId
+	pending := &pendingWorkout{
+		UserID:    ctx.EffectiveUser.Id,
+		Date:      date,
+		Created:   time.Now(),
+		Result:    result,
+		Fields:    fields,
+		ImageHash: imageHash,
+	}
+	cm.pending.add(pendingKey(ctx.EffectiveChat.Id, messageID), pending)
+
+	text, keyboard := cm.pickQuestion(ctx, messageID, result, fields[0])
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_TEXT, text, keyboard)
+}
+
+// pickQuestion asks about a field, with a button per candidate and the callback data
+// "pick:<screenshot message id>:<candidate index>".
+func (cm *ChatManager) pickQuestion(ctx *ext.Context, messageID int64, result *imageprocessor.ParseResult, name string) (string, gotgbot.InlineKeyboardMarkup) {
+	field := result.Fields[name]
+
+	var buttons []gotgbot.InlineKeyboardButton
+	for i, candidate := range field.Candidates {
+		if i == PICK_MAX_CANDIDATES {
+			break
+		}
+		buttons = append(buttons, gotgbot.InlineKeyboardButton{
+			Text:         candidate.Value,
+			CallbackData: fmt.Sprintf("%s:%d:%d", PICK_CALLBACK, messageID, i),
+		})
+	}
+
+	keyboard := gotgbot.InlineKeyboardMarkup{InlineKeyboard: [][]gotgbot.InlineKeyboardButton{
+		buttons,
+		{{Text: cm.translate(ctx, "pick.cancel"), CallbackData: fmt.Sprintf("%s:%d:%s", PICK_CALLBACK, messageID, PICK_CANCEL)}},
+	}}
+
+	fieldName := cm.translate(ctx, "pick.field."+strings.ToLower(name))
+	return cm.translate(ctx, "pick.ask", fieldName), keyboard
+}
+
+// handlePick takes the answer to a question of askCandidates. Only the user who sent the
+// screenshot can answer, once every field is settled the workout is logged.
+func (cm *ChatManager) handlePick(b *gotgbot.Bot, ctx *ext.Context) error {
+	query := ctx.CallbackQuery
+
+	parts := strings.SplitN(query.Data, ":", 3)
+	if len(parts) != 3 {
+		log.Warn().Msgf("Invalid pick callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+	messageID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		log.Warn().Msgf("Invalid pick callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	key := pendingKey(ctx.EffectiveChat.Id, messageID)
+	pending := cm.pending.get(key)
+	if pending == nil {
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "pick.expired")})
+		return err
+	}
+	if query.From.Id != pending.UserID {
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "pick.not_yours")})
+		return err
+	}
+
+	if parts[2] == PICK_CANCEL {
+		cm.pending.remove(key)
+		cm.edit(b, ctx, query.Message.GetMessageId(), messagerenderer.TEMPLATE_TEXT, cm.translate(ctx, "pick.cancelled"), gotgbot.InlineKeyboardMarkup{})
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	index, err := strconv.Atoi(parts[2])
+	if err != nil {
+		log.Warn().Msgf("Invalid pick callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	next, err := cm.pending.pick(key, index)
+	if err != nil {
+		log.Warn().Msgf("Error picking candidate %s: %v", query.Data, err)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "pick.expired")})
+		return err
+	}
+
+	if next != "" {
+		text, keyboard := cm.pickQuestion(ctx, messageID, pending.Result, next)
+		cm.edit(b, ctx, query.Message.GetMessageId(), messagerenderer.TEMPLATE_TEXT, text, keyboard)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	cm.edit(b, ctx, query.Message.GetMessageId(), messagerenderer.TEMPLATE_TEXT, cm.translate(ctx, "pick.thanks"), gotgbot.InlineKeyboardMarkup{})
+	if _, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil); err != nil {
+		log.Warn().Msgf("Error answering pick callback: %v", err)
+	}
+
+	return cm.logWorkout(b, ctx, pending.Date, screenshotDetails(pending.Result, pending.ImageHash), nil)
+}
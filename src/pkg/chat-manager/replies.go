@@ -0,0 +1,185 @@
+package chatmanager
+
+import (
+	"bytes"
+	"fmt"
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/localizer"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"run-tracker-telebot/src/pkg/stats"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// locale picks the language to answer in: the user's own choice, then the group's,
+// then the language of the user's Telegram client.
+func (cm *ChatManager) locale(ctx *ext.Context) string {
+	if ctx.EffectiveUser != nil {
+		if language := cm.DatabaseManager.GetUserSettings(ctx.EffectiveUser.Id).Language; language != "" {
+			return language
+		}
+	}
+	if ctx.EffectiveChat != nil {
+		if language := cm.DatabaseManager.GetGroupSettings(ctx.EffectiveChat.Id).Language; language != "" {
+			return language
+		}
+	}
+	if ctx.EffectiveUser != nil {
+		return cm.Localizer.Match(ctx.EffectiveUser.LanguageCode)
+	}
+	return localizer.DEFAULT_LOCALE
+}
+
+// translate returns the catalog message for key in the locale of ctx, as plain text.
+func (cm *ChatManager) translate(ctx *ext.Context, key string, args ...interface{}) string {
+	return cm.Localizer.T(cm.locale(ctx), key, args...)
+}
+
+// reply renders the template and replies to the effective message. Output longer
+// than Telegram allows is split over several messages, the markup goes on the last one.
+func (cm *ChatManager) reply(b *gotgbot.Bot, ctx *ext.Context, name string, data interface{}, markup gotgbot.ReplyMarkup) error {
+	return cm.deliver(b, cm.locale(ctx), ctx.EffectiveChat.Id, ctx.EffectiveMessage.MessageId, name, data, markup)
+}
+
+// send is like reply, without quoting the message that triggered it.
+func (cm *ChatManager) send(b *gotgbot.Bot, ctx *ext.Context, name string, data interface{}, markup gotgbot.ReplyMarkup) error {
+	return cm.deliver(b, cm.locale(ctx), ctx.EffectiveChat.Id, 0, name, data, markup)
+}
+
+// replyText replies with the catalog message for key, args are formatted into it.
+func (cm *ChatManager) replyText(b *gotgbot.Bot, ctx *ext.Context, key string, args ...interface{}) error {
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_TEXT, cm.translate(ctx, key, args...), nil)
+}
+
+func (cm *ChatManager) replyError(b *gotgbot.Bot, ctx *ext.Context, key string, args ...interface{}) error {
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_ERROR, cm.translate(ctx, key, args...), nil)
+}
+
+func (cm *ChatManager) sendText(b *gotgbot.Bot, ctx *ext.Context, key string, args ...interface{}) error {
+	return cm.send(b, ctx, messagerenderer.TEMPLATE_TEXT, cm.translate(ctx, key, args...), nil)
+}
+
+func (cm *ChatManager) sendError(b *gotgbot.Bot, ctx *ext.Context, key string, args ...interface{}) error {
+	return cm.send(b, ctx, messagerenderer.TEMPLATE_ERROR, cm.translate(ctx, key, args...), nil)
+}
+
+// periodTotals sums up the distance of every user of the group in the period, counting
+// workouts of the activity type, an empty type counts them all.
+func (cm *ChatManager) periodTotals(ctx *ext.Context, period stats.Period, activity string) (messagerenderer.Totals, error) {
+	startDate := period.Start.Format(stats.DATE_LAYOUT)
+	endDate := period.End.AddDate(0, 0, -1).Format(stats.DATE_LAYOUT)
+
+	totalDistanceByUser, err := cm.DatabaseManager.GetTotalDistanceByWeek(ctx.EffectiveChat.Id, startDate, endDate, activity)
+	if err != nil {
+		return messagerenderer.Totals{}, err
+	}
+
+	label := cm.MessageRenderer.PeriodLabel(cm.locale(ctx), period)
+	return cm.totals(cm.translate(ctx, "distance.period_title", label, cm.activityName(ctx, activity)), totalDistanceByUser), nil
+}
+
+// totals lists the total distance of every user, longest first.
+func (cm *ChatManager) totals(title string, totalDistanceByUser map[int64]string) messagerenderer.Totals {
+	totals := messagerenderer.Totals{Title: title}
+	for userID, distance := range totalDistanceByUser {
+		totals.Rows = append(totals.Rows, messagerenderer.Total{Name: cm.usernameOrId(userID), Distance: distance})
+	}
+
+	sort.Slice(totals.Rows, func(i, j int) bool {
+		left, _ := strconv.ParseFloat(totals.Rows[i].Distance, 64)
+		right, _ := strconv.ParseFloat(totals.Rows[j].Distance, 64)
+		return left > right
+	})
+
+	return totals
+}
+
+func (cm *ChatManager) deliver(b *gotgbot.Bot, locale string, chatID int64, replyTo int64, name string, data interface{}, markup gotgbot.ReplyMarkup) error {
+	text, err := cm.MessageRenderer.Render(locale, name, data)
+	if err != nil {
+		return err
+	}
+
+	chunks := messagerenderer.Split(text, messagerenderer.MESSAGE_LIMIT)
+	for i, chunk := range chunks {
+		opts := &gotgbot.SendMessageOpts{ParseMode: messagerenderer.PARSE_MODE}
+		if i == len(chunks)-1 {
+			opts.ReplyMarkup = markup
+		}
+
+		var err error
+		if i == 0 && replyTo != 0 {
+			_, err = cm.Messenger.Reply(chatID, replyTo, chunk, opts)
+		} else {
+			_, err = cm.Messenger.SendMessage(chatID, chunk, opts)
+		}
+		if err != nil {
+			log.Warn().Msgf("Error sending message to user in telegram: %v", err)
+			return fmt.Errorf("failed to send message: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// replyPhoto replies with a PNG image, the rendered template as its caption. Captions
+// are much shorter than messages, text that does not fit follows the image instead.
+func (cm *ChatManager) replyPhoto(b *gotgbot.Bot, ctx *ext.Context, image []byte, fileName string, name string, data interface{}) error {
+	text, err := cm.MessageRenderer.Render(cm.locale(ctx), name, data)
+	if err != nil {
+		return err
+	}
+
+	opts := &gotgbot.SendPhotoOpts{
+		ParseMode:       messagerenderer.PARSE_MODE,
+		ReplyParameters: &gotgbot.ReplyParameters{MessageId: ctx.EffectiveMessage.MessageId, AllowSendingWithoutReply: true},
+	}
+	fits := utf8.RuneCountInString(text) <= messagerenderer.CAPTION_LIMIT
+	if fits {
+		opts.Caption = text
+	}
+
+	_, err = cm.Messenger.SendPhoto(ctx.EffectiveChat.Id, gotgbot.NamedFile{File: bytes.NewReader(image), FileName: fileName}, opts)
+	if err != nil {
+		log.Warn().Msgf("Error sending photo to user in telegram: %v", err)
+		return fmt.Errorf("failed to send photo: %w", err)
+	}
+
+	if !fits {
+		return cm.send(b, ctx, name, data, nil)
+	}
+	return nil
+}
+
+// edit replaces the text of a message the bot sent before, e.g. when paging.
+func (cm *ChatManager) edit(b *gotgbot.Bot, ctx *ext.Context, messageID int64, name string, data interface{}, markup gotgbot.InlineKeyboardMarkup) error {
+	text, err := cm.MessageRenderer.Render(cm.locale(ctx), name, data)
+	if err != nil {
+		return err
+	}
+
+	// An edit is one message, what does not fit is cut off
+	chunks := messagerenderer.Split(text, messagerenderer.MESSAGE_LIMIT)
+	if len(chunks) > 1 {
+		log.Warn().Msgf("Edited message is too long, cutting off %d of %d chunks", len(chunks)-1, len(chunks))
+		chunks = messagerenderer.Split(text, messagerenderer.MESSAGE_LIMIT-utf8.RuneCountInString(messagerenderer.TRUNCATED))
+		chunks[0] += messagerenderer.TRUNCATED
+	}
+
+	err = cm.Messenger.EditMessage(chunks[0], &gotgbot.EditMessageTextOpts{
+		ChatId:      ctx.EffectiveChat.Id,
+		MessageId:   messageID,
+		ParseMode:   messagerenderer.PARSE_MODE,
+		ReplyMarkup: markup,
+	})
+	if err != nil {
+		log.Warn().Msgf("Error editing message in telegram: %v", err)
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package chatmanager
+
+import (
+	"fmt"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"run-tracker-telebot/src/pkg/stats"
+	"run-tracker-telebot/src/pkg/validator"
+	"strconv"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const (
+	REVIEW_CALLBACK = "review"
+	REVIEW_APPROVE  = "approve"
+	REVIEW_REJECT   = "reject"
+)
+
+// flagReasons describes the failed checks of a workout with the rules of the group.
+func (cm *ChatManager) flagReasons(ctx *ext.Context, flags []string) []string {
+	rules := validator.NewValidator(cm.DatabaseManager).Rules(ctx.EffectiveChat.Id)
+
+	var reasons []string
+	for _, flag := range flags {
+		switch flag {
+		case validator.FLAG_TOO_FAST:
+			reasons = append(reasons, cm.translate(ctx, "review.flag.too_fast", stats.FormatPace(rules.Fastest())))
+		case validator.FLAG_TOO_SLOW:
+			reasons = append(reasons, cm.translate(ctx, "review.flag.too_slow", stats.FormatPace(rules.Slowest())))
+		case validator.FLAG_TOO_LONG:
+			reasons = append(reasons, cm.translate(ctx, "review.flag.too_long", formatKm(rules.MaxDistance)))
+		case validator.FLAG_DAILY_DISTANCE:
+			reasons = append(reasons, cm.translate(ctx, "review.flag.daily_distance", formatKm(rules.MaxDailyDistance)))
+		case validator.FLAG_TIME_MISMATCH:
+			reasons = append(reasons, cm.translate(ctx, "review.flag.time_mismatch"))
+		default:
+			reasons = append(reasons, flag)
+		}
+	}
+	return reasons
+}
+
+// handleRules shows the plausibility rules of the group, or lets admins change them
+// with e.g. "/rules pace 2:30 15:00", "/rules distance 60", "/rules daily off",
+// "/rules tolerance 10" or "/rules default".
+func (cm *ChatManager) handleRules(b *gotgbot.Bot, ctx *ext.Context) error {
+	args := strings.Fields(strings.ToLower(commandArgs(ctx.EffectiveMessage.Text)))
+	if len(args) == 0 {
+		return cm.replyRules(b, ctx)
+	}
+
+	if !cm.isAdmin(b, ctx) {
+		return cm.replyError(b, ctx, "rules.not_admin")
+	}
+
+	rules := validator.NewValidator(cm.DatabaseManager).Rules(ctx.EffectiveChat.Id)
+	if args[0] == validator.RULE_DEFAULT {
+		rules = validator.DEFAULT_RULES
+	} else if err := validator.SetRule(&rules, args[0], args[1:]); err != nil {
+		log.Warn().Msgf("Invalid rule: %v", err)
+		return cm.replyError(b, ctx, "rules.invalid", cm.translate(ctx, "rules.usage"))
+	}
+
+	err := cm.DatabaseManager.UpdateGroupSettings(ctx.EffectiveChat.Id, func(settings *databasemanager.GroupSettings) {
+		settings.Rules = &rules
+	})
+	if err != nil {
+		log.Warn().Msgf("Error saving rules of group %d: %v", ctx.EffectiveChat.Id, err)
+		return cm.replyError(b, ctx, "rules.error")
+	}
+
+	return cm.replyRules(b, ctx)
+}
+
+func (cm *ChatManager) replyRules(b *gotgbot.Bot, ctx *ext.Context) error {
+	rules := validator.NewValidator(cm.DatabaseManager).Rules(ctx.EffectiveChat.Id)
+	off := cm.translate(ctx, "rules.off")
+
+	pace := off
+	if rules.MinPace > 0 || rules.MaxPace > 0 {
+		pace = cm.translate(ctx, "rules.pace_range", stats.FormatPace(rules.Fastest()), stats.FormatPace(rules.Slowest()))
+	}
+	distance, daily, tolerance := off, off, off
+	if rules.MaxDistance > 0 {
+		distance = formatKm(rules.MaxDistance) + "KM"
+	}
+	if rules.MaxDailyDistance > 0 {
+		daily = formatKm(rules.MaxDailyDistance) + "KM"
+	}
+	if rules.TimeTolerance > 0 {
+		tolerance = strconv.FormatFloat(rules.TimeTolerance*100, 'f', -1, 64) + "%"
+	}
+
+	return cm.replyText(b, ctx, "rules.current", pace, distance, daily, tolerance)
+}
+
+// handleReview shows admins the first workout of the review queue.
+func (cm *ChatManager) handleReview(b *gotgbot.Bot, ctx *ext.Context) error {
+	if !cm.isAdmin(b, ctx) {
+		return cm.replyError(b, ctx, "review.not_admin")
+	}
+
+	review, keyboard := cm.nextReview(ctx)
+	if review == nil {
+		return cm.replyText(b, ctx, "review.empty")
+	}
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_REVIEW, review, keyboard)
+}
+
+// nextReview renders the oldest flagged workout with the buttons to approve or reject
+// it, the callback data being "review:<user id>:<date>:approve|reject".
+func (cm *ChatManager) nextReview(ctx *ext.Context) (*messagerenderer.Review, gotgbot.InlineKeyboardMarkup) {
+	flagged := cm.DatabaseManager.FlaggedWorkouts(ctx.EffectiveChat.Id)
+	if len(flagged) == 0 {
+		return nil, gotgbot.InlineKeyboardMarkup{}
+	}
+
+	first := flagged[0]
+	review := &messagerenderer.Review{
+		Left: len(flagged),
+		Workout: messagerenderer.Workout{
+			Date:          first.Date,
+			Name:          cm.usernameOrId(first.UserID),
+			Type:          first.Entry.Type,
+			Distance:      first.Entry.Distance,
+			Pace:          first.Entry.Pace,
+			Time:          first.Entry.Time,
+			ElevationGain: first.Entry.ElevationGain,
+			HeartRate:     first.Entry.HeartRate,
+			Flags:         cm.flagReasons(ctx, first.Entry.Flags),
+		},
+	}
+
+	data := fmt.Sprintf("%s:%d:%s", REVIEW_CALLBACK, first.UserID, first.Date)
+	keyboard := gotgbot.InlineKeyboardMarkup{InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{
+		{Text: cm.translate(ctx, "review.approve"), CallbackData: data + ":" + REVIEW_APPROVE},
+		{Text: cm.translate(ctx, "review.reject"), CallbackData: data + ":" + REVIEW_REJECT},
+	}}}
+	return review, keyboard
+}
+
+// handleReviewChoice approves or rejects a flagged workout and moves on to the next one.
+func (cm *ChatManager) handleReviewChoice(b *gotgbot.Bot, ctx *ext.Context) error {
+	query := ctx.CallbackQuery
+	if !cm.isAdmin(b, ctx) {
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, "review.not_admin")})
+		return err
+	}
+
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 4 {
+		log.Warn().Msgf("Invalid review callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		log.Warn().Msgf("Invalid review callback data: %s", query.Data)
+		_, err := cm.Messenger.AnswerCallbackQuery(query.Id, nil)
+		return err
+	}
+
+	done := false
+	answer := "review.approved"
+	switch parts[3] {
+	case REVIEW_APPROVE:
+		done = cm.DatabaseManager.ApproveWorkout(ctx.EffectiveChat.Id, userID, parts[2])
+	case REVIEW_REJECT:
+		done = cm.DatabaseManager.RejectWorkout(ctx.EffectiveChat.Id, userID, parts[2])
+		answer = "review.rejected"
+	}
+	if !done {
+		answer = "review.gone"
+	}
+	if _, err := cm.Messenger.AnswerCallbackQuery(query.Id, &gotgbot.AnswerCallbackQueryOpts{Text: cm.translate(ctx, answer)}); err != nil {
+		log.Warn().Msgf("Error answering review callback: %v", err)
+	}
+
+	review, keyboard := cm.nextReview(ctx)
+	if review == nil {
+		return cm.edit(b, ctx, query.Message.GetMessageId(), messagerenderer.TEMPLATE_TEXT, cm.translate(ctx, "review.empty"), gotgbot.InlineKeyboardMarkup{})
+	}
+	return cm.edit(b, ctx, query.Message.GetMessageId(), messagerenderer.TEMPLATE_REVIEW, review, keyboard)
+}
+
+func formatKm(distance float64) string {
+	return strconv.FormatFloat(distance, 'f', -1, 64)
+}
@@ -0,0 +1,92 @@
+package chatmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/metrics"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+const (
+	// Telegram retries updates that take longer than this to be accepted.
+	SERVER_READ_TIMEOUT = 10 * time.Second
+	// READY_TIMEOUT bounds the call to Telegram of a readiness check.
+	READY_TIMEOUT = 5 * time.Second
+)
+
+// RESERVED_PATHS are served next to the webhook, it cannot use them.
+var RESERVED_PATHS = map[string]bool{"healthz": true, "readyz": true, "metrics": true}
+
+// startServer serves the health checks and metrics on the listen address:
+//   - /healthz answers as long as the bot runs,
+//   - /readyz once the workouts are loaded and Telegram can be reached,
+//   - /metrics in the Prometheus text format.
+//
+// In webhook mode the webhook is served on it as well.
+func (cm *ChatManager) startServer() error {
+	listener, err := net.Listen("tcp", cm.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", cm.ListenAddr, err)
+	}
+
+	cm.mux = http.NewServeMux()
+	cm.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	cm.mux.HandleFunc("/readyz", cm.handleReady)
+	cm.mux.Handle("/metrics", metrics.Handler())
+
+	cm.server = &http.Server{
+		Handler:           cm.mux,
+		ReadTimeout:       SERVER_READ_TIMEOUT,
+		ReadHeaderTimeout: SERVER_READ_TIMEOUT,
+	}
+	server := cm.server
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warn().Msgf("HTTP server stopped: %v", err)
+		}
+	}()
+
+	log.Info().Msgf("Serving health checks and metrics on %s", cm.ListenAddr)
+	return nil
+}
+
+// stopServer stops the server, letting it answer the requests it is receiving.
+func (cm *ChatManager) stopServer(ctx context.Context) {
+	if cm.server == nil {
+		return
+	}
+
+	if err := cm.server.Shutdown(ctx); err != nil {
+		log.Warn().Msgf("Error stopping HTTP server: %v", err)
+	}
+	cm.server = nil
+	cm.mux = nil
+}
+
+// handleReady answers 503 until the workouts are loaded and while Telegram cannot be
+// reached, so no traffic is sent to a bot that cannot do its job.
+func (cm *ChatManager) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !cm.DatabaseManager.Loaded() {
+		http.Error(w, "store not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	_, err := cm.Bot.GetMe(&gotgbot.GetMeOpts{
+		RequestOpts: &gotgbot.RequestOpts{Timeout: READY_TIMEOUT},
+	})
+	if err != nil {
+		log.Warn().Msgf("Readiness check cannot reach Telegram: %v", err)
+		http.Error(w, "telegram unreachable", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,44 @@
+package chatmanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"run-tracker-telebot/src/pkg/config"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name   string
+		loaded bool
+		fail   []string
+		code   int
+		body   string
+	}{
+		{"ready", true, nil, http.StatusOK, "ok"},
+		{"store not loaded", false, nil, http.StatusServiceUnavailable, "store not loaded"},
+		{"telegram unreachable", true, []string{"getMe"}, http.StatusServiceUnavailable, "telegram unreachable"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI(t, test.fail...)
+			h := newHarness(t)
+			h.cm.Bot = api.bot()
+			if !test.loaded {
+				h.cm.DatabaseManager = databasemanager.NewDatabaseManager(config.Default().Storage)
+			}
+
+			response := httptest.NewRecorder()
+			h.cm.handleReady(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if response.Code != test.code || strings.TrimSpace(response.Body.String()) != test.body {
+				t.Errorf("/readyz = %d %q, want %d %q", response.Code, response.Body.String(), test.code, test.body)
+			}
+			if want := map[bool]int{true: 1, false: 0}[test.loaded]; api.called("getMe") != want {
+				t.Errorf("%d getMe calls, want %d", api.called("getMe"), want)
+			}
+		})
+	}
+}
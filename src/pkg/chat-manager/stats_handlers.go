@@ -0,0 +1,53 @@
+package chatmanager
+
+import (
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"run-tracker-telebot/src/pkg/stats"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// handleStats sums up the runs of the user in a period, or the workouts of another type
+// with e.g. "/stats month cycle".
+func (cm *ChatManager) handleStats(b *gotgbot.Bot, ctx *ext.Context) error {
+	chatID := ctx.EffectiveChat.Id
+	userID := ctx.EffectiveUser.Id
+
+	input, activity := stats.SplitActivityType(commandArgs(ctx.EffectiveMessage.Text))
+	period, err := stats.ParsePeriod(input, cm.now(ctx), cm.weekStart(ctx))
+	if err != nil {
+		log.Warn().Msgf("Invalid stats period: %v", err)
+		return cm.replyError(b, ctx, "stats.invalid_period", cm.translate(ctx, "stats.usage"))
+	}
+
+	userWorkouts, err := cm.DatabaseManager.GetUserWorkouts(chatID, userID)
+	if err != nil || len(userWorkouts) == 0 {
+		log.Warn().Msgf("Error getting workouts for user %d in group %d: %v", userID, chatID, err)
+		return cm.replyError(b, ctx, "stats.empty")
+	}
+
+	username, err := cm.DatabaseManager.GetUsernameFromId(userID)
+	if err != nil {
+		log.Warn().Msgf("Error getting username for user %d: %v", userID, err)
+		return err
+	}
+
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_STATS, messagerenderer.Stats{
+		Name:       username,
+		Activity:   activity,
+		Comparison: stats.Compare(stats.FromWorkouts(databasemanager.WorkoutsOfType(userWorkouts, activity)), period),
+	}, nil)
+}
+
+// commandArgs returns everything after the command itself, e.g. "month" for "/stats month".
+func commandArgs(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return ""
+	}
+	return strings.Join(fields[1:], " ")
+}
@@ -0,0 +1,153 @@
+package chatmanager
+
+import (
+	"fmt"
+	"regexp"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const TIMEZONE_TIME_LAYOUT = "2006-01-02 15:04"
+
+// MAX_UTC_OFFSET is the furthest any zone is ahead of or behind UTC, in hours.
+const MAX_UTC_OFFSET = 14
+
+// utcOffsetRegex reads offsets like UTC+8, GMT-3:30, +0545 and UTC+05:30, the name
+// they are saved with. Zones are offset by whole, half or three quarter hours.
+var utcOffsetRegex = regexp.MustCompile(`^(?i)(?:utc|gmt)?\s*([+-])(\d{1,2})(?::?(00|30|45))?$`)
+
+// location picks the timezone of the user: the user's own choice, then the group's,
+// then the default of the config.
+func (cm *ChatManager) location(ctx *ext.Context) *time.Location {
+	name := cm.DefaultTimezone
+	if timezone := cm.DatabaseManager.GetUserSettings(ctx.EffectiveUser.Id).Timezone; timezone != "" {
+		name = timezone
+	} else if timezone := cm.DatabaseManager.GetGroupSettings(ctx.EffectiveChat.Id).Timezone; timezone != "" {
+		name = timezone
+	}
+
+	location, err := loadLocation(name)
+	if err != nil {
+		log.Warn().Msgf("Error loading timezone %s: %v", name, err)
+		return time.UTC
+	}
+	return location
+}
+
+// now is the current time in the user's timezone. Workouts are stamped with its
+// calendar date, and periods like "this week" are computed from it.
+func (cm *ChatManager) now(ctx *ext.Context) time.Time {
+	return time.Now().In(cm.location(ctx))
+}
+
+// handleTimezone shows or changes the timezone: "/timezone Asia/Singapore",
+// "/timezone UTC+8", "/timezone auto" for the user, "/timezone group ..." for the group.
+func (cm *ChatManager) handleTimezone(b *gotgbot.Bot, ctx *ext.Context) error {
+	args := strings.Fields(commandArgs(ctx.EffectiveMessage.Text))
+
+	switch {
+	case len(args) == 0:
+		return cm.replyText(b, ctx, "timezone.current", cm.location(ctx).String(), cm.now(ctx).Format(TIMEZONE_TIME_LAYOUT))
+	case len(args) == 2 && strings.ToLower(args[0]) == SETTING_GROUP:
+		return cm.setGroupTimezone(b, ctx, args[1])
+	case len(args) == 1:
+		return cm.setUserTimezone(b, ctx, args[0])
+	default:
+		return cm.replyError(b, ctx, "timezone.unknown", strings.Join(args, " "))
+	}
+}
+
+func (cm *ChatManager) setUserTimezone(b *gotgbot.Bot, ctx *ext.Context, input string) error {
+	timezone, err := parseTimezone(input)
+	if err != nil {
+		log.Warn().Msgf("Invalid timezone: %v", err)
+		return cm.replyError(b, ctx, "timezone.unknown", input)
+	}
+
+	err = cm.DatabaseManager.UpdateUserSettings(ctx.EffectiveUser.Id, func(settings *databasemanager.UserSettings) {
+		settings.Timezone = timezone
+	})
+	if err != nil {
+		log.Warn().Msgf("Error saving timezone of user %d: %v", ctx.EffectiveUser.Id, err)
+		return cm.replyError(b, ctx, "timezone.error")
+	}
+
+	if timezone == "" {
+		return cm.replyText(b, ctx, "timezone.reset", cm.location(ctx).String())
+	}
+	return cm.replyText(b, ctx, "timezone.set", timezone, cm.now(ctx).Format(TIMEZONE_TIME_LAYOUT))
+}
+
+func (cm *ChatManager) setGroupTimezone(b *gotgbot.Bot, ctx *ext.Context, input string) error {
+	if !cm.isAdmin(b, ctx) {
+		return cm.replyError(b, ctx, "timezone.not_admin")
+	}
+
+	timezone, err := parseTimezone(input)
+	if err != nil {
+		log.Warn().Msgf("Invalid timezone: %v", err)
+		return cm.replyError(b, ctx, "timezone.unknown", input)
+	}
+
+	err = cm.DatabaseManager.UpdateGroupSettings(ctx.EffectiveChat.Id, func(settings *databasemanager.GroupSettings) {
+		settings.Timezone = timezone
+	})
+	if err != nil {
+		log.Warn().Msgf("Error saving timezone of group %d: %v", ctx.EffectiveChat.Id, err)
+		return cm.replyError(b, ctx, "timezone.error")
+	}
+
+	if timezone == "" {
+		return cm.replyText(b, ctx, "timezone.reset", cm.DefaultTimezone)
+	}
+	return cm.replyText(b, ctx, "timezone.group_set", timezone)
+}
+
+// parseTimezone validates an IANA name like "Asia/Singapore" or an offset like "UTC+8"
+// or "UTC+5:30", which is saved as "UTC+05:30". "auto" gives the empty setting.
+func parseTimezone(input string) (string, error) {
+	if strings.EqualFold(input, SETTING_AUTO) {
+		return "", nil
+	}
+	if strings.EqualFold(input, "utc") || strings.EqualFold(input, "gmt") {
+		return "UTC", nil
+	}
+	if input == "Local" {
+		return "", fmt.Errorf("unknown timezone: %s", input)
+	}
+
+	location, err := loadLocation(input)
+	if err != nil {
+		return "", err
+	}
+	return location.String(), nil
+}
+
+// loadLocation loads a timezone saved by parseTimezone, offsets are fixed zones. Names
+// like "Etc/GMT-8" saved before are IANA names.
+func loadLocation(name string) (*time.Location, error) {
+	match := utcOffsetRegex.FindStringSubmatch(name)
+	if match == nil {
+		return time.LoadLocation(name)
+	}
+
+	hours, _ := strconv.Atoi(match[2])
+	minutes, _ := strconv.Atoi(match[3])
+	offset := hours*60 + minutes
+	if offset > MAX_UTC_OFFSET*60 {
+		return nil, fmt.Errorf("offset out of range: %s", name)
+	}
+	if offset == 0 {
+		return time.UTC, nil
+	}
+	if match[1] == "-" {
+		offset = -offset
+	}
+	return time.FixedZone(fmt.Sprintf("UTC%s%02d:%02d", match[1], hours, minutes), offset*60), nil
+}
@@ -0,0 +1,58 @@
+package chatmanager
+
+import (
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/stats"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// activityName translates a type of activity, an empty type being all of them.
+func (cm *ChatManager) activityName(ctx *ext.Context, activity string) string {
+	if activity == "" {
+		return cm.translate(ctx, "type.any")
+	}
+	return cm.translate(ctx, "type."+activity)
+}
+
+// captionActivity is the type of activity named in the caption of a screenshot or an
+// activity file, e.g. "ride", which wins over the one the bot detected.
+func captionActivity(ctx *ext.Context) (string, bool) {
+	for _, word := range strings.Fields(ctx.EffectiveMessage.Caption) {
+		if activity, ok := stats.ParseActivityType(strings.Trim(word, "#.,!")); ok {
+			return activity, true
+		}
+	}
+	return "", false
+}
+
+// handleType corrects the type of activity of a workout of the user, e.g. "/type walk"
+// for today or "/type cycle yesterday".
+func (cm *ChatManager) handleType(b *gotgbot.Bot, ctx *ext.Context) error {
+	args := strings.Fields(commandArgs(ctx.EffectiveMessage.Text))
+	if len(args) == 0 {
+		return cm.replyError(b, ctx, "type.usage")
+	}
+	activity, ok := stats.ParseActivityType(args[0])
+	if !ok {
+		return cm.replyError(b, ctx, "type.unknown", args[0], cm.translate(ctx, "type.usage"))
+	}
+
+	day := cm.now(ctx)
+	if len(args) > 1 {
+		parsed, err := stats.ParseDate(strings.Join(args[1:], " "), cm.now(ctx))
+		if err != nil {
+			log.Warn().Msgf("Invalid workout date: %v", err)
+			return cm.replyError(b, ctx, "type.invalid_date", cm.translate(ctx, "type.usage"))
+		}
+		day = parsed
+	}
+	date := day.Format(stats.DATE_LAYOUT)
+
+	if !cm.DatabaseManager.SetWorkoutType(ctx.EffectiveChat.Id, ctx.EffectiveUser.Id, date, activity) {
+		return cm.replyError(b, ctx, "type.not_found", cm.Localizer.FormatDate(cm.locale(ctx), day))
+	}
+	return cm.replyText(b, ctx, "type.set", cm.Localizer.FormatDate(cm.locale(ctx), day), cm.activityName(ctx, activity))
+}
@@ -0,0 +1,118 @@
+package chatmanager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/config"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// How updates reach the bot, picked with telegram.update_mode.
+const (
+	MODE_POLLING = config.MODE_POLLING
+	MODE_WEBHOOK = config.MODE_WEBHOOK
+)
+
+// Telegram only accepts these characters in a secret token.
+var secretTokenRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// WebhookConfig is where Telegram sends updates in webhook mode, they are served on the
+// listen address of the health checks.
+type WebhookConfig struct {
+	// URL is the public HTTPS address of the bot, e.g. https://bot.example.com, the
+	// path is appended to it.
+	URL  string
+	Path string
+	// SecretToken is sent by Telegram with every update, updates without it are
+	// refused. A random one is used when none is set.
+	SecretToken string
+}
+
+// Validate checks what Telegram requires of a webhook, filling in a random secret
+// token when none is set.
+func (wc *WebhookConfig) Validate() error {
+	address, err := url.Parse(wc.URL)
+	if err != nil || address.Host == "" {
+		return fmt.Errorf("invalid webhook url: %q", wc.URL)
+	}
+	if address.Scheme != "https" {
+		return fmt.Errorf("webhook url must use https: %q", wc.URL)
+	}
+
+	wc.Path = strings.Trim(wc.Path, "/")
+	if wc.Path == "" {
+		return errors.New("empty webhook path")
+	}
+	if RESERVED_PATHS[wc.Path] {
+		return fmt.Errorf("webhook path %q is taken by the health checks", wc.Path)
+	}
+
+	if wc.SecretToken == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("error generating webhook secret: %w", err)
+		}
+		wc.SecretToken = hex.EncodeToString(secret)
+	}
+	if !secretTokenRegex.MatchString(wc.SecretToken) {
+		return errors.New("webhook secret may only use A-Z, a-z, 0-9, _ and -, up to 256 characters")
+	}
+	return nil
+}
+
+// Endpoint is the address Telegram posts updates to.
+func (wc WebhookConfig) Endpoint() string {
+	return strings.TrimSuffix(wc.URL, "/") + "/" + wc.Path
+}
+
+// startWebhook serves updates on the HTTP server and points Telegram at it. On error
+// no updates are taken, so the caller can fall back to polling.
+func (cm *ChatManager) startWebhook(updater *ext.Updater) error {
+	wc := cm.Webhook
+	if err := wc.Validate(); err != nil {
+		return err
+	}
+	if cm.mux == nil {
+		return fmt.Errorf("no HTTP server listening on %s", cm.ListenAddr)
+	}
+
+	err := updater.AddWebhook(cm.Bot, wc.Path, &ext.AddWebhookOpts{SecretToken: wc.SecretToken})
+	if err != nil {
+		return err
+	}
+	// Once the bot is stopped the updater refuses updates for it, so the path can stay
+	cm.mux.Handle("/"+wc.Path, updater.GetHandlerFunc("/"))
+
+	_, err = cm.Bot.SetWebhook(wc.Endpoint(), &gotgbot.SetWebhookOpts{
+		SecretToken:        wc.SecretToken,
+		DropPendingUpdates: true,
+	})
+	if err != nil {
+		updater.StopBot(cm.Bot.Token)
+		return fmt.Errorf("error setting webhook: %w", err)
+	}
+
+	cm.webhookActive = true
+	log.Info().Msgf("Receiving updates on %s, listening on %s", wc.Endpoint(), cm.ListenAddr)
+	return nil
+}
+
+// stopWebhook tells Telegram to stop sending updates, which then wait for the next start.
+func (cm *ChatManager) stopWebhook() {
+	if !cm.webhookActive {
+		return
+	}
+
+	if _, err := cm.Bot.DeleteWebhook(nil); err != nil {
+		log.Warn().Msgf("Error deleting webhook: %v", err)
+	}
+	cm.webhookActive = false
+}
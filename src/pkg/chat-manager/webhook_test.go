@@ -0,0 +1,263 @@
+package chatmanager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// testAPI is a Bot API answering every method, failing the ones in fail.
+type testAPI struct {
+	server *httptest.Server
+	lock   sync.Mutex
+	fail   map[string]bool
+	calls  map[string]int
+}
+
+func newTestAPI(t *testing.T, fail ...string) *testAPI {
+	t.Helper()
+
+	api := &testAPI{fail: make(map[string]bool), calls: make(map[string]int)}
+	for _, method := range fail {
+		api.fail[method] = true
+	}
+	api.server = httptest.NewServer(http.HandlerFunc(api.handle))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (api *testAPI) handle(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	api.lock.Lock()
+	api.calls[method]++
+	fail := api.fail[method]
+	api.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if fail {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": 400, "description": "Bad Request: " + method + " failed"})
+		return
+	}
+
+	var result interface{} = true
+	switch method {
+	case "getMe":
+		result = gotgbot.User{Id: 1, IsBot: true, Username: "run_tracker_test_bot"}
+	case "getUpdates":
+		// Long polling without updates
+		time.Sleep(10 * time.Millisecond)
+		result = []gotgbot.Update{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// called is how often the bot called method.
+func (api *testAPI) called(method string) int {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	return api.calls[method]
+}
+
+// bot talks to the test API.
+func (api *testAPI) bot() *gotgbot.Bot {
+	return &gotgbot.Bot{
+		Token: "123:test",
+		User:  gotgbot.User{Id: 1, IsBot: true, Username: "run_tracker_test_bot"},
+		BotClient: &gotgbot.BaseBotClient{
+			DefaultRequestOpts: &gotgbot.RequestOpts{Timeout: time.Second, APIURL: api.server.URL},
+		},
+	}
+}
+
+func TestWebhookConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config WebhookConfig
+		// path is the path after validation, err what the error contains.
+		path string
+		err  string
+	}{
+		{"valid", WebhookConfig{URL: "https://bot.example.com", Path: "telegram", SecretToken: "s3cret_-"}, "telegram", ""},
+		{"path trimmed", WebhookConfig{URL: "https://bot.example.com", Path: "/hooks/telegram/", SecretToken: "secret"}, "hooks/telegram", ""},
+		{"http", WebhookConfig{URL: "http://bot.example.com", Path: "telegram"}, "", "must use https"},
+		{"no host", WebhookConfig{URL: "bot.example.com", Path: "telegram"}, "", "invalid webhook url"},
+		{"empty path", WebhookConfig{URL: "https://bot.example.com", Path: "/"}, "", "empty webhook path"},
+		{"reserved path", WebhookConfig{URL: "https://bot.example.com", Path: "/readyz"}, "", "health checks"},
+		{"invalid secret", WebhookConfig{URL: "https://bot.example.com", Path: "telegram", SecretToken: "not secret!"}, "", "webhook secret"},
+		{"long secret", WebhookConfig{URL: "https://bot.example.com", Path: "telegram", SecretToken: strings.Repeat("a", 257)}, "", "webhook secret"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wc := test.config
+			err := wc.Validate()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Validate() = %v, want an error containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() = %v", err)
+			}
+			if wc.Path != test.path {
+				t.Errorf("Path = %q, want %q", wc.Path, test.path)
+			}
+			if wc.SecretToken != test.config.SecretToken {
+				t.Errorf("SecretToken = %q, want %q", wc.SecretToken, test.config.SecretToken)
+			}
+		})
+	}
+
+	t.Run("generated secret", func(t *testing.T) {
+		wc := WebhookConfig{URL: "https://bot.example.com", Path: "telegram"}
+		if err := wc.Validate(); err != nil {
+			t.Fatalf("Validate() = %v", err)
+		}
+		if !secretTokenRegex.MatchString(wc.SecretToken) || len(wc.SecretToken) != 64 {
+			t.Errorf("SecretToken = %q, want 64 random hex digits", wc.SecretToken)
+		}
+
+		other := WebhookConfig{URL: "https://bot.example.com", Path: "telegram"}
+		if err := other.Validate(); err != nil {
+			t.Fatalf("Validate() = %v", err)
+		}
+		if other.SecretToken == wc.SecretToken {
+			t.Errorf("two generated secrets are both %q", wc.SecretToken)
+		}
+	})
+}
+
+func TestWebhookConfigEndpoint(t *testing.T) {
+	tests := []struct {
+		url, path, want string
+	}{
+		{"https://bot.example.com", "telegram", "https://bot.example.com/telegram"},
+		{"https://bot.example.com/", "telegram", "https://bot.example.com/telegram"},
+		{"https://example.com/bots", "run/telegram", "https://example.com/bots/run/telegram"},
+	}
+
+	for _, test := range tests {
+		wc := WebhookConfig{URL: test.url, Path: test.path}
+		if got := wc.Endpoint(); got != test.want {
+			t.Errorf("Endpoint() of %q and %q = %q, want %q", test.url, test.path, got, test.want)
+		}
+	}
+}
+
+func TestStartWebhook(t *testing.T) {
+	valid := WebhookConfig{URL: "https://bot.example.com", Path: "telegram", SecretToken: "secret"}
+
+	// start sets a webhook up with the HTTP server as startServer would.
+	start := func(t *testing.T, api *testAPI, wc WebhookConfig, mux bool) (*ChatManager, error) {
+		t.Helper()
+
+		h := newHarness(t)
+		h.cm.Bot = api.bot()
+		h.cm.Webhook = wc
+		if mux {
+			h.cm.mux = http.NewServeMux()
+		}
+		return h.cm, h.cm.startWebhook(ext.NewUpdater(h.dispatcher, nil))
+	}
+
+	t.Run("updates", func(t *testing.T) {
+		api := newTestAPI(t)
+		cm, err := start(t, api, valid, true)
+		if err != nil {
+			t.Fatalf("startWebhook() = %v", err)
+		}
+		if !cm.webhookActive || api.called("setWebhook") != 1 {
+			t.Fatalf("webhook active %v after %d setWebhook calls, want set once", cm.webhookActive, api.called("setWebhook"))
+		}
+
+		update := `{"update_id": 1}`
+		for secret, want := range map[string]int{"secret": http.StatusOK, "wrong": http.StatusUnauthorized, "": http.StatusUnauthorized} {
+			request := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(update))
+			if secret != "" {
+				request.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+			}
+			response := httptest.NewRecorder()
+			cm.mux.ServeHTTP(response, request)
+			if response.Code != want {
+				t.Errorf("update with secret %q answered %d, want %d", secret, response.Code, want)
+			}
+		}
+
+		cm.stopWebhook()
+		if cm.webhookActive || api.called("deleteWebhook") != 1 {
+			t.Errorf("webhook active %v after %d deleteWebhook calls, want deleted once", cm.webhookActive, api.called("deleteWebhook"))
+		}
+	})
+
+	// On error no webhook is active, so Start falls back to polling
+	tests := []struct {
+		name string
+		wc   WebhookConfig
+		mux  bool
+		fail []string
+		err  string
+	}{
+		{"invalid config", WebhookConfig{URL: "http://bot.example.com", Path: "telegram"}, true, nil, "must use https"},
+		{"no server", valid, false, nil, "no HTTP server"},
+		{"telegram refuses", valid, true, []string{"setWebhook"}, "error setting webhook"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI(t, test.fail...)
+			cm, err := start(t, api, test.wc, test.mux)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("startWebhook() = %v, want an error containing %q", err, test.err)
+			}
+			if cm.webhookActive {
+				t.Error("webhook active after an error")
+			}
+			if cm.Webhook != test.wc {
+				t.Errorf("Webhook = %+v, changed from %+v", cm.Webhook, test.wc)
+			}
+		})
+	}
+}
+
+func TestStartFallsBackToPolling(t *testing.T) {
+	api := newTestAPI(t)
+	h := newHarness(t)
+	h.cm.Bot = api.bot()
+	h.cm.Mode = MODE_WEBHOOK
+	h.cm.Webhook = WebhookConfig{URL: "http://bot.example.com", Path: "telegram"}
+	h.cm.ListenAddr = "127.0.0.1:0"
+
+	if err := h.cm.Start(); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := h.cm.Stop(ctx); err != nil {
+			t.Errorf("Stop() = %v", err)
+		}
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for api.called("getUpdates") == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if api.called("getUpdates") == 0 {
+		t.Error("no getUpdates call, the bot is not polling")
+	}
+	if h.cm.webhookActive || api.called("setWebhook") != 0 {
+		t.Errorf("webhook active %v after %d setWebhook calls, want none", h.cm.webhookActive, api.called("setWebhook"))
+	}
+}
@@ -0,0 +1,87 @@
+package chatmanager
+
+import (
+	"fmt"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	messagerenderer "run-tracker-telebot/src/pkg/message-renderer"
+	"run-tracker-telebot/src/pkg/stats"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// weekStart is the first day of the week in the group, Monday unless an admin changed it.
+func (cm *ChatManager) weekStart(ctx *ext.Context) time.Weekday {
+	setting := cm.DatabaseManager.GetGroupSettings(ctx.EffectiveChat.Id).WeekStart
+	if setting == "" {
+		return stats.DEFAULT_WEEK_START
+	}
+
+	weekStart, err := stats.ParseWeekday(setting)
+	if err != nil {
+		log.Warn().Msgf("Invalid week start %s for group %d: %v", setting, ctx.EffectiveChat.Id, err)
+		return stats.DEFAULT_WEEK_START
+	}
+	return weekStart
+}
+
+// handleWeekStart shows the first day of the week, or lets admins change it with
+// "/weekstart sunday", "/weekstart auto" going back to Monday.
+func (cm *ChatManager) handleWeekStart(b *gotgbot.Bot, ctx *ext.Context) error {
+	input := strings.ToLower(commandArgs(ctx.EffectiveMessage.Text))
+	if input == "" {
+		return cm.replyText(b, ctx, "weekstart.current", cm.weekdayName(ctx, cm.weekStart(ctx)))
+	}
+
+	if !cm.isAdmin(b, ctx) {
+		return cm.replyError(b, ctx, "weekstart.not_admin")
+	}
+
+	setting := ""
+	if input != SETTING_AUTO {
+		weekStart, err := stats.ParseWeekday(input)
+		if err != nil {
+			log.Warn().Msgf("Invalid week start: %v", err)
+			return cm.replyError(b, ctx, "weekstart.unknown", input)
+		}
+		setting = strings.ToLower(weekStart.String())
+	}
+
+	err := cm.DatabaseManager.UpdateGroupSettings(ctx.EffectiveChat.Id, func(settings *databasemanager.GroupSettings) {
+		settings.WeekStart = setting
+	})
+	if err != nil {
+		log.Warn().Msgf("Error saving week start of group %d: %v", ctx.EffectiveChat.Id, err)
+		return cm.replyError(b, ctx, "weekstart.error")
+	}
+
+	return cm.replyText(b, ctx, "weekstart.set", cm.weekdayName(ctx, cm.weekStart(ctx)))
+}
+
+// replyPeriodTotals answers "/getdistance <period> [type]" right away, e.g. "week",
+// "last week cycle" or "2024-W19 any", without going through the conversation. Without
+// a type it counts runs.
+func (cm *ChatManager) replyPeriodTotals(b *gotgbot.Bot, ctx *ext.Context, input string) error {
+	rest, activity := stats.SplitActivityType(input)
+	period, err := stats.ParsePeriod(rest, cm.now(ctx), cm.weekStart(ctx))
+	if err != nil {
+		log.Warn().Msgf("Invalid distance period: %v", err)
+		return cm.replyError(b, ctx, "distance.invalid_period", cm.translate(ctx, "distance.usage"))
+	}
+
+	totals, err := cm.periodTotals(ctx, period, activity)
+	if err != nil {
+		log.Warn().Msgf("Error getting total distance for user: %v", err)
+		return cm.replyError(b, ctx, "distance.error")
+	}
+
+	return cm.reply(b, ctx, messagerenderer.TEMPLATE_TOTALS, totals, nil)
+}
+
+// weekdayName translates a weekday, the catalog numbers them from Monday.
+func (cm *ChatManager) weekdayName(ctx *ext.Context, day time.Weekday) string {
+	return cm.translate(ctx, fmt.Sprintf("weekday.%d", (int(day)+6)%7))
+}
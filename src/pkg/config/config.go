@@ -0,0 +1,192 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"run-tracker-telebot/src/pkg/shared"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DEFAULT_FILE is read when CONFIG_FILE is not set, the bot runs on defaults and
+// environment variables without it.
+const DEFAULT_FILE = "config.toml"
+
+// Values of the choices of the config.
+const (
+	MODE_POLLING = "polling"
+	MODE_WEBHOOK = "webhook"
+
+	// DEFAULT_API_URL is the Bot API of Telegram, a local Bot API server or a fake one
+	// in tests can stand in for it.
+	DEFAULT_API_URL = "https://api.telegram.org"
+
+	// DEFAULT_LISTEN_ADDR is the port docker-compose publishes.
+	DEFAULT_LISTEN_ADDR  = ":8080"
+	DEFAULT_WEBHOOK_PATH = "telegram"
+
+	// BACKEND_JSON keeps the store in JSON files in the data dir, the only backend so far.
+	BACKEND_JSON = "json"
+)
+
+// Config is everything the bot is set up with. Each value comes from the defaults,
+// then the config file, then the environment variable named in its env tag.
+type Config struct {
+	Telegram TelegramConfig `toml:"telegram"`
+	Storage  StorageConfig  `toml:"storage"`
+	OCR      OCRConfig      `toml:"ocr"`
+	Defaults DefaultsConfig `toml:"defaults"`
+	Auth     AuthConfig     `toml:"auth"`
+	Log      LogConfig      `toml:"log"`
+}
+
+type TelegramConfig struct {
+	Token  string `toml:"token" env:"TELEGRAM_BOT_TOKEN"`
+	APIURL string `toml:"api_url" env:"TELEGRAM_API_URL"`
+	// UpdateMode is how updates reach the bot, MODE_POLLING or MODE_WEBHOOK.
+	UpdateMode string `toml:"update_mode" env:"UPDATE_MODE"`
+	// ListenAddr serves the health checks, the metrics and the webhook.
+	ListenAddr string `toml:"listen_addr" env:"LISTEN_ADDR"`
+	// WebhookURL is the public HTTPS address of the bot, WebhookPath is appended to it.
+	WebhookURL    string `toml:"webhook_url" env:"WEBHOOK_URL"`
+	WebhookPath   string `toml:"webhook_path" env:"WEBHOOK_PATH"`
+	WebhookSecret string `toml:"webhook_secret" env:"WEBHOOK_SECRET"`
+}
+
+type StorageConfig struct {
+	Backend string `toml:"backend" env:"STORAGE_BACKEND"`
+	// DataDir holds the files of the store, relative file names are in it.
+	DataDir      string `toml:"data_dir" env:"DATA_DIR"`
+	WorkoutsFile string `toml:"workouts_file" env:"WORKOUTS_FILE"`
+	UsersFile    string `toml:"users_file" env:"USERS_FILE"`
+	SettingsFile string `toml:"settings_file" env:"SETTINGS_FILE"`
+	RoutesDir    string `toml:"routes_dir" env:"ROUTES_DIR"`
+}
+
+type OCRConfig struct {
+	// Preprocessing lists the steps run before OCR, e.g. "grayscale,invert" or "none",
+	// empty for the default steps.
+	Preprocessing string `toml:"preprocessing" env:"OCR_PREPROCESSING"`
+	// Languages are the language packs of the first pass, e.g. "eng+deu".
+	Languages string `toml:"languages" env:"OCR_LANGUAGES"`
+}
+
+type DefaultsConfig struct {
+	// Timezone is used for users and groups that did not pick one.
+	Timezone string `toml:"timezone" env:"DEFAULT_TIMEZONE"`
+}
+
+type AuthConfig struct {
+	// Password is what new users answer /start with to be authorized.
+	Password string `toml:"password" env:"SECRET_PASSWORD"`
+}
+
+type LogConfig struct {
+	// Level is the lowest level logged, e.g. "debug" or "info".
+	Level string `toml:"level" env:"LOG_LEVEL"`
+	Dir   string `toml:"dir" env:"LOG_DIR"`
+}
+
+// Default is the config without a file or environment variables, short of the token
+// and the password.
+func Default() *Config {
+	return &Config{
+		Telegram: TelegramConfig{
+			APIURL:      DEFAULT_API_URL,
+			UpdateMode:  MODE_POLLING,
+			ListenAddr:  DEFAULT_LISTEN_ADDR,
+			WebhookPath: DEFAULT_WEBHOOK_PATH,
+		},
+		Storage: StorageConfig{
+			Backend:      BACKEND_JSON,
+			DataDir:      shared.WORKOUT_DATA_DIR,
+			WorkoutsFile: shared.WORKOUT_DATA_FILE,
+			UsersFile:    shared.AUTHORIZED_USERS_FILE,
+			SettingsFile: shared.SETTINGS_FILE,
+			RoutesDir:    shared.ROUTES_DIR,
+		},
+		Defaults: DefaultsConfig{Timezone: shared.DEFAULT_TIMEZONE},
+		Log:      LogConfig{Level: "debug", Dir: shared.LOGS_DIR},
+	}
+}
+
+// Load reads the config file at path over the defaults, then the environment over
+// both. An empty path reads CONFIG_FILE, or DEFAULT_FILE when it exists. The config is
+// not validated, see Validate.
+func Load(path string) (*Config, error) {
+	config := Default()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		if _, err := os.Stat(DEFAULT_FILE); err == nil {
+			path = DEFAULT_FILE
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config: %w", err)
+		}
+		if err := decodeTOML(string(data), config); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", path, err)
+		}
+	}
+
+	applyEnv(config)
+	return config, nil
+}
+
+// Validate checks every value, so a bad config stops the bot at startup rather than
+// on the first update that needs it. All problems are reported at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Telegram.Token != "", "telegram.token (TELEGRAM_BOT_TOKEN) is not set")
+	api, err := url.Parse(c.Telegram.APIURL)
+	check(err == nil && (api.Scheme == "https" || api.Scheme == "http") && api.Host != "", "telegram.api_url must be an http(s) url: %q", c.Telegram.APIURL)
+	mode := strings.ToLower(c.Telegram.UpdateMode)
+	check(mode == MODE_POLLING || mode == MODE_WEBHOOK, "telegram.update_mode must be %s or %s: %q", MODE_POLLING, MODE_WEBHOOK, c.Telegram.UpdateMode)
+	check(c.Telegram.ListenAddr != "", "telegram.listen_addr is empty")
+	if mode == MODE_WEBHOOK {
+		address, err := url.Parse(c.Telegram.WebhookURL)
+		check(err == nil && address.Scheme == "https" && address.Host != "", "telegram.webhook_url must be an https url: %q", c.Telegram.WebhookURL)
+	}
+
+	check(c.Storage.Backend == BACKEND_JSON, "storage.backend must be %s: %q", BACKEND_JSON, c.Storage.Backend)
+	check(c.Storage.DataDir != "", "storage.data_dir is empty")
+	check(c.Storage.WorkoutsFile != "", "storage.workouts_file is empty")
+	check(c.Storage.UsersFile != "", "storage.users_file is empty")
+	check(c.Storage.SettingsFile != "", "storage.settings_file is empty")
+	check(c.Storage.RoutesDir != "", "storage.routes_dir is empty")
+
+	_, err = time.LoadLocation(c.Defaults.Timezone)
+	check(c.Defaults.Timezone != "" && err == nil, "defaults.timezone is no timezone: %q", c.Defaults.Timezone)
+
+	check(c.Auth.Password != "", "auth.password (SECRET_PASSWORD) is not set, nobody could sign up")
+
+	_, err = zerolog.ParseLevel(strings.ToLower(c.Log.Level))
+	check(c.Log.Level != "" && err == nil, "log.level is no level: %q", c.Log.Level)
+	check(c.Log.Dir != "", "log.dir is empty")
+
+	return errors.Join(errs...)
+}
+
+// Path is where a file of the store is, relative names being in the data dir.
+func (s StorageConfig) Path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.DataDir, name)
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeTOML(t *testing.T) {
+	text := `# The bot
+[telegram]
+token = "123:abc" # the token
+update_mode = 'webhook'
+webhook_secret = "a \"quoted\" # secret\t"
+webhook_path = 'C:\bots\run "tracker"'
+api_url = "\u00e9\U0001F3C3\b\f\n\r\\"
+
+  [ ocr ]
+preprocessing='grayscale,invert # not a comment'
+
+[log]
+level="info"
+`
+	config := Default()
+	if err := decodeTOML(text, config); err != nil {
+		t.Fatalf("decodeTOML: %v", err)
+	}
+
+	tests := map[string][2]string{
+		"token":          {config.Telegram.Token, "123:abc"},
+		"update_mode":    {config.Telegram.UpdateMode, MODE_WEBHOOK},
+		"webhook_secret": {config.Telegram.WebhookSecret, "a \"quoted\" # secret\t"},
+		"preprocessing":  {config.OCR.Preprocessing, "grayscale,invert # not a comment"},
+		"level":          {config.Log.Level, "info"},
+		"webhook_path":   {config.Telegram.WebhookPath, `C:\bots\run "tracker"`},
+		"api_url":        {config.Telegram.APIURL, "é🏃\b\f\n\r\\"},
+		// Left alone
+		"data_dir": {config.Storage.DataDir, Default().Storage.DataDir},
+	}
+	for key, values := range tests {
+		if values[0] != values[1] {
+			t.Errorf("%s = %q, want %q", key, values[0], values[1])
+		}
+	}
+}
+
+func TestDecodeTOMLErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"[telegram\ntoken = \"x\"", "line 1: unclosed table header"},
+		{"[telegrma]", "line 1: unknown table [telegrma]"},
+		{"token = \"x\"", "line 1: key \"token\" outside of a table"},
+		{"[telegram]\n\ntokn = \"x\"", "line 3: unknown key telegram.tokn"},
+		{"[telegram]\ntoken", "line 2: expected key = value"},
+		{"[telegram]\ntoken = 123", "line 2: telegram.token: expected a quoted string, got 123"},
+		{"[telegram]\ntoken = \"123", "line 2: telegram.token: invalid string \"123: missing closing quote"},
+		{"[telegram]\ntoken = \"bad \\q escape\"", "line 2: telegram.token: invalid string"},
+		// Go escapes TOML does not have
+		{`[telegram]` + "\n" + `token = "\x41"`, `line 2: telegram.token: invalid string "\x41": invalid escape \x`},
+		{`[telegram]` + "\n" + `token = "\101"`, `line 2: telegram.token: invalid string "\101": invalid escape \1`},
+		{`[telegram]` + "\n" + `token = "\a"`, `line 2: telegram.token: invalid string "\a": invalid escape \a`},
+		{`[telegram]` + "\n" + `token = "\ud800"`, `line 2: telegram.token: invalid string "\ud800": invalid escape \ud800`},
+		{`[telegram]` + "\n" + `token = "\u00"`, `line 2: telegram.token: invalid string "\u00": short escape`},
+		{"[telegram]\ntoken = \"a\x01\"", "line 2: telegram.token: invalid string \"a\x01\": control character U+0001"},
+		{"[telegram]\ntoken = 'a\x7f'", "line 2: telegram.token: invalid string 'a\x7f': control character U+007F"},
+		{`[telegram]` + "\n" + `token = "a" "b"`, `line 2: telegram.token: invalid string "a" "b": text after the closing quote`},
+		{`[telegram]` + "\n" + `token = 'a'b'`, `line 2: telegram.token: invalid string 'a'b': text after the closing quote`},
+		{`[telegram]` + "\n" + `token = 'a`, `line 2: telegram.token: invalid string 'a: missing closing quote`},
+		// Syntax outside of the subset
+		{`[telegram]` + "\n" + `token = """x"""`, "line 2: telegram.token: multi-line strings are not supported"},
+		{`[telegram]` + "\n" + `token = '''x'''`, "line 2: telegram.token: multi-line strings are not supported"},
+		{`[telegram]` + "\n" + `token = ["x"]`, "line 2: telegram.token: expected a quoted string"},
+		{`[telegram]` + "\n" + `token = { a = "x" }`, "line 2: telegram.token: expected a quoted string"},
+		{`[telegram]` + "\n" + `"token" = "x"`, `line 2: unsupported key "token"`},
+		{`[telegram]` + "\n" + `telegram.token = "x"`, "line 2: unsupported key telegram.token"},
+		{"[[telegram]]", "line 1: arrays of tables are not supported"},
+		{"[telegram.webhook]", "line 1: unknown table [telegram.webhook]"},
+		{"[telegram]\ntoken = \"x\"\ntoken = \"y\"", "line 3: key telegram.token set twice"},
+		{"[telegram]\n[log]\n[telegram]", "line 3: table [telegram] defined twice"},
+	}
+
+	for _, test := range tests {
+		err := decodeTOML(test.text, Default())
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("decodeTOML(%q) = %v, want %q", test.text, err, test.want)
+		}
+	}
+}
+
+func TestSampleConfig(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "config.sample.toml"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if err := decodeTOML(string(data), Default()); err != nil {
+		t.Errorf("config.sample.toml: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.toml")
+	err := os.WriteFile(path, []byte("[telegram]\ntoken = \"from-file\"\nlisten_addr = \":9000\"\n[auth]\npassword = \"file\"\n"), 0o644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// The environment wins over the file and empty variables do not count
+	t.Setenv("TELEGRAM_BOT_TOKEN", "from-env")
+	t.Setenv("SECRET_PASSWORD", "")
+	t.Setenv("LISTEN_ADDR", ":7000")
+	t.Setenv("DEFAULT_TIMEZONE", "Europe/Berlin")
+	t.Setenv("LOG_LEVEL", "")
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	tests := map[string][2]string{
+		"token":       {config.Telegram.Token, "from-env"},
+		"password":    {config.Auth.Password, "file"},
+		"listen_addr": {config.Telegram.ListenAddr, ":7000"},
+		"timezone":    {config.Defaults.Timezone, "Europe/Berlin"},
+		"level":       {config.Log.Level, Default().Log.Level},
+	}
+	for key, values := range tests {
+		if values[0] != values[1] {
+			t.Errorf("%s = %q, want %q", key, values[0], values[1])
+		}
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Errorf("Load() of a missing file succeeded, want error")
+	}
+	t.Setenv("CONFIG_FILE", path)
+	if config, err := Load(""); err != nil || config.Auth.Password != "file" {
+		t.Errorf("Load() with CONFIG_FILE = %+v, %v, want the file read", config, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		config := Default()
+		config.Telegram.Token = "123:abc"
+		config.Auth.Password = "secret"
+		return config
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Validate() of a valid config: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   []string
+	}{
+		{"webhook without url", func(c *Config) { c.Telegram.UpdateMode = "WEBHOOK" }, []string{"telegram.webhook_url"}},
+		{"webhook over http", func(c *Config) {
+			c.Telegram.UpdateMode, c.Telegram.WebhookURL = MODE_WEBHOOK, "http://bot.example.com"
+		}, []string{"telegram.webhook_url"}},
+		{"everything", func(c *Config) {
+			*c = Config{Telegram: TelegramConfig{APIURL: "api.telegram.org", UpdateMode: "push"}, Defaults: DefaultsConfig{Timezone: "Mars/Olympus"}, Log: LogConfig{Level: "loud"}}
+		}, []string{
+			"telegram.token", "telegram.api_url", "telegram.update_mode", "telegram.listen_addr",
+			"storage.backend", "storage.data_dir", "storage.workouts_file", "storage.users_file",
+			"storage.settings_file", "storage.routes_dir", "defaults.timezone", "auth.password",
+			"log.level", "log.dir",
+		}},
+	}
+
+	for _, test := range tests {
+		config := valid()
+		test.change(config)
+		err := config.Validate()
+		if err == nil {
+			t.Errorf("%s: Validate() succeeded, want errors about %v", test.name, test.want)
+			continue
+		}
+		// Every problem on a line of its own, in the order of the config
+		lines := strings.Split(err.Error(), "\n")
+		if len(lines) != len(test.want) {
+			t.Errorf("%s: Validate() = %d errors, want %d:\n%v", test.name, len(lines), len(test.want), err)
+			continue
+		}
+		for i, line := range lines {
+			if !strings.HasPrefix(line, test.want[i]) {
+				t.Errorf("%s: error %d = %q, want it about %s", test.name, i+1, line, test.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The config file is a subset of TOML, the tables of strings the bot needs:
+//
+//	# comment
+//	[telegram]
+//	token = "123:abc"
+//	update_mode = 'webhook'
+//
+// Keys are bare, strings are basic strings in double quotes with the escapes of TOML
+// or literal strings in single quotes. Anything else, numbers, arrays, inline tables,
+// quoted or dotted keys, arrays of tables and multi-line strings, is an error rather
+// than read the wrong way, as are unknown and repeated tables and keys, so typos do
+// not go unnoticed.
+
+// bareKeyRegex matches the keys and table names of the subset.
+var bareKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// decodeTOML sets the fields of config named by the toml tags of its tables and keys.
+func decodeTOML(text string, config *Config) error {
+	root := reflect.ValueOf(config).Elem()
+	var table reflect.Value
+	tableName := ""
+
+	seen := make(map[string]bool)
+
+	for i, line := range strings.Split(text, "\n") {
+		number := i + 1
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") {
+			return fmt.Errorf("line %d: arrays of tables are not supported", number)
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %d: unclosed table header", number)
+			}
+			tableName = strings.TrimSpace(line[1 : len(line)-1])
+			field, ok := fieldByTag(root, "toml", tableName)
+			if !bareKeyRegex.MatchString(tableName) || !ok || field.Kind() != reflect.Struct {
+				return fmt.Errorf("line %d: unknown table [%s]", number, tableName)
+			}
+			if seen["["+tableName+"]"] {
+				return fmt.Errorf("line %d: table [%s] defined twice", number, tableName)
+			}
+			seen["["+tableName+"]"] = true
+			table = field
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return fmt.Errorf("line %d: expected key = value", number)
+		}
+		key = strings.TrimSpace(key)
+		if !bareKeyRegex.MatchString(key) {
+			return fmt.Errorf("line %d: unsupported key %s, only bare keys are", number, key)
+		}
+		if !table.IsValid() {
+			return fmt.Errorf("line %d: key %q outside of a table", number, key)
+		}
+		field, ok := fieldByTag(table, "toml", key)
+		if !ok {
+			return fmt.Errorf("line %d: unknown key %s.%s", number, tableName, key)
+		}
+		if seen[tableName+"."+key] {
+			return fmt.Errorf("line %d: key %s.%s set twice", number, tableName, key)
+		}
+		seen[tableName+"."+key] = true
+		if err := setTOML(field, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("line %d: %s.%s: %w", number, tableName, key, err)
+		}
+	}
+	return nil
+}
+
+// stripComment cuts a comment off a line, unless the # is in a string.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// setTOML sets a field from a TOML string, in double quotes with escapes or in single
+// quotes as it is.
+func setTOML(field reflect.Value, value string) error {
+	var unquoted string
+	var err error
+	switch {
+	case strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''"):
+		return errors.New("multi-line strings are not supported")
+	case strings.HasPrefix(value, `"`):
+		unquoted, err = basicString(value)
+	case strings.HasPrefix(value, "'"):
+		unquoted, err = literalString(value)
+	default:
+		return fmt.Errorf("expected a quoted string, got %s", value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid string %s: %w", value, err)
+	}
+	field.SetString(unquoted)
+	return nil
+}
+
+// basicString unquotes a string in double quotes. The escapes are those of TOML 1.0,
+// not of Go: there are no \a, \v, \x or octal escapes.
+func basicString(value string) (string, error) {
+	var unquoted strings.Builder
+	for i := 1; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		switch {
+		case r == '"':
+			if i+size != len(value) {
+				return "", errors.New("text after the closing quote")
+			}
+			return unquoted.String(), nil
+		case r == '\\':
+			escaped, length, err := unescape(value[i:])
+			if err != nil {
+				return "", err
+			}
+			unquoted.WriteRune(escaped)
+			i += length
+			continue
+		case isControl(r):
+			return "", fmt.Errorf("control character %U", r)
+		}
+		unquoted.WriteRune(r)
+		i += size
+	}
+	return "", errors.New("missing closing quote")
+}
+
+// unescape reads the escape sequence at the start of text, returning the rune and the
+// length of the sequence.
+func unescape(text string) (rune, int, error) {
+	if len(text) < 2 {
+		return 0, 0, errors.New("missing closing quote")
+	}
+	switch text[1] {
+	case 'b':
+		return '\b', 2, nil
+	case 't':
+		return '\t', 2, nil
+	case 'n':
+		return '\n', 2, nil
+	case 'f':
+		return '\f', 2, nil
+	case 'r':
+		return '\r', 2, nil
+	case '"':
+		return '"', 2, nil
+	case '\\':
+		return '\\', 2, nil
+	case 'u', 'U':
+		digits := 4
+		if text[1] == 'U' {
+			digits = 8
+		}
+		if len(text) < 2+digits {
+			return 0, 0, fmt.Errorf("short escape %s", text)
+		}
+		code, err := strconv.ParseUint(text[2:2+digits], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return 0, 0, fmt.Errorf("invalid escape %s", text[:2+digits])
+		}
+		return rune(code), 2 + digits, nil
+	}
+	return 0, 0, fmt.Errorf("invalid escape %s", text[:2])
+}
+
+// literalString unquotes a string in single quotes, which has no escapes.
+func literalString(value string) (string, error) {
+	end := strings.IndexByte(value[1:], '\'')
+	if end < 0 {
+		return "", errors.New("missing closing quote")
+	}
+	if end+2 != len(value) {
+		return "", errors.New("text after the closing quote")
+	}
+	unquoted := value[1 : end+1]
+	for _, r := range unquoted {
+		if isControl(r) {
+			return "", fmt.Errorf("control character %U", r)
+		}
+	}
+	return unquoted, nil
+}
+
+// isControl reports the characters TOML does not allow in a string unescaped, tabs
+// are allowed.
+func isControl(r rune) bool {
+	return r != '\t' && (r < 0x20 || r == 0x7f)
+}
+
+// applyEnv sets the fields of config from the variables of their env tags. Empty
+// variables are skipped, as in an .env file listing every variable.
+func applyEnv(config *Config) {
+	root := reflect.ValueOf(config).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		table := root.Field(i)
+		for j := 0; j < table.NumField(); j++ {
+			name := table.Type().Field(j).Tag.Get("env")
+			if value := os.Getenv(name); name != "" && value != "" {
+				table.Field(j).SetString(value)
+			}
+		}
+	}
+}
+
+func fieldByTag(v reflect.Value, tag string, name string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get(tag) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
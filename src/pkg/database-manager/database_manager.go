@@ -2,13 +2,18 @@ package databasemanager
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"run-tracker-telebot/src/log"
-	"run-tracker-telebot/src/pkg/shared"
+	"run-tracker-telebot/src/pkg/config"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type WorkoutData struct {
@@ -17,15 +22,57 @@ type WorkoutData struct {
 }
 
 type WorkoutEntry struct {
-	Distance string `json:"distance"`
-	Pace     string `json:"pace"`
+	// Type is the kind of activity, entries from before there were types are runs.
+	Type          string  `json:"type,omitempty"`
+	Distance      string  `json:"distance"`
+	Pace          string  `json:"pace"`
+	Time          string  `json:"time,omitempty"`
+	ElevationGain string  `json:"elevation_gain,omitempty"`
+	HeartRate     string  `json:"heart_rate,omitempty"`
+	MaxHeartRate  string  `json:"max_heart_rate,omitempty"`
+	Source        string  `json:"source,omitempty"`
+	Confidence    float64 `json:"confidence,omitempty"`
+	// Route is the file name of the route map, for workouts with a GPS track.
+	Route string `json:"route,omitempty"`
+	// ImageHash is the perceptual hash of the screenshot, Fingerprint identifies the
+	// run by distance, time and date. Both are used to spot duplicates.
+	ImageHash   string `json:"image_hash,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Review is set for workouts that failed a plausibility check, Flags says which.
+	Review string   `json:"review,omitempty"`
+	Flags  []string `json:"flags,omitempty"`
 }
 
+// Where a workout entry comes from. Text read from a screenshot can be off, activity
+// files hold what the watch measured, so their entries are trusted more.
+const (
+	SOURCE_SCREENSHOT = "screenshot"
+
+	CONFIDENCE_SCREENSHOT    = 0.6
+	CONFIDENCE_ACTIVITY_FILE = 0.95
+)
+
+// Kinds of activity a workout entry can be.
+const (
+	ACTIVITY_RUN   = "run"
+	ACTIVITY_WALK  = "walk"
+	ACTIVITY_CYCLE = "cycle"
+	ACTIVITY_SWIM  = "swim"
+	ACTIVITY_HIKE  = "hike"
+)
+
+var ACTIVITY_TYPES = []string{ACTIVITY_RUN, ACTIVITY_WALK, ACTIVITY_CYCLE, ACTIVITY_SWIM, ACTIVITY_HIKE}
+
 type DatabaseManager struct {
-	FilePath     string
-	UserFilePath string
-	Data         *WorkoutData
-	UserData     *UserToIdMap
+	FilePath         string
+	UserFilePath     string
+	SettingsFilePath string
+	RoutesDir        string
+	Data             *WorkoutData
+	UserData         *UserToIdMap
+	Settings         *Settings
+	// loaded is set once the workouts are read, the bot is not ready before.
+	loaded atomic.Bool
 }
 
 type UserToIdMap struct {
@@ -33,11 +80,16 @@ type UserToIdMap struct {
 	sync.Mutex
 }
 
-func NewDatabaseManager(filePath string, userFilepath string) *DatabaseManager {
+// NewDatabaseManager keeps the store in the files of the storage config, which is
+// validated with the config.
+func NewDatabaseManager(storage config.StorageConfig) *DatabaseManager {
 	return &DatabaseManager{
-		FilePath:     filePath,
-		UserFilePath: userFilepath,
-		Data:         &WorkoutData{},
+		FilePath:         storage.Path(storage.WorkoutsFile),
+		UserFilePath:     storage.Path(storage.UsersFile),
+		SettingsFilePath: storage.Path(storage.SettingsFile),
+		RoutesDir:        storage.Path(storage.RoutesDir),
+		Data:             &WorkoutData{},
+		Settings:         NewSettings(),
 	}
 }
 
@@ -48,23 +100,24 @@ func NewWorkoutData() *WorkoutData {
 }
 
 func (db *DatabaseManager) LoadUserData() error {
-	if _, err := os.Stat(shared.WORKOUT_DATA_DIR); os.IsNotExist(err) {
-		log.Debug().Msgf("Data dir does not exist, Creating workout data directory: %v", shared.WORKOUT_DATA_DIR)
-		os.Mkdir(shared.WORKOUT_DATA_DIR, os.ModePerm)
+	dir := filepath.Dir(db.UserFilePath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		log.Debug().Msgf("Data dir does not exist, Creating workout data directory: %v", dir)
+		os.MkdirAll(dir, os.ModePerm)
 	}
 
 	log.Debug().Msgf("Data Directory Exists...")
 
-	if _, err := os.Stat(shared.WORKOUT_DATA_DIR + "/" + shared.AUTHORIZED_USERS_FILE); os.IsNotExist(err) {
-		log.Debug().Msgf("User data file does not exist, Creating User data file: %v", shared.AUTHORIZED_USERS_FILE)
-		file, err := os.Create(shared.WORKOUT_DATA_DIR + "/" + shared.AUTHORIZED_USERS_FILE)
+	if _, err := os.Stat(db.UserFilePath); os.IsNotExist(err) {
+		log.Debug().Msgf("User data file does not exist, Creating User data file: %v", db.UserFilePath)
+		file, err := os.Create(db.UserFilePath)
 		if err != nil {
 			log.Warn().Msgf("Error creating User data file: %v", err)
 		}
 		defer file.Close()
 	}
 
-	fileContent, err := ioutil.ReadFile(shared.WORKOUT_DATA_DIR + "/" + shared.AUTHORIZED_USERS_FILE)
+	fileContent, err := ioutil.ReadFile(db.UserFilePath)
 	if err != nil {
 		log.Warn().Msgf("Error reading file: %v", err)
 		return fmt.Errorf("error reading file: %v", err)
@@ -86,6 +139,8 @@ func (db *DatabaseManager) LoadUserData() error {
 }
 
 func (db *DatabaseManager) SaveUserData() error {
+	defer storeWrite.Since(time.Now(), STORE_USERS)
+
 	file, err := os.Create(db.UserFilePath)
 	if err != nil {
 		log.Warn().Msgf("Error creating file: %v", err)
@@ -154,14 +209,15 @@ func (db *DatabaseManager) GetAllWorkouts(groupID int64) (map[int64]map[string]W
 
 func (db *DatabaseManager) LoadData() error {
 
-	if _, err := os.Stat(shared.WORKOUT_DATA_DIR); os.IsNotExist(err) {
+	dir := filepath.Dir(db.FilePath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		log.Debug().Msgf("Data dir does not exist, Creating workout data directory")
-		os.Mkdir(shared.WORKOUT_DATA_DIR, os.ModePerm)
+		os.MkdirAll(dir, os.ModePerm)
 	}
 
-	if _, err := os.Stat(shared.WORKOUT_DATA_DIR + "/" + shared.WORKOUT_DATA_FILE); os.IsNotExist(err) {
+	if _, err := os.Stat(db.FilePath); os.IsNotExist(err) {
 		log.Debug().Msgf("Workout data file does not exist, Creating workout data file")
-		file, err := os.Create(shared.WORKOUT_DATA_DIR + "/" + shared.WORKOUT_DATA_FILE)
+		file, err := os.Create(db.FilePath)
 		if err != nil {
 			log.Warn().Msgf("Error creating workout data file: %v", err)
 		}
@@ -186,10 +242,20 @@ func (db *DatabaseManager) LoadData() error {
 		return fmt.Errorf("error unmarshalling JSON: %v", err)
 	}
 
+	db.loaded.Store(true)
 	return nil
 }
 
+// Loaded reports whether the workouts were read from disk.
+func (db *DatabaseManager) Loaded() bool {
+	return db.loaded.Load()
+}
+
+// SaveData writes the workouts to disk, the caller must hold the data lock so that
+// Flush waits for the write.
 func (db *DatabaseManager) SaveData() error {
+	defer storeWrite.Since(time.Now(), STORE_WORKOUTS)
+
 	file, err := os.Create(db.FilePath)
 	if err != nil {
 		log.Warn().Msgf("Error creating file: %v", err)
@@ -210,6 +276,40 @@ func (db *DatabaseManager) SaveData() error {
 	return nil
 }
 
+// Flush waits for writes in progress and syncs the files of the store to disk, e.g. on
+// shutdown. Changes are written as they are made, so nothing else is pending.
+func (db *DatabaseManager) Flush() error {
+	db.Data.Lock()
+	errData := syncFile(db.FilePath)
+	db.Data.Unlock()
+
+	var errUsers error
+	if db.UserData != nil {
+		db.UserData.Lock()
+		errUsers = syncFile(db.UserFilePath)
+		db.UserData.Unlock()
+	}
+
+	db.Settings.Lock()
+	errSettings := syncFile(db.SettingsFilePath)
+	db.Settings.Unlock()
+
+	return errors.Join(errData, errUsers, errSettings)
+}
+
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
 func (db *DatabaseManager) InsertWorkoutEntry(chatID int64, userID int64, date string, workoutDetails map[string]string) bool {
 
 	log.Debug().Msgf("Inserting Workout Entry in database: %v", workoutDetails)
@@ -236,20 +336,92 @@ func (db *DatabaseManager) InsertWorkoutEntry(chatID int64, userID int64, date s
 	}
 
 	log.Debug().Msgf("Appending into Workout Database: %v", workoutDetails)
-	db.Data.Workouts[chatID][userID][date] = WorkoutEntry{
-		Distance: workoutDetails["Distance"],
-		Pace:     workoutDetails["Pace"],
-	}
+	confidence, _ := strconv.ParseFloat(workoutDetails["Confidence"], 64)
+	entry := WorkoutEntry{
+		Type:          workoutDetails["Type"],
+		Distance:      workoutDetails["Distance"],
+		Pace:          workoutDetails["Pace"],
+		Time:          workoutDetails["TotalTime"],
+		ElevationGain: workoutDetails["ElevationGain"],
+		HeartRate:     workoutDetails["HeartRate"],
+		MaxHeartRate:  workoutDetails["MaxHeartRate"],
+		Source:        workoutDetails["Source"],
+		Confidence:    confidence,
+		Route:         workoutDetails["Route"],
+		ImageHash:     workoutDetails["ImageHash"],
+		Fingerprint:   WorkoutFingerprint(date, workoutDetails["Distance"], workoutDetails["TotalTime"], workoutDetails["Pace"]),
+		Review:        workoutDetails["Review"],
+	}
+	if flags := workoutDetails["Flags"]; flags != "" {
+		entry.Flags = strings.Split(flags, ",")
+	}
+	db.Data.Workouts[chatID][userID][date] = entry
 
 	log.Info().Msgf("Workout entry inserted into database successfully: %v", workoutDetails)
 	return true
 }
 
+// ActivityType is the kind of activity of the entry, run for entries without one.
+func (entry WorkoutEntry) ActivityType() string {
+	if entry.Type == "" {
+		return ACTIVITY_RUN
+	}
+	return entry.Type
+}
+
+// IsActivity tells if the entry is of the activity type, any type matches an empty one.
+func (entry WorkoutEntry) IsActivity(activity string) bool {
+	return activity == "" || entry.ActivityType() == activity
+}
+
+// WorkoutsOfType leaves out the workouts of other activity types.
+func WorkoutsOfType(workouts map[string]WorkoutEntry, activity string) map[string]WorkoutEntry {
+	filtered := make(map[string]WorkoutEntry, len(workouts))
+	for date, entry := range workouts {
+		if entry.IsActivity(activity) {
+			filtered[date] = entry
+		}
+	}
+	return filtered
+}
+
+// SetWorkoutType changes the activity type of a logged workout.
+func (db *DatabaseManager) SetWorkoutType(chatID int64, userID int64, date string, activity string) bool {
+	db.Data.Lock()
+	defer db.Data.Unlock()
+
+	entry, exists := db.Data.Workouts[chatID][userID][date]
+	if !exists {
+		log.Warn().Msgf("No workout of user %d on %s in chat %d", userID, date, chatID)
+		return false
+	}
+
+	entry.Type = activity
+	db.Data.Workouts[chatID][userID][date] = entry
+	if err := db.SaveData(); err != nil {
+		log.Warn().Msgf("Error saving workout type: %v", err)
+		return false
+	}
+	return true
+}
+
+// HasWorkout tells if the user already logged a workout on date. Like for
+// InsertWorkoutEntry, the caller holds the lock of Data.
+func (db *DatabaseManager) HasWorkout(chatID int64, userID int64, date string) bool {
+	_, exists := db.Data.Workouts[chatID][userID][date]
+	return exists
+}
+
 func (db *DatabaseManager) DeleteWorkout(chatID int64, userID int64, date string) bool {
 	log.Debug().Msgf("Acquiring lock...")
 	db.Data.Lock()
 	defer db.Data.Unlock()
 
+	return db.deleteWorkout(chatID, userID, date)
+}
+
+// deleteWorkout is DeleteWorkout for callers that hold the lock of Data.
+func (db *DatabaseManager) deleteWorkout(chatID int64, userID int64, date string) bool {
 	if db.Data.Workouts[chatID] == nil {
 		log.Warn().Msgf("No workouts found for chat: %v", chatID)
 		return false
@@ -264,6 +436,9 @@ func (db *DatabaseManager) DeleteWorkout(chatID int64, userID int64, date string
 			// Delete the date key
 
 			log.Info().Msgf("Deleting workout entry for user: %v, date: %v", userID, date)
+			if route := dateMap[date].Route; route != "" {
+				db.deleteRoute(route)
+			}
 			delete(dateMap, date)
 
 			// If the dateMap becomes empty after deletion, clean up the map
@@ -285,40 +460,9 @@ func (db *DatabaseManager) DeleteWorkout(chatID int64, userID int64, date string
 	return true
 }
 
-func (db *DatabaseManager) GetTotalDistanceByWeek(chatId int64, startDate string, endDate string) (map[int64]string, error) {
-	log.Debug().Msgf("Acquiring lock...")
-	db.Data.Lock()
-	defer db.Data.Unlock()
-
-	if db.Data.Workouts[chatId] == nil {
-		log.Warn().Msgf("No workouts found for chat: %v", chatId)
-		return nil, fmt.Errorf("no workouts found for chat: %v", chatId)
-	}
-
-	totalDistance := make(map[int64]string)
-
-	for userID, userWorkouts := range db.Data.Workouts[chatId] {
-		var distance float64
-		for date, workout := range userWorkouts {
-			if date >= startDate && date <= endDate {
-				log.Debug().Msgf("Adding distance to float: %v", workout.Distance)
-				floatValue, err := strconv.ParseFloat(workout.Distance, 64)
-				if err != nil {
-					log.Warn().Msgf("Error parsing distance: %v", err)
-					return nil, fmt.Errorf("error parsing distance: %v", err)
-				}
-
-				log.Debug().Msgf("Adding distance: %v", workout.Distance)
-				distance += floatValue
-			}
-		}
-		totalDistance[userID] = fmt.Sprintf("%.2f", distance)
-	}
-
-	return totalDistance, nil
-}
-
-func (db *DatabaseManager) GetTotalDistanceByMonth(chatId int64, month string, year string) (map[int64]string, error) {
+// GetTotalDistanceByWeek sums up the distance of every user between both dates. Only
+// workouts of the activity type count, of any type when it is empty.
+func (db *DatabaseManager) GetTotalDistanceByWeek(chatId int64, startDate string, endDate string, activity string) (map[int64]string, error) {
 	log.Debug().Msgf("Acquiring lock...")
 	db.Data.Lock()
 	defer db.Data.Unlock()
@@ -330,13 +474,10 @@ func (db *DatabaseManager) GetTotalDistanceByMonth(chatId int64, month string, y
 
 	totalDistance := make(map[int64]string)
 
-	log.Debug().Msgf("Month: %v, Year: %v", month, year)
 	for userID, userWorkouts := range db.Data.Workouts[chatId] {
 		var distance float64
 		for date, workout := range userWorkouts {
-			log.Debug().Msgf("date: %v", date)
-			log.Debug().Msgf("month: %v, year: %v", date[5:6], date[0:3])
-			if date[5:7] == month && date[0:4] == year {
+			if date >= startDate && date <= endDate && workout.Counted() && workout.IsActivity(activity) {
 				log.Debug().Msgf("Adding distance to float: %v", workout.Distance)
 				floatValue, err := strconv.ParseFloat(workout.Distance, 64)
 				if err != nil {
@@ -351,13 +492,13 @@ func (db *DatabaseManager) GetTotalDistanceByMonth(chatId int64, month string, y
 		totalDistance[userID] = fmt.Sprintf("%.2f", distance)
 	}
 
-	log.Debug().Msgf("Releasing Lock...")
 	return totalDistance, nil
 }
 
 func (db *DatabaseManager) SaveUser(userName string, userId int64) error {
 	log.Debug().Msgf("Acquiring lock...")
 	db.UserData.Lock()
+	defer db.UserData.Unlock()
 
 	// Check if the map is nil and initialize it if necessary
 	if db.UserData.Users == nil {
@@ -374,7 +515,6 @@ func (db *DatabaseManager) SaveUser(userName string, userId int64) error {
 
 	log.Debug().Msgf("Writing into database")
 	db.SaveUserData()
-	log.Debug().Msgf("Releasing lock...")
 	return nil
 }
 
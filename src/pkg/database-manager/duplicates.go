@@ -0,0 +1,174 @@
+package databasemanager
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Workouts are taken as duplicates when their screenshots look the same, or when they
+// have the same fingerprint: distance, time and date. That catches a screenshot
+// forwarded twice as well as the same run posted to two groups. Screenshots of one app
+// share their layout and can hash alike, so they also need the same distance to match.
+
+// Duplicate is a logged workout another one looks like.
+type Duplicate struct {
+	ChatID int64
+	UserID int64
+	Date   string
+	Entry  WorkoutEntry
+	// SameImage is set when the screenshots match, otherwise the fingerprints do.
+	SameImage bool
+}
+
+// DuplicatePair is two logged workouts that look like the same run.
+type DuplicatePair struct {
+	First  Duplicate
+	Second Duplicate
+}
+
+// WorkoutFingerprint identifies a run by its distance, its time and the day it was
+// logged. Workouts without a time, like most screenshots, use their pace instead.
+func WorkoutFingerprint(date string, distance string, totalTime string, pace string) string {
+	distance = normalizeDistance(distance)
+	if seconds, ok := clockSeconds(totalTime); ok {
+		return fmt.Sprintf("%s|%s|%ds", date, distance, seconds)
+	}
+	return fmt.Sprintf("%s|%s|%s", date, distance, strings.Join(strings.Fields(pace), ""))
+}
+
+// FindDuplicate looks through the workouts the user logged in any group for one like
+// the workout about to be logged. Matching screenshots win over matching fingerprints,
+// sameImage compares two image hashes.
+func (db *DatabaseManager) FindDuplicate(userID int64, date string, workoutDetails map[string]string, sameImage func(a string, b string) bool) *Duplicate {
+	db.Data.Lock()
+	defer db.Data.Unlock()
+
+	fingerprint := WorkoutFingerprint(date, workoutDetails["Distance"], workoutDetails["TotalTime"], workoutDetails["Pace"])
+	candidate := WorkoutEntry{Distance: workoutDetails["Distance"], ImageHash: workoutDetails["ImageHash"]}
+
+	var found *Duplicate
+	for _, chatID := range db.sortedChats() {
+		for entryDate, entry := range db.Data.Workouts[chatID][userID] {
+			duplicate := &Duplicate{ChatID: chatID, UserID: userID, Date: entryDate, Entry: entry}
+			switch {
+			case sameScreenshot(candidate, entry, sameImage):
+				duplicate.SameImage = true
+			case entry.fingerprint(entryDate) == fingerprint:
+			default:
+				continue
+			}
+			if found == nil || better(duplicate, found) {
+				found = duplicate
+			}
+		}
+	}
+	return found
+}
+
+// ScanDuplicates lists the pairs of workouts logged in the chat that look like the same
+// run, or like a run the same user logged in another group.
+func (db *DatabaseManager) ScanDuplicates(chatID int64, sameImage func(a string, b string) bool) []DuplicatePair {
+	db.Data.Lock()
+	defer db.Data.Unlock()
+
+	var pairs []DuplicatePair
+	for userID := range db.Data.Workouts[chatID] {
+		local := db.userWorkouts(chatID, userID)
+		var others []Duplicate
+		for _, otherChat := range db.sortedChats() {
+			if otherChat != chatID {
+				others = append(others, db.userWorkouts(otherChat, userID)...)
+			}
+		}
+
+		for i, first := range local {
+			// Pairs within the chat once, then every pair with another group
+			candidates := append(append([]Duplicate{}, local[i+1:]...), others...)
+			for _, second := range candidates {
+				second.SameImage = sameScreenshot(first.Entry, second.Entry, sameImage)
+				if !second.SameImage && first.Entry.fingerprint(first.Date) != second.Entry.fingerprint(second.Date) {
+					continue
+				}
+				pairs = append(pairs, DuplicatePair{First: first, Second: second})
+			}
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].First.UserID != pairs[j].First.UserID {
+			return pairs[i].First.UserID < pairs[j].First.UserID
+		}
+		return pairs[i].First.Date < pairs[j].First.Date
+	})
+	return pairs
+}
+
+// fingerprint is the stored fingerprint of the entry, workouts logged before there were
+// fingerprints get theirs computed.
+func (entry WorkoutEntry) fingerprint(date string) string {
+	if entry.Fingerprint != "" {
+		return entry.Fingerprint
+	}
+	return WorkoutFingerprint(date, entry.Distance, entry.Time, entry.Pace)
+}
+
+func sameScreenshot(a WorkoutEntry, b WorkoutEntry, sameImage func(a string, b string) bool) bool {
+	if a.ImageHash == "" || b.ImageHash == "" || normalizeDistance(a.Distance) != normalizeDistance(b.Distance) {
+		return false
+	}
+	return sameImage(a.ImageHash, b.ImageHash)
+}
+
+// better prefers matching screenshots, then the most recent workout.
+func better(a *Duplicate, b *Duplicate) bool {
+	if a.SameImage != b.SameImage {
+		return a.SameImage
+	}
+	return a.Date > b.Date
+}
+
+func (db *DatabaseManager) sortedChats() []int64 {
+	chats := make([]int64, 0, len(db.Data.Workouts))
+	for chatID := range db.Data.Workouts {
+		chats = append(chats, chatID)
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i] < chats[j] })
+	return chats
+}
+
+// userWorkouts lists the workouts of the user in the chat, oldest first.
+func (db *DatabaseManager) userWorkouts(chatID int64, userID int64) []Duplicate {
+	var workouts []Duplicate
+	for date, entry := range db.Data.Workouts[chatID][userID] {
+		workouts = append(workouts, Duplicate{ChatID: chatID, UserID: userID, Date: date, Entry: entry})
+	}
+	sort.Slice(workouts, func(i, j int) bool { return workouts[i].Date < workouts[j].Date })
+	return workouts
+}
+
+func normalizeDistance(distance string) string {
+	if value, err := strconv.ParseFloat(strings.TrimSpace(distance), 64); err == nil {
+		return strconv.FormatFloat(value, 'f', 2, 64)
+	}
+	return distance
+}
+
+// clockSeconds reads times like 27:33 or 1:02:03.
+func clockSeconds(clock string) (int, bool) {
+	parts := strings.Split(strings.TrimSpace(clock), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+
+	seconds := 0
+	for _, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, false
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, true
+}
@@ -0,0 +1,157 @@
+package databasemanager
+
+import (
+	"run-tracker-telebot/src/pkg/config"
+	"testing"
+)
+
+const (
+	TEST_USER  = int64(42)
+	OTHER_USER = int64(7)
+	GROUP      = int64(-1)
+	OTHER      = int64(-2)
+)
+
+// sameHash stands in for the perceptual hash comparison of the image processor.
+func sameHash(a string, b string) bool { return a == b }
+
+// newTestStore returns a store in memory with the workouts inserted.
+func newTestStore(t *testing.T, workouts map[int64]map[int64]map[string]map[string]string) *DatabaseManager {
+	t.Helper()
+
+	storage := config.Default().Storage
+	storage.DataDir = t.TempDir()
+	db := NewDatabaseManager(storage)
+	db.Data = NewWorkoutData()
+	for chatID, users := range workouts {
+		for userID, days := range users {
+			for date, details := range days {
+				if !db.InsertWorkoutEntry(chatID, userID, date, details) {
+					t.Fatalf("InsertWorkoutEntry(%d, %d, %s, %v) failed", chatID, userID, date, details)
+				}
+			}
+		}
+	}
+	return db
+}
+
+// duplicateStore has a screenshot sent twice to GROUP and a run posted to both groups.
+func duplicateStore(t *testing.T) *DatabaseManager {
+	return newTestStore(t, map[int64]map[int64]map[string]map[string]string{
+		GROUP: {TEST_USER: {
+			"2024-04-20": {"Distance": "10.00", "Pace": "5:30", "ImageHash": "bbbb"},
+			"2024-05-01": {"Distance": "5.02", "Pace": "6:00", "ImageHash": "aaaa"},
+			"2024-05-03": {"Distance": "10.00", "Pace": "5:30", "TotalTime": "55:00"},
+			"2024-05-06": {"Distance": "5.020", "Pace": "6:00", "ImageHash": "aaaa"},
+		}},
+		OTHER: {
+			TEST_USER:  {"2024-05-03": {"Distance": "10", "Pace": "5'30\"", "TotalTime": "0:55:00"}},
+			OTHER_USER: {"2024-05-08": {"Distance": "5.02", "Pace": "6:00", "ImageHash": "aaaa"}},
+		},
+	})
+}
+
+func TestWorkoutFingerprint(t *testing.T) {
+	tests := []struct {
+		name  string
+		a     [4]string
+		b     [4]string
+		equal bool
+	}{
+		{"time formats", [4]string{"2024-05-01", "5.0", "30:00", "6:00"}, [4]string{"2024-05-01", " 5.00", "0:30:00", "9:99"}, true},
+		{"pace without time", [4]string{"2024-05-01", "5.02", "", "6' 00\""}, [4]string{"2024-05-01", "5.020", "", "6'00\""}, true},
+		{"unreadable time", [4]string{"2024-05-01", "5.02", "half an hour", "6:00"}, [4]string{"2024-05-01", "5.02", "", "6:00"}, true},
+		{"other day", [4]string{"2024-05-01", "5.02", "30:00", ""}, [4]string{"2024-05-02", "5.02", "30:00", ""}, false},
+		{"other time", [4]string{"2024-05-01", "5.02", "30:00", ""}, [4]string{"2024-05-01", "5.02", "30:01", ""}, false},
+		{"pace is no time", [4]string{"2024-05-01", "5.02", "6:00", ""}, [4]string{"2024-05-01", "5.02", "", "6:00"}, false},
+	}
+
+	for _, test := range tests {
+		a := WorkoutFingerprint(test.a[0], test.a[1], test.a[2], test.a[3])
+		b := WorkoutFingerprint(test.b[0], test.b[1], test.b[2], test.b[3])
+		if (a == b) != test.equal {
+			t.Errorf("%s: %q and %q, want equal %v", test.name, a, b, test.equal)
+		}
+	}
+
+	if got := WorkoutFingerprint("2024-05-01", "5.0", "1:02:03", ""); got != "2024-05-01|5.00|3723s" {
+		t.Errorf("WorkoutFingerprint() = %q, want 2024-05-01|5.00|3723s", got)
+	}
+}
+
+func TestFindDuplicate(t *testing.T) {
+	db := duplicateStore(t)
+
+	tests := []struct {
+		name      string
+		date      string
+		details   map[string]string
+		want      string
+		sameImage bool
+	}{
+		// The most recent of both screenshots, on any day
+		{"same screenshot", "2024-05-10", map[string]string{"Distance": "5.02", "Pace": "6:00", "ImageHash": "aaaa"}, "2024-05-06", true},
+		// Screenshots of an app hash alike, the distance tells them apart
+		{"same layout", "2024-05-10", map[string]string{"Distance": "7.00", "Pace": "6:00", "ImageHash": "aaaa"}, "", false},
+		{"same run", "2024-05-03", map[string]string{"Distance": "10.0", "Pace": "5:30", "TotalTime": "55:00"}, "2024-05-03", false},
+		// A matching screenshot beats a matching fingerprint
+		{"both", "2024-05-03", map[string]string{"Distance": "10.00", "Pace": "5:30", "TotalTime": "55:00", "ImageHash": "bbbb"}, "2024-04-20", true},
+		// Only workouts of the same user count
+		{"other user", "2024-05-08", map[string]string{"Distance": "5.02", "Pace": "6:00"}, "", false},
+	}
+
+	for _, test := range tests {
+		got := db.FindDuplicate(TEST_USER, test.date, test.details, sameHash)
+		if test.want == "" {
+			if got != nil {
+				t.Errorf("%s: FindDuplicate() = %+v, want none", test.name, *got)
+			}
+			continue
+		}
+		if got == nil || got.Date != test.want || got.SameImage != test.sameImage || got.UserID != TEST_USER {
+			t.Errorf("%s: FindDuplicate() = %+v, want the workout of %s, same image %v", test.name, got, test.want, test.sameImage)
+		}
+	}
+}
+
+func TestScanDuplicates(t *testing.T) {
+	db := duplicateStore(t)
+
+	type pair struct {
+		firstChat  int64
+		firstDate  string
+		secondChat int64
+		secondDate string
+		sameImage  bool
+	}
+	tests := []struct {
+		chatID int64
+		want   []pair
+	}{
+		{GROUP, []pair{
+			{GROUP, "2024-05-01", GROUP, "2024-05-06", true},
+			{GROUP, "2024-05-03", OTHER, "2024-05-03", false},
+		}},
+		// The run posted to both groups shows up in either, the screenshot of the
+		// other user is nobody's duplicate
+		{OTHER, []pair{
+			{OTHER, "2024-05-03", GROUP, "2024-05-03", false},
+		}},
+	}
+
+	for _, test := range tests {
+		var got []pair
+		for _, p := range db.ScanDuplicates(test.chatID, sameHash) {
+			got = append(got, pair{p.First.ChatID, p.First.Date, p.Second.ChatID, p.Second.Date, p.Second.SameImage})
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("ScanDuplicates(%d) = %+v, want %+v", test.chatID, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("ScanDuplicates(%d) pair %d = %+v, want %+v", test.chatID, i, got[i], test.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package databasemanager
+
+import "run-tracker-telebot/src/pkg/metrics"
+
+// Files of the store, the labels of its metrics.
+const (
+	STORE_WORKOUTS = "workouts"
+	STORE_USERS    = "users"
+	STORE_SETTINGS = "settings"
+)
+
+var storeWrite = metrics.NewHistogram("runtracker_store_write_seconds",
+	"Time taken to write a file of the store.", metrics.DURATION_BUCKETS, "file")
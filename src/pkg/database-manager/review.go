@@ -0,0 +1,128 @@
+package databasemanager
+
+import (
+	"run-tracker-telebot/src/log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Workouts failing a plausibility check are logged flagged and wait in the review queue
+// of their group. Until an admin approves them they do not count on leaderboards, a
+// rejected workout is deleted.
+const (
+	REVIEW_FLAGGED  = "flagged"
+	REVIEW_APPROVED = "approved"
+)
+
+// FlaggedWorkout is a workout waiting in the review queue.
+type FlaggedWorkout struct {
+	UserID int64
+	Date   string
+	Entry  WorkoutEntry
+}
+
+// Counted tells if the workout counts on leaderboards, flagged ones only once approved.
+func (entry WorkoutEntry) Counted() bool {
+	return entry.Review != REVIEW_FLAGGED
+}
+
+// CountedWorkouts leaves out the workouts that do not count on leaderboards.
+func CountedWorkouts(workouts map[string]WorkoutEntry) map[string]WorkoutEntry {
+	counted := make(map[string]WorkoutEntry, len(workouts))
+	for date, entry := range workouts {
+		if entry.Counted() {
+			counted[date] = entry
+		}
+	}
+	return counted
+}
+
+// FlaggedWorkouts lists the review queue of the chat, oldest first.
+func (db *DatabaseManager) FlaggedWorkouts(chatID int64) []FlaggedWorkout {
+	db.Data.Lock()
+	defer db.Data.Unlock()
+
+	var flagged []FlaggedWorkout
+	for userID, workouts := range db.Data.Workouts[chatID] {
+		for date, entry := range workouts {
+			if entry.Review == REVIEW_FLAGGED {
+				flagged = append(flagged, FlaggedWorkout{UserID: userID, Date: date, Entry: entry})
+			}
+		}
+	}
+
+	sort.Slice(flagged, func(i, j int) bool {
+		if flagged[i].Date != flagged[j].Date {
+			return flagged[i].Date < flagged[j].Date
+		}
+		return flagged[i].UserID < flagged[j].UserID
+	})
+	return flagged
+}
+
+// ApproveWorkout takes a flagged workout out of the review queue so it counts again.
+func (db *DatabaseManager) ApproveWorkout(chatID int64, userID int64, date string) bool {
+	db.Data.Lock()
+	defer db.Data.Unlock()
+
+	entry, exists := db.Data.Workouts[chatID][userID][date]
+	if !exists || entry.Review != REVIEW_FLAGGED {
+		log.Warn().Msgf("No flagged workout of user %d on %s in chat %d", userID, date, chatID)
+		return false
+	}
+
+	entry.Review = REVIEW_APPROVED
+	db.Data.Workouts[chatID][userID][date] = entry
+	if err := db.SaveData(); err != nil {
+		log.Warn().Msgf("Error saving approved workout: %v", err)
+		return false
+	}
+	return true
+}
+
+// RejectWorkout deletes a flagged workout. It is checked and deleted under one lock, so
+// a workout approved or replaced in between is left alone.
+func (db *DatabaseManager) RejectWorkout(chatID int64, userID int64, date string) bool {
+	db.Data.Lock()
+	defer db.Data.Unlock()
+
+	entry, exists := db.Data.Workouts[chatID][userID][date]
+	if !exists || entry.Review != REVIEW_FLAGGED {
+		log.Warn().Msgf("No flagged workout of user %d on %s in chat %d", userID, date, chatID)
+		return false
+	}
+	db.deleteWorkout(chatID, userID, date)
+	if err := db.SaveData(); err != nil {
+		log.Warn().Msgf("Error saving after rejecting workout: %v", err)
+		return false
+	}
+	return true
+}
+
+// DailyDistance is how far the user got on date with the workout about to be logged in
+// the chat: its distance and that of the workouts logged that day in other groups. The
+// same run posted to several groups only counts once, workouts of other types of
+// activity not at all. The caller must hold the lock of the data, until the workout is
+// inserted, so that workouts logged at the same time are not both under a cap.
+func (db *DatabaseManager) DailyDistance(chatID int64, userID int64, date string, workoutDetails map[string]string) float64 {
+	activity := WorkoutEntry{Type: workoutDetails["Type"]}.ActivityType()
+
+	fingerprint := WorkoutFingerprint(date, workoutDetails["Distance"], workoutDetails["TotalTime"], workoutDetails["Pace"])
+	seen := map[string]bool{fingerprint: true}
+
+	total, _ := strconv.ParseFloat(strings.TrimSpace(workoutDetails["Distance"]), 64)
+	for otherChat, users := range db.Data.Workouts {
+		entry, exists := users[userID][date]
+		if otherChat == chatID || !exists || !entry.IsActivity(activity) || seen[entry.fingerprint(date)] {
+			continue
+		}
+		seen[entry.fingerprint(date)] = true
+
+		distance, err := strconv.ParseFloat(strings.TrimSpace(entry.Distance), 64)
+		if err == nil {
+			total += distance
+		}
+	}
+	return total
+}
@@ -0,0 +1,33 @@
+package databasemanager
+
+import "testing"
+
+func TestRejectWorkout(t *testing.T) {
+	db := newTestStore(t, map[int64]map[int64]map[string]map[string]string{
+		GROUP: {TEST_USER: {
+			"2024-05-01": {"Distance": "50", "Pace": "2:00", "Review": REVIEW_FLAGGED, "Flags": "too_fast"},
+			"2024-05-02": {"Distance": "50", "Pace": "2:00", "Review": REVIEW_APPROVED},
+			"2024-05-03": {"Distance": "5", "Pace": "6:00"},
+		}},
+	})
+
+	for _, date := range []string{"2024-05-02", "2024-05-03", "2024-05-04"} {
+		if db.RejectWorkout(GROUP, TEST_USER, date) {
+			t.Errorf("RejectWorkout(%s) of a workout that is not flagged succeeded", date)
+		}
+	}
+	if !db.RejectWorkout(GROUP, TEST_USER, "2024-05-01") {
+		t.Fatalf("RejectWorkout() of a flagged workout failed")
+	}
+	if db.RejectWorkout(GROUP, TEST_USER, "2024-05-01") {
+		t.Errorf("RejectWorkout() succeeded twice")
+	}
+
+	workouts, _ := db.GetUserWorkouts(GROUP, TEST_USER)
+	if _, ok := workouts["2024-05-01"]; ok || len(workouts) != 2 {
+		t.Errorf("workouts after rejecting = %v, want the approved and the plain one", workouts)
+	}
+	if flagged := db.FlaggedWorkouts(GROUP); len(flagged) != 0 {
+		t.Errorf("review queue = %+v, want it empty", flagged)
+	}
+}
@@ -0,0 +1,55 @@
+package databasemanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"run-tracker-telebot/src/log"
+)
+
+// Route maps of workouts logged from activity files are kept as PNG files next to the
+// workout data, the entry only holds the file name.
+
+// SaveRoute stores the route map of a workout and returns the name to keep in its entry.
+func (db *DatabaseManager) SaveRoute(chatID int64, userID int64, date string, image []byte) (string, error) {
+	if err := os.MkdirAll(db.RoutesDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%d_%d_%s.png", chatID, userID, date)
+	if err := ioutil.WriteFile(filepath.Join(db.RoutesDir, name), image, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// LoadRoute reads a route map saved by SaveRoute.
+func (db *DatabaseManager) LoadRoute(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(db.RoutesDir, filepath.Base(name)))
+}
+
+// SetRoute links a route map saved by SaveRoute to the workout of the user on date.
+// Like for InsertWorkoutEntry, the caller holds the lock of Data.
+func (db *DatabaseManager) SetRoute(chatID int64, userID int64, date string, name string) bool {
+	entry, exists := db.Data.Workouts[chatID][userID][date]
+	if !exists {
+		return false
+	}
+	entry.Route = name
+	db.Data.Workouts[chatID][userID][date] = entry
+	return true
+}
+
+// HasRoute tells if the route map saved by SaveRoute is still there.
+func (db *DatabaseManager) HasRoute(name string) bool {
+	_, err := os.Stat(filepath.Join(db.RoutesDir, filepath.Base(name)))
+	return err == nil
+}
+
+func (db *DatabaseManager) deleteRoute(name string) {
+	err := os.Remove(filepath.Join(db.RoutesDir, filepath.Base(name)))
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn().Msgf("Error deleting route map %s: %v", name, err)
+	}
+}
@@ -0,0 +1,168 @@
+package databasemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"run-tracker-telebot/src/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings holds the per-group and per-user preferences. A user setting, when set,
+// wins over the setting of the group the message was sent in.
+type Settings struct {
+	Groups map[int64]*GroupSettings `json:"groups"`
+	Users  map[int64]*UserSettings  `json:"users"`
+	sync.Mutex
+}
+
+type GroupSettings struct {
+	Language  string `json:"language,omitempty"`
+	Timezone  string `json:"timezone,omitempty"`
+	WeekStart string `json:"week_start,omitempty"`
+	// Rules are the plausibility checks of the group, the defaults when nil.
+	Rules *ValidationRules `json:"rules,omitempty"`
+}
+
+// ValidationRules bound what a plausible workout looks like. A rule of zero is off.
+type ValidationRules struct {
+	// Fastest and slowest pace, in seconds per km.
+	MinPace int `json:"min_pace_seconds"`
+	MaxPace int `json:"max_pace_seconds"`
+	// Longest run and most distance a day, in km.
+	MaxDistance      float64 `json:"max_distance_km"`
+	MaxDailyDistance float64 `json:"max_daily_distance_km"`
+	// How far pace times distance may be off the total time, as a share of it.
+	TimeTolerance float64 `json:"time_tolerance"`
+}
+
+// Fastest is the fastest plausible pace per km, zero when the pace rule is off.
+func (rules ValidationRules) Fastest() time.Duration {
+	return time.Duration(rules.MinPace) * time.Second
+}
+
+// Slowest is the slowest plausible pace per km, zero when the pace rule is off.
+func (rules ValidationRules) Slowest() time.Duration {
+	return time.Duration(rules.MaxPace) * time.Second
+}
+
+type UserSettings struct {
+	Language string `json:"language,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+func NewSettings() *Settings {
+	return &Settings{
+		Groups: make(map[int64]*GroupSettings),
+		Users:  make(map[int64]*UserSettings),
+	}
+}
+
+func (db *DatabaseManager) LoadSettings() error {
+	if err := os.MkdirAll(filepath.Dir(db.SettingsFilePath), os.ModePerm); err != nil {
+		log.Warn().Msgf("Error creating settings directory: %v", err)
+		return fmt.Errorf("error creating settings directory: %v", err)
+	}
+
+	fileContent, err := os.ReadFile(db.SettingsFilePath)
+	if os.IsNotExist(err) {
+		log.Debug().Msgf("Settings file does not exist, starting with default settings: %v", db.SettingsFilePath)
+		return nil
+	}
+	if err != nil {
+		log.Warn().Msgf("Error reading file: %v", err)
+		return fmt.Errorf("error reading file: %v", err)
+	}
+
+	if len(strings.TrimSpace(string(fileContent))) == 0 {
+		return nil
+	}
+
+	db.Settings.Lock()
+	defer db.Settings.Unlock()
+
+	if err := json.Unmarshal(fileContent, db.Settings); err != nil {
+		log.Warn().Msgf("Error unmarshalling JSON: %v", err)
+		return fmt.Errorf("error unmarshalling JSON: %v", err)
+	}
+
+	if db.Settings.Groups == nil {
+		db.Settings.Groups = make(map[int64]*GroupSettings)
+	}
+	if db.Settings.Users == nil {
+		db.Settings.Users = make(map[int64]*UserSettings)
+	}
+
+	return nil
+}
+
+// saveSettings writes the settings to disk, the caller must hold the settings lock.
+func (db *DatabaseManager) saveSettings() error {
+	defer storeWrite.Since(time.Now(), STORE_SETTINGS)
+
+	file, err := os.Create(db.SettingsFilePath)
+	if err != nil {
+		log.Warn().Msgf("Error creating file: %v", err)
+		return err
+	}
+	defer file.Close()
+
+	log.Debug().Msgf("Saving settings to file: %v", db.SettingsFilePath)
+	if err := json.NewEncoder(file).Encode(db.Settings); err != nil {
+		log.Warn().Msgf("Error encoding settings: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetGroupSettings returns a copy of the settings of a group, empty when never set.
+func (db *DatabaseManager) GetGroupSettings(groupID int64) GroupSettings {
+	db.Settings.Lock()
+	defer db.Settings.Unlock()
+
+	if settings, ok := db.Settings.Groups[groupID]; ok {
+		return *settings
+	}
+	return GroupSettings{}
+}
+
+// GetUserSettings returns a copy of the settings of a user, empty when never set.
+func (db *DatabaseManager) GetUserSettings(userID int64) UserSettings {
+	db.Settings.Lock()
+	defer db.Settings.Unlock()
+
+	if settings, ok := db.Settings.Users[userID]; ok {
+		return *settings
+	}
+	return UserSettings{}
+}
+
+// UpdateGroupSettings applies update to the settings of a group and saves them.
+func (db *DatabaseManager) UpdateGroupSettings(groupID int64, update func(*GroupSettings)) error {
+	db.Settings.Lock()
+	defer db.Settings.Unlock()
+
+	if db.Settings.Groups[groupID] == nil {
+		db.Settings.Groups[groupID] = &GroupSettings{}
+	}
+	update(db.Settings.Groups[groupID])
+
+	return db.saveSettings()
+}
+
+// UpdateUserSettings applies update to the settings of a user and saves them.
+func (db *DatabaseManager) UpdateUserSettings(userID int64, update func(*UserSettings)) error {
+	db.Settings.Lock()
+	defer db.Settings.Unlock()
+
+	if db.Settings.Users[userID] == nil {
+		db.Settings.Users[userID] = &UserSettings{}
+	}
+	update(db.Settings.Users[userID])
+
+	return db.saveSettings()
+}
@@ -0,0 +1,202 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"run-tracker-telebot/src/pkg/stats"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_CSV  = "csv"
+	FORMAT_JSON = "json"
+)
+
+// CSV_HEADER is the first row of every CSV export, in the order of the Record fields.
+var CSV_HEADER = []string{
+	"group_id", "user_id", "name", "date", "distance_km", "pace",
+	"time", "elevation_gain_m", "heart_rate", "max_heart_rate", "source", "type",
+	"confidence", "review", "flags", "route",
+}
+
+// Record is one exported workout entry.
+type Record struct {
+	GroupID  int64  `json:"group_id"`
+	UserID   int64  `json:"user_id"`
+	Name     string `json:"name"`
+	Date     string `json:"date"`
+	Distance string `json:"distance_km"`
+	Pace     string `json:"pace"`
+	// Only known for some workouts, e.g. those from activity files.
+	Time          string `json:"time,omitempty"`
+	ElevationGain string `json:"elevation_gain_m,omitempty"`
+	HeartRate     string `json:"heart_rate,omitempty"`
+	MaxHeartRate  string `json:"max_heart_rate,omitempty"`
+	Source        string `json:"source,omitempty"`
+	Type          string `json:"type,omitempty"`
+	Confidence    string `json:"confidence,omitempty"`
+	// Review and Flags are set for workouts that failed a plausibility check.
+	Review string   `json:"review,omitempty"`
+	Flags  []string `json:"flags,omitempty"`
+	// Route is the file name of the route map, the map itself is not exported.
+	Route string `json:"route,omitempty"`
+}
+
+type Exporter struct {
+	DatabaseManager *databasemanager.DatabaseManager
+}
+
+func NewExporter(databaseManager *databasemanager.DatabaseManager) *Exporter {
+	return &Exporter{
+		DatabaseManager: databaseManager,
+	}
+}
+
+// IsFormat tells if format is one of the supported export formats.
+func IsFormat(format string) bool {
+	return format == FORMAT_CSV || format == FORMAT_JSON
+}
+
+// FileName names the export of a period, e.g. workouts_2024-05-01_2024-05-31.csv.
+func FileName(format string, period stats.Period) string {
+	if period.Start.IsZero() {
+		return fmt.Sprintf("workouts.%s", format)
+	}
+	end := period.End.AddDate(0, 0, -1)
+	return fmt.Sprintf("workouts_%s_%s.%s", period.Start.Format(stats.DATE_LAYOUT), end.Format(stats.DATE_LAYOUT), format)
+}
+
+// Records collects the workouts of a user in the group during the period, or of every
+// member of the group when userID is 0. Records are sorted by date, then by user.
+func (e *Exporter) Records(groupID int64, userID int64, period stats.Period) ([]Record, error) {
+	var workouts map[int64]map[string]databasemanager.WorkoutEntry
+	if userID == 0 {
+		allWorkouts, err := e.DatabaseManager.GetAllWorkouts(groupID)
+		if err != nil {
+			return nil, err
+		}
+		workouts = allWorkouts
+	} else {
+		userWorkouts, err := e.DatabaseManager.GetUserWorkouts(groupID, userID)
+		if err != nil {
+			return nil, err
+		}
+		workouts = map[int64]map[string]databasemanager.WorkoutEntry{userID: userWorkouts}
+	}
+
+	var records []Record
+	for userID, userWorkouts := range workouts {
+		// Members who never finished onboarding have no name, the id still tells them apart.
+		name, _ := e.DatabaseManager.GetUsernameFromId(userID)
+
+		for date, entry := range userWorkouts {
+			day, err := time.Parse(stats.DATE_LAYOUT, date)
+			if err != nil {
+				log.Warn().Msgf("Skipping workout with invalid date %s: %v", date, err)
+				continue
+			}
+			if !period.Contains(day) {
+				continue
+			}
+
+			records = append(records, Record{
+				GroupID:       groupID,
+				UserID:        userID,
+				Name:          name,
+				Date:          date,
+				Distance:      entry.Distance,
+				Pace:          entry.Pace,
+				Time:          entry.Time,
+				ElevationGain: entry.ElevationGain,
+				HeartRate:     entry.HeartRate,
+				MaxHeartRate:  entry.MaxHeartRate,
+				Source:        entry.Source,
+				Type:          entry.Type,
+				Confidence:    formatConfidence(entry.Confidence),
+				Review:        entry.Review,
+				Flags:         entry.Flags,
+				Route:         entry.Route,
+			})
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Date != records[j].Date {
+			return records[i].Date < records[j].Date
+		}
+		return records[i].UserID < records[j].UserID
+	})
+
+	return records, nil
+}
+
+// Export writes the workouts picked like Records does to w and returns how many were written.
+func (e *Exporter) Export(w io.Writer, format string, groupID int64, userID int64, period stats.Period) (int, error) {
+	records, err := e.Records(groupID, userID, period)
+	if err != nil {
+		return 0, err
+	}
+	return len(records), Write(w, format, records)
+}
+
+// Write encodes records as CSV with CSV_HEADER, or as a JSON array.
+func Write(w io.Writer, format string, records []Record) error {
+	switch format {
+	case FORMAT_CSV:
+		writer := csv.NewWriter(w)
+		err := writer.Write(CSV_HEADER)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			err = writer.Write([]string{
+				strconv.FormatInt(record.GroupID, 10),
+				strconv.FormatInt(record.UserID, 10),
+				record.Name,
+				record.Date,
+				record.Distance,
+				record.Pace,
+				record.Time,
+				record.ElevationGain,
+				record.HeartRate,
+				record.MaxHeartRate,
+				record.Source,
+				record.Type,
+				record.Confidence,
+				record.Review,
+				strings.Join(record.Flags, ","),
+				record.Route,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+
+	case FORMAT_JSON:
+		if records == nil {
+			records = []Record{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+
+	return fmt.Errorf("unknown export format: %q", format)
+}
+
+// formatConfidence leaves out the confidence of entries from before there was one.
+func formatConfidence(confidence float64) string {
+	if confidence == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(confidence, 'f', 2, 64)
+}
@@ -0,0 +1,99 @@
+package imageprocessor
+
+import (
+	"fmt"
+	"regexp"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// activityTitles are the titles apps print on the summary of each type of activity,
+// matched case-insensitively. Runs come last, "Running" also shows up in the
+// splits of other workouts.
+var activityTitles = []struct {
+	activity string
+	titles   []string
+}{
+	{databasemanager.ACTIVITY_CYCLE, []string{"Outdoor Cycle", "Indoor Cycle", "Cycling", "Ride", "Radfahren"}},
+	{databasemanager.ACTIVITY_SWIM, []string{"Pool Swim", "Open Water Swim", "Swimming", "Schwimmen"}},
+	{databasemanager.ACTIVITY_HIKE, []string{"Hiking", "Hike", "Wandern"}},
+	{databasemanager.ACTIVITY_WALK, []string{"Outdoor Walk", "Indoor Walk", "Walking", "Gehen"}},
+	{databasemanager.ACTIVITY_RUN, []string{"Outdoor Run", "Indoor Run", "Running", "Laufen"}},
+}
+
+// lineTitleRegexes match the titles of activityTitles as a line of their own, apart
+// from marks like the "<" of a back button, wordTitleRegexes as whole words anywhere.
+var lineTitleRegexes, wordTitleRegexes = titleRegexes(`^\W*`, `\W*$`), titleRegexes(`\b`, `\b`)
+
+func titleRegexes(before string, after string) map[string]*regexp.Regexp {
+	regexes := make(map[string]*regexp.Regexp)
+	for _, activity := range activityTitles {
+		for _, title := range activity.titles {
+			regexes[title] = regexp.MustCompile(`(?i)` + before + regexp.QuoteMeta(title) + after)
+		}
+	}
+	return regexes
+}
+
+// DetectActivityType reads the type of activity off the text of a screenshot, empty
+// when no title is found. The first line that is a title wins, then titles as whole
+// words, so a run is no ride for its "Stride".
+func DetectActivityType(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if activity := matchTitle(line, lineTitleRegexes); activity != "" {
+			return activity
+		}
+	}
+	return matchTitle(text, wordTitleRegexes)
+}
+
+func matchTitle(text string, regexes map[string]*regexp.Regexp) string {
+	for _, activity := range activityTitles {
+		for _, title := range activity.titles {
+			if regexes[title].MatchString(text) {
+				return activity.activity
+			}
+		}
+	}
+	return ""
+}
+
+var speedRegex = regexp.MustCompile(`\b\d{1,2}[.,]\d\s?(?i:km/h|kmh)`)
+
+// findSpeed reads the average speed rides show instead of a pace and turns each
+// candidate into the pace it stands for, unless a pace was found.
+func (r *ParseResult) findSpeed(text string) {
+	if r.Value(FIELD_PACE) != "" {
+		return
+	}
+
+	candidates := findCandidates(text, fieldRules{
+		rules:  []candidateRule{{speedRegex, 0.7}},
+		labels: []string{"Speed", "Geschwindigkeit"},
+		key:    distanceKey,
+		clean: func(match string) string {
+			return speedPace(numberRegex.FindString(strings.ReplaceAll(match, ",", ".")))
+		},
+	})
+	var paces []Candidate
+	for _, candidate := range candidates {
+		if candidate.Value != "" {
+			paces = append(paces, candidate)
+		}
+	}
+	if len(paces) > 0 {
+		r.Fields[FIELD_PACE] = newField(paces)
+	}
+}
+
+// speedPace turns a speed in km/h into a pace like "2:30", empty for no speed.
+func speedPace(speed string) string {
+	value, err := strconv.ParseFloat(speed, 64)
+	if err != nil || value <= 0 {
+		return ""
+	}
+	pace := time.Duration(float64(time.Hour) / value).Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(pace.Minutes()), int(pace.Seconds())%60)
+}
@@ -0,0 +1,33 @@
+package imageprocessor
+
+import (
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"testing"
+)
+
+func TestDetectActivityType(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"9:41\n< Outdoor Cycle\nWorkout Details", databasemanager.ACTIVITY_CYCLE},
+		{"X Running\nFri, May 3", databasemanager.ACTIVITY_RUN},
+		// Words that contain a title are no title
+		{"Outdoor Run\nAvg. Stride Length\n1.12M", databasemanager.ACTIVITY_RUN},
+		{"Running\nPride Run 5K", databasemanager.ACTIVITY_RUN},
+		{"Avg. Stride 1.1m\n5.02 km", ""},
+		// The title line wins over a title in the splits
+		{"Hiking\nkm Running Pace\n1 12:30", databasemanager.ACTIVITY_HIKE},
+		{"Walking\nSplits\nRide home", databasemanager.ACTIVITY_WALK},
+		// Without a title line, whole words anywhere
+		{"Sunday Ride with the club 42.1 km", databasemanager.ACTIVITY_CYCLE},
+		{"OUTDOOR WALK 3.2KM", databasemanager.ACTIVITY_WALK},
+		{"5.02 km\n6:00 min/km", ""},
+	}
+
+	for _, test := range tests {
+		if got := DetectActivityType(test.text); got != test.want {
+			t.Errorf("DetectActivityType(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
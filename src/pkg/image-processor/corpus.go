@@ -0,0 +1,94 @@
+package imageprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CORPUS_DIR holds the text OCR read off screenshots, a directory per app, e.g.
+// apple/dark_km.txt, with what the screenshot shows next to it in apple/dark_km.json.
+// The parser tests read every text of it.
+const CORPUS_DIR = "testdata/ocr"
+
+// PARSER_UNKNOWN is the directory of texts of apps no parser should recognize.
+const PARSER_UNKNOWN = "unknown"
+
+// Golden is what a screenshot of the corpus shows, the values the parsers must read
+// off its text. Parser is empty when no parser should recognize it.
+type Golden struct {
+	Parser   string `json:"parser"`
+	Activity string `json:"activity,omitempty"`
+	Distance string `json:"distance,omitempty"`
+	Pace     string `json:"pace,omitempty"`
+	Time     string `json:"time,omitempty"`
+	// Image is the screenshot, when it is committed next to the text.
+	Image string `json:"image,omitempty"`
+	// Skip says why the parsers do not read the screenshot right yet, the test is
+	// skipped until they do.
+	Skip string `json:"skip,omitempty"`
+}
+
+// Record reads a screenshot into the corpus as app/name.txt and app/name.json, copying
+// the screenshot as well when keepImage is set. An empty app is the one of the parser
+// recognizing the text. The values are what the parsers read now, they have to be
+// checked against the screenshot and corrected before committing. It returns the
+// directory the fixture was written to.
+func (ip *ImageProcessor) Record(imagePath string, corpus string, app string, name string, keepImage bool) (string, Golden, error) {
+	text, err := ip.ProcessImage(imagePath)
+	if err != nil {
+		return "", Golden{}, err
+	}
+
+	var golden Golden
+	if parser := ip.parserFor(text); parser != nil {
+		result, _ := parser.Parse(text)
+		golden = Golden{
+			Parser:   parser.Name,
+			Activity: result.Activity,
+			Distance: result.Value(FIELD_DISTANCE),
+			Pace:     result.Value(FIELD_PACE),
+			Time:     result.Value(FIELD_TIME),
+		}
+	}
+	// The app given is what the screenshot shows, whatever parser recognizes it now
+	switch {
+	case app == "" && golden.Parser == "":
+		app = PARSER_UNKNOWN
+	case app == "":
+		app = golden.Parser
+	case app == PARSER_UNKNOWN:
+		golden.Parser = ""
+	default:
+		golden.Parser = app
+	}
+	dir := filepath.Join(corpus, app)
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return dir, golden, err
+	}
+	if keepImage {
+		data, err := os.ReadFile(imagePath)
+		if err != nil {
+			return dir, golden, err
+		}
+		golden.Image = name + strings.ToLower(filepath.Ext(imagePath))
+		if err := os.WriteFile(filepath.Join(dir, golden.Image), data, 0644); err != nil {
+			return dir, golden, err
+		}
+	}
+
+	data, err := json.MarshalIndent(golden, "", "  ")
+	if err != nil {
+		return dir, golden, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".txt"), []byte(text), 0644); err != nil {
+		return dir, golden, fmt.Errorf("error writing text: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".json"), append(data, '\n'), 0644); err != nil {
+		return dir, golden, fmt.Errorf("error writing values: %w", err)
+	}
+	return dir, golden, nil
+}
@@ -0,0 +1,120 @@
+package imageprocessor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"run-tracker-telebot/src/pkg/config"
+	"strings"
+	"testing"
+)
+
+// goldenCase is a text of the corpus with what its screenshot shows.
+type goldenCase struct {
+	name   string
+	text   string
+	golden Golden
+}
+
+func loadCorpus(t *testing.T) []goldenCase {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(CORPUS_DIR, "*", "*.txt"))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no texts in %s", CORPUS_DIR)
+	}
+
+	var cases []goldenCase
+	for _, path := range paths {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		data, err := os.ReadFile(strings.TrimSuffix(path, ".txt") + ".json")
+		if err != nil {
+			t.Fatalf("%s has no values: %v", path, err)
+		}
+		var golden Golden
+		if err := json.Unmarshal(data, &golden); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		app := filepath.Base(filepath.Dir(path))
+		if want := golden.Parser; want != app && !(want == "" && app == PARSER_UNKNOWN) {
+			t.Fatalf("%s: parser %q does not match its directory", path, want)
+		}
+		name, _ := filepath.Rel(CORPUS_DIR, path)
+		cases = append(cases, goldenCase{name: strings.TrimSuffix(name, ".txt"), text: string(text), golden: golden})
+	}
+	return cases
+}
+
+func newTestProcessor(t *testing.T) *ImageProcessor {
+	t.Helper()
+
+	ip, err := NewImageProcessor(config.OCRConfig{})
+	if err != nil {
+		t.Fatalf("NewImageProcessor: %v", err)
+	}
+	return ip
+}
+
+func TestRecognizeApp(t *testing.T) {
+	ip := newTestProcessor(t)
+
+	for _, c := range loadCorpus(t) {
+		t.Run(c.name, func(t *testing.T) {
+			if c.golden.Skip != "" {
+				t.Skip(c.golden.Skip)
+			}
+			if got, want := ip.IsAppleWorkout(c.text), c.golden.Parser == PARSER_APPLE; got != want {
+				t.Errorf("IsAppleWorkout = %v, want %v", got, want)
+			}
+			if got, want := ip.IsRunKeeper(c.text), c.golden.Parser == PARSER_RUNKEEPER; got != want {
+				t.Errorf("IsRunKeeper = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseWorkout(t *testing.T) {
+	ip := newTestProcessor(t)
+	parsers := map[string]func(text string) (*ParseResult, error){
+		PARSER_APPLE:     ip.ParseWorkoutDetails,
+		PARSER_RUNKEEPER: ip.ParseRunKeepWorkoutDetails,
+	}
+
+	for _, c := range loadCorpus(t) {
+		if c.golden.Parser == "" {
+			continue
+		}
+		t.Run(c.name, func(t *testing.T) {
+			if c.golden.Skip != "" {
+				t.Skip(c.golden.Skip)
+			}
+			result, err := parsers[c.golden.Parser](c.text)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			if got := result.Activity; got != c.golden.Activity {
+				t.Errorf("activity = %q, want %q", got, c.golden.Activity)
+			}
+			if got := result.Value(FIELD_DISTANCE); !sameDistance(got, c.golden.Distance) {
+				t.Errorf("distance = %q, want %q", got, c.golden.Distance)
+			}
+			if got := result.Value(FIELD_PACE); !samePace(got, c.golden.Pace) {
+				t.Errorf("pace = %q, want %q", got, c.golden.Pace)
+			}
+			if got := result.Value(FIELD_TIME); got != c.golden.Time {
+				t.Errorf("time = %q, want %q", got, c.golden.Time)
+			}
+			if ambiguous := result.Ambiguous(); len(ambiguous) > 0 {
+				t.Errorf("ambiguous fields %v, the user would be asked", ambiguous)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package imageprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"run-tracker-telebot/src/pkg/stats"
+	"strconv"
+)
+
+// FIXTURES_FILE lists the screenshots of a fixture directory with what they show.
+const FIXTURES_FILE = "fixtures.json"
+
+// Fixture is a screenshot with the values a person reads off it, e.g.
+//
+//	{"image": "apple_dark.jpg", "distance": "5.02", "pace": "5'41\""}
+type Fixture struct {
+	Image    string `json:"image"`
+	Distance string `json:"distance"`
+	Pace     string `json:"pace"`
+}
+
+// Evaluation counts how many fixtures the OCR and the parsers got right with a set
+// of preprocessing steps.
+type Evaluation struct {
+	Steps    []string
+	Total    int
+	Parsed   int
+	Distance int
+	Pace     int
+	Correct  int
+	// Misses describes every fixture that was not read correctly.
+	Misses []string
+}
+
+// LoadFixtures reads the fixtures file of dir.
+func LoadFixtures(dir string) ([]Fixture, error) {
+	data, err := os.ReadFile(filepath.Join(dir, FIXTURES_FILE))
+	if err != nil {
+		return nil, err
+	}
+
+	var fixtures []Fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", FIXTURES_FILE, err)
+	}
+	return fixtures, nil
+}
+
+// Evaluate runs every fixture of dir through OCR with the steps and the parsers.
+func (ip *ImageProcessor) Evaluate(dir string, fixtures []Fixture, steps []string) Evaluation {
+	evaluation := Evaluation{Steps: steps, Total: len(fixtures)}
+
+	evaluator := *ip
+	evaluator.Steps = steps
+
+	for _, fixture := range fixtures {
+		result, err := evaluator.ReadWorkout(filepath.Join(dir, fixture.Image))
+		if err != nil {
+			evaluation.Misses = append(evaluation.Misses, fmt.Sprintf("%s: %v", fixture.Image, err))
+			continue
+		}
+		evaluation.Parsed++
+
+		distance, pace := result.Value(FIELD_DISTANCE), result.Value(FIELD_PACE)
+		distanceOK := sameDistance(distance, fixture.Distance)
+		paceOK := samePace(pace, fixture.Pace)
+		if distanceOK {
+			evaluation.Distance++
+		}
+		if paceOK {
+			evaluation.Pace++
+		}
+		if distanceOK && paceOK {
+			evaluation.Correct++
+		} else {
+			evaluation.Misses = append(evaluation.Misses, fmt.Sprintf("%s: read %s km at %s, expected %s km at %s",
+				fixture.Image, distance, pace, fixture.Distance, fixture.Pace))
+		}
+	}
+
+	return evaluation
+}
+
+// Accuracy is the share of fixtures with both distance and pace right.
+func (e Evaluation) Accuracy() float64 {
+	if e.Total == 0 {
+		return 0
+	}
+	return float64(e.Correct) / float64(e.Total)
+}
+
+func sameDistance(read string, expected string) bool {
+	readValue, errRead := strconv.ParseFloat(read, 64)
+	expectedValue, errExpected := strconv.ParseFloat(expected, 64)
+	return errRead == nil && errExpected == nil && readValue == expectedValue
+}
+
+// samePace compares paces by their minutes and seconds, apps write them as 5'41",
+// 5:41 or 5:41/km.
+func samePace(read string, expected string) bool {
+	readPace, errRead := stats.ParsePace(read)
+	expectedPace, errExpected := stats.ParsePace(expected)
+	return errRead == nil && errExpected == nil && readPace == expectedPace
+}
@@ -1,36 +1,102 @@
 package imageprocessor
 
 import (
+	"errors"
+	"fmt"
+	_ "image/jpeg"
+	_ "image/png"
 	"os"
 	"regexp"
 	"run-tracker-telebot/src/log"
+	"run-tracker-telebot/src/pkg/config"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"run-tracker-telebot/src/pkg/stats"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/otiai10/gosseract/v2"
 )
 
-type ImageProcessor struct{}
+// ErrUnknownApp is returned for text that no parser recognizes.
+var ErrUnknownApp = errors.New("no parser recognizes the app")
 
-func NewImageProcessor() *ImageProcessor {
-	return &ImageProcessor{}
+// Parsers recognized by ExtractWorkoutDetails, named after the app they read.
+const (
+	PARSER_APPLE     = "apple"
+	PARSER_RUNKEEPER = "runkeeper"
+)
+
+// Parser reads the workout of one app from the text of its screenshots.
+type Parser struct {
+	Name    string
+	Matches func(text string) bool
+	Parse   func(text string) (*ParseResult, error)
+	// SecondPass reads parts of the screenshot again with settings suited to them, its
+	// candidates are merged with those of the first pass. Nil skips it.
+	SecondPass *OCRConfig
 }
 
-func (ip *ImageProcessor) ProcessImage(imagePath string) (string, error) {
-	log.Info().Msgf("Creating new Tesseract client...")
-	client := gosseract.NewClient()
-	defer client.Close()
+type ImageProcessor struct {
+	// Steps are the preprocessing steps run before OCR, see Preprocess.
+	Steps []string
+	// OCR is how whole screenshots are read, before the app is known.
+	OCR     OCRConfig
+	Parsers []Parser
+}
+
+// NewImageProcessor preprocesses with DEFAULT_STEPS unless the OCR config lists other
+// steps, e.g. "grayscale,invert" or "none", and reads the first pass with the language
+// packs it lists, e.g. "eng+deu". Unknown steps are an error.
+func NewImageProcessor(ocr config.OCRConfig) (*ImageProcessor, error) {
+	ip := &ImageProcessor{Steps: DEFAULT_STEPS, OCR: DEFAULT_OCR}
 
+	if ocr.Preprocessing != "" {
+		steps, err := ParseSteps(ocr.Preprocessing)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ocr.preprocessing: %w", err)
+		}
+		ip.Steps = steps
+	}
+	if ocr.Languages != "" {
+		ip.OCR.Languages = ParseLanguages(ocr.Languages)
+	}
+
+	ip.Parsers = []Parser{
+		{
+			Name:    PARSER_APPLE,
+			Matches: ip.IsAppleWorkout,
+			Parse:   ip.ParseWorkoutDetails,
+			// Apple Fitness prints distance and pace in the workout details grid at the
+			// top, read it once more as sparse numbers so labels do not get in the way
+			SecondPass: &OCRConfig{
+				PageSegMode: gosseract.PSM_SPARSE_TEXT,
+				Whitelist:   WHITELIST_NUMBERS + WHITELIST_UNITS,
+				Languages:   []string{DEFAULT_LANGUAGE},
+				Regions:     []Region{{Left: 0, Top: 0.1, Width: 1, Height: 0.45}},
+			},
+		},
+		{
+			Name:    PARSER_RUNKEEPER,
+			Matches: ip.IsRunKeeper,
+			Parse:   ip.ParseRunKeepWorkoutDetails,
+		},
+	}
+
+	return ip, nil
+}
+
+// ProcessImage reads the whole screenshot with the first pass settings.
+func (ip *ImageProcessor) ProcessImage(imagePath string) (string, error) {
 	log.Info().Msgf("Processing image: %s", imagePath)
 
-	_, err := os.Stat(imagePath)
+	data, err := os.ReadFile(imagePath)
 	if err != nil {
 		log.Warn().Msgf("Error reading file: %v", err)
 		return "", err
 	}
 
-	client.SetImage(imagePath)
-	text, err := client.Text()
+	text, err := ip.recognize(data, ip.OCR)
 	if err != nil {
 		log.Warn().Msgf("Error reading text from image: %v", err)
 		return "", err
@@ -41,85 +107,194 @@ func (ip *ImageProcessor) ProcessImage(imagePath string) (string, error) {
 	return text, nil
 }
 
-func (ip *ImageProcessor) ParseWorkoutDetails(text string) (map[string]string, error) {
-	// Define regular expressions for matching
-	distanceRegex := regexp.MustCompile(`\d+\.\d+[a-zA-Z]*`)
-	paceRegex := regexp.MustCompile(`\d{1,2}[':]\d{2}(")?/[a-zA-Z]*`)
-	// heartRateRegex := regexp.MustCompile(`\d{2,3}[BPM]{0,1}`)
-
-	// Extract details using regular expressions
-	distance := distanceRegex.FindString(text)
-	pace := paceRegex.FindString(text)
-	// heartRate := heartRateRegex.FindString(text)
+// ReadWorkout reads the workout details off a screenshot: a first pass finds the app,
+// its parser reads the details and may ask for a second pass on the parts that matter.
+// Like the parsers, it returns what was found along with ErrMissingField.
+func (ip *ImageProcessor) ReadWorkout(imagePath string) (*ParseResult, error) {
+	text, err := ip.ProcessImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
 
-	log.Debug().Msgf("Distance: %s", distance)
-	log.Debug().Msgf("Pace: %s", pace)
-	// log.Debug().Msgf("Heart Rate: %s", heartRate)
+	parser := ip.parserFor(text)
+	if parser == nil {
+		log.Debug().Msgf("No parser for text: %s", text)
+		countParse(PARSER_NONE, ErrUnknownApp)
+		return nil, ErrUnknownApp
+	}
 
-	if distance == "" || pace == "" {
-		log.Warn().Msgf("Error extracting workout details")
-		return nil, nil
+	result, err := parser.Parse(text)
+	if parser.SecondPass == nil {
+		countParse(parser.Name, err)
+		return result, err
 	}
 
-	distance = cleanDistanceData(distance)
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return result, err
+	}
+	regionText, err := ip.recognize(data, *parser.SecondPass)
+	if err != nil {
+		log.Warn().Msgf("Error in second OCR pass for %s: %v", parser.Name, err)
+		err = result.Validate()
+		countParse(parser.Name, err)
+		return result, err
+	}
+	log.Debug().Msgf("Text extracted in second pass: %s", regionText)
 
-	date := time.Now().Format("2006-01-02")
-	// Store extracted details in a map
-	workoutDetails := map[string]string{
-		"Date":     date,
-		"Distance": distance,
-		"Pace":     pace,
-		// "HeartRate": heartRate,
+	// The parts read again may have lost their unit
+	regionResult, _ := parser.Parse(regionText)
+	if result.Miles && !regionResult.Miles {
+		regionResult.toKilometres()
 	}
+	result.Merge(regionResult)
+	err = result.Validate()
+	countParse(parser.Name, err)
+	return result, err
+}
 
-	return workoutDetails, nil
+// ExtractWorkoutDetails recognizes the app the text was read from and parses it with
+// the matching parser.
+func (ip *ImageProcessor) ExtractWorkoutDetails(text string) (*ParseResult, error) {
+	if parser := ip.parserFor(text); parser != nil {
+		return parser.Parse(text)
+	}
+	return nil, ErrUnknownApp
 }
 
-func (ip *ImageProcessor) ParseRunKeepWorkoutDetails(text string) (map[string]string, error) {
-	// Define regular expressions for matching
-	distanceRegex := regexp.MustCompile(`\b\d+\.\d+\b`)
-	paceRegex := regexp.MustCompile(`\b\d{1,2}:\d{2}\b`)
-	// timeRegex := regexp.MustCompile(`\b\d{1,2}:\d{2}\b`) // To extract total time
-	caloriesRegex := regexp.MustCompile(`\b\d+\b`) // To extract calories
+func (ip *ImageProcessor) parserFor(text string) *Parser {
+	for i := range ip.Parsers {
+		if ip.Parsers[i].Matches(text) {
+			return &ip.Parsers[i]
+		}
+	}
+	return nil
+}
 
-	// Extract details using regular expressions
-	distance := distanceRegex.FindString(text)
-	timeAndPaceMatches := paceRegex.FindAllString(text, -1)
-	calories := caloriesRegex.FindString(text)
+// Patterns shared by the parsers. OCR often drops the space before a unit.
+var (
+	distanceWithUnitRegex = regexp.MustCompile(`\b\d+\.\d+\s?(?i:km|mi)\b`)
+	distanceRegex         = regexp.MustCompile(`\b\d+\.\d+\b`)
+	paceWithUnitRegex     = regexp.MustCompile(`\b\d{1,2}[':]\d{2}"?/(?i:km|mi)`)
+	applePaceRegex        = regexp.MustCompile(`\b\d{1,2}'\d{2}"?`)
+	clockRegex            = regexp.MustCompile(`\b\d{1,2}:\d{2}(:\d{2})?\b`)
+	longClockRegex        = regexp.MustCompile(`\b\d{1,2}:\d{2}:\d{2}\b`)
+	caloriesRegex         = regexp.MustCompile(`\b\d{2,4}\b`)
+	numberRegex           = regexp.MustCompile(`\d+(\.\d+)?`)
+)
 
-	// Ensure the first pace match is not the total time
-	if len(timeAndPaceMatches) < 2 || len(calories) < 1 {
-		log.Printf("Error extracting workout details")
-		return nil, nil
+// Keys and checks of the values of each field.
+var (
+	distanceKey = func(match string) string { return numberRegex.FindString(match) }
+	clockKey    = func(match string) string {
+		return paceClockRegex.ReplaceAllString(paceClockRegex.FindString(match), "$1:$2")
 	}
+	sameText = func(match string) string { return match }
+)
 
-	totalTime := timeAndPaceMatches[1]
-	pace := timeAndPaceMatches[0]
+var paceClockRegex = regexp.MustCompile(`(\d{1,2})[':](\d{2})`)
 
-	log.Debug().Msgf("Total Time: %s", totalTime)
-	log.Debug().Msgf("Distance: %s", distance)
-	log.Debug().Msgf("Pace: %s", pace)
-	log.Debug().Msgf("Calories: %s", calories)
+// Plausible values for a run, from a walk to an ultra.
+func plausibleDistance(value string) bool {
+	distance, err := strconv.ParseFloat(value, 64)
+	return err == nil && distance >= 0.1 && distance <= 150
+}
 
-	if distance == "" || pace == "" {
-		log.Warn().Msgf("Error extracting workout details")
-		return nil, nil
+func plausiblePace(value string) bool {
+	pace, err := stats.ParsePace(value)
+	return err == nil && pace >= 2*time.Minute && pace <= 20*time.Minute
+}
+
+// ParseWorkoutDetails reads an Apple Fitness summary, which prints labels above their
+// values, like 5.02KM and 5'41"/KM, with the units attached. The result holds what was
+// found even when a required field is missing.
+func (ip *ImageProcessor) ParseWorkoutDetails(text string) (*ParseResult, error) {
+	result := newParseResult(PARSER_APPLE)
+	result.Activity = DetectActivityType(text)
+
+	result.find(FIELD_DISTANCE, text, fieldRules{
+		rules:     []candidateRule{{distanceWithUnitRegex, 0.6}, {distanceRegex, 0.2}},
+		labels:    []string{"Distance"},
+		key:       distanceKey,
+		clean:     cleanDistanceData,
+		plausible: plausibleDistance,
+	})
+	result.find(FIELD_PACE, text, fieldRules{
+		rules:     []candidateRule{{paceWithUnitRegex, 0.7}, {applePaceRegex, 0.3}},
+		labels:    []string{"Pace"},
+		key:       clockKey,
+		clean:     strings.TrimSpace,
+		plausible: plausiblePace,
+	})
+	// Rides show a speed instead
+	if result.Activity == databasemanager.ACTIVITY_CYCLE {
+		result.findSpeed(text)
+	}
+	result.find(FIELD_TIME, text, fieldRules{
+		rules:  []candidateRule{{longClockRegex, 0.4}},
+		labels: []string{"Time"},
+		key:    sameText,
+		clean:  sameText,
+	})
+	result.checkConsistency()
+	if inMiles(text) {
+		result.toKilometres()
 	}
 
-	// Clean distance data
-	distance = cleanDistanceData(distance)
+	log.Debug().Msgf("Distance: %s", result.Value(FIELD_DISTANCE))
+	log.Debug().Msgf("Pace: %s", result.Value(FIELD_PACE))
 
-	date := time.Now().Format("2006-01-02")
-	// Store extracted details in a map
-	workoutDetails := map[string]string{
-		"Date":      date,
-		"Distance":  distance,
-		"TotalTime": totalTime,
-		"Pace":      pace,
-		"Calories":  calories,
+	return result, result.Validate()
+}
+
+// ParseRunKeepWorkoutDetails reads a RunKeeper summary, which prints labels below their
+// values. Pace and total time look alike, their labels and how well they fit the
+// distance tell them apart.
+func (ip *ImageProcessor) ParseRunKeepWorkoutDetails(text string) (*ParseResult, error) {
+	result := newParseResult(PARSER_RUNKEEPER)
+	result.Activity = DetectActivityType(text)
+
+	result.find(FIELD_DISTANCE, text, fieldRules{
+		rules:      []candidateRule{{distanceWithUnitRegex, 0.6}, {distanceRegex, 0.3}},
+		labels:     []string{"km", "mi"},
+		labelBelow: true,
+		key:        distanceKey,
+		clean:      cleanDistanceData,
+		plausible:  plausibleDistance,
+	})
+	result.find(FIELD_PACE, text, fieldRules{
+		rules:      []candidateRule{{paceWithUnitRegex, 0.7}, {clockRegex, 0.3}},
+		labels:     []string{"min/km", "min/mi", "pace"},
+		labelBelow: true,
+		key:        clockKey,
+		clean:      strings.TrimSpace,
+		plausible:  plausiblePace,
+	})
+	result.find(FIELD_TIME, text, fieldRules{
+		rules:      []candidateRule{{clockRegex, 0.3}},
+		labels:     []string{"time", "duration"},
+		labelBelow: true,
+		key:        sameText,
+		clean:      sameText,
+	})
+	result.find(FIELD_CALORIES, text, fieldRules{
+		rules:      []candidateRule{{caloriesRegex, 0.2}},
+		labels:     []string{"calories", "kcal"},
+		labelBelow: true,
+		key:        sameText,
+		clean:      sameText,
+	})
+	result.checkConsistency()
+	if inMiles(text) {
+		result.toKilometres()
 	}
 
-	return workoutDetails, nil
+	log.Debug().Msgf("Total Time: %s", result.Value(FIELD_TIME))
+	log.Debug().Msgf("Distance: %s", result.Value(FIELD_DISTANCE))
+	log.Debug().Msgf("Pace: %s", result.Value(FIELD_PACE))
+	log.Debug().Msgf("Calories: %s", result.Value(FIELD_CALORIES))
+
+	return result, result.Validate()
 }
 
 func cleanDistanceData(distance string) string {
@@ -130,7 +305,8 @@ func cleanDistanceData(distance string) string {
 
 	re := regexp.MustCompile(`[a-zA-Z]+`)
 
-	distance = re.ReplaceAllString(distance, "")
+	// OCR often reads a space before the unit, as in 5.02 km
+	distance = strings.TrimSpace(re.ReplaceAllString(distance, ""))
 	log.Debug().Msgf("After replacing strings: %s", distance)
 
 	return distance
@@ -154,7 +330,7 @@ func (ip *ImageProcessor) IsAppleWorkout(text string) bool {
 
 func (ip *ImageProcessor) IsRunKeeper(text string) bool {
 	keywords := []string{
-		"km", "time", "min/km", "Calories",
+		"time", "Calories",
 	}
 
 	for _, keyword := range keywords {
@@ -164,5 +340,11 @@ func (ip *ImageProcessor) IsRunKeeper(text string) bool {
 			return false
 		}
 	}
+	// Paces per km or per mile
+	if !strings.Contains(text, "min/km") && !strings.Contains(text, "min/mi") {
+		log.Debug().Msgf("Keyword not found in Text: min/km or min/mi")
+		log.Debug().Msgf("Not Run Keeper")
+		return false
+	}
 	return true
 }
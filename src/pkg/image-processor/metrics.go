@@ -0,0 +1,33 @@
+package imageprocessor
+
+import (
+	"errors"
+	"run-tracker-telebot/src/pkg/metrics"
+)
+
+// Results of reading a workout, counted per parser.
+const (
+	PARSE_OK            = "ok"
+	PARSE_MISSING_FIELD = "missing_field"
+	PARSE_ERROR         = "error"
+	// PARSER_NONE counts the screenshots of apps no parser recognizes.
+	PARSER_NONE = "none"
+)
+
+var (
+	ocrDuration = metrics.NewHistogram("runtracker_ocr_duration_seconds",
+		"Time taken by one OCR pass over a screenshot.", metrics.DURATION_BUCKETS)
+	parseTotal = metrics.NewCounter("runtracker_parse_total",
+		"Screenshots read, by the parser of the app and the result.", "parser", "result")
+)
+
+func countParse(parser string, err error) {
+	switch {
+	case err == nil:
+		parseTotal.Inc(parser, PARSE_OK)
+	case errors.Is(err, ErrMissingField):
+		parseTotal.Inc(parser, PARSE_MISSING_FIELD)
+	default:
+		parseTotal.Inc(parser, PARSE_ERROR)
+	}
+}
@@ -0,0 +1,172 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"run-tracker-telebot/src/log"
+	"strings"
+	"time"
+
+	"github.com/otiai10/gosseract/v2"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Characters workout numbers are written with: digits, clock and decimal separators,
+// the pace's slash and the letters of km, mi and min.
+const (
+	WHITELIST_NUMBERS = "0123456789:.,'\"/"
+	WHITELIST_UNITS   = "kmiKMIn"
+)
+
+const DEFAULT_LANGUAGE = "eng"
+
+// Region is a rectangle of the screenshot to read, in fractions of its width and height
+// so it fits screenshots of any resolution.
+type Region struct {
+	Left   float64
+	Top    float64
+	Width  float64
+	Height float64
+}
+
+// OCRConfig is how Tesseract reads an image. Without regions the whole image is read.
+type OCRConfig struct {
+	PageSegMode gosseract.PageSegMode
+	// Whitelist limits the characters Tesseract may recognize, empty allows all.
+	Whitelist string
+	// Languages are the language packs to use, e.g. "eng" and "deu".
+	Languages []string
+	Regions   []Region
+}
+
+// DEFAULT_OCR reads whole screenshots, which is enough to tell the apps apart.
+var DEFAULT_OCR = OCRConfig{
+	PageSegMode: gosseract.PSM_AUTO,
+	Languages:   []string{DEFAULT_LANGUAGE},
+}
+
+// ParseLanguages reads language packs joined like Tesseract does, e.g. "eng+deu".
+func ParseLanguages(input string) []string {
+	var languages []string
+	for _, language := range strings.FieldsFunc(input, func(r rune) bool { return r == '+' || r == ',' }) {
+		languages = append(languages, strings.TrimSpace(language))
+	}
+	return languages
+}
+
+func (r Region) rect(bounds image.Rectangle) image.Rectangle {
+	x := func(fraction float64) int { return bounds.Min.X + int(fraction*float64(bounds.Dx())) }
+	y := func(fraction float64) int { return bounds.Min.Y + int(fraction*float64(bounds.Dy())) }
+	return image.Rect(x(r.Left), y(r.Top), x(r.Left+r.Width), y(r.Top+r.Height)).Intersect(bounds)
+}
+
+// recognize runs OCR with config on the image in data, after the preprocessing steps.
+// The text of every region goes on its own lines, in the order of the regions. Images
+// Go cannot decode are handed to Tesseract as they are, without regions.
+func (ip *ImageProcessor) recognize(data []byte, config OCRConfig) (string, error) {
+	defer ocrDuration.Since(time.Now())
+
+	log.Info().Msgf("Creating new Tesseract client...")
+	client := gosseract.NewClient()
+	defer client.Close()
+
+	languages := config.Languages
+	if len(languages) == 0 {
+		languages = []string{DEFAULT_LANGUAGE}
+	}
+	if err := client.SetLanguage(languages...); err != nil {
+		return "", err
+	}
+	if err := client.SetPageSegMode(config.PageSegMode); err != nil {
+		return "", err
+	}
+	if config.Whitelist != "" {
+		if err := client.SetWhitelist(config.Whitelist); err != nil {
+			return "", err
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Warn().Msgf("Error decoding image, using it as is: %v", err)
+		client.SetImageFromBytes(data)
+		return client.Text()
+	}
+
+	images := []image.Image{img}
+	steps := ip.Steps
+	if len(config.Regions) > 0 {
+		images = nil
+		for _, region := range config.Regions {
+			images = append(images, crop(img, region.rect(img.Bounds())))
+		}
+		// Regions are below the status bar already
+		steps = nil
+		for _, step := range ip.Steps {
+			if step != STEP_CROP {
+				steps = append(steps, step)
+			}
+		}
+	}
+
+	var texts []string
+	for _, part := range images {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, Preprocess(part, steps)); err != nil {
+			return "", err
+		}
+		client.SetImageFromBytes(buf.Bytes())
+
+		text, err := client.Text()
+		if err != nil {
+			return "", err
+		}
+		texts = append(texts, text)
+	}
+
+	return strings.Join(texts, "\n"), nil
+}
+
+// OCR_CHECK_TEXT is what CheckOCR writes and expects Tesseract to read back.
+const OCR_CHECK_TEXT = "42"
+
+// CheckOCR reads a rendered number with the first pass languages, to tell whether
+// Tesseract and its language packs work before relying on them.
+func (ip *ImageProcessor) CheckOCR() error {
+	face := basicfont.Face7x13
+	small := image.NewGray(image.Rect(0, 0, 40, 24))
+	draw.Draw(small, small.Rect, image.White, image.Point{}, draw.Src)
+	drawer := &font.Drawer{Dst: small, Src: image.Black, Face: face, Dot: fixed.P(12, 17)}
+	drawer.DrawString(OCR_CHECK_TEXT)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, upscale(small)); err != nil {
+		return err
+	}
+
+	config := ip.OCR
+	config.Regions = nil
+	text, err := ip.recognize(buf.Bytes(), config)
+	if err != nil {
+		return fmt.Errorf("tesseract is not working: %w", err)
+	}
+	if !strings.Contains(text, OCR_CHECK_TEXT) {
+		return fmt.Errorf("tesseract read %q instead of %q", strings.TrimSpace(text), OCR_CHECK_TEXT)
+	}
+	return nil
+}
+
+func crop(img image.Image, rect image.Rectangle) image.Image {
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+	if sub, ok := img.(subImager); ok {
+		return sub.SubImage(rect)
+	}
+	return img
+}
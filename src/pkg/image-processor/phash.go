@@ -0,0 +1,55 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// The image is shrunk to HASH_SIZE+1 by HASH_SIZE pixels, comparing neighbours
+	// gives a hash of HASH_SIZE*HASH_SIZE bits.
+	HASH_SIZE = 8
+	// Hashes differing in at most this many bits are taken as the same screenshot,
+	// recompressing or resizing a forwarded photo flips a few.
+	HASH_MAX_DISTANCE = 6
+)
+
+// PerceptualHash hashes the image in data so that the same screenshot, forwarded,
+// recompressed or resized, hashes (almost) the same. It is a difference hash: every bit
+// tells whether a pixel of the shrunk grayscale image is brighter than its right neighbour.
+func PerceptualHash(data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("error decoding image: %w", err)
+	}
+
+	small := image.NewGray(image.Rect(0, 0, HASH_SIZE+1, HASH_SIZE))
+	draw.ApproxBiLinear.Scale(small, small.Rect, img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < HASH_SIZE; y++ {
+		for x := 0; x < HASH_SIZE; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+// SimilarImages tells if two perceptual hashes are of the same screenshot. Hashes that
+// cannot be read are never similar.
+func SimilarImages(a string, b string) bool {
+	hashA, errA := strconv.ParseUint(a, 16, 64)
+	hashB, errB := strconv.ParseUint(b, 16, 64)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return bits.OnesCount64(hashA^hashB) <= HASH_MAX_DISTANCE
+}
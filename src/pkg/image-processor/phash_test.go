@@ -0,0 +1,106 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// gradient hashes an image getting brighter to the right, or to the left when reversed.
+func gradient(t *testing.T, reversed bool) string {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, 90, 160))
+	for y := 0; y < 160; y++ {
+		for x := 0; x < 90; x++ {
+			value := uint8(x * 2)
+			if reversed {
+				value = 255 - value
+			}
+			img.Pix[y*img.Stride+x] = value
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	hash, err := PerceptualHash(buf.Bytes())
+	if err != nil {
+		t.Fatalf("PerceptualHash: %v", err)
+	}
+	return hash
+}
+
+func TestPerceptualHash(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "screenshots", "apple_dark_km.png"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	original, err := PerceptualHash(data)
+	if err != nil {
+		t.Fatalf("PerceptualHash: %v", err)
+	}
+	if len(original) != 16 {
+		t.Errorf("PerceptualHash() = %q, want 16 hex digits", original)
+	}
+
+	// Telegram forwards photos shrunk and recompressed as JPEG
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	small := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx()/2, img.Bounds().Dy()/2))
+	draw.ApproxBiLinear.Scale(small, small.Rect, img, img.Bounds(), draw.Src, nil)
+	var forwarded bytes.Buffer
+	if err := jpeg.Encode(&forwarded, small, &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	hash, err := PerceptualHash(forwarded.Bytes())
+	if err != nil {
+		t.Fatalf("PerceptualHash: %v", err)
+	}
+	if !SimilarImages(original, hash) {
+		t.Errorf("forwarded screenshot hashes to %s, too far from %s", hash, original)
+	}
+
+	// Mostly flat screenshots of text hash alike whatever they show, which is why
+	// FindDuplicate wants the same distance too. Pictures with shapes hash apart.
+	left, right := gradient(t, false), gradient(t, true)
+	if SimilarImages(left, right) {
+		t.Errorf("opposite gradients hash to %s and %s, want them apart", left, right)
+	}
+
+	if _, err := PerceptualHash([]byte("not an image")); err == nil {
+		t.Errorf("PerceptualHash() of text succeeded, want error")
+	}
+}
+
+func TestSimilarImages(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want bool
+	}{
+		{"f0f0f0f0f0f0f0f0", "f0f0f0f0f0f0f0f0", true},
+		// HASH_MAX_DISTANCE bits apart, then one more
+		{"0000000000000000", "000000000000003f", true},
+		{"0000000000000000", "000000000000007f", false},
+		{"8000000000000000", "0000000000000001", true},
+		{"ffffffffffffffff", "0000000000000000", false},
+		{"", "", false},
+		{"not a hash", "not a hash", false},
+	}
+
+	for _, test := range tests {
+		if got := SimilarImages(test.a, test.b); got != test.want {
+			t.Errorf("SimilarImages(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
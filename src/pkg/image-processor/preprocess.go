@@ -0,0 +1,238 @@
+package imageprocessor
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Preprocessing steps, applied in the configured order before the image goes to Tesseract.
+const (
+	// STEP_CROP cuts off the phone's status bar, its clock reads like a pace.
+	STEP_CROP = "crop"
+	// STEP_GRAYSCALE drops the colors, Tesseract only looks at brightness anyway.
+	STEP_GRAYSCALE = "grayscale"
+	// STEP_INVERT turns dark mode screenshots, like Apple Fitness, into dark text on white.
+	STEP_INVERT = "invert"
+	// STEP_UPSCALE enlarges small screenshots, Tesseract wants letters of 20-30px.
+	STEP_UPSCALE = "upscale"
+	// STEP_CONTRAST stretches the brightness so grey labels get darker.
+	STEP_CONTRAST = "contrast"
+	// STEP_THRESHOLD turns the image black and white with Otsu's method.
+	STEP_THRESHOLD = "threshold"
+)
+
+const (
+	// Share of the height of a portrait screenshot taken by the status bar.
+	STATUS_BAR_HEIGHT = 0.06
+	// Screenshots narrower than this are scaled up to it, in pixels.
+	MIN_WIDTH = 1600
+	// Images are not scaled up more than this, blurry letters do not get better.
+	MAX_SCALE = 3.0
+	// Average brightness below which a screenshot is taken as dark mode.
+	DARK_BACKGROUND = 110
+	// Share of pixels ignored at each end of the histogram when stretching the contrast.
+	CONTRAST_CLIP = 0.01
+)
+
+// DEFAULT_STEPS leaves out the threshold, Tesseract binarizes on its own and a bad
+// global threshold loses thin letters.
+var DEFAULT_STEPS = []string{STEP_CROP, STEP_GRAYSCALE, STEP_INVERT, STEP_CONTRAST, STEP_UPSCALE}
+
+type step func(image.Image) image.Image
+
+var steps = map[string]step{
+	STEP_CROP:      cropStatusBar,
+	STEP_GRAYSCALE: func(img image.Image) image.Image { return grayscale(img) },
+	STEP_INVERT:    invertDark,
+	STEP_UPSCALE:   upscale,
+	STEP_CONTRAST:  stretchContrast,
+	STEP_THRESHOLD: threshold,
+}
+
+// ParseSteps reads a comma separated list of steps, e.g. "crop,grayscale,invert".
+// "none" turns preprocessing off.
+func ParseSteps(input string) ([]string, error) {
+	input = strings.ToLower(strings.TrimSpace(input))
+	if input == "none" {
+		return []string{}, nil
+	}
+
+	var parsed []string
+	for _, name := range strings.Split(input, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := steps[name]; !ok {
+			return nil, fmt.Errorf("unknown preprocessing step: %q", name)
+		}
+		parsed = append(parsed, name)
+	}
+	return parsed, nil
+}
+
+// Preprocess runs the steps over img in order. Unknown steps are skipped, ParseSteps
+// already complains about them.
+func Preprocess(img image.Image, names []string) image.Image {
+	for _, name := range names {
+		if apply, ok := steps[name]; ok {
+			img = apply(img)
+		}
+	}
+	return img
+}
+
+func cropStatusBar(img image.Image) image.Image {
+	bounds := img.Bounds()
+	// Only phone screenshots have a status bar, photos of a watch do not
+	if bounds.Dy() <= bounds.Dx() {
+		return img
+	}
+
+	cropped := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()-int(float64(bounds.Dy())*STATUS_BAR_HEIGHT)))
+	draw.Draw(cropped, cropped.Bounds(), img, image.Point{X: bounds.Min.X, Y: bounds.Max.Y - cropped.Bounds().Dy()}, draw.Src)
+	return cropped
+}
+
+// grayscale converts img, gray images are returned as they are.
+func grayscale(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	if gray, ok := img.(*image.Gray); ok && bounds.Min == (image.Point{}) && gray.Stride == bounds.Dx() {
+		return gray
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Rect, img, bounds.Min, draw.Src)
+	return gray
+}
+
+// invertDark inverts images with a dark background, light ones are left alone.
+func invertDark(img image.Image) image.Image {
+	gray := grayscale(img)
+
+	var sum int
+	for _, value := range gray.Pix {
+		sum += int(value)
+	}
+	if len(gray.Pix) == 0 || sum/len(gray.Pix) >= DARK_BACKGROUND {
+		return gray
+	}
+
+	inverted := image.NewGray(gray.Rect)
+	for i, value := range gray.Pix {
+		inverted.Pix[i] = 255 - value
+	}
+	return inverted
+}
+
+func upscale(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dx() >= MIN_WIDTH {
+		return img
+	}
+
+	scale := float64(MIN_WIDTH) / float64(bounds.Dx())
+	if scale > MAX_SCALE {
+		scale = MAX_SCALE
+	}
+
+	rect := image.Rect(0, 0, int(float64(bounds.Dx())*scale), int(float64(bounds.Dy())*scale))
+	var scaled draw.Image = image.NewRGBA(rect)
+	if _, ok := img.(*image.Gray); ok {
+		scaled = image.NewGray(rect)
+	}
+	draw.CatmullRom.Scale(scaled, rect, img, bounds, draw.Src, nil)
+	return scaled
+}
+
+// stretchContrast maps the darkest and brightest percent of the pixels to black and
+// white and spreads the rest linearly in between.
+func stretchContrast(img image.Image) image.Image {
+	gray := grayscale(img)
+	histogram := histogramOf(gray)
+
+	clip := int(float64(len(gray.Pix)) * CONTRAST_CLIP)
+	low, high := 0, 255
+	for count := 0; low < 255 && count+histogram[low] <= clip; low++ {
+		count += histogram[low]
+	}
+	for count := 0; high > 0 && count+histogram[high] <= clip; high-- {
+		count += histogram[high]
+	}
+	if high <= low {
+		return gray
+	}
+
+	var lookup [256]uint8
+	for value := range lookup {
+		switch {
+		case value <= low:
+			lookup[value] = 0
+		case value >= high:
+			lookup[value] = 255
+		default:
+			lookup[value] = uint8((value - low) * 255 / (high - low))
+		}
+	}
+
+	stretched := image.NewGray(gray.Rect)
+	for i, value := range gray.Pix {
+		stretched.Pix[i] = lookup[value]
+	}
+	return stretched
+}
+
+// threshold turns img black and white at the level that best splits its histogram in two.
+func threshold(img image.Image) image.Image {
+	gray := grayscale(img)
+	histogram := histogramOf(gray)
+
+	total := len(gray.Pix)
+	var sum float64
+	for value, count := range histogram {
+		sum += float64(value * count)
+	}
+
+	// Otsu's method: pick the level with the largest variance between both classes
+	var sumBackground, best float64
+	var weightBackground int
+	level := 128
+	for value, count := range histogram {
+		weightBackground += count
+		if weightBackground == 0 {
+			continue
+		}
+		weightForeground := total - weightBackground
+		if weightForeground == 0 {
+			break
+		}
+
+		sumBackground += float64(value * count)
+		meanBackground := sumBackground / float64(weightBackground)
+		meanForeground := (sum - sumBackground) / float64(weightForeground)
+		variance := float64(weightBackground) * float64(weightForeground) * (meanBackground - meanForeground) * (meanBackground - meanForeground)
+		if variance > best {
+			best = variance
+			level = value
+		}
+	}
+
+	binary := image.NewGray(gray.Rect)
+	for i, value := range gray.Pix {
+		if int(value) > level {
+			binary.Pix[i] = 255
+		}
+	}
+	return binary
+}
+
+func histogramOf(gray *image.Gray) [256]int {
+	var histogram [256]int
+	for _, value := range gray.Pix {
+		histogram[value]++
+	}
+	return histogram
+}
@@ -0,0 +1,212 @@
+package imageprocessor
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// filled returns a gray image of the size filled with value.
+func filled(width int, height int, value uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Rect, image.NewUniform(color.Gray{Y: value}), image.Point{}, draw.Src)
+	return img
+}
+
+// values lists the distinct gray values of img.
+func values(img image.Image) map[uint8]bool {
+	found := map[uint8]bool{}
+	gray := grayscale(img)
+	for _, value := range gray.Pix {
+		found[value] = true
+	}
+	return found
+}
+
+func TestParseSteps(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"crop, Grayscale,,invert", []string{STEP_CROP, STEP_GRAYSCALE, STEP_INVERT}},
+		{"none", []string{}},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		got, err := ParseSteps(test.input)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseSteps(%q) = %#v, %v, want %#v", test.input, got, err, test.want)
+		}
+	}
+
+	if got, err := ParseSteps("crop,sharpen"); err == nil {
+		t.Errorf("ParseSteps() with an unknown step = %v, want error", got)
+	}
+}
+
+func TestCropStatusBar(t *testing.T) {
+	// A portrait screenshot with a black status bar over white
+	img := image.NewRGBA(image.Rect(0, 0, 100, 200))
+	draw.Draw(img, img.Rect, image.White, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 100, 12), image.Black, image.Point{}, draw.Src)
+
+	cropped := cropStatusBar(img)
+	if got := cropped.Bounds(); got != image.Rect(0, 0, 100, 188) {
+		t.Fatalf("cropped to %v, want the bottom 188 rows", got)
+	}
+	if got := values(cropped); len(got) != 1 || !got[255] {
+		t.Errorf("cropped image has values %v, want only the white below the status bar", got)
+	}
+
+	// Sub images keep their offset, 11 of 194 rows are cut off the top
+	sub := img.SubImage(image.Rect(0, 6, 100, 200))
+	if got := cropStatusBar(sub); got.Bounds().Dy() != 183 || len(values(got)) != 1 {
+		t.Errorf("cropped sub image to %d rows with values %v, want 183 white rows", got.Bounds().Dy(), values(got))
+	}
+
+	landscape := filled(200, 100, 0)
+	if got := cropStatusBar(landscape); got != image.Image(landscape) {
+		t.Errorf("landscape image was cropped to %v", got.Bounds())
+	}
+}
+
+func TestInvertDark(t *testing.T) {
+	tests := []struct {
+		background uint8
+		want       uint8
+	}{
+		{0, 255},
+		{DARK_BACKGROUND - 1, 255 - (DARK_BACKGROUND - 1)},
+		{DARK_BACKGROUND, DARK_BACKGROUND},
+		{255, 255},
+	}
+
+	for _, test := range tests {
+		got := invertDark(filled(10, 10, test.background)).(*image.Gray)
+		if got.Pix[0] != test.want {
+			t.Errorf("invertDark() of %d = %d, want %d", test.background, got.Pix[0], test.want)
+		}
+	}
+
+	// Colors count by their brightness, a dark blue is dark
+	blue := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(blue, blue.Rect, image.NewUniform(color.RGBA{B: 120, A: 255}), image.Point{}, draw.Src)
+	if got := invertDark(blue).(*image.Gray); got.Pix[0] < 200 {
+		t.Errorf("invertDark() of dark blue = %d, want it inverted", got.Pix[0])
+	}
+}
+
+func TestStretchContrast(t *testing.T) {
+	// Gray text on a lighter gray background
+	img := filled(100, 100, 200)
+	draw.Draw(img, image.Rect(0, 0, 100, 30), image.NewUniform(color.Gray{Y: 120}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 30, 100, 40), image.NewUniform(color.Gray{Y: 160}), image.Point{}, draw.Src)
+
+	got := values(stretchContrast(img))
+	if !got[0] || !got[255] || !got[127] || len(got) != 3 {
+		t.Errorf("stretchContrast() values = %v, want 0, 127 and 255", got)
+	}
+
+	// A flat image has nothing to stretch
+	if got := values(stretchContrast(filled(10, 10, 90))); len(got) != 1 || !got[90] {
+		t.Errorf("stretchContrast() of a flat image = %v, want it unchanged", got)
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	// Two noisy peaks around 60 and 190
+	img := filled(100, 100, 0)
+	for i := range img.Pix {
+		if i%2 == 0 {
+			img.Pix[i] = uint8(50 + i%20)
+		} else {
+			img.Pix[i] = uint8(180 + i%20)
+		}
+	}
+
+	binary := threshold(img).(*image.Gray)
+	for i, value := range binary.Pix {
+		want := uint8(0)
+		if img.Pix[i] > 120 {
+			want = 255
+		}
+		if value != want {
+			t.Fatalf("pixel %d of %d = %d, want %d", i, img.Pix[i], value, want)
+		}
+	}
+}
+
+func TestUpscale(t *testing.T) {
+	tests := []struct {
+		width int
+		want  int
+	}{
+		// No more than MAX_SCALE
+		{400, 1200},
+		{800, MIN_WIDTH},
+		{MIN_WIDTH, MIN_WIDTH},
+		{2000, 2000},
+	}
+
+	for _, test := range tests {
+		got := upscale(filled(test.width, 10, 128))
+		if got.Bounds().Dx() != test.want {
+			t.Errorf("upscale() of %d px = %d px, want %d", test.width, got.Bounds().Dx(), test.want)
+		}
+		if _, ok := got.(*image.Gray); !ok {
+			t.Errorf("upscale() of a gray image returned %T", got)
+		}
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	// A dark mode screenshot comes out with a white background and no status bar
+	img := image.NewRGBA(image.Rect(0, 0, 400, 800))
+	draw.Draw(img, img.Rect, image.NewUniform(color.RGBA{R: 20, G: 20, B: 20, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 400, 350, 500), image.White, image.Point{}, draw.Src)
+
+	got := Preprocess(img, DEFAULT_STEPS)
+	if want := image.Rect(0, 0, 1200, 3*(800-int(800*STATUS_BAR_HEIGHT))); got.Bounds() != want {
+		t.Errorf("preprocessed to %v, want %v", got.Bounds(), want)
+	}
+	gray, ok := got.(*image.Gray)
+	if !ok {
+		t.Fatalf("preprocessed to %T, want a gray image", got)
+	}
+	if gray.GrayAt(10, 10).Y != 255 {
+		t.Errorf("background = %d, want white", gray.GrayAt(10, 10).Y)
+	}
+
+	if got := Preprocess(img, nil); got != image.Image(img) {
+		t.Errorf("Preprocess() without steps changed the image")
+	}
+}
+
+// TestEvaluateScreenshots measures the accuracy on the committed screenshots with and
+// without preprocessing.
+func TestEvaluateScreenshots(t *testing.T) {
+	ip := newTestProcessor(t)
+	if err := ip.CheckOCR(); err != nil {
+		t.Skipf("OCR is not available: %v", err)
+	}
+
+	dir := filepath.Join("testdata", "screenshots")
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatalf("LoadFixtures: %v", err)
+	}
+
+	raw := ip.Evaluate(dir, fixtures, nil)
+	preprocessed := ip.Evaluate(dir, fixtures, DEFAULT_STEPS)
+	t.Logf("accuracy without preprocessing %.0f%%, with %v %.0f%%", 100*raw.Accuracy(), DEFAULT_STEPS, 100*preprocessed.Accuracy())
+	for _, miss := range preprocessed.Misses {
+		t.Logf("miss: %s", miss)
+	}
+	if preprocessed.Correct < raw.Correct {
+		t.Errorf("preprocessing read %d of %d screenshots, %d without", preprocessed.Correct, preprocessed.Total, raw.Correct)
+	}
+}
@@ -0,0 +1,322 @@
+package imageprocessor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"run-tracker-telebot/src/pkg/stats"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields of a ParseResult, named like the keys of the workout details.
+const (
+	FIELD_DISTANCE = "Distance"
+	FIELD_PACE     = "Pace"
+	FIELD_TIME     = "TotalTime"
+	FIELD_CALORIES = "Calories"
+)
+
+// REQUIRED_FIELDS are needed to log a workout.
+var REQUIRED_FIELDS = []string{FIELD_DISTANCE, FIELD_PACE}
+
+// ErrMissingField is returned when no candidate was found for a required field.
+var ErrMissingField = errors.New("missing field")
+
+// Confidence scoring of candidates. A match starts with the score of its rule and gains
+// or loses from what surrounds it.
+const (
+	LABEL_BONUS         = 0.3
+	IMPLAUSIBLE_PENALTY = 0.3
+	// Paces and times that agree with the distance back each other up.
+	CONSISTENCY_BONUS = 0.2
+	// Values read the same in both OCR passes.
+	AGREEMENT_BONUS = 0.2
+	// Candidates closer than this to the best one make a field ambiguous.
+	TIE_MARGIN        = 0.1
+	AMBIGUITY_PENALTY = 0.3
+	// Below this a field is ambiguous enough to ask the user.
+	LOW_CONFIDENCE = 0.6
+)
+
+// Candidate is one value a field could have, with how sure the parser is about it.
+type Candidate struct {
+	Value      string
+	Confidence float64
+	// key compares values written differently, e.g. 5'41" and 5:41.
+	key string
+}
+
+// Field is what a parser read for one detail, the best candidate first.
+type Field struct {
+	Value      string
+	Confidence float64
+	Candidates []Candidate
+}
+
+// ParseResult holds the fields a parser read from the text of a screenshot.
+type ParseResult struct {
+	Parser string
+	// Activity is the type of activity the screenshot shows, empty when unknown.
+	Activity string
+	// Miles is set when the screenshot was in miles, distance and pace are converted to km.
+	Miles  bool
+	Fields map[string]*Field
+}
+
+// candidateRule is a pattern for a field, the score is how much a match of it alone says.
+type candidateRule struct {
+	regex *regexp.Regexp
+	score float64
+}
+
+// fieldRules describe where to look for a field. Labels are matched case-insensitively
+// on the line of the value and on the line above or below it, as the app lays them out.
+type fieldRules struct {
+	rules      []candidateRule
+	labels     []string
+	labelBelow bool
+	// key normalizes a match, matches with the same key are one candidate.
+	key       func(match string) string
+	clean     func(match string) string
+	plausible func(value string) bool
+}
+
+func newParseResult(parser string) *ParseResult {
+	return &ParseResult{Parser: parser, Fields: make(map[string]*Field)}
+}
+
+// Value is the best candidate of a field, empty if none was found.
+func (r *ParseResult) Value(name string) string {
+	if r == nil || r.Fields[name] == nil {
+		return ""
+	}
+	return r.Fields[name].Value
+}
+
+// Validate reports the first required field without a candidate.
+func (r *ParseResult) Validate() error {
+	for _, name := range REQUIRED_FIELDS {
+		if r.Value(name) == "" {
+			return fmt.Errorf("%w: %s", ErrMissingField, name)
+		}
+	}
+	return nil
+}
+
+// Confidence is the confidence of the least certain required field.
+func (r *ParseResult) Confidence() float64 {
+	confidence := 1.0
+	for _, name := range REQUIRED_FIELDS {
+		if r.Fields[name] == nil {
+			return 0
+		}
+		confidence = math.Min(confidence, r.Fields[name].Confidence)
+	}
+	return confidence
+}
+
+// Ambiguous lists the required fields with a confidence below LOW_CONFIDENCE and more
+// than one candidate to choose from.
+func (r *ParseResult) Ambiguous() []string {
+	var names []string
+	for _, name := range REQUIRED_FIELDS {
+		field := r.Fields[name]
+		if field != nil && field.Confidence < LOW_CONFIDENCE && len(field.Candidates) > 1 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Choose settles a field on one of its candidates, e.g. the one the user picked.
+func (r *ParseResult) Choose(name string, index int) error {
+	field := r.Fields[name]
+	if field == nil || index < 0 || index >= len(field.Candidates) {
+		return fmt.Errorf("no candidate %d for %s", index, name)
+	}
+	field.Value = field.Candidates[index].Value
+	field.Confidence = 1
+	return nil
+}
+
+// WorkoutDetails are the best values of all fields, keyed like the fields.
+func (r *ParseResult) WorkoutDetails() map[string]string {
+	workoutDetails := make(map[string]string)
+	for name, field := range r.Fields {
+		workoutDetails[name] = field.Value
+	}
+	if r.Activity != "" {
+		workoutDetails["Type"] = r.Activity
+	}
+	return workoutDetails
+}
+
+// Merge adds the candidates of another pass over the same screenshot. Values both
+// passes read gain AGREEMENT_BONUS.
+func (r *ParseResult) Merge(other *ParseResult) {
+	if other == nil {
+		return
+	}
+	for name, otherField := range other.Fields {
+		field := r.Fields[name]
+		if field == nil {
+			r.Fields[name] = otherField
+			continue
+		}
+
+		candidates := append([]Candidate{}, field.Candidates...)
+		for _, otherCandidate := range otherField.Candidates {
+			found := false
+			for i := range candidates {
+				if candidates[i].key == otherCandidate.key {
+					candidates[i].Confidence = math.Min(1, math.Max(candidates[i].Confidence, otherCandidate.Confidence)+AGREEMENT_BONUS)
+					found = true
+				}
+			}
+			if !found {
+				candidates = append(candidates, otherCandidate)
+			}
+		}
+		r.Fields[name] = newField(candidates)
+	}
+}
+
+// find looks for a field in text and keeps it in the result when there is a candidate.
+func (r *ParseResult) find(name string, text string, rules fieldRules) {
+	candidates := findCandidates(text, rules)
+	if len(candidates) > 0 {
+		r.Fields[name] = newField(candidates)
+	}
+}
+
+func findCandidates(text string, rules fieldRules) []Candidate {
+	lines := strings.Split(text, "\n")
+	byKey := make(map[string]*Candidate)
+	var order []string
+
+	for i, line := range lines {
+		neighbour := ""
+		if rules.labelBelow && i+1 < len(lines) {
+			neighbour = lines[i+1]
+		} else if !rules.labelBelow && i > 0 {
+			neighbour = lines[i-1]
+		}
+		labeled := hasLabel(line, rules.labels) || hasLabel(neighbour, rules.labels)
+
+		for _, rule := range rules.rules {
+			for _, index := range rule.regex.FindAllStringIndex(line, -1) {
+				// Skip pieces of a longer number, like the 02 of 5.02 or the 33 of 27:33
+				if partOfNumber(line, index[0], index[1]) {
+					continue
+				}
+				match := line[index[0]:index[1]]
+				value := rules.clean(match)
+				score := rule.score
+				if labeled {
+					score += LABEL_BONUS
+				}
+				if rules.plausible != nil && !rules.plausible(value) {
+					score -= IMPLAUSIBLE_PENALTY
+				}
+				score = math.Max(0, math.Min(1, score))
+
+				key := rules.key(match)
+				if existing, ok := byKey[key]; ok {
+					if score > existing.Confidence {
+						existing.Value, existing.Confidence = value, score
+					}
+					continue
+				}
+				byKey[key] = &Candidate{Value: value, Confidence: score, key: key}
+				order = append(order, key)
+			}
+		}
+	}
+
+	candidates := make([]Candidate, 0, len(order))
+	for _, key := range order {
+		candidates = append(candidates, *byKey[key])
+	}
+	return candidates
+}
+
+func partOfNumber(line string, start int, end int) bool {
+	separators := ".,:'"
+	if start > 0 && strings.ContainsRune(separators, rune(line[start-1])) {
+		return true
+	}
+	return end < len(line) && strings.ContainsRune(separators, rune(line[end])) && end+1 < len(line) && line[end+1] >= '0' && line[end+1] <= '9'
+}
+
+func hasLabel(line string, labels []string) bool {
+	line = strings.ToLower(line)
+	for _, label := range labels {
+		if strings.Contains(line, strings.ToLower(label)) {
+			return true
+		}
+	}
+	return false
+}
+
+// newField sorts the candidates, best first, and lowers the confidence when the
+// runner-up is about as likely as the best one.
+func newField(candidates []Candidate) *Field {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+
+	field := &Field{Value: candidates[0].Value, Confidence: candidates[0].Confidence, Candidates: candidates}
+	if len(candidates) > 1 && candidates[1].Confidence > candidates[0].Confidence-TIE_MARGIN {
+		field.Confidence = math.Max(0, field.Confidence-AMBIGUITY_PENALTY)
+	}
+	return field
+}
+
+// checkConsistency backs up the distances, paces and times that fit together, pace
+// times distance giving the time. This tells a pace from a total time of the same format.
+func (r *ParseResult) checkConsistency() {
+	distanceField, paceField, timeField := r.Fields[FIELD_DISTANCE], r.Fields[FIELD_PACE], r.Fields[FIELD_TIME]
+	if distanceField == nil || paceField == nil || timeField == nil {
+		return
+	}
+
+	consistent := make(map[*Candidate]bool)
+	for i := range distanceField.Candidates {
+		distance, err := strconv.ParseFloat(distanceField.Candidates[i].Value, 64)
+		if err != nil || distance <= 0 {
+			continue
+		}
+		for j := range paceField.Candidates {
+			pace, err := stats.ParsePace(paceField.Candidates[j].Value)
+			if err != nil {
+				continue
+			}
+			for k := range timeField.Candidates {
+				total, err := stats.ParseClock(timeField.Candidates[k].Value)
+				if err != nil || total <= 0 {
+					continue
+				}
+				expected := time.Duration(float64(pace) * distance)
+				if math.Abs(float64(expected-total))/float64(total) < 0.05 {
+					consistent[&distanceField.Candidates[i]] = true
+					consistent[&paceField.Candidates[j]] = true
+					consistent[&timeField.Candidates[k]] = true
+				}
+			}
+		}
+	}
+	if len(consistent) == 0 {
+		return
+	}
+
+	for candidate := range consistent {
+		candidate.Confidence = math.Min(1, candidate.Confidence+CONSISTENCY_BONUS)
+	}
+	r.Fields[FIELD_DISTANCE] = newField(distanceField.Candidates)
+	r.Fields[FIELD_PACE] = newField(paceField.Candidates)
+	r.Fields[FIELD_TIME] = newField(timeField.Candidates)
+}
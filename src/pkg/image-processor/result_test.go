@@ -0,0 +1,246 @@
+package imageprocessor
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// appleDistance are the distance rules of the Apple parser, labels above the values.
+var appleDistance = fieldRules{
+	rules:     []candidateRule{{distanceWithUnitRegex, 0.6}, {distanceRegex, 0.2}},
+	labels:    []string{"Distance"},
+	key:       distanceKey,
+	clean:     cleanDistanceData,
+	plausible: plausibleDistance,
+}
+
+// candidates builds candidates keyed by their value.
+func candidates(values ...interface{}) []Candidate {
+	var list []Candidate
+	for i := 0; i+1 < len(values); i += 2 {
+		value := values[i].(string)
+		list = append(list, Candidate{Value: value, Confidence: values[i+1].(float64), key: value})
+	}
+	return list
+}
+
+// scores lists the values and confidences of candidates, rounded to two decimals.
+func scores(list []Candidate) map[string]float64 {
+	found := map[string]float64{}
+	for _, candidate := range list {
+		found[candidate.Value] = math.Round(candidate.Confidence*100) / 100
+	}
+	return found
+}
+
+func TestFindCandidates(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want map[string]float64
+	}{
+		{
+			name: "label above",
+			text: "Distance\n5.02KM\n3.10",
+			want: map[string]float64{"5.02": 0.9, "3.10": 0.2},
+		},
+		{
+			name: "label on the line",
+			text: "Distance 5.02 km",
+			want: map[string]float64{"5.02": 0.9},
+		},
+		{
+			// The same value twice keeps its best score
+			name: "same key",
+			text: "5.02\nDistance\n5.02 KM",
+			want: map[string]float64{"5.02": 0.9},
+		},
+		{
+			name: "implausible",
+			text: "Distance\n250.00KM",
+			want: map[string]float64{"250.00": 0.6},
+		},
+		{
+			// Neither a piece of 3.41.5 nor the 33.5 of 27:33.5
+			name: "pieces of numbers",
+			text: "3.41.5\n27:33.5\n5.02",
+			want: map[string]float64{"5.02": 0.2},
+		},
+	}
+
+	for _, test := range tests {
+		got := findCandidates(test.text, appleDistance)
+		if !reflect.DeepEqual(scores(got), test.want) {
+			t.Errorf("%s: findCandidates(%q) = %v, want %v", test.name, test.text, scores(got), test.want)
+		}
+	}
+
+	// RunKeeper prints labels below the values
+	below := appleDistance
+	below.labels, below.labelBelow = []string{"Distance"}, true
+	got := scores(findCandidates("Distance\n5.02\n3.10\nDistance", below))
+	if want := map[string]float64{"5.02": 0.2, "3.10": 0.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findCandidates() with labels below = %v, want %v", got, want)
+	}
+}
+
+func TestNewField(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		value      string
+		confidence float64
+	}{
+		{"single", candidates("5.02", 0.4), "5.02", 0.4},
+		{"clear winner", candidates("3.10", 0.2, "5.02", 0.9), "5.02", 0.9},
+		{"close runner-up", candidates("5.02", 0.8, "3.10", 0.75), "5.02", 0.5},
+		// The first found wins a tie
+		{"tie", candidates("5.02", 0.6, "3.10", 0.6), "5.02", 0.3},
+		{"tie at the bottom", candidates("5.02", 0.2, "3.10", 0.2), "5.02", 0},
+	}
+
+	for _, test := range tests {
+		field := newField(test.candidates)
+		if field.Value != test.value || math.Abs(field.Confidence-test.confidence) > 1e-9 {
+			t.Errorf("%s: newField() = %s at %.2f, want %s at %.2f", test.name, field.Value, field.Confidence, test.value, test.confidence)
+		}
+		if field.Candidates[0].Value != test.value {
+			t.Errorf("%s: best candidate %s is not first", test.name, test.value)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	result := newParseResult(PARSER_APPLE)
+	result.Fields[FIELD_DISTANCE] = newField(candidates("3.10", 0.6, "5.02", 0.55))
+	result.Fields[FIELD_PACE] = newField(candidates("6:02", 0.7))
+
+	second := newParseResult(PARSER_APPLE)
+	second.Fields[FIELD_DISTANCE] = newField(candidates("5.02", 0.5, "8.00", 0.3))
+	second.Fields[FIELD_TIME] = newField(candidates("0:30:21", 0.4))
+	result.Merge(second)
+	result.Merge(nil)
+
+	// 5.02 read in both passes beats 3.10 now
+	distance := result.Fields[FIELD_DISTANCE]
+	if want := map[string]float64{"5.02": 0.75, "3.10": 0.6, "8.00": 0.3}; !reflect.DeepEqual(scores(distance.Candidates), want) {
+		t.Errorf("merged distance candidates = %v, want %v", scores(distance.Candidates), want)
+	}
+	if distance.Value != "5.02" || math.Abs(distance.Confidence-0.75) > 1e-9 {
+		t.Errorf("merged distance = %s at %.2f, want 5.02 at 0.75", distance.Value, distance.Confidence)
+	}
+	if result.Value(FIELD_PACE) != "6:02" || result.Value(FIELD_TIME) != "0:30:21" {
+		t.Errorf("merged pace and time = %s and %s, want 6:02 and 0:30:21", result.Value(FIELD_PACE), result.Value(FIELD_TIME))
+	}
+
+	// Agreeing never goes above certain
+	sure := newParseResult(PARSER_APPLE)
+	sure.Fields[FIELD_PACE] = newField(candidates("6:02", 0.95))
+	sure.Merge(result)
+	if got := sure.Fields[FIELD_PACE].Confidence; got != 1 {
+		t.Errorf("merged pace confidence = %.2f, want 1", got)
+	}
+}
+
+// ambiguousResult is a RunKeeper summary where the pace cannot be told from the time
+// by the labels, only the distance tells them apart.
+func ambiguousResult() *ParseResult {
+	result := newParseResult(PARSER_RUNKEEPER)
+	result.Fields[FIELD_DISTANCE] = newField(candidates("5.00", 0.6))
+	result.Fields[FIELD_PACE] = newField(candidates("30:00", 0.3, "6:00", 0.3))
+	result.Fields[FIELD_TIME] = newField(candidates("30:00", 0.3, "6:00", 0.3))
+	return result
+}
+
+func TestCheckConsistency(t *testing.T) {
+	result := ambiguousResult()
+	if got := result.Ambiguous(); !reflect.DeepEqual(got, []string{FIELD_PACE}) {
+		t.Fatalf("Ambiguous() before = %v, want the pace", got)
+	}
+
+	// 6:00/km over 5 km takes 30:00
+	result.checkConsistency()
+	for name, want := range map[string]string{FIELD_DISTANCE: "5.00", FIELD_PACE: "6:00", FIELD_TIME: "30:00"} {
+		if got := result.Value(name); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	if got := result.Fields[FIELD_PACE].Confidence; math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("pace confidence = %.2f, want 0.50", got)
+	}
+	if got := result.Fields[FIELD_DISTANCE].Confidence; math.Abs(got-0.8) > 1e-9 {
+		t.Errorf("distance confidence = %.2f, want 0.80", got)
+	}
+	// Still not sure enough to log without asking
+	if got := result.Ambiguous(); !reflect.DeepEqual(got, []string{FIELD_PACE}) {
+		t.Errorf("Ambiguous() after = %v, want the pace", got)
+	}
+
+	// Nothing fits a distance of 7 km
+	other := ambiguousResult()
+	other.Fields[FIELD_DISTANCE] = newField(candidates("7.00", 0.6))
+	other.checkConsistency()
+	if got := other.Fields[FIELD_PACE].Confidence; got != 0 {
+		t.Errorf("pace confidence without a fit = %.2f, want 0", got)
+	}
+
+	// Without a time there is nothing to check
+	missing := ambiguousResult()
+	delete(missing.Fields, FIELD_TIME)
+	missing.checkConsistency()
+	if got := missing.Fields[FIELD_DISTANCE].Confidence; got != 0.6 {
+		t.Errorf("distance confidence without a time = %.2f, want 0.60", got)
+	}
+}
+
+func TestAmbiguous(t *testing.T) {
+	result := newParseResult(PARSER_RUNKEEPER)
+	// Unsure, but with nothing else to pick
+	result.Fields[FIELD_DISTANCE] = newField(candidates("5.02", 0.2))
+	result.Fields[FIELD_PACE] = newField(candidates("6:00", 0.4, "6:30", 0.4))
+	// Not a required field
+	result.Fields[FIELD_TIME] = newField(candidates("30:00", 0.3, "6:00", 0.3))
+
+	if got := result.Ambiguous(); !reflect.DeepEqual(got, []string{FIELD_PACE}) {
+		t.Errorf("Ambiguous() = %v, want the pace", got)
+	}
+	if got := result.Confidence(); math.Abs(got-0.1) > 1e-9 {
+		t.Errorf("Confidence() = %.2f, want the 0.10 of the pace", got)
+	}
+
+	delete(result.Fields, FIELD_PACE)
+	if got := result.Confidence(); got != 0 {
+		t.Errorf("Confidence() without a pace = %.2f, want 0", got)
+	}
+}
+
+func TestChoose(t *testing.T) {
+	result := ambiguousResult()
+
+	for _, index := range []int{-1, 2} {
+		if err := result.Choose(FIELD_PACE, index); err == nil {
+			t.Errorf("Choose(%s, %d) succeeded, want error", FIELD_PACE, index)
+		}
+	}
+	if err := result.Choose(FIELD_CALORIES, 0); err == nil {
+		t.Errorf("Choose() of a field without candidates succeeded, want error")
+	}
+	if result.Value(FIELD_PACE) != "30:00" {
+		t.Errorf("failed choices changed the pace to %s", result.Value(FIELD_PACE))
+	}
+
+	if err := result.Choose(FIELD_PACE, 1); err != nil {
+		t.Fatalf("Choose: %v", err)
+	}
+	if result.Value(FIELD_PACE) != "6:00" || result.Fields[FIELD_PACE].Confidence != 1 {
+		t.Errorf("chosen pace = %s at %.2f, want 6:00 at 1", result.Value(FIELD_PACE), result.Fields[FIELD_PACE].Confidence)
+	}
+	if got := result.Ambiguous(); len(got) != 0 {
+		t.Errorf("Ambiguous() after choosing = %v, want none", got)
+	}
+	if details := result.WorkoutDetails(); details[FIELD_PACE] != "6:00" || strings.Contains(details[FIELD_DISTANCE], " ") {
+		t.Errorf("WorkoutDetails() = %v", details)
+	}
+}
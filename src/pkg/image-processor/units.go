@@ -0,0 +1,60 @@
+package imageprocessor
+
+import (
+	"fmt"
+	"regexp"
+	"run-tracker-telebot/src/pkg/stats"
+	"strconv"
+	"time"
+)
+
+// KM_PER_MILE converts the workouts of apps set to miles, the bot logs km.
+const KM_PER_MILE = 1.609344
+
+// milesRegex finds a unit in miles: 6.21MI, 9'17"/MI, min/mi, or mi alone on the line
+// below a RunKeeper distance.
+var milesRegex = regexp.MustCompile(`(?im)\d\s?mi\b|/mi\b|^\s*mi\s*$`)
+
+func inMiles(text string) bool {
+	return milesRegex.MatchString(text)
+}
+
+// toKilometres converts the distance and pace candidates of a screenshot in miles.
+// Keys stay as read, so the passes over a screenshot still agree.
+func (r *ParseResult) toKilometres() {
+	r.Miles = true
+	r.convert(FIELD_DISTANCE, milesToKm)
+	r.convert(FIELD_PACE, pacePerKm)
+}
+
+func (r *ParseResult) convert(name string, convert func(string) string) {
+	field := r.Fields[name]
+	if field == nil {
+		return
+	}
+	field.Value = convert(field.Value)
+	for i := range field.Candidates {
+		field.Candidates[i].Value = convert(field.Candidates[i].Value)
+	}
+}
+
+// milesToKm turns a distance like "3.12" into km, "5.02". Values that are no number
+// are kept.
+func milesToKm(distance string) string {
+	value, err := strconv.ParseFloat(distance, 64)
+	if err != nil {
+		return distance
+	}
+	return fmt.Sprintf("%.2f", value*KM_PER_MILE)
+}
+
+// pacePerKm turns a pace per mile like "9:42" into one per km, "6:02". Values that
+// are no pace are kept.
+func pacePerKm(pace string) string {
+	perMile, err := stats.ParsePace(pace)
+	if err != nil {
+		return pace
+	}
+	perKm := time.Duration(float64(perMile) / KM_PER_MILE).Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(perKm.Minutes()), int(perKm.Seconds())%60)
+}
@@ -0,0 +1,74 @@
+package imageprocessor
+
+import "testing"
+
+func TestInMiles(t *testing.T) {
+	tests := map[string]bool{
+		"0:57:42 6.21MI":          true,
+		"170SPM 9'17\"/MI":        true,
+		"3.12\nmi\n30:16":         true,
+		"9:17 min/mi":             true,
+		"6.21 mi":                 true,
+		"0:30:21 5.02KM":          false,
+		"6:02\nmin/km":            false,
+		"Running\nmiles of smile": false,
+		"5.02 km\n6:30 AM":        false,
+	}
+
+	for text, want := range tests {
+		if got := inMiles(text); got != want {
+			t.Errorf("inMiles(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestMilesToKm(t *testing.T) {
+	tests := map[string]string{
+		"3.12": "5.02",
+		"6.21": "9.99",
+		"26.2": "42.16",
+		"":     "",
+		"x":    "x",
+	}
+	for miles, want := range tests {
+		if got := milesToKm(miles); got != want {
+			t.Errorf("milesToKm(%q) = %q, want %q", miles, got, want)
+		}
+	}
+}
+
+func TestPacePerKm(t *testing.T) {
+	tests := map[string]string{
+		"9:42":   "6:02",
+		"9'17\"": "5:46",
+		// Seconds that round up to a whole minute
+		"8:02":     "5:00",
+		"16:05":    "10:00",
+		"not pace": "not pace",
+	}
+	for perMile, want := range tests {
+		if got := pacePerKm(perMile); got != want {
+			t.Errorf("pacePerKm(%q) = %q, want %q", perMile, got, want)
+		}
+	}
+}
+
+func TestToKilometres(t *testing.T) {
+	ip := newTestProcessor(t)
+	result, err := ip.ParseRunKeepWorkoutDetails("3.12\nmi\n30:16\ntime\n9:42\nmin/mi\n338\nCalories")
+	if err != nil {
+		t.Fatalf("ParseRunKeepWorkoutDetails: %v", err)
+	}
+	if !result.Miles || result.Value(FIELD_DISTANCE) != "5.02" || result.Value(FIELD_PACE) != "6:02" {
+		t.Fatalf("result = %+v, want 5.02 km at 6:02", result)
+	}
+
+	// A second pass that lost the unit agrees on what was read, not on the km
+	region := newParseResult(PARSER_RUNKEEPER)
+	region.find(FIELD_DISTANCE, "3.12", fieldRules{rules: []candidateRule{{distanceRegex, 0.3}}, key: distanceKey, clean: cleanDistanceData})
+	region.toKilometres()
+	result.Merge(region)
+	if got := result.Fields[FIELD_DISTANCE].Candidates; len(got) != 1 || got[0].Value != "5.02" {
+		t.Errorf("merged candidates = %+v, want 5.02 once", got)
+	}
+}
@@ -0,0 +1,473 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"run-tracker-telebot/src/pkg/exporter"
+	"run-tracker-telebot/src/pkg/stats"
+	"run-tracker-telebot/src/pkg/validator"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Supported CSV files, told apart by their header.
+const (
+	FORMAT_EXPORT = "export"
+	FORMAT_STRAVA = "strava"
+	FORMAT_GARMIN = "garmin"
+)
+
+// Reasons for skipping or failing a row, the chat manager translates them.
+const (
+	REASON_DUPLICATE        = "duplicate"
+	REASON_SAME_DAY         = "same_day"
+	REASON_OTHER_USER       = "other_user"
+	REASON_UNKNOWN_ACTIVITY = "unknown_activity"
+	REASON_INVALID_DATE     = "invalid_date"
+	REASON_INVALID_DISTANCE = "invalid_distance"
+	REASON_INVALID_PACE     = "invalid_pace"
+	// Imported, but waiting for review like a screenshot failing the same checks.
+	REASON_FLAGGED = "flagged"
+)
+
+const (
+	STRAVA_DATE_LAYOUT = "Jan 2, 2006, 3:04:05 PM"
+	GARMIN_DATE_LAYOUT = "2006-01-02 15:04:05"
+
+	STRAVA_ACTIVITIES_FILE = "activities.csv"
+)
+
+// Issue explains why a row was not imported. Rows are numbered like in a spreadsheet,
+// the header being row 1.
+type Issue struct {
+	Row    int
+	Reason string
+	Detail string
+}
+
+// Result counts what happened to the rows of one file.
+type Result struct {
+	Format   string
+	Imported int
+	Skipped  []Issue
+	Failed   []Issue
+	// Flagged rows are imported, but do not count until an admin approves them.
+	Flagged []Issue
+}
+
+// entry is a row converted into a workout, not yet checked against the store.
+type entry struct {
+	row      int
+	date     string
+	distance string
+	pace     string
+	// activity is the type of activity, empty for runs.
+	activity string
+	// details are further workout details, only our own export has them.
+	details map[string]string
+}
+
+// exportDetails maps the optional columns of our own export to workout details.
+var exportDetails = map[string]string{
+	"time":             "TotalTime",
+	"elevation_gain_m": "ElevationGain",
+	"heart_rate":       "HeartRate",
+	"max_heart_rate":   "MaxHeartRate",
+	"source":           "Source",
+	"confidence":       "Confidence",
+	"review":           "Review",
+	"flags":            "Flags",
+	"route":            "Route",
+}
+
+// columns finds the values of a row by header name. Strava repeats some names, e.g.
+// "Distance" once in km and once in meters, the first one wins.
+type columns map[string]int
+
+func (c columns) get(row []string, name string) string {
+	i, ok := c[name]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func (c columns) has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := c[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type Importer struct {
+	DatabaseManager *databasemanager.DatabaseManager
+}
+
+func NewImporter(databaseManager *databasemanager.DatabaseManager) *Importer {
+	return &Importer{
+		DatabaseManager: databaseManager,
+	}
+}
+
+// ImportFile imports an uploaded file, either a CSV or a zip archive as Strava's bulk
+// export, from which activities.csv is read, or else the first CSV in it.
+func (i *Importer) ImportFile(data []byte, groupID int64, userID int64, location *time.Location) (Result, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return i.Import(bytes.NewReader(data), groupID, userID, location)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return Result{}, fmt.Errorf("error opening zip archive: %w", err)
+	}
+
+	var found *zip.File
+	for _, file := range archive.File {
+		name := strings.ToLower(path.Base(file.Name))
+		if name == STRAVA_ACTIVITIES_FILE {
+			found = file
+			break
+		}
+		if found == nil && path.Ext(name) == ".csv" {
+			found = file
+		}
+	}
+	if found == nil {
+		return Result{}, fmt.Errorf("no CSV file in zip archive")
+	}
+
+	file, err := found.Open()
+	if err != nil {
+		return Result{}, fmt.Errorf("error opening %s in zip archive: %w", found.Name, err)
+	}
+	defer file.Close()
+
+	return i.Import(file, groupID, userID, location)
+}
+
+// Import reads a CSV of workouts and logs them for the user in the group. Days that
+// already have a workout are skipped, the store keeps one workout per day. Times in
+// the file that carry no zone are taken as local to location. Workouts failing the
+// plausibility checks of the group are logged flagged, as screenshots are.
+func (i *Importer) Import(r io.Reader, groupID int64, userID int64, location *time.Location) (Result, error) {
+	result, entries, err := parse(r, userID, location)
+	if err != nil {
+		return result, err
+	}
+
+	i.DatabaseManager.Data.Lock()
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if seen[entry.date] {
+			result.Skipped = append(result.Skipped, Issue{Row: entry.row, Reason: REASON_SAME_DAY, Detail: entry.date})
+			continue
+		}
+		seen[entry.date] = true
+
+		if i.DatabaseManager.HasWorkout(groupID, userID, entry.date) {
+			result.Skipped = append(result.Skipped, Issue{Row: entry.row, Reason: REASON_DUPLICATE, Detail: entry.date})
+			continue
+		}
+
+		workoutDetails := map[string]string{
+			"Distance": entry.distance,
+			"Pace":     entry.pace,
+			"Type":     entry.activity,
+		}
+		for key, value := range entry.details {
+			workoutDetails[key] = value
+		}
+		// The route map only comes along when it is still around, as on a restore
+		if route := workoutDetails["Route"]; route != "" && !i.DatabaseManager.HasRoute(route) {
+			delete(workoutDetails, "Route")
+		}
+
+		// Anyone can write a CSV, so an approval in the file does not count, the checks
+		// run again. A workout flagged in an export stays flagged. They run under the
+		// lock, like the insert, so the daily distance cannot change in between.
+		flags := validator.NewValidator(i.DatabaseManager).Check(groupID, userID, entry.date, workoutDetails)
+		if len(flags) > 0 {
+			workoutDetails["Review"] = databasemanager.REVIEW_FLAGGED
+			workoutDetails["Flags"] = strings.Join(flags, ",")
+		} else if workoutDetails["Review"] != databasemanager.REVIEW_FLAGGED {
+			delete(workoutDetails, "Review")
+			delete(workoutDetails, "Flags")
+		}
+
+		i.DatabaseManager.InsertWorkoutEntry(groupID, userID, entry.date, workoutDetails)
+		result.Imported++
+		if workoutDetails["Review"] == databasemanager.REVIEW_FLAGGED {
+			result.Flagged = append(result.Flagged, Issue{Row: entry.row, Reason: REASON_FLAGGED, Detail: workoutDetails["Flags"]})
+		}
+	}
+	if result.Imported > 0 {
+		err = i.DatabaseManager.SaveData()
+	}
+	i.DatabaseManager.Data.Unlock()
+
+	if err != nil {
+		return result, fmt.Errorf("error saving imported workouts: %w", err)
+	}
+
+	log.Info().Msgf("Imported %d workouts for user %d in group %d, flagged %d, skipped %d, failed %d", result.Imported, userID, groupID, len(result.Flagged), len(result.Skipped), len(result.Failed))
+	return result, nil
+}
+
+// parse detects the format of the CSV and converts its rows. Rows that cannot be
+// converted end up in the Skipped or Failed issues of the result.
+func parse(r io.Reader, userID int64, location *time.Location) (Result, []entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return Result{}, nil, fmt.Errorf("error reading CSV header: %w", err)
+	}
+
+	index := make(columns)
+	for i, name := range header {
+		// Spreadsheets often start the file with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))
+		if _, ok := index[name]; !ok {
+			index[name] = i
+		}
+	}
+
+	result := Result{Format: detectFormat(index)}
+	if result.Format == "" {
+		return result, nil, fmt.Errorf("unknown CSV format, header: %v", header)
+	}
+
+	var entries []entry
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, entries, fmt.Errorf("error reading CSV row %d: %w", row, err)
+		}
+
+		var e entry
+		var issue *Issue
+		switch result.Format {
+		case FORMAT_EXPORT:
+			e, issue = exportEntry(index, record, userID)
+		case FORMAT_STRAVA:
+			e, issue = stravaEntry(index, record, location)
+		case FORMAT_GARMIN:
+			e, issue = garminEntry(index, record)
+		}
+
+		if issue != nil {
+			issue.Row = row
+			if issue.Reason == REASON_OTHER_USER || issue.Reason == REASON_UNKNOWN_ACTIVITY {
+				result.Skipped = append(result.Skipped, *issue)
+			} else {
+				result.Failed = append(result.Failed, *issue)
+			}
+			continue
+		}
+
+		e.row = row
+		entries = append(entries, e)
+	}
+
+	return result, entries, nil
+}
+
+func detectFormat(index columns) string {
+	switch {
+	case index.has("activity id", "activity date", "activity type", "distance"):
+		return FORMAT_STRAVA
+	case index.has("activity type", "date", "distance", "time"):
+		return FORMAT_GARMIN
+	case index.has(exporter.CSV_HEADER[3], exporter.CSV_HEADER[4], exporter.CSV_HEADER[5]):
+		return FORMAT_EXPORT
+	}
+	return ""
+}
+
+// exportEntry reads a row of our own /export CSV. Rows of other members, as in a
+// group export, are left out.
+func exportEntry(index columns, record []string, userID int64) (entry, *Issue) {
+	if id := index.get(record, "user_id"); id != "" && id != strconv.FormatInt(userID, 10) {
+		return entry{}, &Issue{Reason: REASON_OTHER_USER, Detail: index.get(record, "name")}
+	}
+
+	date := index.get(record, "date")
+	if _, err := time.Parse(stats.DATE_LAYOUT, date); err != nil {
+		return entry{}, &Issue{Reason: REASON_INVALID_DATE, Detail: date}
+	}
+
+	distance, issue := parseDistance(index.get(record, "distance_km"))
+	if issue != nil {
+		return entry{}, issue
+	}
+
+	pace := index.get(record, "pace")
+	if _, err := stats.ParsePace(pace); err != nil {
+		return entry{}, &Issue{Reason: REASON_INVALID_PACE, Detail: pace}
+	}
+
+	// Exports from before there were types have no type column, those are runs
+	activity, _ := stats.ParseActivityType(index.get(record, "type"))
+
+	details := make(map[string]string)
+	for column, key := range exportDetails {
+		if value := index.get(record, column); value != "" {
+			details[key] = value
+		}
+	}
+
+	return entry{date: date, distance: distance, pace: pace, activity: activity, details: details}, nil
+}
+
+// stravaEntry reads a row of activities.csv from a Strava bulk export. Dates are in
+// UTC, distances in km and times in seconds.
+func stravaEntry(index columns, record []string, location *time.Location) (entry, *Issue) {
+	kind := index.get(record, "activity type")
+	activity, ok := activityOf(kind)
+	if !ok {
+		return entry{}, &Issue{Reason: REASON_UNKNOWN_ACTIVITY, Detail: kind}
+	}
+
+	value := index.get(record, "activity date")
+	date, err := time.Parse(STRAVA_DATE_LAYOUT, value)
+	if err != nil {
+		return entry{}, &Issue{Reason: REASON_INVALID_DATE, Detail: value}
+	}
+
+	distance, issue := parseDistance(index.get(record, "distance"))
+	if issue != nil {
+		return entry{}, issue
+	}
+
+	seconds := index.get(record, "moving time")
+	if seconds == "" {
+		seconds = index.get(record, "elapsed time")
+	}
+	duration, err := strconv.ParseFloat(seconds, 64)
+	if err != nil || duration <= 0 {
+		return entry{}, &Issue{Reason: REASON_INVALID_PACE, Detail: seconds}
+	}
+
+	return entry{
+		date:     date.In(location).Format(stats.DATE_LAYOUT),
+		distance: distance,
+		pace:     paceOf(time.Duration(duration*float64(time.Second)), distance),
+		activity: activity,
+	}, nil
+}
+
+// garminEntry reads a row of Activities.csv from Garmin Connect. Dates are local
+// already, times look like 00:27:33 and paces like 5:30.
+func garminEntry(index columns, record []string) (entry, *Issue) {
+	kind := index.get(record, "activity type")
+	activity, ok := activityOf(kind)
+	if !ok {
+		return entry{}, &Issue{Reason: REASON_UNKNOWN_ACTIVITY, Detail: kind}
+	}
+
+	value := index.get(record, "date")
+	date, err := time.Parse(GARMIN_DATE_LAYOUT, value)
+	if err != nil {
+		return entry{}, &Issue{Reason: REASON_INVALID_DATE, Detail: value}
+	}
+
+	distance, issue := parseDistance(index.get(record, "distance"))
+	if issue != nil {
+		return entry{}, issue
+	}
+
+	if pace, err := stats.ParsePace(index.get(record, "avg pace")); err == nil {
+		return entry{date: date.Format(stats.DATE_LAYOUT), distance: distance, pace: stats.FormatPace(pace), activity: activity}, nil
+	}
+
+	value = index.get(record, "time")
+	duration, err := parseClock(value)
+	if err != nil {
+		return entry{}, &Issue{Reason: REASON_INVALID_PACE, Detail: value}
+	}
+
+	return entry{date: date.Format(stats.DATE_LAYOUT), distance: distance, pace: paceOf(duration, distance), activity: activity}, nil
+}
+
+// activityOf reads the type of activity of a Strava or Garmin row off its last word
+// naming one, e.g. "Trail Run", "Treadmill Running" or "Virtual Ride". Runs are empty,
+// like in exports. Types no word names, like "Weight Training", are not logged.
+func activityOf(kind string) (string, bool) {
+	words := strings.FieldsFunc(kind, func(r rune) bool { return !unicode.IsLetter(r) })
+	for i := len(words) - 1; i >= 0; i-- {
+		if activity, ok := stats.ParseActivityType(words[i]); ok {
+			if activity == databasemanager.ACTIVITY_RUN {
+				return "", true
+			}
+			return activity, true
+		}
+	}
+	return "", false
+}
+
+// Distances written with a decimal comma, as 5,02, or with thousands separators, as 1,234.5.
+var (
+	decimalCommaRegex = regexp.MustCompile(`^\d+,\d{1,2}$`)
+	thousandsRegex    = regexp.MustCompile(`^\d{1,3}(,\d{3})+(\.\d+)?$`)
+)
+
+// parseDistance checks a distance in km and formats it like the rest of the store. A
+// single comma before one or two digits is the decimal separator, commas between groups
+// of three digits separate thousands.
+func parseDistance(value string) (string, *Issue) {
+	number := value
+	switch {
+	case decimalCommaRegex.MatchString(value):
+		number = strings.Replace(value, ",", ".", 1)
+	case thousandsRegex.MatchString(value):
+		number = strings.ReplaceAll(value, ",", "")
+	}
+	distance, err := strconv.ParseFloat(number, 64)
+	if err != nil || distance <= 0 {
+		return "", &Issue{Reason: REASON_INVALID_DISTANCE, Detail: value}
+	}
+	return strconv.FormatFloat(distance, 'f', 2, 64), nil
+}
+
+func paceOf(duration time.Duration, distance string) string {
+	km, _ := strconv.ParseFloat(distance, 64)
+	return stats.FormatPace(time.Duration(float64(duration) / km))
+}
+
+// parseClock reads h:mm:ss or mm:ss, with optional fractions of a second.
+func parseClock(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time: %q", value)
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time: %q", value)
+		}
+		seconds = seconds*60 + n
+	}
+	if seconds <= 0 {
+		return 0, fmt.Errorf("invalid time: %q", value)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
@@ -0,0 +1,305 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"run-tracker-telebot/src/pkg/config"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"run-tracker-telebot/src/pkg/exporter"
+	"run-tracker-telebot/src/pkg/stats"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_GROUP = int64(-100)
+	TEST_USER  = int64(42)
+)
+
+// newStore returns an empty store in a temporary directory.
+func newStore(t *testing.T) *databasemanager.DatabaseManager {
+	t.Helper()
+
+	storage := config.Default().Storage
+	storage.DataDir = t.TempDir()
+
+	// LoadData cannot read empty files
+	db := databasemanager.NewDatabaseManager(storage)
+	for _, path := range []string{db.FilePath, db.UserFilePath} {
+		if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	if err := db.LoadData(); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	if err := db.LoadUserData(); err != nil {
+		t.Fatalf("LoadUserData: %v", err)
+	}
+	return db
+}
+
+// insert logs a workout of TEST_USER in TEST_GROUP.
+func insert(t *testing.T, db *databasemanager.DatabaseManager, date string, details map[string]string) {
+	t.Helper()
+
+	db.Data.Lock()
+	defer db.Data.Unlock()
+
+	if !db.InsertWorkoutEntry(TEST_GROUP, TEST_USER, date, details) {
+		t.Fatalf("InsertWorkoutEntry(%s, %v) failed", date, details)
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	source := newStore(t)
+	route, err := source.SaveRoute(TEST_GROUP, TEST_USER, "2024-05-02", []byte("png"))
+	if err != nil {
+		t.Fatalf("SaveRoute: %v", err)
+	}
+	insert(t, source, "2024-05-01", map[string]string{
+		"Distance": "5.02", "Pace": "6'00\"/km", "Source": databasemanager.SOURCE_SCREENSHOT,
+		"Confidence": "0.57", "Review": databasemanager.REVIEW_FLAGGED, "Flags": "pace,distance_jump",
+	})
+	insert(t, source, "2024-05-02", map[string]string{
+		"Distance": "10.00", "Pace": "5'30\"/km", "TotalTime": "55:00", "ElevationGain": "120",
+		"HeartRate": "150", "MaxHeartRate": "172", "Source": "gpx", "Confidence": "0.95",
+		"Type": databasemanager.ACTIVITY_WALK, "Route": route,
+	})
+	insert(t, source, "2024-05-03", map[string]string{"Distance": "3.00", "Pace": "6:00"})
+
+	records, err := exporter.NewExporter(source).Records(TEST_GROUP, TEST_USER, stats.Period{Name: stats.PERIOD_ALL, End: time.Now()})
+	if err != nil {
+		t.Fatalf("Records: %v", err)
+	}
+	var csv bytes.Buffer
+	if err := exporter.Write(&csv, exporter.FORMAT_CSV, records); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// A restore into a fresh store that still has the route maps
+	target := newStore(t)
+	target.RoutesDir = source.RoutesDir
+	result, err := NewImporter(target).Import(&csv, TEST_GROUP, TEST_USER, time.UTC)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if result.Format != FORMAT_EXPORT || result.Imported != 3 || len(result.Skipped)+len(result.Failed) > 0 {
+		t.Fatalf("Import() = %+v, want 3 workouts imported from an export", result)
+	}
+
+	want, _ := source.GetUserWorkouts(TEST_GROUP, TEST_USER)
+	got, _ := target.GetUserWorkouts(TEST_GROUP, TEST_USER)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported workouts differ from the exported ones:\n got %+v\nwant %+v", got, want)
+	}
+
+	// Without the route maps the workouts come back without a route
+	other := newStore(t)
+	csv.Reset()
+	if err := exporter.Write(&csv, exporter.FORMAT_CSV, records); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := NewImporter(other).Import(&csv, TEST_GROUP, TEST_USER, time.UTC); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	workouts, _ := other.GetUserWorkouts(TEST_GROUP, TEST_USER)
+	if workouts["2024-05-02"].Route != "" || filepath.Base(want["2024-05-02"].Route) != route {
+		t.Errorf("route = %q, want none without the route map", workouts["2024-05-02"].Route)
+	}
+}
+
+// logged is what a test expects in the store for a day.
+type logged struct {
+	distance string
+	pace     string
+	activity string
+	flags    string
+}
+
+// rows lists the row numbers and reasons of issues, ordered by row.
+func rows(issues []Issue) []string {
+	var rows []string
+	for _, issue := range issues {
+		rows = append(rows, fmt.Sprintf("%d %s", issue.Row, issue.Reason))
+	}
+	sort.Strings(rows)
+	return rows
+}
+
+func TestImport(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+
+	tests := []struct {
+		file     string
+		location *time.Location
+		// existing are days the user logged a workout on before the import.
+		existing []string
+		format   string
+		want     map[string]logged
+		skipped  []string
+		failed   []string
+		flagged  []string
+	}{
+		{
+			file:     "export.csv",
+			location: time.UTC,
+			existing: []string{"2024-05-02"},
+			format:   FORMAT_EXPORT,
+			want: map[string]logged{
+				"2024-05-01": {distance: "5.02", pace: "6'00\"/km"},
+				// The approval in the file does not count
+				"2024-05-03": {distance: "50.00", pace: "2'00\"/km", flags: "too_fast"},
+				"2024-05-04": {distance: "4.00", pace: "9'30\"/km", activity: databasemanager.ACTIVITY_WALK},
+			},
+			skipped: []string{"3 other_user", "4 duplicate", "7 same_day"},
+			failed:  []string{"10 invalid_pace", "8 invalid_distance", "9 invalid_date"},
+			flagged: []string{"5 flagged"},
+		},
+		{
+			file:     "strava.csv",
+			location: berlin,
+			format:   FORMAT_STRAVA,
+			want: map[string]logged{
+				"2024-05-01": {distance: "5.02", pace: "6'00\"/km"},
+				// 22:30 UTC is past midnight in Berlin, and 10,5 has a decimal comma
+				"2024-05-03": {distance: "10.50", pace: "6'00\"/km"},
+				// Rides are logged as rides, and paces of rides are no reason to flag them
+				"2024-05-04": {distance: "20.50", pace: "2'51\"/km", activity: databasemanager.ACTIVITY_CYCLE},
+				"2024-05-06": {distance: "50.00", pace: "2'00\"/km", flags: "too_fast"},
+			},
+			skipped: []string{"3 same_day", "9 unknown_activity"},
+			failed:  []string{"6 invalid_distance", "8 invalid_date"},
+			flagged: []string{"7 flagged"},
+		},
+		{
+			file:     "garmin.csv",
+			location: time.UTC,
+			format:   FORMAT_GARMIN,
+			want: map[string]logged{
+				"2024-05-01": {distance: "5.02", pace: "6'00\"/km"},
+				"2024-05-02": {distance: "10.00", pace: "5'30\"/km"},
+				// 1,234.50 has a thousands separator, and is no run anyone did
+				"2024-05-03": {distance: "1234.50", pace: "0'29\"/km", flags: "too_fast,too_long,daily_distance"},
+				"2024-05-04": {distance: "42.20", pace: "2'08\"/km", activity: databasemanager.ACTIVITY_CYCLE},
+			},
+			skipped: []string{"8 unknown_activity"},
+			failed:  []string{"6 invalid_date", "7 invalid_pace"},
+			flagged: []string{"5 flagged"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			db := newStore(t)
+			for _, date := range test.existing {
+				insert(t, db, date, map[string]string{"Distance": "1.00", "Pace": "6:00"})
+			}
+
+			data, err := os.ReadFile(filepath.Join("testdata", test.file))
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			result, err := NewImporter(db).ImportFile(data, TEST_GROUP, TEST_USER, test.location)
+			if err != nil {
+				t.Fatalf("ImportFile: %v", err)
+			}
+
+			if result.Format != test.format || result.Imported != len(test.want) {
+				t.Errorf("imported %d workouts as %q, want %d as %q", result.Imported, result.Format, len(test.want), test.format)
+			}
+			if got := rows(result.Skipped); !reflect.DeepEqual(got, test.skipped) {
+				t.Errorf("skipped %v, want %v", got, test.skipped)
+			}
+			if got := rows(result.Failed); !reflect.DeepEqual(got, test.failed) {
+				t.Errorf("failed %v, want %v", got, test.failed)
+			}
+			if got := rows(result.Flagged); !reflect.DeepEqual(got, test.flagged) {
+				t.Errorf("flagged %v, want %v", got, test.flagged)
+			}
+
+			workouts, _ := db.GetUserWorkouts(TEST_GROUP, TEST_USER)
+			for date, want := range test.want {
+				entry, ok := workouts[date]
+				got := logged{entry.Distance, entry.Pace, entry.Type, strings.Join(entry.Flags, ",")}
+				if !ok || got != want {
+					t.Errorf("%s: got %+v, want %+v", date, got, want)
+				}
+				if flagged := entry.Review == databasemanager.REVIEW_FLAGGED; flagged != (want.flags != "") {
+					t.Errorf("%s: review %q, want flagged %v", date, entry.Review, want.flags != "")
+				}
+			}
+			if len(workouts) != len(test.want)+len(test.existing) {
+				t.Errorf("%d workouts in the store, want %d", len(workouts), len(test.want)+len(test.existing))
+			}
+		})
+	}
+}
+
+func TestActivityOf(t *testing.T) {
+	tests := map[string]string{
+		"Run":                 "",
+		"Trail Run":           "",
+		"Treadmill Running":   "",
+		"Virtual Ride":        databasemanager.ACTIVITY_CYCLE,
+		"E-Bike Ride":         databasemanager.ACTIVITY_CYCLE,
+		"Mountain Biking":     databasemanager.ACTIVITY_CYCLE,
+		"Open Water Swimming": databasemanager.ACTIVITY_SWIM,
+		"Hiking":              databasemanager.ACTIVITY_HIKE,
+		"Walk":                databasemanager.ACTIVITY_WALK,
+	}
+	for kind, want := range tests {
+		if got, ok := activityOf(kind); !ok || got != want {
+			t.Errorf("activityOf(%q) = %q, %v, want %q", kind, got, ok, want)
+		}
+	}
+
+	for _, kind := range []string{"Weight Training", "Yoga", "Rowing", ""} {
+		if got, ok := activityOf(kind); ok {
+			t.Errorf("activityOf(%q) = %q, want no type of activity", kind, got)
+		}
+	}
+}
+
+func TestImportUnknownFormat(t *testing.T) {
+	_, err := NewImporter(newStore(t)).Import(strings.NewReader("when,how far\n2024-05-01,5\n"), TEST_GROUP, TEST_USER, time.UTC)
+	if err == nil {
+		t.Errorf("Import() of an unknown CSV succeeded, want error")
+	}
+}
+
+func TestParseDistance(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"5.02", "5.02"},
+		{"5,02", "5.02"},
+		{"5,2", "5.20"},
+		{"10", "10.00"},
+		{"1,234.5", "1234.50"},
+		{"1,234", "1234.00"},
+		{"12,345,678", "12345678.00"},
+	}
+
+	for _, test := range tests {
+		got, issue := parseDistance(test.input)
+		if issue != nil || got != test.want {
+			t.Errorf("parseDistance(%q) = %q, %v, want %q", test.input, got, issue, test.want)
+		}
+	}
+
+	for _, input := range []string{"", "far", "0", "-5", "5,02,1"} {
+		if got, issue := parseDistance(input); issue == nil || issue.Reason != REASON_INVALID_DISTANCE {
+			t.Errorf("parseDistance(%q) = %q, %v, want an invalid distance", input, got, issue)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package localizer
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"run-tracker-telebot/src/log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_LOCALE is used when neither the user, the group nor Telegram tell us better,
+// and for keys missing from another catalog.
+const DEFAULT_LOCALE = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Format describes how numbers and dates are written in a locale. Date and Month are
+// Go time layouts.
+type Format struct {
+	Decimal string `json:"decimal"`
+	Group   string `json:"group"`
+	Date    string `json:"date"`
+	Month   string `json:"month"`
+}
+
+// Catalog is the content of a locale file, e.g. locales/en.json.
+type Catalog struct {
+	Name     string            `json:"name"`
+	Format   Format            `json:"format"`
+	Messages map[string]string `json:"messages"`
+}
+
+type Localizer struct {
+	catalogs map[string]*Catalog
+}
+
+// NewLocalizer loads every embedded locale file, the locale is the file name without extension.
+func NewLocalizer() *Localizer {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic("failed to read locales: " + err.Error())
+	}
+
+	catalogs := make(map[string]*Catalog)
+	for _, entry := range entries {
+		content, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic("failed to read locale " + entry.Name() + ": " + err.Error())
+		}
+
+		var catalog Catalog
+		if err := json.Unmarshal(content, &catalog); err != nil {
+			panic("failed to parse locale " + entry.Name() + ": " + err.Error())
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))] = &catalog
+	}
+
+	if _, ok := catalogs[DEFAULT_LOCALE]; !ok {
+		panic("missing default locale " + DEFAULT_LOCALE)
+	}
+
+	return &Localizer{catalogs: catalogs}
+}
+
+// Locales returns the supported locales, sorted.
+func (l *Localizer) Locales() []string {
+	locales := make([]string, 0, len(l.catalogs))
+	for locale := range l.catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+func (l *Localizer) Supported(locale string) bool {
+	_, ok := l.catalogs[locale]
+	return ok
+}
+
+// Name returns the name of the locale in its own language, e.g. "Deutsch" for "de".
+func (l *Localizer) Name(locale string) string {
+	return l.catalog(locale).Name
+}
+
+// Match maps a Telegram language_code such as "de-AT" or "zh-hans" to a supported locale.
+func (l *Localizer) Match(languageCode string) string {
+	code := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(languageCode), "_", "-"))
+	if l.Supported(code) {
+		return code
+	}
+
+	if base, _, found := strings.Cut(code, "-"); found && l.Supported(base) {
+		return base
+	}
+
+	return DEFAULT_LOCALE
+}
+
+// T translates key, formatting args into the message like fmt.Sprintf. Keys missing
+// from the locale fall back to the default locale, and to the key itself as last resort.
+func (l *Localizer) T(locale string, key string, args ...interface{}) string {
+	message, ok := l.catalog(locale).Messages[key]
+	if !ok {
+		message, ok = l.catalogs[DEFAULT_LOCALE].Messages[key]
+	}
+	if !ok {
+		log.Warn().Msgf("Missing translation for key %s in locale %s", key, locale)
+		message = key
+	}
+
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// FormatNumber writes value with the given number of decimals, using the decimal and
+// digit group separators of the locale.
+func (l *Localizer) FormatNumber(locale string, value float64, precision int) string {
+	format := l.catalog(locale).Format
+
+	sign := ""
+	if value < 0 && math.Round(value*math.Pow10(precision)) != 0 {
+		sign = "-"
+	}
+
+	digits := strconv.FormatFloat(math.Abs(value), 'f', precision, 64)
+	integer, fraction, _ := strings.Cut(digits, ".")
+
+	var grouped strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped.WriteString(format.Group)
+		}
+		grouped.WriteRune(digit)
+	}
+
+	if fraction == "" {
+		return sign + grouped.String()
+	}
+	return sign + grouped.String() + format.Decimal + fraction
+}
+
+func (l *Localizer) FormatDate(locale string, date time.Time) string {
+	return date.Format(l.catalog(locale).Format.Date)
+}
+
+// FormatMonth writes the month and year of date, month names are taken from the catalog.
+func (l *Localizer) FormatMonth(locale string, date time.Time) string {
+	layout := l.catalog(locale).Format.Month
+	month := l.T(locale, fmt.Sprintf("month.%d", int(date.Month())))
+	return strings.ReplaceAll(date.Format(strings.ReplaceAll(layout, "January", "\x00")), "\x00", month)
+}
+
+func (l *Localizer) catalog(locale string) *Catalog {
+	if catalog, ok := l.catalogs[locale]; ok {
+		return catalog
+	}
+	return l.catalogs[DEFAULT_LOCALE]
+}
@@ -0,0 +1,468 @@
+package messagerenderer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"run-tracker-telebot/src/pkg/localizer"
+	"run-tracker-telebot/src/pkg/stats"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// PARSE_MODE is the Telegram parse mode every rendered message is meant for.
+	PARSE_MODE = "HTML"
+
+	// Telegram limits, counted in characters after entities parsing. We count the raw
+	// text, which is always at least as long, so chunks stay under the limit.
+	MESSAGE_LIMIT = 4096
+	CAPTION_LIMIT = 1024
+
+	// TRUNCATED ends a message cut short where it cannot go on in another one, as an edit.
+	TRUNCATED = "\n…"
+)
+
+// Template names, one per kind of reply.
+const (
+	TEMPLATE_TEXT           = "text"
+	TEMPLATE_ERROR          = "error"
+	TEMPLATE_HELP           = "help"
+	TEMPLATE_WELCOME        = "welcome"
+	TEMPLATE_WORKOUT_LOGGED = "workout_logged"
+	TEMPLATE_TOTALS         = "totals"
+	TEMPLATE_HISTORY        = "history"
+	TEMPLATE_STATS          = "stats"
+	TEMPLATE_IMPORT_REPORT  = "import_report"
+	TEMPLATE_DUPLICATES     = "duplicates"
+	TEMPLATE_REVIEW         = "review"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// MessageRenderer keeps a copy of the templates per locale, each with its own
+// translation and formatting functions.
+type MessageRenderer struct {
+	Localizer *localizer.Localizer
+	templates map[string]*template.Template
+}
+
+// Workout is how a single workout is shown everywhere: when logged, in history, etc.
+type Workout struct {
+	Date     string
+	Name     string
+	Type     string
+	Distance string
+	Pace     string
+	// Only known for some workouts, e.g. those from activity files.
+	Time          string
+	ElevationGain string
+	HeartRate     string
+	// HasRoute marks workouts with a route map, from an activity file with GPS.
+	HasRoute bool
+	// Flagged marks workouts waiting for review, Flags describe the checks they failed.
+	Flagged bool
+	Flags   []string
+}
+
+type Total struct {
+	Name     string
+	Distance string
+}
+
+type Totals struct {
+	Title string
+	Rows  []Total
+}
+
+type History struct {
+	Title    string
+	Page     int
+	Pages    int
+	Workouts []Workout
+}
+
+type Stats struct {
+	Name string
+	// Activity is the type of the workouts summed up, empty for all of them.
+	Activity   string
+	Comparison stats.Comparison
+}
+
+// ImportReport sums up an /import, listing the first failed, flagged or skipped rows.
+type ImportReport struct {
+	Source   string
+	Imported int
+	Skipped  int
+	Failed   int
+	Issues   []ImportIssue
+	More     int
+}
+
+type ImportIssue struct {
+	Row    int
+	Reason string
+}
+
+// Duplicates lists the workouts an admin scan found logged twice.
+type Duplicates struct {
+	Pairs []DuplicatePair
+}
+
+type DuplicatePair struct {
+	Name   string
+	First  Workout
+	Second Workout
+	// SameImage is set when the screenshots match, otherwise distance and time do.
+	SameImage  bool
+	OtherGroup bool
+}
+
+// Review shows admins a flagged workout, Left counts the workouts in the review queue.
+type Review struct {
+	Left    int
+	Workout Workout
+}
+
+func NewMessageRenderer(loc *localizer.Localizer) *MessageRenderer {
+	mr := &MessageRenderer{
+		Localizer: loc,
+		templates: make(map[string]*template.Template),
+	}
+
+	// The locale functions are only placeholders here, they are replaced in every
+	// clone, which has to happen before any template is executed.
+	base := template.Must(template.New("").Funcs(mr.funcs(localizer.DEFAULT_LOCALE)).ParseFS(templateFiles, "templates/*.tmpl"))
+	for _, locale := range loc.Locales() {
+		mr.templates[locale] = template.Must(base.Clone()).Funcs(mr.funcs(locale))
+	}
+
+	return mr
+}
+
+func (mr *MessageRenderer) funcs(locale string) template.FuncMap {
+	number := func(value float64) string { return mr.Localizer.FormatNumber(locale, value, 2) }
+
+	return template.FuncMap{
+		"t": func(key string, args ...interface{}) template.HTML {
+			return mr.Translate(locale, key, args...)
+		},
+		"km":       number,
+		"distance": func(distance string) string { return formatDistance(distance, number) },
+		"pace":     stats.FormatPace,
+		"duration": stats.FormatDuration,
+		"date":     func(date time.Time) string { return mr.Localizer.FormatDate(locale, date) },
+		"day": func(date string) string {
+			parsed, err := time.Parse(stats.DATE_LAYOUT, date)
+			if err != nil {
+				return date
+			}
+			return mr.Localizer.FormatDate(locale, parsed)
+		},
+		"period":  func(period stats.Period) string { return mr.PeriodLabel(locale, period) },
+		"weekday": func(i int) string { return mr.Localizer.T(locale, fmt.Sprintf("weekday.%d", i)) },
+		"change": func(current float64, previous float64, unit string) string {
+			return mr.formatChange(locale, current, previous, unit)
+		},
+		"paceDiff": func(current time.Duration, previous time.Duration) string {
+			return mr.formatPaceChange(locale, current, previous)
+		},
+		"effort": func(activity string, pace interface{}) string {
+			return formatEffort(activity, pace, func(value float64) string { return mr.Localizer.FormatNumber(locale, value, 1) })
+		},
+		"speed": func(activity string) bool { return stats.UnitOf(activity) == stats.UNIT_SPEED },
+		"icon":  func(activity string) string { return activityIcons[activity] },
+		"inc":   func(i int) int { return i + 1 },
+		"float": func(i int) float64 { return float64(i) },
+	}
+}
+
+// Render executes the named template in the given locale, unsupported locales use the
+// default one. Every string in data is HTML escaped.
+func (mr *MessageRenderer) Render(locale string, name string, data interface{}) (string, error) {
+	templates, ok := mr.templates[locale]
+	if !ok {
+		templates = mr.templates[localizer.DEFAULT_LOCALE]
+	}
+
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Warn().Msgf("Error rendering template %s: %v", name, err)
+		return "", fmt.Errorf("error rendering template %s: %w", name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Translate looks up a catalog message for use in a template. Catalog messages are
+// trusted HTML, string arguments are escaped before they are formatted into it.
+func (mr *MessageRenderer) Translate(locale string, key string, args ...interface{}) template.HTML {
+	escaped := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch value := arg.(type) {
+		case string:
+			escaped[i] = Escape(value)
+		case fmt.Stringer:
+			escaped[i] = Escape(value.String())
+		default:
+			escaped[i] = arg
+		}
+	}
+	return template.HTML(mr.Localizer.T(locale, key, escaped...))
+}
+
+// PeriodLabel writes a period as a localized date range, or "all time".
+func (mr *MessageRenderer) PeriodLabel(locale string, period stats.Period) string {
+	if period.Name == stats.PERIOD_ALL {
+		return mr.Localizer.T(locale, "period.all")
+	}
+
+	if period.Name == stats.PERIOD_MONTH {
+		return mr.Localizer.FormatMonth(locale, period.Start)
+	}
+
+	start := mr.Localizer.FormatDate(locale, period.Start)
+	end := mr.Localizer.FormatDate(locale, period.End.AddDate(0, 0, -1))
+	if start == end {
+		return start
+	}
+	return start + " - " + end
+}
+
+// Escape escapes user supplied text for the HTML parse mode.
+func Escape(text string) string {
+	return html.EscapeString(text)
+}
+
+// EscapeMarkdownV2 escapes user supplied text for the MarkdownV2 parse mode.
+func EscapeMarkdownV2(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune("_*[]()~`>#+-=|{}.!\\", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Split cuts HTML text into chunks of at most limit characters, preferring line
+// boundaries, then spaces, and only cutting through a word as a last resort. Tags and
+// entities are never cut, tags open at a cut are closed and opened again in the next
+// chunk so that Telegram accepts each of them.
+func Split(text string, limit int) []string {
+	var chunks []string
+	tokens := tokenize(text)
+	// open are the tags in effect where the next chunk starts, outermost first
+	var open []string
+
+	for start := 0; start < len(tokens); {
+		// A chunk does not start with the blanks it was cut at
+		for start > 0 && start < len(tokens) && (tokens[start] == " " || tokens[start] == "\n") {
+			start++
+		}
+		if start == len(tokens) {
+			break
+		}
+
+		prefix := strings.Join(open, "")
+		size := utf8.RuneCountInString(prefix)
+		stack := open
+
+		// Take tokens while they and the closing tags fit, remembering the last line
+		// and space boundary to cut at
+		end := start
+		lineEnd, spaceEnd := -1, -1
+		var lineStack, spaceStack []string
+		for end < len(tokens) {
+			next := applyTag(stack, tokens[end])
+			tokenSize := utf8.RuneCountInString(tokens[end])
+			if size+tokenSize+closersLength(next) > limit {
+				break
+			}
+			size += tokenSize
+			stack = next
+			end++
+			switch tokens[end-1] {
+			case "\n":
+				lineEnd, lineStack = end, stack
+			case " ":
+				spaceEnd, spaceStack = end, stack
+			}
+		}
+
+		cut, cutStack := end, stack
+		switch {
+		case end == len(tokens):
+		case lineEnd > start:
+			cut, cutStack = lineEnd, lineStack
+		case spaceEnd > start:
+			cut, cutStack = spaceEnd, spaceStack
+		case end == start:
+			// A single token longer than the limit, it goes out as it is
+			cut, cutStack = start+1, applyTag(open, tokens[start])
+		}
+
+		body := strings.TrimRight(strings.Join(tokens[start:cut], ""), " \n")
+		if hasText(tokens[start:cut]) {
+			chunks = append(chunks, prefix+body+closers(cutStack))
+		}
+		open = cutStack
+		start = cut
+	}
+
+	if len(chunks) == 0 {
+		return []string{""}
+	}
+	return chunks
+}
+
+var (
+	tagRegex    = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9-]*)[^<>]*>`)
+	entityRegex = regexp.MustCompile(`^&(#[0-9]+|#x[0-9a-fA-F]+|[a-zA-Z]+);`)
+)
+
+// tokenize splits HTML text into tags, entities and single runes of text.
+func tokenize(text string) []string {
+	var tokens []string
+	for len(text) > 0 {
+		size := 0
+		switch text[0] {
+		case '<':
+			size = len(tagRegex.FindString(text))
+		case '&':
+			size = len(entityRegex.FindString(text))
+		}
+		if size == 0 {
+			_, size = utf8.DecodeRuneInString(text)
+		}
+		tokens = append(tokens, text[:size])
+		text = text[size:]
+	}
+	return tokens
+}
+
+// hasText tells whether tokens show anything, Telegram rejects messages of only tags.
+func hasText(tokens []string) bool {
+	for _, token := range tokens {
+		if token != " " && token != "\n" && !tagRegex.MatchString(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyTag returns the open tags after token, a new slice when it opens or closes one.
+func applyTag(open []string, token string) []string {
+	if token[0] != '<' {
+		return open
+	}
+	match := tagRegex.FindStringSubmatch(token)
+	if match == nil {
+		return open
+	}
+	if !strings.HasPrefix(token, "</") {
+		return append(open[:len(open):len(open)], token)
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		if tagRegex.FindStringSubmatch(open[i])[1] == match[1] {
+			return append(open[:i:i], open[i+1:]...)
+		}
+	}
+	return open
+}
+
+// closers closes the open tags, innermost first.
+func closers(open []string) string {
+	var b strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + tagRegex.FindStringSubmatch(open[i])[1] + ">")
+	}
+	return b.String()
+}
+
+func closersLength(open []string) int {
+	length := 0
+	for _, tag := range open {
+		length += len(tagRegex.FindStringSubmatch(tag)[1]) + 3
+	}
+	return length
+}
+
+// formatDistance formats a stored distance such as "5.02", leaving it as is when it
+// is not a number.
+func formatDistance(distance string, number func(float64) string) string {
+	value, err := strconv.ParseFloat(strings.TrimSpace(distance), 64)
+	if err != nil {
+		return distance
+	}
+	return number(value)
+}
+
+// activityIcons mark workouts that are not runs.
+var activityIcons = map[string]string{
+	databasemanager.ACTIVITY_WALK:  "🚶",
+	databasemanager.ACTIVITY_CYCLE: "🚴",
+	databasemanager.ACTIVITY_SWIM:  "🏊",
+	databasemanager.ACTIVITY_HIKE:  "🥾",
+}
+
+// formatEffort writes a pace in the unit of the activity type, a stored pace such as
+// "5:30" or a summed up time.Duration. Stored paces of runs and those that do not
+// parse are left as they are, speeds are written with number.
+func formatEffort(activity string, pace interface{}, number func(float64) string) string {
+	var parsed time.Duration
+	switch value := pace.(type) {
+	case time.Duration:
+		parsed = value
+	case string:
+		var err error
+		parsed, err = stats.ParsePace(value)
+		if err != nil || stats.UnitOf(activity) == stats.UNIT_PACE {
+			return value
+		}
+	default:
+		return fmt.Sprint(pace)
+	}
+
+	if stats.UnitOf(activity) == stats.UNIT_SPEED && parsed > 0 {
+		return number(float64(time.Hour)/float64(parsed)) + " km/h"
+	}
+	return stats.FormatEffort(activity, parsed)
+}
+
+func (mr *MessageRenderer) formatChange(locale string, current float64, previous float64, unit string) string {
+	precision := 2
+	if unit == "" {
+		precision = 0
+	}
+
+	signed := func(value float64, precision int) string {
+		formatted := mr.Localizer.FormatNumber(locale, value, precision)
+		if !strings.HasPrefix(formatted, "-") {
+			formatted = "+" + formatted
+		}
+		return formatted
+	}
+
+	change := signed(current-previous, precision) + unit
+	if previous > 0 {
+		change += fmt.Sprintf(" (%s%%)", signed((current-previous)/previous*100, 1))
+	}
+	return change
+}
+
+func (mr *MessageRenderer) formatPaceChange(locale string, current time.Duration, previous time.Duration) string {
+	diff := current - previous
+	if diff < 0 {
+		return mr.Localizer.T(locale, "stats.faster", (-diff).Round(time.Second))
+	}
+	return mr.Localizer.T(locale, "stats.slower", diff.Round(time.Second))
+}
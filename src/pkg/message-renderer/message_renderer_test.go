@@ -0,0 +1,188 @@
+package messagerenderer
+
+import (
+	"run-tracker-telebot/src/pkg/localizer"
+	"run-tracker-telebot/src/pkg/stats"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestEscape(t *testing.T) {
+	tests := map[string]string{
+		"Alice":              "Alice",
+		"<b>Bob</b>":         "&lt;b&gt;Bob&lt;/b&gt;",
+		"Tom & Jerry":        "Tom &amp; Jerry",
+		`"Quoted" 'names'`:   "&#34;Quoted&#34; &#39;names&#39;",
+		"Zoë 跑步 🏃":           "Zoë 跑步 🏃",
+		"&amp; stays quoted": "&amp;amp; stays quoted",
+	}
+	for text, want := range tests {
+		if got := Escape(text); got != want {
+			t.Errorf("Escape(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	tests := map[string]string{
+		"Alice":             "Alice",
+		"5.02 km":           `5\.02 km`,
+		"_*[]()~`>#+-=|{}!": "\\_\\*\\[\\]\\(\\)\\~\\`\\>\\#\\+\\-\\=\\|\\{\\}\\!",
+		`back\slash`:        `back\\slash`,
+		"跑步 5:30":           "跑步 5:30",
+	}
+	for text, want := range tests {
+		if got := EscapeMarkdownV2(text); got != want {
+			t.Errorf("EscapeMarkdownV2(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"fits", "one\ntwo", 10, []string{"one\ntwo"}},
+		{"empty", "", 10, []string{""}},
+		{"lines", "one\ntwo\nthree", 8, []string{"one\ntwo", "three"}},
+		{"spaces", "one two three", 8, []string{"one two", "three"}},
+		{"words", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"runes", "跑步跑步跑步", 4, []string{"跑步跑步", "跑步"}},
+		{"blank lines", "one\n\n\ntwo", 4, []string{"one", "two"}},
+		// Tags open at a cut are closed and opened again
+		{"tag across lines", "<b>one\ntwo</b>", 12, []string{"<b>one</b>", "<b>two</b>"}},
+		{"tag across words", "<i>one two</i> three", 12, []string{"<i>one</i>", "<i>two</i>", "three"}},
+		{"nested", `<a href="x"><b>aa bb</b></a>`, 26, []string{`<a href="x"><b>aa</b></a>`, `<a href="x"><b>bb</b></a>`}},
+		{"closed tag", "<b>a</b> bb cc", 8, []string{"<b>a</b>", "bb cc"}},
+		// Entities are never cut
+		{"entity", "a &amp; b", 4, []string{"a", "&amp;", "b"}},
+		{"entity in word", "ab&lt;cd", 4, []string{"ab", "&lt;", "cd"}},
+		{"not a tag", "1 < 2", 10, []string{"1 < 2"}},
+	}
+
+	for _, test := range tests {
+		got := Split(test.text, test.limit)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: Split(%q, %d) = %q, want %q", test.name, test.text, test.limit, got, test.want)
+		}
+	}
+}
+
+// TestSplitLongMessage splits a long history, every chunk must fit and balance its tags.
+func TestSplitLongMessage(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("<b>Workouts for Tom &amp; Jerry</b>\n")
+	for i := 0; i < 400; i++ {
+		b.WriteString("<code>2024-05-01</code> <i>5.02 km at 6'00\"/km, a long note &lt;3</i>\n")
+	}
+	b.WriteString("<pre>" + strings.Repeat("x", 5000) + "</pre>")
+
+	chunks := Split(b.String(), MESSAGE_LIMIT)
+	if len(chunks) < 8 {
+		t.Fatalf("Split() = %d chunks, want at least 8", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if n := utf8.RuneCountInString(chunk); n > MESSAGE_LIMIT {
+			t.Errorf("chunk %d has %d characters", i, n)
+		}
+		var open []string
+		for _, token := range tokenize(chunk) {
+			if token[0] == '&' && !entityRegex.MatchString(token) {
+				t.Errorf("chunk %d cuts an entity: %q", i, token)
+			}
+			open = applyTag(open, token)
+		}
+		if len(open) > 0 {
+			t.Errorf("chunk %d leaves %v open", i, open)
+		}
+		if strings.Count(chunk, "<") != strings.Count(chunk, ">") {
+			t.Errorf("chunk %d cuts a tag", i)
+		}
+	}
+}
+
+// TELEGRAM_TAGS are the tags the HTML parse mode of Telegram supports, it rejects a
+// message with any other.
+var TELEGRAM_TAGS = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true, "s": true, "strike": true, "del": true,
+	"span": true, "tg-spoiler": true, "a": true, "tg-emoji": true, "code": true, "pre": true, "blockquote": true,
+}
+
+// TestTemplatesTelegramHTML renders every template in every locale, with names that
+// look like HTML, and checks that Telegram would accept the result.
+func TestTemplatesTelegramHTML(t *testing.T) {
+	const name = "<b>Tom</b> & <Jerry>"
+	workout := Workout{Date: "2024-05-01", Name: name, Type: "cycle", Distance: "42.20", Pace: "2:08", Time: "1:30:00",
+		ElevationGain: "120", HeartRate: "150", HasRoute: true, Flagged: true, Flags: []string{name}}
+	period := stats.Period{Name: stats.PERIOD_MONTH, Start: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}
+	summary := stats.Summary{Period: period, Runs: 2, TotalDistance: 15, TotalTime: time.Hour, AvgDistance: 7.5, AvgPace: 4 * time.Minute, BestPace: 3 * time.Minute}
+	samples := map[string]interface{}{
+		TEMPLATE_TEXT:           name,
+		TEMPLATE_ERROR:          name,
+		TEMPLATE_HELP:           nil,
+		TEMPLATE_WELCOME:        name,
+		TEMPLATE_WORKOUT_LOGGED: workout,
+		TEMPLATE_TOTALS:         Totals{Title: name, Rows: []Total{{Name: name, Distance: "5.02"}}},
+		TEMPLATE_HISTORY:        History{Title: name, Pages: 2, Workouts: []Workout{workout, {Date: "2024-05-02", Distance: "5", Pace: "6:00"}}},
+		TEMPLATE_STATS:          Stats{Name: name, Comparison: stats.Comparison{Current: summary, Previous: &summary}},
+		TEMPLATE_IMPORT_REPORT:  ImportReport{Source: name, Imported: 1, Skipped: 1, Issues: []ImportIssue{{Row: 2, Reason: name}}, More: 3},
+		TEMPLATE_DUPLICATES:     Duplicates{Pairs: []DuplicatePair{{Name: name, First: workout, Second: workout, OtherGroup: true}}},
+		TEMPLATE_REVIEW:         Review{Left: 1, Workout: workout},
+	}
+
+	loc := localizer.NewLocalizer()
+	mr := NewMessageRenderer(loc)
+	// The file and the templates used by others
+	partials := map[string]bool{"": true, "messages.tmpl": true, "manual": true, "workout": true}
+	for _, template := range mr.templates[localizer.DEFAULT_LOCALE].Templates() {
+		if _, ok := samples[template.Name()]; !ok && !partials[template.Name()] {
+			t.Errorf("template %q has no sample", template.Name())
+		}
+	}
+
+	for _, locale := range loc.Locales() {
+		for templateName, data := range samples {
+			text, err := mr.Render(locale, templateName, data)
+			if err != nil {
+				t.Errorf("%s %s: %v", locale, templateName, err)
+				continue
+			}
+			if problem := telegramHTMLProblem(text); problem != "" {
+				t.Errorf("%s %s: %s in %q", locale, templateName, problem, text)
+			}
+		}
+	}
+}
+
+// telegramHTMLProblem tells what Telegram would reject in text, empty when nothing.
+func telegramHTMLProblem(text string) string {
+	var open []string
+	for _, token := range tokenize(text) {
+		switch {
+		case token == "<" || token == ">" || token == "&":
+			return "unescaped " + token
+		case token[0] == '<':
+			tag := tagRegex.FindStringSubmatch(token)[1]
+			if !TELEGRAM_TAGS[tag] {
+				return "unsupported tag " + token
+			}
+			// Tags close in the order they opened
+			if strings.HasPrefix(token, "</") && (len(open) == 0 || tagRegex.FindStringSubmatch(open[len(open)-1])[1] != tag) {
+				return "unbalanced " + token
+			}
+			open = applyTag(open, token)
+		case token[0] == '&' && !strings.HasPrefix(token, "&#") &&
+			token != "&lt;" && token != "&gt;" && token != "&amp;" && token != "&quot;":
+			return "unsupported entity " + token
+		}
+	}
+	if len(open) > 0 {
+		return "unclosed " + strings.Join(open, "")
+	}
+	return ""
+}
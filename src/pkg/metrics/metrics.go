@@ -0,0 +1,265 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are kept in memory and served in the Prometheus text format, which is all a
+// scraper needs from a single process.
+
+// CONTENT_TYPE is the Prometheus text exposition format.
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// DURATION_BUCKETS suit work taking milliseconds to seconds, in seconds.
+var DURATION_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metric is written out by the registry, in the order of registration.
+type metric interface {
+	write(w io.Writer)
+}
+
+var registry struct {
+	sync.Mutex
+	metrics []metric
+}
+
+func register(m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+// Write writes every metric in the Prometheus text format.
+func Write(w io.Writer) {
+	registry.Lock()
+	metrics := append([]metric{}, registry.metrics...)
+	registry.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics, for /metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", CONTENT_TYPE)
+		Write(w)
+	})
+}
+
+// family holds the values of a metric by their label values.
+type family struct {
+	sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	// keys keeps the label values of each key, which joins them.
+	keys map[string][]string
+}
+
+func newFamily(name string, help string, kind string, labels []string) family {
+	return family{name: name, help: help, kind: kind, labels: labels, keys: make(map[string][]string)}
+}
+
+// key joins the label values, the caller holds the lock.
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s needs %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	if _, ok := f.keys[key]; !ok {
+		f.keys[key] = append([]string{}, values...)
+	}
+	return key
+}
+
+func (f *family) sortedKeys() []string {
+	keys := make([]string, 0, len(f.keys))
+	for key := range f.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Escaping of the text format: help texts escape backslashes and line feeds, label
+// values double quotes as well.
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, helpEscaper.Replace(f.help), f.name, f.kind)
+}
+
+// labelPairs writes the labels of key, with extra pairs like le="0.5" appended.
+func (f *family) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	for i, value := range f.keys[key] {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labels[i], labelEscaper.Replace(value)))
+	}
+	pairs = append(pairs, extra...)
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a value that only goes up, e.g. the number of updates handled.
+type Counter struct {
+	family
+	values map[string]float64
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, "counter", labels), values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter of the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(delta float64, values ...string) {
+	c.Lock()
+	defer c.Unlock()
+	c.values[c.key(values)] += delta
+}
+
+// Value is the count of the label values, for tests.
+func (c *Counter) Value(values ...string) float64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.values[strings.Join(values, "\xff")]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+	c.header(w)
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge is a value that goes up and down, e.g. the number of open conversations.
+type Gauge struct {
+	family
+	values map[string]float64
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{family: newFamily(name, help, "gauge", labels), values: make(map[string]float64)}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.Lock()
+	defer g.Unlock()
+	g.values[g.key(values)] += delta
+}
+
+func (g *Gauge) Set(value float64, values ...string) {
+	g.Lock()
+	defer g.Unlock()
+	g.values[g.key(values)] = value
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.Lock()
+	defer g.Unlock()
+	g.header(w)
+	for _, key := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(key), formatFloat(g.values[key]))
+	}
+}
+
+// Histogram counts observations in buckets, e.g. how long OCR takes.
+type Histogram struct {
+	family
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram counts observations up to each of the upper bounds in buckets, which
+// are sorted. The +Inf bucket is always written, it is left out of buckets.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		family: newFamily(name, help, "histogram", labels),
+		series: make(map[string]*histogramSeries),
+	}
+	for _, bound := range buckets {
+		if !math.IsInf(bound, 1) {
+			h.buckets = append(h.buckets, bound)
+		}
+	}
+	sort.Float64s(h.buckets)
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.Lock()
+	defer h.Unlock()
+
+	key := h.key(values)
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+// Since observes the seconds passed since start, e.g. deferred at the start of the work.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	h.header(w)
+	for _, key := range h.sortedKeys() {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, fmt.Sprintf(`le="%s"`, formatFloat(bound))), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, `le="+Inf"`), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), series.count)
+	}
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// output is what m writes in the text format.
+func output(m metric) string {
+	var buf bytes.Buffer
+	m.write(&buf)
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_updates_total", "Updates handled, by type.", "type")
+	c.Inc("message")
+	c.Add(2, "callback")
+	c.Inc("message")
+
+	want := `# HELP test_updates_total Updates handled, by type.
+# TYPE test_updates_total counter
+test_updates_total{type="callback"} 2
+test_updates_total{type="message"} 2
+`
+	if got := output(c); got != want {
+		t.Errorf("counter output:\n%s\nwant:\n%s", got, want)
+	}
+	if got := c.Value("message"); got != 2 {
+		t.Errorf("Value(message) = %v, want 2", got)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	c := NewCounter("test_escaped_total", "Help with a \\ and a\nline feed.", "path", "user")
+	c.Inc(`C:\data`, "say \"hi\"\n")
+	c.Inc("Zoë", "")
+
+	want := `# HELP test_escaped_total Help with a \\ and a\nline feed.
+# TYPE test_escaped_total counter
+test_escaped_total{path="C:\\data",user="say \"hi\"\n"} 1
+test_escaped_total{path="Zoë",user=""} 1
+`
+	if got := output(c); got != want {
+		t.Errorf("escaped output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_conversations", "Open conversations.")
+	if got := output(g); got != "# HELP test_conversations Open conversations.\n# TYPE test_conversations gauge\n" {
+		t.Errorf("empty gauge output = %q, want only the header", got)
+	}
+
+	g.Add(3)
+	g.Add(-1)
+	if got := output(g); !strings.HasSuffix(got, "\ntest_conversations 2\n") {
+		t.Errorf("gauge output = %q, want test_conversations 2 without labels", got)
+	}
+	g.Set(0.5)
+	if got := output(g); !strings.HasSuffix(got, "\ntest_conversations 0.5\n") {
+		t.Errorf("gauge output = %q, want test_conversations 0.5", got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_ocr_seconds", "OCR duration.", []float64{1, 0.1, math.Inf(1)}, "parser")
+	h.Observe(0.05, "apple")
+	h.Observe(0.5, "apple")
+	h.Observe(7, "apple")
+
+	// Sorted, with +Inf once
+
+	want := `# HELP test_ocr_seconds OCR duration.
+# TYPE test_ocr_seconds histogram
+test_ocr_seconds_bucket{parser="apple",le="0.1"} 1
+test_ocr_seconds_bucket{parser="apple",le="1"} 2
+test_ocr_seconds_bucket{parser="apple",le="+Inf"} 3
+test_ocr_seconds_sum{parser="apple"} 7.55
+test_ocr_seconds_count{parser="apple"} 3
+`
+	if got := output(h); got != want {
+		t.Errorf("histogram output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	c := NewCounter("test_labels_total", "Labels.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Inc() with one of two label values did not panic")
+		}
+	}()
+	c.Inc("only one")
+}
+
+func TestHandler(t *testing.T) {
+	c := NewCounter("test_scrapes_total", "Scrapes.")
+	c.Inc()
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); got != CONTENT_TYPE {
+		t.Errorf("Content-Type = %q, want %q", got, CONTENT_TYPE)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "# TYPE test_scrapes_total counter\ntest_scrapes_total 1\n") {
+		t.Errorf("/metrics does not serve the counter:\n%s", body)
+	}
+}
@@ -6,4 +6,7 @@ const (
 	AUTHORIZED_USERS_FILE = "authorized_users.json"
 	WORKOUT_DATA_DIR      = "data"
 	WORKOUT_DATA_FILE     = "workout_data.json"
+	SETTINGS_FILE         = "settings.json"
+	ROUTES_DIR            = "routes"
+	DEFAULT_TIMEZONE      = "UTC"
 )
@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"fmt"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"strings"
+	"time"
+)
+
+// Units workouts are measured in, each type of activity has its convention.
+const (
+	// UNIT_PACE is minutes per km, for runs, walks and hikes.
+	UNIT_PACE = "pace"
+	// UNIT_SPEED is km per hour, for rides.
+	UNIT_SPEED = "speed"
+	// UNIT_SWIM_PACE is minutes per 100 m, for swims.
+	UNIT_SWIM_PACE = "swim_pace"
+)
+
+// ACTIVITY_ANY picks workouts of every type, e.g. in "/getdistance week any". "all"
+// is taken, it is the period of all time.
+const ACTIVITY_ANY = "any"
+
+var activityUnits = map[string]string{
+	databasemanager.ACTIVITY_RUN:   UNIT_PACE,
+	databasemanager.ACTIVITY_WALK:  UNIT_PACE,
+	databasemanager.ACTIVITY_HIKE:  UNIT_PACE,
+	databasemanager.ACTIVITY_CYCLE: UNIT_SPEED,
+	databasemanager.ACTIVITY_SWIM:  UNIT_SWIM_PACE,
+}
+
+// activityWords are the words users, apps and activity files name the types with.
+var activityWords = map[string]string{
+	"run": databasemanager.ACTIVITY_RUN, "runs": databasemanager.ACTIVITY_RUN, "running": databasemanager.ACTIVITY_RUN,
+	"lauf": databasemanager.ACTIVITY_RUN, "laufen": databasemanager.ACTIVITY_RUN, "跑步": databasemanager.ACTIVITY_RUN,
+	"walk": databasemanager.ACTIVITY_WALK, "walks": databasemanager.ACTIVITY_WALK, "walking": databasemanager.ACTIVITY_WALK,
+	"gehen": databasemanager.ACTIVITY_WALK, "spaziergang": databasemanager.ACTIVITY_WALK, "步行": databasemanager.ACTIVITY_WALK,
+	"cycle": databasemanager.ACTIVITY_CYCLE, "cycling": databasemanager.ACTIVITY_CYCLE, "ride": databasemanager.ACTIVITY_CYCLE,
+	"rides": databasemanager.ACTIVITY_CYCLE, "bike": databasemanager.ACTIVITY_CYCLE, "biking": databasemanager.ACTIVITY_CYCLE,
+	"radfahren": databasemanager.ACTIVITY_CYCLE, "rad": databasemanager.ACTIVITY_CYCLE, "骑行": databasemanager.ACTIVITY_CYCLE,
+	"swim": databasemanager.ACTIVITY_SWIM, "swims": databasemanager.ACTIVITY_SWIM, "swimming": databasemanager.ACTIVITY_SWIM,
+	"schwimmen": databasemanager.ACTIVITY_SWIM, "游泳": databasemanager.ACTIVITY_SWIM,
+	"hike": databasemanager.ACTIVITY_HIKE, "hikes": databasemanager.ACTIVITY_HIKE, "hiking": databasemanager.ACTIVITY_HIKE,
+	"wandern": databasemanager.ACTIVITY_HIKE, "徒步": databasemanager.ACTIVITY_HIKE,
+}
+
+// ParseActivityType reads the type of activity a word names, e.g. "ride" or "cycling".
+func ParseActivityType(word string) (string, bool) {
+	activity, ok := activityWords[strings.ToLower(strings.TrimSpace(word))]
+	return activity, ok
+}
+
+// SplitActivityType takes a type of activity out of the words of input, e.g. "cycle"
+// out of "last week cycle", and returns the other words. Without one it is runs, "any"
+// gives an empty type for every activity.
+func SplitActivityType(input string) (string, string) {
+	activity := databasemanager.ACTIVITY_RUN
+	var rest []string
+	for _, word := range strings.Fields(input) {
+		if strings.ToLower(word) == ACTIVITY_ANY {
+			activity = ""
+			continue
+		}
+		if parsed, ok := ParseActivityType(word); ok {
+			activity = parsed
+			continue
+		}
+		rest = append(rest, word)
+	}
+	return strings.Join(rest, " "), activity
+}
+
+// UnitOf is the unit workouts of the activity type are measured in.
+func UnitOf(activity string) string {
+	if unit, ok := activityUnits[activity]; ok {
+		return unit
+	}
+	return UNIT_PACE
+}
+
+// FormatEffort writes a pace per km in the unit of the activity type, e.g. 5'30"/km,
+// 24.0 km/h or 2'05"/100m.
+func FormatEffort(activity string, pace time.Duration) string {
+	if pace <= 0 {
+		return "-"
+	}
+
+	switch UnitOf(activity) {
+	case UNIT_SPEED:
+		return fmt.Sprintf("%.1f km/h", float64(time.Hour)/float64(pace))
+	case UNIT_SWIM_PACE:
+		per100m := (pace / 10).Round(time.Second)
+		return fmt.Sprintf("%d'%02d\"/100m", int(per100m.Minutes()), int(per100m.Seconds())%60)
+	}
+	return FormatPace(pace)
+}
@@ -0,0 +1,147 @@
+package stats
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	agoRegex      = regexp.MustCompile(`^(\d+|a|an|one) (day|week)s? ago$`)
+	lastNRegex    = regexp.MustCompile(`^(?:last|past) (\d+) (day|week)s?$`)
+	weekdayRegex  = regexp.MustCompile(`^(?:(last|this) )?([a-z]+)$`)
+	dateSeparator = regexp.MustCompile(`^(?:from )?(.+?)(?:\.\.|,|\s+to\s+|\s+-\s+)(.+)$`)
+	whitespace    = regexp.MustCompile(`\s+`)
+)
+
+// Layouts tried in order. Dates without a year fall in the last twelve months.
+var (
+	dateLayouts = []string{
+		DATE_LAYOUT, "2006/01/02",
+		"January 2 2006", "January 2, 2006", "Jan 2 2006", "Jan 2, 2006",
+		"2 January 2006", "2 Jan 2006",
+	}
+	dateLayoutsNoYear  = []string{"January 2", "Jan 2", "2 January", "2 Jan"}
+	monthLayouts       = []string{"2006-01", "January 2006", "Jan 2006"}
+	monthLayoutsNoYear = []string{"January", "Jan"}
+)
+
+// ParseDate turns a date expression into a calendar day, relative to the calendar date
+// of now in its own location. It understands "today", "yesterday", "3 days ago",
+// "a week ago", weekday names like "saturday" (today or before) and "last saturday"
+// (strictly before today), "2024-05-03", "may 3", "3 may 2024" and "may 3, 2024".
+func ParseDate(input string, now time.Time) (time.Time, error) {
+	input = normalizeExpression(input)
+	today := truncateToDay(now)
+
+	switch input {
+	case "today", "now":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+
+	if matches := agoRegex.FindStringSubmatch(input); matches != nil {
+		days := count(matches[1]) * unitDays(matches[2])
+		return today.AddDate(0, 0, -days), nil
+	}
+
+	if matches := weekdayRegex.FindStringSubmatch(input); matches != nil {
+		if weekday, err := ParseWeekday(matches[2]); err == nil {
+			back := (int(today.Weekday()) - int(weekday) + 7) % 7
+			if matches[1] == "last" && back == 0 {
+				back = 7
+			}
+			return today.AddDate(0, 0, -back), nil
+		}
+	}
+
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, input); err == nil {
+			return date, nil
+		}
+	}
+
+	for _, layout := range dateLayoutsNoYear {
+		if date, err := time.Parse(layout, input); err == nil {
+			return pastYear(date, today), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unknown date: %q", input)
+}
+
+// parseRelativePeriod handles the expressions that only make sense as a period:
+// "last 30 days", "last 2 weeks", "may", "may 2024" and ranges between two dates.
+func parseRelativePeriod(input string, now time.Time) (Period, bool, error) {
+	today := truncateToDay(now)
+
+	if matches := lastNRegex.FindStringSubmatch(input); matches != nil {
+		days := count(matches[1]) * unitDays(matches[2])
+		if days <= 0 {
+			return Period{}, true, fmt.Errorf("invalid number of days: %q", input)
+		}
+		return Period{Name: PERIOD_RANGE, Start: today.AddDate(0, 0, 1-days), End: today.AddDate(0, 0, 1)}, true, nil
+	}
+
+	for _, layout := range monthLayouts {
+		if start, err := time.Parse(layout, input); err == nil {
+			return Period{Name: PERIOD_MONTH, Start: start, End: start.AddDate(0, 1, 0)}, true, nil
+		}
+	}
+
+	for _, layout := range monthLayoutsNoYear {
+		if month, err := time.Parse(layout, input); err == nil {
+			start := pastYear(month, today)
+			return Period{Name: PERIOD_MONTH, Start: start, End: start.AddDate(0, 1, 0)}, true, nil
+		}
+	}
+
+	if matches := dateSeparator.FindStringSubmatch(input); matches != nil {
+		start, err := ParseDate(matches[1], now)
+		if err != nil {
+			return Period{}, true, fmt.Errorf("invalid start date: %w", err)
+		}
+		end, err := ParseDate(matches[2], now)
+		if err != nil {
+			return Period{}, true, fmt.Errorf("invalid end date: %w", err)
+		}
+		if end.Before(start) {
+			return Period{}, true, fmt.Errorf("end date %s is earlier than start date %s", end.Format(DATE_LAYOUT), start.Format(DATE_LAYOUT))
+		}
+		return Period{Name: PERIOD_RANGE, Start: start, End: end.AddDate(0, 0, 1)}, true, nil
+	}
+
+	return Period{}, false, nil
+}
+
+// pastYear moves a date parsed without a year into the current year, or the year
+// before when it is still to come: on January 10th "may 3" is last May.
+func pastYear(date time.Time, today time.Time) time.Time {
+	date = time.Date(today.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	if date.After(today) {
+		return date.AddDate(-1, 0, 0)
+	}
+	return date
+}
+
+func normalizeExpression(input string) string {
+	input = strings.ToLower(strings.TrimSpace(input))
+	return whitespace.ReplaceAllString(input, " ")
+}
+
+func count(word string) int {
+	if n, err := strconv.Atoi(word); err == nil {
+		return n
+	}
+	return 1
+}
+
+func unitDays(unit string) int {
+	if unit == "week" {
+		return 7
+	}
+	return 1
+}
@@ -0,0 +1,148 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+// Wednesday, 2024-05-15.
+var testNow = time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+
+func day(input string) time.Time {
+	date, err := time.Parse(DATE_LAYOUT, input)
+	if err != nil {
+		panic(err)
+	}
+	return date
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"today", "2024-05-15"},
+		{"now", "2024-05-15"},
+		{"  Yesterday ", "2024-05-14"},
+		{"3 days ago", "2024-05-12"},
+		{"1 day ago", "2024-05-14"},
+		{"a week ago", "2024-05-08"},
+		{"2 weeks ago", "2024-05-01"},
+		{"saturday", "2024-05-11"},
+		{"last saturday", "2024-05-11"},
+		{"sat", "2024-05-11"},
+		{"wednesday", "2024-05-15"},
+		{"this wednesday", "2024-05-15"},
+		{"last wednesday", "2024-05-08"},
+		{"2024-05-03", "2024-05-03"},
+		{"2024/05/03", "2024-05-03"},
+		{"may 3", "2024-05-03"},
+		{"3 May", "2024-05-03"},
+		{"may 20", "2023-05-20"},
+		{"3 may 2024", "2024-05-03"},
+		{"May 3, 2024", "2024-05-03"},
+		{"december 31 2023", "2023-12-31"},
+	}
+
+	for _, test := range tests {
+		got, err := ParseDate(test.input, testNow)
+		if err != nil {
+			t.Errorf("ParseDate(%q) returned error: %v", test.input, err)
+			continue
+		}
+		if got.Format(DATE_LAYOUT) != test.want {
+			t.Errorf("ParseDate(%q) = %s, want %s", test.input, got.Format(DATE_LAYOUT), test.want)
+		}
+	}
+}
+
+func TestParseDateInvalid(t *testing.T) {
+	for _, input := range []string{"", "tomorrow", "2024-13-01", "last week", "m", "may 32"} {
+		if got, err := ParseDate(input, testNow); err == nil {
+			t.Errorf("ParseDate(%q) = %s, want error", input, got.Format(DATE_LAYOUT))
+		}
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		input string
+		name  string
+		start string
+		end   string
+	}{
+		{"", PERIOD_MONTH, "2024-05-01", "2024-06-01"},
+		{"month", PERIOD_MONTH, "2024-05-01", "2024-06-01"},
+		{"this month", PERIOD_MONTH, "2024-05-01", "2024-06-01"},
+		{"last month", PERIOD_MONTH, "2024-04-01", "2024-05-01"},
+		{"week", PERIOD_WEEK, "2024-05-13", "2024-05-20"},
+		{"this week", PERIOD_WEEK, "2024-05-13", "2024-05-20"},
+		{"last week", PERIOD_WEEK, "2024-05-06", "2024-05-13"},
+		{"year", PERIOD_YEAR, "2024-01-01", "2025-01-01"},
+		{"last year", PERIOD_YEAR, "2023-01-01", "2024-01-01"},
+		{"last 30 days", PERIOD_RANGE, "2024-04-16", "2024-05-16"},
+		{"past 7 days", PERIOD_RANGE, "2024-05-09", "2024-05-16"},
+		{"last 2 weeks", PERIOD_RANGE, "2024-05-02", "2024-05-16"},
+		{"may 2024", PERIOD_MONTH, "2024-05-01", "2024-06-01"},
+		{"May", PERIOD_MONTH, "2024-05-01", "2024-06-01"},
+		{"june", PERIOD_MONTH, "2023-06-01", "2023-07-01"},
+		{"2024-02", PERIOD_MONTH, "2024-02-01", "2024-03-01"},
+		{"2023", PERIOD_YEAR, "2023-01-01", "2024-01-01"},
+		{"2024-W19", PERIOD_WEEK, "2024-05-06", "2024-05-13"},
+		{"today", PERIOD_DAY, "2024-05-15", "2024-05-16"},
+		{"yesterday", PERIOD_DAY, "2024-05-14", "2024-05-15"},
+		{"last saturday", PERIOD_DAY, "2024-05-11", "2024-05-12"},
+		{"2024-05-01..2024-05-10", PERIOD_RANGE, "2024-05-01", "2024-05-11"},
+		{"2024-05-01, 2024-05-10", PERIOD_RANGE, "2024-05-01", "2024-05-11"},
+		{"2024-05-01 2024-05-10", PERIOD_RANGE, "2024-05-01", "2024-05-11"},
+		{"last monday to yesterday", PERIOD_RANGE, "2024-05-13", "2024-05-15"},
+		{"from may 1 to may 10", PERIOD_RANGE, "2024-05-01", "2024-05-11"},
+		{"3 days ago - today", PERIOD_RANGE, "2024-05-12", "2024-05-16"},
+	}
+
+	for _, test := range tests {
+		got, err := ParsePeriod(test.input, testNow, DEFAULT_WEEK_START)
+		if err != nil {
+			t.Errorf("ParsePeriod(%q) returned error: %v", test.input, err)
+			continue
+		}
+		if got.Name != test.name || !got.Start.Equal(day(test.start)) || !got.End.Equal(day(test.end)) {
+			t.Errorf("ParsePeriod(%q) = %s %s..%s, want %s %s..%s", test.input,
+				got.Name, got.Start.Format(DATE_LAYOUT), got.End.Format(DATE_LAYOUT), test.name, test.start, test.end)
+		}
+	}
+}
+
+func TestParsePeriodAll(t *testing.T) {
+	got, err := ParsePeriod("all time", testNow, DEFAULT_WEEK_START)
+	if err != nil {
+		t.Fatalf("ParsePeriod(\"all time\") returned error: %v", err)
+	}
+	if got.Name != PERIOD_ALL || !got.Start.IsZero() || !got.End.Equal(day("2024-05-16")) {
+		t.Errorf("ParsePeriod(\"all time\") = %+v, want everything up to today", got)
+	}
+}
+
+func TestParsePeriodInvalid(t *testing.T) {
+	for _, input := range []string{"next week", "2024-05-10..2024-05-01", "last 0 days", "2024-W60", "yesterday to someday"} {
+		if got, err := ParsePeriod(input, testNow, DEFAULT_WEEK_START); err == nil {
+			t.Errorf("ParsePeriod(%q) = %+v, want error", input, got)
+		}
+	}
+}
+
+func TestParseDateUsesLocalDay(t *testing.T) {
+	// 01:00 in Singapore is still the previous day in UTC.
+	singapore := time.FixedZone("SGT", 8*60*60)
+	now := time.Date(2024, 5, 15, 1, 0, 0, 0, singapore)
+
+	today, err := ParseDate("today", now)
+	if err != nil || today.Format(DATE_LAYOUT) != "2024-05-15" {
+		t.Errorf("ParseDate(\"today\") = %s, %v, want 2024-05-15", today.Format(DATE_LAYOUT), err)
+	}
+
+	yesterday, err := ParsePeriod("yesterday", now, DEFAULT_WEEK_START)
+	if err != nil || !yesterday.Start.Equal(day("2024-05-14")) {
+		t.Errorf("ParsePeriod(\"yesterday\") = %+v, %v, want 2024-05-14", yesterday, err)
+	}
+}
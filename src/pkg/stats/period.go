@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Period is a half-open date range [Start, End) of calendar dates, kept in UTC so they
+// line up with the YYYY-MM-DD keys of the store. A zero Start means "since forever".
+type Period struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+const (
+	PERIOD_WEEK  = "week"
+	PERIOD_MONTH = "month"
+	PERIOD_YEAR  = "year"
+	PERIOD_ALL   = "all"
+	PERIOD_DAY   = "day"
+	PERIOD_RANGE = "range"
+)
+
+var customRangeRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})\s*(?:\.\.|,|\s|to)\s*(\d{4}-\d{2}-\d{2})$`)
+
+// ParsePeriod understands "week", "month", "year" and "all", each also with "this" or
+// "last" in front, "last 30 days", "YYYY", "YYYY-MM", "may 2024", ISO weeks like
+// "2024-W19", any single day ParseDate understands, and ranges between two of those
+// days like "2024-05-01..2024-05-10" or "last monday to yesterday". An empty input
+// means "month". Weeks start on weekStart. Today is the calendar date of now in its
+// own location, so pass the user's local time.
+func ParsePeriod(input string, now time.Time, weekStart time.Weekday) (Period, error) {
+	input = normalizeExpression(input)
+	today := truncateToDay(now)
+	thisMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	thisYear := time.Date(today.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+
+	switch input {
+	case "", PERIOD_MONTH, "this month":
+		return Period{Name: PERIOD_MONTH, Start: thisMonth, End: thisMonth.AddDate(0, 1, 0)}, nil
+	case "last month":
+		return Period{Name: PERIOD_MONTH, Start: thisMonth.AddDate(0, -1, 0), End: thisMonth}, nil
+	case PERIOD_WEEK, "this week":
+		return WeekOf(today, weekStart), nil
+	case "last week":
+		return WeekOf(today.AddDate(0, 0, -7), weekStart), nil
+	case PERIOD_YEAR, "this year":
+		return Period{Name: PERIOD_YEAR, Start: thisYear, End: thisYear.AddDate(1, 0, 0)}, nil
+	case "last year":
+		return Period{Name: PERIOD_YEAR, Start: thisYear.AddDate(-1, 0, 0), End: thisYear}, nil
+	case PERIOD_ALL, "all time":
+		return Period{Name: PERIOD_ALL, End: today.AddDate(0, 0, 1)}, nil
+	}
+
+	if period, ok, err := ParseISOWeek(input, weekStart); ok {
+		return period, err
+	}
+
+	if day, err := ParseDate(input, now); err == nil {
+		return Period{Name: PERIOD_DAY, Start: day, End: day.AddDate(0, 0, 1)}, nil
+	}
+
+	if start, err := time.Parse("2006", input); err == nil {
+		return Period{Name: PERIOD_YEAR, Start: start, End: start.AddDate(1, 0, 0)}, nil
+	}
+
+	if matches := customRangeRegex.FindStringSubmatch(input); matches != nil {
+		input = matches[1] + ".." + matches[2]
+	}
+
+	if period, ok, err := parseRelativePeriod(input, now); ok {
+		return period, err
+	}
+
+	return Period{}, fmt.Errorf("unknown period: %q", input)
+}
+
+func (p Period) Contains(date time.Time) bool {
+	if !p.Start.IsZero() && date.Before(p.Start) {
+		return false
+	}
+	return date.Before(p.End)
+}
+
+// Previous returns the period of the same kind right before this one.
+// "all" has no previous period.
+func (p Period) Previous() (Period, bool) {
+	switch p.Name {
+	case PERIOD_ALL:
+		return Period{}, false
+	case PERIOD_WEEK:
+		return Period{Name: p.Name, Start: p.Start.AddDate(0, 0, -7), End: p.Start}, true
+	case PERIOD_MONTH:
+		return Period{Name: p.Name, Start: p.Start.AddDate(0, -1, 0), End: p.Start}, true
+	case PERIOD_YEAR:
+		return Period{Name: p.Name, Start: p.Start.AddDate(-1, 0, 0), End: p.Start}, true
+	case PERIOD_DAY:
+		return Period{Name: p.Name, Start: p.Start.AddDate(0, 0, -1), End: p.Start}, true
+	}
+
+	days := int(p.End.Sub(p.Start).Hours()/24 + 0.5)
+	return Period{Name: p.Name, Start: p.Start.AddDate(0, 0, -days), End: p.Start}, true
+}
+
+// Label renders the period as "2024-05-01 - 2024-05-31", or "all time".
+func (p Period) Label() string {
+	if p.Start.IsZero() {
+		return "all time"
+	}
+	if p.Name == PERIOD_DAY {
+		return p.Start.Format(DATE_LAYOUT)
+	}
+	return p.Start.Format(DATE_LAYOUT) + " - " + p.End.AddDate(0, 0, -1).Format(DATE_LAYOUT)
+}
+
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
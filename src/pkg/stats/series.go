@@ -0,0 +1,50 @@
+package stats
+
+import "time"
+
+// WeeklyTotals returns the first day of each of the last n weeks (current week last)
+// together with the distance run in that week.
+func WeeklyTotals(runs []Run, n int, now time.Time, weekStart time.Weekday) ([]time.Time, []float64) {
+	currentWeek := WeekOf(now, weekStart).Start
+
+	weeks := make([]time.Time, n)
+	totals := make([]float64, n)
+	for i := range weeks {
+		weeks[i] = currentWeek.AddDate(0, 0, -7*(n-1-i))
+	}
+
+	for _, run := range runs {
+		for i, week := range weeks {
+			if !run.Date.Before(week) && run.Date.Before(week.AddDate(0, 0, 7)) {
+				totals[i] += run.Distance
+				break
+			}
+		}
+	}
+
+	return weeks, totals
+}
+
+// CumulativeByDay returns the running distance total for every day of the period,
+// stopping at today when the period is not over yet.
+func CumulativeByDay(runs []Run, period Period, now time.Time) ([]time.Time, []float64) {
+	end := period.End
+	if tomorrow := truncateToDay(now).AddDate(0, 0, 1); tomorrow.Before(end) {
+		end = tomorrow
+	}
+
+	var days []time.Time
+	var totals []float64
+	var total float64
+	for day := period.Start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		for _, run := range runs {
+			if run.Date.Equal(day) {
+				total += run.Distance
+			}
+		}
+		days = append(days, day)
+		totals = append(totals, total)
+	}
+
+	return days, totals
+}
@@ -0,0 +1,59 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func seriesRuns() []Run {
+	runs := []Run{}
+	for date, distance := range map[string]float64{
+		"2024-04-28": 7, "2024-05-01": 5, "2024-05-05": 3, "2024-05-06": 10, "2024-05-15": 2, "2024-05-20": 4,
+	} {
+		runs = append(runs, Run{Date: day(date), Distance: distance})
+	}
+	return runs
+}
+
+func TestWeeklyTotals(t *testing.T) {
+	tests := []struct {
+		weekStart time.Weekday
+		first     string
+		want      []float64
+	}{
+		// 2024-05-05 is a Sunday, the end of a week starting on Monday
+		{time.Monday, "2024-04-29", []float64{8, 10, 2}},
+		{time.Sunday, "2024-04-28", []float64{12, 13, 2}},
+	}
+
+	for _, test := range tests {
+		weeks, totals := WeeklyTotals(seriesRuns(), 3, testNow, test.weekStart)
+		if len(weeks) != 3 || !weeks[0].Equal(day(test.first)) || !weeks[2].Equal(weeks[0].AddDate(0, 0, 14)) {
+			t.Errorf("WeeklyTotals(%s) weeks = %v, want 3 from %s", test.weekStart, weeks, test.first)
+		}
+		for i := range test.want {
+			if i >= len(totals) || totals[i] != test.want[i] {
+				t.Errorf("WeeklyTotals(%s) = %v, want %v", test.weekStart, totals, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCumulativeByDay(t *testing.T) {
+	month, err := ParsePeriod(PERIOD_MONTH, testNow, DEFAULT_WEEK_START)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	days, totals := CumulativeByDay(seriesRuns(), month, testNow)
+	// Up to today, the rest of the month is still to run
+	if len(days) != 15 || len(totals) != 15 || !days[14].Equal(day("2024-05-15")) {
+		t.Fatalf("CumulativeByDay() = %d days up to %v, want 15 up to 2024-05-15", len(days), days[len(days)-1])
+	}
+	for i, want := range map[int]float64{0: 5, 3: 5, 4: 8, 5: 18, 13: 18, 14: 20} {
+		if totals[i] != want {
+			t.Errorf("total on day %d = %.1f, want %.1f", i+1, totals[i], want)
+		}
+	}
+}
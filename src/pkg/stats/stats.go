@@ -0,0 +1,196 @@
+package stats
+
+import (
+	"fmt"
+	"regexp"
+	"run-tracker-telebot/src/log"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DATE_LAYOUT = "2006-01-02"
+
+// Run is a single workout entry with its string fields parsed into numbers.
+type Run struct {
+	Date     time.Time
+	Distance float64
+	Duration time.Duration
+	Pace     time.Duration
+}
+
+type Summary struct {
+	Period        Period
+	Runs          int
+	TotalDistance float64
+	TotalTime     time.Duration
+	AvgDistance   float64
+	AvgPace       time.Duration
+	BestPace      time.Duration
+	Longest       Run
+	ByWeekday     [7]float64
+}
+
+type Comparison struct {
+	Current  Summary
+	Previous *Summary
+}
+
+var paceRegex = regexp.MustCompile(`(\d{1,2})\s*[':]\s*(\d{2})`)
+
+// ParsePace turns pace strings such as 5'30"/km or 5:30 into a duration per km.
+func ParsePace(pace string) (time.Duration, error) {
+	matches := paceRegex.FindStringSubmatch(pace)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid pace: %q", pace)
+	}
+
+	minutes, _ := strconv.Atoi(matches[1])
+	seconds, _ := strconv.Atoi(matches[2])
+	if seconds >= 60 {
+		return 0, fmt.Errorf("invalid pace seconds: %q", pace)
+	}
+
+	return time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second, nil
+}
+
+// ParseClock reads times like 27:33 as minutes and 1:02:03 as hours.
+func ParseClock(clock string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(clock), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time: %q", clock)
+	}
+
+	var total time.Duration
+	for _, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time: %q", clock)
+		}
+		total = total*60 + time.Duration(value)
+	}
+	return total * time.Second, nil
+}
+
+// FormatPace renders a pace the same way Apple Workout does, e.g. 5'30"/km.
+func FormatPace(pace time.Duration) string {
+	if pace <= 0 {
+		return "-"
+	}
+	pace = pace.Round(time.Second)
+	return fmt.Sprintf("%d'%02d\"/km", int(pace.Minutes()), int(pace.Seconds())%60)
+}
+
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh%02dm", hours, minutes)
+}
+
+// FromWorkouts converts the stored entries of one user into runs sorted by date.
+// Entries that cannot be parsed are skipped.
+func FromWorkouts(workouts map[string]databasemanager.WorkoutEntry) []Run {
+	runs := make([]Run, 0, len(workouts))
+	for date, entry := range workouts {
+		run, err := NewRun(date, entry)
+		if err != nil {
+			log.Warn().Msgf("Skipping workout on %s: %v", date, err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Date.Before(runs[j].Date)
+	})
+
+	return runs
+}
+
+func NewRun(date string, entry databasemanager.WorkoutEntry) (Run, error) {
+	parsedDate, err := time.Parse(DATE_LAYOUT, date)
+	if err != nil {
+		return Run{}, fmt.Errorf("invalid date: %w", err)
+	}
+
+	distance, err := strconv.ParseFloat(strings.TrimSpace(entry.Distance), 64)
+	if err != nil {
+		return Run{}, fmt.Errorf("invalid distance: %w", err)
+	}
+
+	pace, err := ParsePace(entry.Pace)
+	if err != nil {
+		return Run{}, err
+	}
+
+	// Pace times distance when the workout has no time of its own, as screenshots
+	// without one. Files and exports carry the time that was moving.
+	duration := time.Duration(float64(pace) * distance).Round(time.Second)
+	if total, err := ParseClock(entry.Time); err == nil && total > 0 {
+		duration = total
+	}
+
+	return Run{
+		Date:     parsedDate,
+		Distance: distance,
+		Pace:     pace,
+		Duration: duration,
+	}, nil
+}
+
+// Summarize aggregates the runs that fall inside the period.
+func Summarize(runs []Run, period Period) Summary {
+	summary := Summary{Period: period}
+
+	for _, run := range runs {
+		if !period.Contains(run.Date) {
+			continue
+		}
+
+		summary.Runs++
+		summary.TotalDistance += run.Distance
+		summary.TotalTime += run.Duration
+		summary.ByWeekday[weekdayIndex(run.Date)] += run.Distance
+
+		if run.Distance > summary.Longest.Distance {
+			summary.Longest = run
+		}
+		if run.Pace > 0 && (summary.BestPace == 0 || run.Pace < summary.BestPace) {
+			summary.BestPace = run.Pace
+		}
+	}
+
+	if summary.Runs > 0 {
+		summary.AvgDistance = summary.TotalDistance / float64(summary.Runs)
+	}
+	if summary.TotalDistance > 0 {
+		summary.AvgPace = time.Duration(float64(summary.TotalTime) / summary.TotalDistance).Round(time.Second)
+	}
+
+	return summary
+}
+
+// Compare summarizes the period together with the one right before it.
+func Compare(runs []Run, period Period) Comparison {
+	comparison := Comparison{Current: Summarize(runs, period)}
+
+	if previous, ok := period.Previous(); ok {
+		summary := Summarize(runs, previous)
+		comparison.Previous = &summary
+	}
+
+	return comparison
+}
+
+// weekdayIndex maps Monday to 0 and Sunday to 6.
+func weekdayIndex(date time.Time) int {
+	return (int(date.Weekday()) + 6) % 7
+}
+
+var WEEKDAYS = [7]string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
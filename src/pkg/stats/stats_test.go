@@ -0,0 +1,149 @@
+package stats
+
+import (
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"testing"
+	"time"
+)
+
+func TestParsePace(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Duration
+	}{
+		{"5'30\"/km", 5*time.Minute + 30*time.Second},
+		{"5:30", 5*time.Minute + 30*time.Second},
+		{"12' 05\"", 12*time.Minute + 5*time.Second},
+	}
+
+	for _, test := range tests {
+		got, err := ParsePace(test.input)
+		if err != nil || got != test.want {
+			t.Errorf("ParsePace(%q) = %v, %v, want %v", test.input, got, err, test.want)
+		}
+	}
+
+	for _, input := range []string{"", "fast", "5:75"} {
+		if got, err := ParsePace(input); err == nil {
+			t.Errorf("ParsePace(%q) = %v, want error", input, got)
+		}
+	}
+}
+
+func TestPeriodPrevious(t *testing.T) {
+	tests := []struct {
+		period Period
+		start  string
+		end    string
+	}{
+		{Period{Name: PERIOD_WEEK, Start: day("2024-05-13"), End: day("2024-05-20")}, "2024-05-06", "2024-05-13"},
+		{Period{Name: PERIOD_MONTH, Start: day("2024-03-01"), End: day("2024-04-01")}, "2024-02-01", "2024-03-01"},
+		{Period{Name: PERIOD_YEAR, Start: day("2024-01-01"), End: day("2025-01-01")}, "2023-01-01", "2024-01-01"},
+		{Period{Name: PERIOD_DAY, Start: day("2024-05-15"), End: day("2024-05-16")}, "2024-05-14", "2024-05-15"},
+		{Period{Name: PERIOD_RANGE, Start: day("2024-05-01"), End: day("2024-05-11")}, "2024-04-21", "2024-05-01"},
+	}
+
+	for _, test := range tests {
+		got, ok := test.period.Previous()
+		if !ok || got.Name != test.period.Name || !got.Start.Equal(day(test.start)) || !got.End.Equal(day(test.end)) {
+			t.Errorf("%s %s.Previous() = %s..%s, want %s..%s", test.period.Name, test.period.Label(),
+				got.Start.Format(DATE_LAYOUT), got.End.Format(DATE_LAYOUT), test.start, test.end)
+		}
+	}
+
+	if _, ok := (Period{Name: PERIOD_ALL, End: day("2024-05-16")}).Previous(); ok {
+		t.Errorf("all time should have no previous period")
+	}
+}
+
+func TestPeriodLabel(t *testing.T) {
+	tests := []struct {
+		period Period
+		want   string
+	}{
+		{Period{Name: PERIOD_MONTH, Start: day("2024-05-01"), End: day("2024-06-01")}, "2024-05-01 - 2024-05-31"},
+		{Period{Name: PERIOD_DAY, Start: day("2024-05-15"), End: day("2024-05-16")}, "2024-05-15"},
+		{Period{Name: PERIOD_ALL, End: day("2024-05-16")}, "all time"},
+	}
+
+	for _, test := range tests {
+		if got := test.period.Label(); got != test.want {
+			t.Errorf("Label() = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestNewRun(t *testing.T) {
+	tests := []struct {
+		entry databasemanager.WorkoutEntry
+		want  time.Duration
+	}{
+		// Pace times distance without a time
+		{databasemanager.WorkoutEntry{Distance: "10", Pace: "6:00"}, time.Hour},
+		// The stored time, as of files with pauses the pace does not show
+		{databasemanager.WorkoutEntry{Distance: "10", Pace: "6:00", Time: "1:02:03"}, time.Hour + 2*time.Minute + 3*time.Second},
+		{databasemanager.WorkoutEntry{Distance: "5.02", Pace: "6:00", Time: "30:07"}, 30*time.Minute + 7*time.Second},
+		{databasemanager.WorkoutEntry{Distance: "10", Pace: "6:00", Time: "later"}, time.Hour},
+	}
+
+	for _, test := range tests {
+		run, err := NewRun("2024-05-01", test.entry)
+		if err != nil || run.Duration != test.want {
+			t.Errorf("NewRun(%+v) = %v, %v, want %v", test.entry, run.Duration, err, test.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	runs := FromWorkouts(map[string]databasemanager.WorkoutEntry{
+		"2024-04-30": {Distance: "10", Pace: "6:00"},
+		"2024-05-01": {Distance: "5", Pace: "5:00"},
+		"2024-05-13": {Distance: "10", Pace: "6:00"},
+		"2024-05-31": {Distance: "3", Pace: "4:30"},
+		"2024-06-01": {Distance: "21", Pace: "5:30"},
+		"2024-05-20": {Distance: "oops", Pace: "6:00"},
+	})
+	if len(runs) != 5 {
+		t.Fatalf("FromWorkouts() kept %d runs, want 5", len(runs))
+	}
+
+	month, err := ParsePeriod("month", testNow, DEFAULT_WEEK_START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparison := Compare(runs, month)
+
+	current := comparison.Current
+	if current.Runs != 3 || current.TotalDistance != 18 {
+		t.Errorf("current = %d runs, %.1f km, want 3 runs, 18.0 km", current.Runs, current.TotalDistance)
+	}
+	// 25:00 + 60:00 + 13:30 over 18 km.
+	if current.TotalTime != 98*time.Minute+30*time.Second || current.AvgPace != 5*time.Minute+28*time.Second {
+		t.Errorf("current time = %v, avg pace = %v, want 1h38m30s at 5m28s", current.TotalTime, current.AvgPace)
+	}
+	if current.BestPace != 4*time.Minute+30*time.Second || current.Longest.Distance != 10 {
+		t.Errorf("current best pace = %v, longest = %.1f, want 4m30s and 10.0", current.BestPace, current.Longest.Distance)
+	}
+	// 2024-05-01 is a Wednesday, 2024-05-13 a Monday and 2024-05-31 a Friday.
+	if current.ByWeekday != [7]float64{10, 0, 5, 0, 3, 0, 0} {
+		t.Errorf("current by weekday = %v", current.ByWeekday)
+	}
+
+	if comparison.Previous == nil {
+		t.Fatalf("a month should be compared to the previous month")
+	}
+	previous := *comparison.Previous
+	if previous.Runs != 1 || previous.TotalDistance != 10 || !previous.Period.Start.Equal(day("2024-04-01")) {
+		t.Errorf("previous = %d runs, %.1f km from %s, want 1 run, 10.0 km from 2024-04-01",
+			previous.Runs, previous.TotalDistance, previous.Period.Start.Format(DATE_LAYOUT))
+	}
+
+	all, err := ParsePeriod("all", testNow, DEFAULT_WEEK_START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comparison := Compare(runs, all); comparison.Previous != nil || comparison.Current.Runs != 3 {
+		t.Errorf("Compare(all) = %d runs with previous %v, want 3 runs up to today and no previous",
+			comparison.Current.Runs, comparison.Previous)
+	}
+}
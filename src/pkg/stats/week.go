@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_WEEK_START is the first day of the week as defined by ISO 8601.
+const DEFAULT_WEEK_START = time.Monday
+
+var isoWeekRegex = regexp.MustCompile(`^(\d{4})-?w(\d{1,2})$`)
+
+// WeekOf returns the week containing date, starting on weekStart.
+func WeekOf(date time.Time, weekStart time.Weekday) Period {
+	day := truncateToDay(date)
+	start := day.AddDate(0, 0, -((int(day.Weekday()) - int(weekStart) + 7) % 7))
+	return Period{Name: PERIOD_WEEK, Start: start, End: start.AddDate(0, 0, 7)}
+}
+
+// ISOWeek returns the ISO 8601 week of year, e.g. 2024-W19. ISO weeks start on Monday,
+// with another weekStart the week begins on the last weekStart day before that Monday.
+func ISOWeek(year int, week int, weekStart time.Weekday) (Period, error) {
+	// January 4th always falls in week 1.
+	jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, time.UTC)
+	monday := jan4.AddDate(0, 0, -weekdayIndex(jan4)+7*(week-1))
+
+	if isoYear, isoWeek := monday.ISOWeek(); week < 1 || isoYear != year || isoWeek != week {
+		return Period{}, fmt.Errorf("%d has no week %d", year, week)
+	}
+
+	return WeekOf(monday, weekStart), nil
+}
+
+// ParseISOWeek parses "2024-W19", also accepting "2024w19".
+func ParseISOWeek(input string, weekStart time.Weekday) (Period, bool, error) {
+	matches := isoWeekRegex.FindStringSubmatch(strings.ToLower(strings.TrimSpace(input)))
+	if matches == nil {
+		return Period{}, false, nil
+	}
+
+	year, _ := strconv.Atoi(matches[1])
+	week, _ := strconv.Atoi(matches[2])
+	period, err := ISOWeek(year, week, weekStart)
+	return period, true, err
+}
+
+// ParseWeekday understands English day names and their abbreviations, e.g. "sun" or "Sunday".
+func ParseWeekday(input string) (time.Weekday, error) {
+	input = strings.ToLower(strings.TrimSpace(input))
+	if len(input) >= 2 {
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.HasPrefix(strings.ToLower(day.String()), input) {
+				return day, nil
+			}
+		}
+	}
+	return time.Sunday, fmt.Errorf("unknown weekday: %q", input)
+}
@@ -0,0 +1,154 @@
+package validator
+
+import (
+	"fmt"
+	"math"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"run-tracker-telebot/src/pkg/stats"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Flags of the checks a workout can fail.
+const (
+	FLAG_TOO_FAST       = "too_fast"
+	FLAG_TOO_SLOW       = "too_slow"
+	FLAG_TOO_LONG       = "too_long"
+	FLAG_DAILY_DISTANCE = "daily_distance"
+	FLAG_TIME_MISMATCH  = "time_mismatch"
+)
+
+// Rule names, as admins set them with /rules.
+const (
+	RULE_PACE      = "pace"
+	RULE_DISTANCE  = "distance"
+	RULE_DAILY     = "daily"
+	RULE_TOLERANCE = "tolerance"
+	RULE_OFF       = "off"
+	RULE_DEFAULT   = "default"
+)
+
+// DEFAULT_RULES are generous enough for any runner, they only catch OCR garbage and
+// obvious mischief like 50 km at 2:00/km.
+var DEFAULT_RULES = databasemanager.ValidationRules{
+	MinPace:          150,
+	MaxPace:          1200,
+	MaxDistance:      60,
+	MaxDailyDistance: 100,
+	TimeTolerance:    0.1,
+}
+
+type Validator struct {
+	DatabaseManager *databasemanager.DatabaseManager
+}
+
+func NewValidator(databaseManager *databasemanager.DatabaseManager) *Validator {
+	return &Validator{
+		DatabaseManager: databaseManager,
+	}
+}
+
+// Rules are the rules of the group, the defaults until an admin changes them.
+func (v *Validator) Rules(groupID int64) databasemanager.ValidationRules {
+	if rules := v.DatabaseManager.GetGroupSettings(groupID).Rules; rules != nil {
+		return *rules
+	}
+	return DEFAULT_RULES
+}
+
+// Check runs the rules of the group over a workout about to be logged and returns the
+// flags of the checks it fails, none for a plausible workout. The pace and distance
+// rules are meant for runs, other activities are only checked for a consistent time.
+// The caller must hold the lock of the workout data, see DailyDistance.
+func (v *Validator) Check(groupID int64, userID int64, date string, workoutDetails map[string]string) []string {
+	rules := v.Rules(groupID)
+	var flags []string
+
+	distance, err := strconv.ParseFloat(strings.TrimSpace(workoutDetails["Distance"]), 64)
+	if err != nil {
+		return flags
+	}
+	run := databasemanager.WorkoutEntry{Type: workoutDetails["Type"]}.IsActivity(databasemanager.ACTIVITY_RUN)
+	pace, errPace := stats.ParsePace(workoutDetails["Pace"])
+	if errPace == nil && run {
+		if rules.MinPace > 0 && pace < rules.Fastest() {
+			flags = append(flags, FLAG_TOO_FAST)
+		}
+		if rules.MaxPace > 0 && pace > rules.Slowest() {
+			flags = append(flags, FLAG_TOO_SLOW)
+		}
+	}
+
+	if run && rules.MaxDistance > 0 && distance > rules.MaxDistance {
+		flags = append(flags, FLAG_TOO_LONG)
+	}
+	if run && rules.MaxDailyDistance > 0 && v.DatabaseManager.DailyDistance(groupID, userID, date, workoutDetails) > rules.MaxDailyDistance {
+		flags = append(flags, FLAG_DAILY_DISTANCE)
+	}
+
+	// Without a total time there is nothing to compare with
+	total, errTime := stats.ParseClock(workoutDetails["TotalTime"])
+	if rules.TimeTolerance > 0 && errPace == nil && errTime == nil && total > 0 {
+		expected := time.Duration(float64(pace) * distance)
+		if math.Abs(float64(expected-total))/float64(total) > rules.TimeTolerance {
+			flags = append(flags, FLAG_TIME_MISMATCH)
+		}
+	}
+
+	return flags
+}
+
+// SetRule changes a rule from the arguments of /rules, e.g. "pace 2:30 15:00",
+// "distance 60", "daily 100", "tolerance 10" in percent, or "distance off".
+func SetRule(rules *databasemanager.ValidationRules, name string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing value for rule %s", name)
+	}
+	off := args[0] == RULE_OFF
+
+	switch name {
+	case RULE_PACE:
+		if off {
+			rules.MinPace, rules.MaxPace = 0, 0
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("rule %s needs a fastest and a slowest pace", name)
+		}
+		fastest, err := stats.ParsePace(args[0])
+		if err != nil {
+			return err
+		}
+		slowest, err := stats.ParsePace(args[1])
+		if err != nil {
+			return err
+		}
+		if fastest >= slowest {
+			return fmt.Errorf("fastest pace %s is not faster than slowest pace %s", args[0], args[1])
+		}
+		rules.MinPace, rules.MaxPace = int(fastest.Seconds()), int(slowest.Seconds())
+	case RULE_DISTANCE:
+		return setNumber(&rules.MaxDistance, args[0], 1)
+	case RULE_DAILY:
+		return setNumber(&rules.MaxDailyDistance, args[0], 1)
+	case RULE_TOLERANCE:
+		return setNumber(&rules.TimeTolerance, args[0], 0.01)
+	default:
+		return fmt.Errorf("unknown rule: %q", name)
+	}
+	return nil
+}
+
+func setNumber(rule *float64, input string, scale float64) error {
+	if input == RULE_OFF {
+		*rule = 0
+		return nil
+	}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(input, ",", "."), 64)
+	if err != nil || value <= 0 {
+		return fmt.Errorf("invalid value: %q", input)
+	}
+	*rule = value * scale
+	return nil
+}
@@ -0,0 +1,150 @@
+package validator
+
+import (
+	"reflect"
+	"run-tracker-telebot/src/pkg/config"
+	databasemanager "run-tracker-telebot/src/pkg/database-manager"
+	"testing"
+)
+
+const (
+	TEST_GROUP = int64(-100)
+	TEST_USER  = int64(42)
+	TEST_DATE  = "2024-05-01"
+)
+
+// newTestStore returns an empty store in a temporary directory.
+func newTestStore(t *testing.T) *databasemanager.DatabaseManager {
+	t.Helper()
+
+	storage := config.Default().Storage
+	storage.DataDir = t.TempDir()
+	db := databasemanager.NewDatabaseManager(storage)
+	db.Data = databasemanager.NewWorkoutData()
+	return db
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		details map[string]string
+		want    []string
+	}{
+		{"plausible", map[string]string{"Distance": "10.00", "Pace": "5'30\"/km", "TotalTime": "55:00"}, nil},
+		{"fastest pace", map[string]string{"Distance": "5.00", "Pace": "2:30"}, nil},
+		{"too fast", map[string]string{"Distance": "5.00", "Pace": "2:00", "TotalTime": "10:00"}, []string{FLAG_TOO_FAST}},
+		{"too slow", map[string]string{"Distance": "5.00", "Pace": "21:00"}, []string{FLAG_TOO_SLOW}},
+		{"too long", map[string]string{"Distance": "65", "Pace": "6:00"}, []string{FLAG_TOO_LONG}},
+		{"off the time", map[string]string{"Distance": "10.00", "Pace": "5:30", "TotalTime": "40:00"}, []string{FLAG_TIME_MISMATCH}},
+		{"within the tolerance", map[string]string{"Distance": "10.00", "Pace": "5:30", "TotalTime": "58:00"}, nil},
+		{"everything", map[string]string{"Distance": "120", "Pace": "1:00", "TotalTime": "1:00:00"},
+			[]string{FLAG_TOO_FAST, FLAG_TOO_LONG, FLAG_DAILY_DISTANCE, FLAG_TIME_MISMATCH}},
+		// Rides are only checked for their time
+		{"ride", map[string]string{"Distance": "80", "Pace": "2:00", "Type": databasemanager.ACTIVITY_CYCLE}, nil},
+		{"ride off the time", map[string]string{"Distance": "80", "Pace": "2:00", "TotalTime": "1:00:00", "Type": databasemanager.ACTIVITY_CYCLE},
+			[]string{FLAG_TIME_MISMATCH}},
+		{"unreadable distance", map[string]string{"Distance": "far", "Pace": "1:00"}, nil},
+	}
+
+	v := NewValidator(newTestStore(t))
+	for _, test := range tests {
+		if got := check(v, TEST_GROUP, TEST_USER, TEST_DATE, test.details); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Check() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckDailyDistance(t *testing.T) {
+	db := newTestStore(t)
+	db.Data.Lock()
+	db.InsertWorkoutEntry(-1, TEST_USER, TEST_DATE, map[string]string{"Distance": "55", "Pace": "6:00"})
+	db.InsertWorkoutEntry(-2, TEST_USER, TEST_DATE, map[string]string{"Distance": "55", "Pace": "6:00"})
+	db.InsertWorkoutEntry(-3, TEST_USER, TEST_DATE, map[string]string{"Distance": "50", "Pace": "3:00", "Type": databasemanager.ACTIVITY_CYCLE})
+	db.Data.Unlock()
+	v := NewValidator(db)
+
+	// The run posted to two groups counts once, the ride not at all
+	if got := check(v, TEST_GROUP, TEST_USER, TEST_DATE, map[string]string{"Distance": "40", "Pace": "6:00"}); len(got) != 0 {
+		t.Errorf("Check() of 95 km = %v, want none", got)
+	}
+	got := check(v, TEST_GROUP, TEST_USER, TEST_DATE, map[string]string{"Distance": "50", "Pace": "6:00"})
+	if !reflect.DeepEqual(got, []string{FLAG_DAILY_DISTANCE}) {
+		t.Errorf("Check() of 105 km = %v, want %s", got, FLAG_DAILY_DISTANCE)
+	}
+	// Other users and days do not count
+	if got := check(v, TEST_GROUP, TEST_USER+1, TEST_DATE, map[string]string{"Distance": "50", "Pace": "6:00"}); len(got) != 0 {
+		t.Errorf("Check() of another user = %v, want none", got)
+	}
+	if got := check(v, TEST_GROUP, TEST_USER, "2024-05-02", map[string]string{"Distance": "50", "Pace": "6:00"}); len(got) != 0 {
+		t.Errorf("Check() of another day = %v, want none", got)
+	}
+}
+
+func TestCheckGroupRules(t *testing.T) {
+	db := newTestStore(t)
+	rules := DEFAULT_RULES
+	rules.MinPace, rules.MaxPace, rules.MaxDistance = 0, 0, 20
+	err := db.UpdateGroupSettings(TEST_GROUP, func(settings *databasemanager.GroupSettings) { settings.Rules = &rules })
+	if err != nil {
+		t.Fatalf("UpdateGroupSettings: %v", err)
+	}
+	v := NewValidator(db)
+
+	if got := v.Rules(TEST_GROUP); got != rules {
+		t.Errorf("Rules() = %+v, want %+v", got, rules)
+	}
+	if got := v.Rules(TEST_GROUP - 1); got != DEFAULT_RULES {
+		t.Errorf("Rules() of another group = %+v, want the defaults", got)
+	}
+	got := check(v, TEST_GROUP, TEST_USER, TEST_DATE, map[string]string{"Distance": "21", "Pace": "1:00"})
+	if !reflect.DeepEqual(got, []string{FLAG_TOO_LONG}) {
+		t.Errorf("Check() = %v, want only %s with the pace rule off", got, FLAG_TOO_LONG)
+	}
+}
+
+func TestSetRule(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want databasemanager.ValidationRules
+	}{
+		{RULE_PACE, []string{"2:30", "15:00"}, databasemanager.ValidationRules{MinPace: 150, MaxPace: 900, MaxDistance: 60, MaxDailyDistance: 100, TimeTolerance: 0.1}},
+		{RULE_PACE, []string{RULE_OFF}, databasemanager.ValidationRules{MaxDistance: 60, MaxDailyDistance: 100, TimeTolerance: 0.1}},
+		{RULE_DISTANCE, []string{"42,2"}, databasemanager.ValidationRules{MinPace: 150, MaxPace: 1200, MaxDistance: 42.2, MaxDailyDistance: 100, TimeTolerance: 0.1}},
+		{RULE_DAILY, []string{RULE_OFF}, databasemanager.ValidationRules{MinPace: 150, MaxPace: 1200, MaxDistance: 60, TimeTolerance: 0.1}},
+		{RULE_TOLERANCE, []string{"25"}, databasemanager.ValidationRules{MinPace: 150, MaxPace: 1200, MaxDistance: 60, MaxDailyDistance: 100, TimeTolerance: 0.25}},
+	}
+
+	for _, test := range tests {
+		rules := DEFAULT_RULES
+		if err := SetRule(&rules, test.name, test.args); err != nil || rules != test.want {
+			t.Errorf("SetRule(%s, %v) = %+v, %v, want %+v", test.name, test.args, rules, err, test.want)
+		}
+	}
+
+	invalid := []struct {
+		name string
+		args []string
+	}{
+		{RULE_PACE, []string{"5:00", "4:00"}},
+		{RULE_PACE, []string{"2:30"}},
+		{RULE_PACE, []string{"fast", "slow"}},
+		{RULE_DISTANCE, []string{"-1"}},
+		{RULE_DAILY, []string{"lots"}},
+		{RULE_TOLERANCE, nil},
+		{"speed", []string{"30"}},
+	}
+	for _, test := range invalid {
+		rules := DEFAULT_RULES
+		if err := SetRule(&rules, test.name, test.args); err == nil {
+			t.Errorf("SetRule(%s, %v) succeeded, want error", test.name, test.args)
+		}
+	}
+}
+
+// check runs Check the way callers do, under the lock of the workout data.
+func check(v *Validator, groupID int64, userID int64, date string, workoutDetails map[string]string) []string {
+	v.DatabaseManager.Data.Lock()
+	defer v.DatabaseManager.Data.Unlock()
+	return v.Check(groupID, userID, date, workoutDetails)
+}